@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/zapstore/relay/pkg/backup"
+	"github.com/zapstore/relay/pkg/config"
+)
+
+// backupDatabases returns the databases of the relay to back up or restore.
+func backupDatabases(c config.Config) []backup.Database {
+	var dbs []backup.Database
+	for _, db := range databases(c) {
+		dbs = append(dbs, backup.Database{Name: db.name, Path: db.path})
+	}
+	return dbs
+}
+
+// runBackup runs the "relay backup <dir>" command and returns the exit code.
+// It can run while the server is running.
+func runBackup(c config.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage:\n  relay backup <dir>")
+		return 1
+	}
+
+	dir, manifest, err := backup.Snapshot(context.Background(), backupDatabases(c), args[0], c.Sys.Version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "backup failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("snapshot written to %s\n", dir)
+	for _, entry := range manifest.Databases {
+		fmt.Printf("  %-10s %s (%d bytes, %d compressed, schema version %d)\n",
+			entry.Name, entry.File, entry.Size, entry.CompressedSize, entry.SchemaVersion)
+	}
+	return 0
+}
+
+// runRestore runs the "relay restore <dir>" command and returns the exit code.
+// The server must not be running, otherwise the restore fails because the databases are locked.
+func runRestore(c config.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage:\n  relay restore <dir>")
+		return 1
+	}
+
+	dir, err := backup.Resolve(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore failed: %v\n", err)
+		return 1
+	}
+
+	manifest, err := backup.Restore(context.Background(), dir, backupDatabases(c))
+	if errors.Is(err, backup.ErrDatabaseInUse) {
+		fmt.Fprintf(os.Stderr, "restore failed: %v (stop the server first)\n", err)
+		return 1
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "restore failed: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("restored snapshot %s taken at %s by relay %s\n", dir, manifest.CreatedAt, manifest.Version)
+	for _, entry := range manifest.Databases {
+		fmt.Printf("  %-10s schema version %d\n", entry.Name, entry.SchemaVersion)
+	}
+	return 0
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/zapstore/relay/pkg/blossom"
+	"github.com/zapstore/relay/pkg/config"
+	"github.com/zapstore/relay/pkg/relay"
+)
+
+// runExport runs the "relay export" command, writing the matching events to stdout as JSONL,
+// and returns the exit code.
+func runExport(c config.Config, args []string) int {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage:\n  relay export [--kinds 32267,30063] [--authors <pubkey>,<pubkey>] [--since 2026-01-02|<unix>] > events.jsonl")
+		flags.PrintDefaults()
+	}
+	kinds := flags.String("kinds", "", "comma-separated list of kinds to export (default all)")
+	authors := flags.String("authors", "", "comma-separated list of hex pubkeys to export (default all)")
+	since := flags.String("since", "", "export only events created at or after this date (YYYY-MM-DD) or unix timestamp")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	filter, err := exportFilter(*kinds, *authors, *since)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+		return 1
+	}
+
+	db, err := relay.NewDB(filepath.Join(c.Sys.Dir, "data", "relay.db"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export failed: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	count, err := relay.Export(context.Background(), db, filter, os.Stdout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export failed after %d events: %v\n", count, err)
+		return 1
+	}
+
+	fmt.Fprintf(os.Stderr, "exported %d events\n", count)
+	return 0
+}
+
+// exportFilter parses the flags of the export command into a filter.
+func exportFilter(kinds, authors, since string) (nostr.Filter, error) {
+	var filter nostr.Filter
+	for _, k := range splitList(kinds) {
+		kind, err := strconv.Atoi(k)
+		if err != nil {
+			return nostr.Filter{}, fmt.Errorf("invalid kind %q", k)
+		}
+		filter.Kinds = append(filter.Kinds, kind)
+	}
+
+	for _, pk := range splitList(authors) {
+		if !nostr.IsValidPublicKey(pk) {
+			return nostr.Filter{}, fmt.Errorf("invalid author %q: must be a hex pubkey", pk)
+		}
+		filter.Authors = append(filter.Authors, pk)
+	}
+
+	if since != "" {
+		if unix, err := strconv.ParseInt(since, 10, 64); err == nil {
+			ts := nostr.Timestamp(unix)
+			filter.Since = &ts
+		} else if day, err := time.Parse(time.DateOnly, since); err == nil {
+			ts := nostr.Timestamp(day.Unix())
+			filter.Since = &ts
+		} else {
+			return nostr.Filter{}, fmt.Errorf("invalid since %q: must be a date (YYYY-MM-DD) or unix timestamp", since)
+		}
+	}
+	return filter, nil
+}
+
+// splitList splits a comma-separated list, ignoring empty elements.
+func splitList(list string) []string {
+	var elements []string
+	for _, e := range strings.Split(list, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			elements = append(elements, e)
+		}
+	}
+	return elements
+}
+
+// runImport runs the "relay import <file>" command and returns the exit code.
+// It prints every rejected line, and exits with 1 if any line was rejected.
+func runImport(c config.Config, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage:\n  relay import <file.jsonl>")
+		return 1
+	}
+
+	file, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed: %v\n", err)
+		return 1
+	}
+	defer file.Close()
+
+	dataDir := filepath.Join(c.Sys.Dir, "data")
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "import failed: %v\n", err)
+		return 1
+	}
+
+	relayDB, err := relay.NewDB(filepath.Join(dataDir, "relay.db"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed: %v\n", err)
+		return 1
+	}
+	defer relayDB.Close()
+
+	blossomDB, err := blossom.NewDB(filepath.Join(dataDir, "blossom.db"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed: %v\n", err)
+		return 1
+	}
+	defer blossomDB.Close()
+
+	report, err := relay.Import(context.Background(), c.Relay, relayDB, blossomDB, file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "import failed: %v\n", err)
+		return 1
+	}
+
+	for _, r := range report.Rejected {
+		fmt.Fprintf(os.Stderr, "line %d: rejected %s: %s\n", r.Line, r.EventID, r.Reason)
+	}
+	fmt.Printf("imported %d events, %d pending assets, %d rejected\n", report.Saved, report.Pending, len(report.Rejected))
+
+	if len(report.Rejected) > 0 {
+		return 1
+	}
+	return 0
+}
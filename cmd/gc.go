@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zapstore/relay/pkg/blossom"
+	"github.com/zapstore/relay/pkg/config"
+	"github.com/zapstore/relay/pkg/relay"
+)
+
+// runGC runs the "relay gc [--dry-run]" command, collecting the blobs not referenced by any event,
+// and returns the exit code. It can run while the server is running.
+func runGC(c config.Config, args []string) int {
+	flags := flag.NewFlagSet("gc", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage:\n  relay gc [--dry-run]")
+		flags.PrintDefaults()
+	}
+	dryRun := flags.Bool("dry-run", false, "report what would be marked and deleted, without changing anything")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	dataDir := filepath.Join(c.Sys.Dir, "data")
+	relayDB, err := relay.NewDB(filepath.Join(dataDir, "relay.db"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gc failed: %v\n", err)
+		return 1
+	}
+	defer relayDB.Close()
+
+	blossomDB, err := blossom.NewDB(filepath.Join(dataDir, "blossom.db"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gc failed: %v\n", err)
+		return 1
+	}
+	defer blossomDB.Close()
+
+	backends, err := blossom.NewBackends(c.Blossom)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gc failed: %v\n", err)
+		return 1
+	}
+
+	gc := blossom.NewGC(c.Blossom, blossomDB, backends, relayDB)
+	report, err := gc.Collect(context.Background(), *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "gc failed: %v\n", err)
+		return 1
+	}
+
+	mark, del := "marked", "deleted"
+	if *dryRun {
+		mark, del = "would mark", "would delete"
+	}
+
+	for _, blob := range report.Marked {
+		fmt.Printf("%-12s %s %s (%d bytes, uploaded %s)\n", mark, blob.Hash, blob.Type, blob.Size, blob.CreatedAt.Format("2006-01-02"))
+	}
+	for _, blob := range report.Deleted {
+		fmt.Printf("%-12s %s %s (%d bytes, marked %s)\n", del, blob.Hash, blob.Type, blob.Size, blob.MarkedAt.Format("2006-01-02"))
+	}
+
+	fmt.Printf("%d blobs, %d referenced, %d %s, %d unmarked, %d %s (%d bytes), %d bytes reclaimable, %d failed\n",
+		report.Blobs, report.Referenced, len(report.Marked), mark, report.Unmarked,
+		len(report.Deleted), del, report.DeletedBytes, report.Reclaimable, report.Failed)
+
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
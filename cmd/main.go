@@ -15,10 +15,13 @@ import (
 	"github.com/nbd-wtf/go-nostr"
 	defender "github.com/zapstore/defender/pkg/client"
 	"github.com/zapstore/relay/pkg/analytics"
+	"github.com/zapstore/relay/pkg/backup"
 	"github.com/zapstore/relay/pkg/blossom"
+	"github.com/zapstore/relay/pkg/blossom/bunny"
 	"github.com/zapstore/relay/pkg/config"
 	"github.com/zapstore/relay/pkg/dashboard"
 	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/fetch"
 	"github.com/zapstore/relay/pkg/indexing"
 	"github.com/zapstore/relay/pkg/rate"
 	"github.com/zapstore/relay/pkg/relay"
@@ -30,9 +33,16 @@ Usage:
   relay <command>
 
 Commands:
-  run      Start the relay and blossom server
-  version  Print the relay version
-  config   Print the active configuration
+  run        Start the relay and blossom server
+  version    Print the relay version
+  config     Print the active configuration
+  migrate    Print (status) or apply (up) the schema migrations of the databases
+  backup     Write a snapshot of the databases to a directory, while the server runs
+  restore    Restore the databases from a snapshot directory, while the server is stopped
+  export     Write events to stdout as JSONL, filtered by --kinds, --authors and --since
+  import     Validate and save the events of a JSONL file
+  gc         Mark and delete the blobs not referenced by any event, or report them with --dry-run
+  reconcile  Re-create the metadata of the blobs in the Bunny storage zone without it, and report the missing ones
 `, config.Version)
 }
 
@@ -61,6 +71,27 @@ func main() {
 		fmt.Println(config)
 		os.Exit(0)
 
+	case "migrate":
+		os.Exit(runMigrate(config, os.Args[2:]))
+
+	case "backup":
+		os.Exit(runBackup(config, os.Args[2:]))
+
+	case "restore":
+		os.Exit(runRestore(config, os.Args[2:]))
+
+	case "export":
+		os.Exit(runExport(config, os.Args[2:]))
+
+	case "import":
+		os.Exit(runImport(config, os.Args[2:]))
+
+	case "gc":
+		os.Exit(runGC(config, os.Args[2:]))
+
+	case "reconcile":
+		os.Exit(runReconcile(config, os.Args[2:]))
+
 	case "run":
 		// continues below
 
@@ -85,6 +116,13 @@ func main() {
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		panic(err)
 	}
+	// the databases are locked while the server runs, so that they can't be restored under it
+	unlock, err := backup.Lock(backupDatabases(config))
+	if err != nil {
+		panic(err)
+	}
+	defer unlock()
+
 	relayDB, err := relay.NewDB(filepath.Join(dataDir, "relay.db"))
 	if err != nil {
 		panic(err)
@@ -108,8 +146,9 @@ func main() {
 	defer analyticsDB.Close()
 
 	// Step 2.
-	// Initialize rate limiter and connect to the defender
+	// Initialize rate limiter, the client for user-supplied URLs, and connect to the defender
 	limiter := rate.NewLimiter(config.Limiter)
+	fetcher := fetch.NewClient(config.Fetch)
 
 	defender, err := defender.Default("localhost:8080")
 	if err != nil {
@@ -154,18 +193,30 @@ func main() {
 		blossomDB,
 		analytics,
 		indexingEngine,
+		fetcher,
 	)
 	if err != nil {
 		panic(err)
 	}
 
+	backends, err := blossom.NewBackends(config.Blossom)
+	if err != nil {
+		panic(err)
+	}
+	gc := blossom.NewGC(config.Blossom, blossomDB, backends, relayDB)
+	reconcile := config.Blossom.ReconcileInterval > 0 && config.Blossom.Backend == blossom.BackendBunny
+	reconciler := blossom.NewReconciler(config.Blossom, blossomDB, bunny.NewClient(config.Blossom.Bunny), relay)
+
+	blossomPaths := blossom.Paths{Chunks: filepath.Join(config.Sys.Dir, "chunks")}
 	blossom, err := blossom.Setup(
 		config.Blossom,
+		blossomPaths,
 		limiter,
 		defender,
 		blossomDB,
 		relay,
 		analytics,
+		fetcher,
 	)
 	if err != nil {
 		panic(err)
@@ -219,6 +270,40 @@ func main() {
 		}
 	}()
 
+	if config.Backup.Enabled() {
+		scheduler := backup.NewScheduler(
+			config.Backup,
+			backupDatabases(config),
+			config.Sys.Version,
+			bunny.NewClient(config.Blossom.Bunny),
+		)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scheduler.Run(ctx)
+		}()
+		slog.Info("backup: scheduled backups enabled", "dir", config.Backup.Dir, "interval", config.Backup.Interval)
+	}
+
+	if config.Blossom.GCInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			gc.Run(ctx)
+		}()
+		slog.Info("blossom: garbage collection enabled", "interval", config.Blossom.GCInterval)
+	}
+
+	if reconcile {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reconciler.Run(ctx)
+		}()
+		slog.Info("blossom: storage reconciliation enabled", "interval", config.Blossom.ReconcileInterval)
+	}
+
 	select {
 	case <-ctx.Done():
 		wg.Wait()
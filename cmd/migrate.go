@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	analyticsstore "github.com/zapstore/relay/pkg/analytics/store"
+	blossomstore "github.com/zapstore/relay/pkg/blossom/store"
+	"github.com/zapstore/relay/pkg/config"
+	indexingstore "github.com/zapstore/relay/pkg/indexing/store"
+	"github.com/zapstore/relay/pkg/migrate"
+	relaystore "github.com/zapstore/relay/pkg/relay/store"
+)
+
+// database is one of the sqlite databases managed by the relay.
+type database struct {
+	name       string
+	path       string
+	migrations func() ([]migrate.Migration, error)
+	open       func(path string) (io.Closer, error) // opens the database, applying pending migrations
+}
+
+// databases returns all the databases of the relay, with their paths under the system directory.
+func databases(c config.Config) []database {
+	return []database{
+		{
+			name:       "relay",
+			path:       filepath.Join(c.Sys.Dir, "data", "relay.db"),
+			migrations: relaystore.Migrations,
+			open: func(path string) (io.Closer, error) {
+				return relaystore.New(path)
+			},
+		},
+		{
+			name:       "blossom",
+			path:       filepath.Join(c.Sys.Dir, "data", "blossom.db"),
+			migrations: blossomstore.Migrations,
+			open: func(path string) (io.Closer, error) {
+				return blossomstore.New(path)
+			},
+		},
+		{
+			name:       "analytics",
+			path:       filepath.Join(c.Sys.Dir, "analytics", "analytics.db"),
+			migrations: analyticsstore.Migrations,
+			open: func(path string) (io.Closer, error) {
+				return analyticsstore.New(path)
+			},
+		},
+		{
+			name:       "indexing",
+			path:       filepath.Join(c.Sys.Dir, "indexing", "indexing.db"),
+			migrations: indexingstore.Migrations,
+			open: func(path string) (io.Closer, error) {
+				return indexingstore.New(path)
+			},
+		},
+	}
+}
+
+func printMigrateHelp() {
+	fmt.Fprintf(os.Stderr, `Usage:
+  relay migrate <command>
+
+Commands:
+  status   Print the schema migrations of every database, and whether they have been applied
+  up       Apply the pending schema migrations of every database
+`)
+}
+
+// runMigrate runs the "relay migrate" command and returns the exit code.
+func runMigrate(c config.Config, args []string) int {
+	if len(args) != 1 {
+		printMigrateHelp()
+		return 1
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "status":
+		healthy := true
+		for _, db := range databases(c) {
+			ok, err := printStatus(ctx, db)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", db.name, err)
+				return 1
+			}
+			healthy = healthy && ok
+		}
+		if !healthy {
+			return 1
+		}
+		return 0
+
+	case "up":
+		for _, db := range databases(c) {
+			if err := os.MkdirAll(filepath.Dir(db.path), 0755); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", db.name, err)
+				return 1
+			}
+			conn, err := db.open(db.path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", db.name, err)
+				return 1
+			}
+			conn.Close()
+
+			if _, err := printStatus(ctx, db); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", db.name, err)
+				return 1
+			}
+		}
+		return 0
+
+	default:
+		printMigrateHelp()
+		return 1
+	}
+}
+
+// printStatus prints the state of the migrations of the database, opened in read-only mode.
+// It returns false if an applied migration has been modified or is unknown to this binary.
+func printStatus(ctx context.Context, db database) (bool, error) {
+	fmt.Printf("%s (%s)\n", db.name, db.path)
+
+	migrations, err := db.migrations()
+	if err != nil {
+		return false, err
+	}
+
+	var states []migrate.State
+	if _, err := os.Stat(db.path); errors.Is(err, os.ErrNotExist) {
+		for _, m := range migrations {
+			states = append(states, migrate.State{Version: m.Version, Name: m.Name, Status: migrate.Pending})
+		}
+
+	} else {
+		conn, err := sql.Open("sqlite3", "file:"+db.path+"?mode=ro")
+		if err != nil {
+			return false, err
+		}
+		defer conn.Close()
+
+		if states, err = migrate.Status(ctx, conn, migrations); err != nil {
+			return false, err
+		}
+	}
+
+	healthy := true
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "  VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, s := range states {
+		appliedAt := "-"
+		if !s.AppliedAt.IsZero() {
+			appliedAt = s.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "  %04d\t%s\t%s\t%s\n", s.Version, s.Name, s.Status, appliedAt)
+
+		if s.Status == migrate.Modified || s.Status == migrate.Unknown {
+			healthy = false
+		}
+	}
+	w.Flush()
+	fmt.Println()
+	return healthy, nil
+}
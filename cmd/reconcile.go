@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/zapstore/relay/pkg/blossom"
+	"github.com/zapstore/relay/pkg/blossom/bunny"
+	"github.com/zapstore/relay/pkg/config"
+)
+
+// runReconcile runs the "relay reconcile [--dry-run]" command, re-creating the metadata of the blobs
+// in the Bunny storage zone without it and reporting the blobs missing from the storage, and returns the exit code.
+// It can run while the server is running.
+func runReconcile(c config.Config, args []string) int {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage:\n  relay reconcile [--dry-run]")
+		flags.PrintDefaults()
+	}
+	dryRun := flags.Bool("dry-run", false, "report the orphan and missing blobs, without downloading or changing anything")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	if c.Blossom.Backend != blossom.BackendBunny {
+		fmt.Fprintf(os.Stderr, "reconcile failed: the storage backend is %q, not %q\n", c.Blossom.Backend, blossom.BackendBunny)
+		return 1
+	}
+
+	blossomDB, err := blossom.NewDB(filepath.Join(c.Sys.Dir, "data", "blossom.db"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reconcile failed: %v\n", err)
+		return 1
+	}
+	defer blossomDB.Close()
+
+	// the relay is not notified of the recovered blobs, and checks its pending assets against them on its periodic reconciliation
+	reconciler := blossom.NewReconciler(c.Blossom, blossomDB, bunny.NewClient(c.Blossom.Bunny), nil)
+	report, err := reconciler.Reconcile(context.Background(), *dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reconcile failed: %v\n", err)
+		return 1
+	}
+
+	recovered := "recovered"
+	if *dryRun {
+		recovered = "would recover"
+	}
+
+	for _, blob := range report.Recovered {
+		fmt.Printf("%-14s %s %s (%d bytes, uploaded %s)\n", recovered, blob.Hash, blob.Type, blob.Size, blob.CreatedAt.Format("2006-01-02"))
+	}
+	for _, path := range report.Invalid {
+		fmt.Printf("%-14s %s\n", "invalid", path)
+	}
+	for _, blob := range report.Dangling {
+		fmt.Printf("%-14s %s %s (%d bytes, uploaded %s)\n", "missing", blob.Hash, blob.Type, blob.Size, blob.CreatedAt.Format("2006-01-02"))
+	}
+
+	fmt.Printf("%d files, %d blobs, %d %s, %d invalid, %d missing, %d recent, %d failed\n",
+		report.Files, report.Blobs, len(report.Recovered), recovered, len(report.Invalid),
+		len(report.Dangling), report.Recent, report.Failed)
+
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -2,16 +2,22 @@
 package store
 
 import (
+	"context"
 	"database/sql"
-	_ "embed"
+	"embed"
 	"fmt"
-	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/zapstore/relay/pkg/migrate"
 )
 
-//go:embed schema.sql
-var schema string
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrations returns the schema migrations of the analytics database, in order.
+func Migrations() ([]migrate.Migration, error) {
+	return migrate.Load(migrations, "migrations")
+}
 
 // T is the store type that manages the analytics SQLite database.
 type T struct {
@@ -26,11 +32,8 @@ func New(path string) (*T, error) {
 		return nil, fmt.Errorf("failed to connect to sqlite3 at %s: %w", path, err)
 	}
 
-	if _, err := db.Exec(schema); err != nil {
-		return nil, fmt.Errorf("failed to apply base schema: %w", err)
-	}
-	if err := migrate(db); err != nil {
-		return nil, fmt.Errorf("failed to migrate: %w", err)
+	if err := applyMigrations(db); err != nil {
+		return nil, err
 	}
 	if _, err := db.Exec("PRAGMA journal_mode = WAL;"); err != nil {
 		return nil, fmt.Errorf("failed to set WAL mode: %w", err)
@@ -57,17 +60,28 @@ func (s *T) Path() string {
 	return s.path
 }
 
-func migrate(db *sql.DB) error {
-	for _, m := range []struct{ stmt, desc string }{
-		{`ALTER TABLE app_downloads ADD COLUMN type TEXT NOT NULL DEFAULT 'unknown'`, "add type"},
-		{`ALTER TABLE app_downloads ADD COLUMN app_id TEXT NOT NULL DEFAULT ''`, "add app_id"},
-		{`ALTER TABLE app_downloads ADD COLUMN app_version TEXT NOT NULL DEFAULT ''`, "add app_version"},
-		{`ALTER TABLE app_downloads ADD COLUMN app_pubkey TEXT NOT NULL DEFAULT ''`, "add app_pubkey"},
-		{`ALTER TABLE app_impressions ADD COLUMN app_version TEXT NOT NULL DEFAULT ''`, "add impressions app_version"},
+// applyMigrations brings databases created before versioned migrations up to the first migration,
+// then applies the pending schema migrations.
+func applyMigrations(db *sql.DB) error {
+	ctx := context.Background()
+	for _, c := range []struct{ table, column, definition string }{
+		{"app_downloads", "type", "TEXT NOT NULL DEFAULT 'unknown'"},
+		{"app_downloads", "app_id", "TEXT NOT NULL DEFAULT ''"},
+		{"app_downloads", "app_version", "TEXT NOT NULL DEFAULT ''"},
+		{"app_downloads", "app_pubkey", "TEXT NOT NULL DEFAULT ''"},
+		{"app_impressions", "app_version", "TEXT NOT NULL DEFAULT ''"},
 	} {
-		if _, err := db.Exec(m.stmt); err != nil && !strings.Contains(err.Error(), "duplicate column") {
-			return fmt.Errorf("%s: %w", m.desc, err)
+		if err := migrate.AddColumn(ctx, db, c.table, c.column, c.definition); err != nil {
+			return err
 		}
 	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	if _, err := migrate.Up(ctx, db, migrations); err != nil {
+		return fmt.Errorf("failed to migrate: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,500 @@
+// Package backup takes consistent, compressed snapshots of the sqlite databases of the relay,
+// and restores them.
+//
+// Snapshots are taken with the sqlite online backup API, so they can be taken while the server runs.
+// A snapshot is a directory named after its creation time (e.g. "20260118T030000Z"), containing
+// one gzip compressed file per database and a manifest with their sizes, hashes and schema versions.
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ManifestFile is the name of the manifest file in a snapshot directory.
+const ManifestFile = "manifest.json"
+
+// generationLayout is the time layout used to name snapshot directories.
+const generationLayout = "20060102T150405Z"
+
+var (
+	ErrNoSnapshot         = errors.New("no snapshot found")
+	ErrChecksumMismatch   = errors.New("snapshot checksum mismatch")
+	ErrSnapshotInProgress = errors.New("another snapshot is in progress")
+	ErrDatabaseInUse      = errors.New("database is in use")
+)
+
+// dbSuffixes are the suffixes of the files of a sqlite database in WAL mode.
+var dbSuffixes = []string{"", "-wal", "-shm"}
+
+// Database is a sqlite database to back up or restore.
+type Database struct {
+	Name string // e.g. "relay"
+	Path string // path to the database file
+}
+
+// Manifest describes a snapshot.
+type Manifest struct {
+	Version   string    `json:"version"` // version of the relay that took the snapshot
+	CreatedAt time.Time `json:"created_at"`
+	Databases []Entry   `json:"databases"`
+}
+
+// Entry describes the snapshot of a single database.
+type Entry struct {
+	Name             string `json:"name"`
+	File             string `json:"file"`   // compressed snapshot, relative to the manifest
+	Size             int64  `json:"size"`   // size of the uncompressed database
+	SHA256           string `json:"sha256"` // hash of the uncompressed database
+	CompressedSize   int64  `json:"compressed_size"`
+	CompressedSHA256 string `json:"compressed_sha256"`
+	SchemaVersion    int    `json:"schema_version"` // latest migration applied, 0 if unknown
+}
+
+// Snapshot takes a snapshot of the databases in a new directory inside dir, and returns its path.
+// Databases whose file doesn't exist are skipped.
+// The snapshot directory appears only once complete, so a failed snapshot never looks like a valid one.
+// It returns [ErrSnapshotInProgress] if another snapshot of the same generation is being taken (e.g. by the CLI and the scheduler).
+func Snapshot(ctx context.Context, dbs []Database, dir, version string) (string, Manifest, error) {
+	now := time.Now().UTC()
+	final := filepath.Join(dir, now.Format(generationLayout))
+	tmp := final + ".tmp"
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", Manifest{}, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	// the temporary directory is owned by a single snapshot, which is the only one that removes it
+	err := os.Mkdir(tmp, 0755)
+	if errors.Is(err, os.ErrExist) {
+		return "", Manifest{}, fmt.Errorf("%w: %s exists", ErrSnapshotInProgress, tmp)
+	}
+	if err != nil {
+		return "", Manifest{}, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	manifest := Manifest{Version: version, CreatedAt: now}
+	for _, db := range dbs {
+		if _, err := os.Stat(db.Path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		entry, err := snapshot(ctx, db, tmp)
+		if err != nil {
+			return "", Manifest{}, fmt.Errorf("%s: %w", db.Name, err)
+		}
+		manifest.Databases = append(manifest.Databases, entry)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return "", Manifest{}, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmp, ManifestFile), data, 0644); err != nil {
+		return "", Manifest{}, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	if err := os.Rename(tmp, final); err != nil {
+		return "", Manifest{}, fmt.Errorf("failed to finalize snapshot: %w", err)
+	}
+	return final, manifest, nil
+}
+
+// snapshot copies the database into dir with the online backup API, then compresses it.
+func snapshot(ctx context.Context, db Database, dir string) (Entry, error) {
+	raw := filepath.Join(dir, db.Name+".db")
+	defer os.Remove(raw)
+
+	if err := copyDatabase(ctx, db.Path, raw); err != nil {
+		return Entry{}, err
+	}
+
+	schemaVersion, err := schemaVersion(ctx, raw)
+	if err != nil {
+		return Entry{}, err
+	}
+
+	entry := Entry{
+		Name:          db.Name,
+		File:          db.Name + ".db.gz",
+		SchemaVersion: schemaVersion,
+	}
+	if err := compress(raw, filepath.Join(dir, entry.File), &entry); err != nil {
+		return Entry{}, err
+	}
+	return entry, nil
+}
+
+// copyDatabase copies the sqlite database at src into a new database at dest, using the online backup API.
+// The copy is consistent even if src is being written to by other connections.
+func copyDatabase(ctx context.Context, src, dest string) error {
+	srcDB, err := sql.Open("sqlite3", src)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer srcDB.Close()
+
+	destDB, err := sql.Open("sqlite3", dest)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer destDB.Close()
+
+	srcConn, err := srcDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer srcConn.Close()
+
+	destConn, err := destDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to snapshot: %w", err)
+	}
+	defer destConn.Close()
+
+	return destConn.Raw(func(destDriver any) error {
+		return srcConn.Raw(func(srcDriver any) error {
+			destSqlite, ok := destDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", destDriver)
+			}
+			srcSqlite, ok := srcDriver.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected driver connection %T", srcDriver)
+			}
+
+			backup, err := destSqlite.Backup("main", srcSqlite, "main")
+			if err != nil {
+				return fmt.Errorf("failed to start backup: %w", err)
+			}
+
+			for {
+				done, err := backup.Step(-1)
+				if err != nil {
+					backup.Close()
+					return fmt.Errorf("failed to backup: %w", err)
+				}
+				if done {
+					break
+				}
+
+				// the source database is busy or locked, retry shortly
+				select {
+				case <-ctx.Done():
+					backup.Close()
+					return ctx.Err()
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("failed to finish backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// schemaVersion returns the latest migration applied to the database at path, or 0 if unknown.
+func schemaVersion(ctx context.Context, path string) (int, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer db.Close()
+
+	// databases created before versioned migrations have no schema_version table
+	var exists bool
+	err = db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version')`).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query schema version: %w", err)
+	}
+	if !exists {
+		return 0, nil
+	}
+
+	var version int
+	err = db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_version`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query schema version: %w", err)
+	}
+	return version, nil
+}
+
+// compress writes the gzip compressed file at src to dest, filling the sizes and hashes of the entry.
+func compress(src, dest string, entry *Entry) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create compressed snapshot: %w", err)
+	}
+	defer out.Close()
+
+	compressed := &countingHasher{w: out, hash: sha256.New()}
+	gz := gzip.NewWriter(compressed)
+
+	raw := &countingHasher{w: gz, hash: sha256.New()}
+	if _, err := io.Copy(raw, in); err != nil {
+		return fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress snapshot: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to sync compressed snapshot: %w", err)
+	}
+
+	entry.Size = raw.n
+	entry.SHA256 = hex.EncodeToString(raw.hash.Sum(nil))
+	entry.CompressedSize = compressed.n
+	entry.CompressedSHA256 = hex.EncodeToString(compressed.hash.Sum(nil))
+	return nil
+}
+
+// countingHasher is a writer that counts and hashes the bytes written to the underlying writer.
+type countingHasher struct {
+	w    io.Writer
+	hash interface {
+		io.Writer
+		Sum([]byte) []byte
+	}
+	n int64
+}
+
+func (c *countingHasher) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.hash.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+// ReadManifest reads the manifest of the snapshot in dir.
+func ReadManifest(dir string) (Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return Manifest{}, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// Generations returns the complete snapshots in dir, from the oldest to the newest.
+func Generations(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var generations []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(generationLayout, entry.Name()); err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, entry.Name(), ManifestFile)); err != nil {
+			continue
+		}
+		generations = append(generations, entry.Name())
+	}
+
+	slices.Sort(generations) // the layout sorts chronologically
+	return generations, nil
+}
+
+// Resolve returns the snapshot directory to restore from dir, which is either a snapshot
+// or a directory of snapshots, in which case the newest one is returned.
+func Resolve(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, ManifestFile)); err == nil {
+		return dir, nil
+	}
+
+	generations, err := Generations(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(generations) == 0 {
+		return "", fmt.Errorf("%w in %s", ErrNoSnapshot, dir)
+	}
+	return filepath.Join(dir, generations[len(generations)-1]), nil
+}
+
+// Restore replaces the databases with their snapshot in dir. The server must not be running,
+// which is enforced with [Lock], so it returns [ErrDatabaseInUse] if a database is in use.
+// Every database in the snapshot is decompressed and verified before any of them is replaced.
+// If a database can't be replaced, the ones already replaced are reverted to their original files,
+// so that the databases are never left from different snapshots. Databases not present in the snapshot are left untouched.
+func Restore(ctx context.Context, dir string, dbs []Database) (Manifest, error) {
+	manifest, err := ReadManifest(dir)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	unlock, err := Lock(dbs)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer unlock()
+
+	paths := make(map[string]string, len(manifest.Databases))
+	for _, entry := range manifest.Databases {
+		i := slices.IndexFunc(dbs, func(db Database) bool { return db.Name == entry.Name })
+		if i == -1 {
+			return Manifest{}, fmt.Errorf("snapshot contains unknown database %q", entry.Name)
+		}
+		path := dbs[i].Path
+
+		if err := decompress(ctx, filepath.Join(dir, entry.File), path+".restore", entry); err != nil {
+			os.Remove(path + ".restore")
+			return Manifest{}, fmt.Errorf("%s: %w", entry.Name, err)
+		}
+		defer os.Remove(path + ".restore")
+		paths[entry.Name] = path
+	}
+
+	var replaced []string
+	for _, entry := range manifest.Databases {
+		path := paths[entry.Name]
+		if err := replace(path); err != nil {
+			err = fmt.Errorf("%s: %w", entry.Name, err)
+
+			for i := len(replaced) - 1; i >= 0; i-- {
+				name := replaced[i]
+				if rerr := revert(paths[name]); rerr != nil {
+					return Manifest{}, fmt.Errorf("%w; failed to revert %s, the databases %v have been replaced: %w", err, name, replaced[:i+1], rerr)
+				}
+			}
+			return Manifest{}, err
+		}
+		replaced = append(replaced, entry.Name)
+	}
+
+	for _, path := range paths {
+		for _, suffix := range dbSuffixes {
+			os.Remove(path + suffix + ".orig")
+		}
+	}
+	return manifest, nil
+}
+
+// replace moves the files of the database at path aside with the ".orig" suffix, then moves the restored
+// database in its place. On failure, the moved files are put back.
+func replace(path string) error {
+	var moved []string
+	undo := func() {
+		for _, name := range moved {
+			os.Rename(name+".orig", name)
+		}
+	}
+
+	for _, suffix := range dbSuffixes {
+		name := path + suffix
+		err := os.Rename(name, name+".orig")
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			undo()
+			return fmt.Errorf("failed to move %s aside: %w", filepath.Base(name), err)
+		}
+		moved = append(moved, name)
+	}
+
+	if err := os.Rename(path+".restore", path); err != nil {
+		undo()
+		return fmt.Errorf("failed to replace database: %w", err)
+	}
+	return nil
+}
+
+// revert puts back the original files of the database at path replaced by [replace],
+// removing the restored database if there was no original.
+func revert(path string) error {
+	var errs []error
+	for _, suffix := range dbSuffixes {
+		name := path + suffix
+		err := os.Rename(name+".orig", name)
+		if errors.Is(err, os.ErrNotExist) {
+			err = os.Remove(name)
+		}
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decompress writes the compressed snapshot at src to dest, and verifies it against the entry.
+func decompress(ctx context.Context, src, dest string, entry Entry) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer in.Close()
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("failed to decompress snapshot: %w", err)
+	}
+	defer gz.Close()
+
+	out, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("failed to create database: %w", err)
+	}
+	defer out.Close()
+
+	raw := &countingHasher{w: out, hash: sha256.New()}
+	if _, err := io.Copy(raw, gz); err != nil {
+		return fmt.Errorf("failed to decompress snapshot: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("failed to sync database: %w", err)
+	}
+
+	if raw.n != entry.Size || hex.EncodeToString(raw.hash.Sum(nil)) != entry.SHA256 {
+		return ErrChecksumMismatch
+	}
+	return integrityCheck(ctx, dest)
+}
+
+// integrityCheck runs the sqlite integrity check on the database at path.
+func integrityCheck(ctx context.Context, path string) error {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("failed to check integrity: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var ctx = context.Background()
+
+// newDatabase creates a database in WAL mode with a table of n rows, and returns it with its connection.
+func newDatabase(t *testing.T, name string, n int) (Database, *sql.DB) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name+".db")
+
+	conn, err := sql.Open("sqlite3", path+"?_journal_mode=WAL")
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	_, err = conn.Exec(`
+		CREATE TABLE items (id INTEGER PRIMARY KEY, value TEXT);
+		CREATE TABLE schema_version (version INTEGER PRIMARY KEY, name TEXT, checksum TEXT, applied_at INTEGER);
+		INSERT INTO schema_version VALUES (1, 'init', 'x', 0), (2, 'next', 'y', 0);`)
+	if err != nil {
+		t.Fatalf("failed to create tables: %v", err)
+	}
+
+	for i := range n {
+		if _, err := conn.Exec("INSERT INTO items (id, value) VALUES (?, ?)", i, "value"); err != nil {
+			t.Fatalf("failed to insert row: %v", err)
+		}
+	}
+	return Database{Name: name, Path: path}, conn
+}
+
+func count(t *testing.T, path string) int {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer conn.Close()
+
+	var n int
+	if err := conn.QueryRow("SELECT COUNT(*) FROM items").Scan(&n); err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	return n
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	relay, relayConn := newDatabase(t, "relay", 1000)
+	blossom, _ := newDatabase(t, "blossom", 10)
+	missing := Database{Name: "indexing", Path: filepath.Join(t.TempDir(), "indexing.db")}
+	dbs := []Database{relay, blossom, missing}
+
+	// keep writing to the relay database while the snapshot is taken
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 1000; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				relayConn.Exec("INSERT INTO items (id, value) VALUES (?, ?)", i, "value")
+			}
+		}
+	}()
+
+	dir, manifest, err := Snapshot(ctx, dbs, t.TempDir(), "v1.2.3")
+	close(stop)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	if len(manifest.Databases) != 2 {
+		t.Fatalf("expected 2 databases in the manifest, got %d", len(manifest.Databases))
+	}
+	for _, entry := range manifest.Databases {
+		if entry.SchemaVersion != 2 {
+			t.Errorf("%s: expected schema version 2, got %d", entry.Name, entry.SchemaVersion)
+		}
+	}
+
+	read, err := ReadManifest(dir)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if read.Version != "v1.2.3" || len(read.Databases) != 2 {
+		t.Fatalf("unexpected manifest %+v", read)
+	}
+
+	if _, err := relayConn.Exec("DELETE FROM items"); err != nil {
+		t.Fatalf("failed to delete rows: %v", err)
+	}
+	relayConn.Close()
+
+	if _, err := Restore(ctx, dir, dbs); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+
+	if n := count(t, relay.Path); n < 1000 {
+		t.Errorf("expected at least 1000 restored rows, got %d", n)
+	}
+	if n := count(t, blossom.Path); n != 10 {
+		t.Errorf("expected 10 restored rows, got %d", n)
+	}
+	if _, err := os.Stat(missing.Path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected missing database to not be restored, got %v", err)
+	}
+}
+
+func TestRestoreCorrupted(t *testing.T) {
+	relay, conn := newDatabase(t, "relay", 10)
+	dir, manifest, err := Snapshot(ctx, []Database{relay}, t.TempDir(), "v1.2.3")
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	// replace the snapshot with another valid one, whose hash doesn't match the manifest
+	other, _ := newDatabase(t, "other", 20)
+	otherDir, _, err := Snapshot(ctx, []Database{other}, t.TempDir(), "v1.2.3")
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+	if err := os.Rename(filepath.Join(otherDir, "other.db.gz"), filepath.Join(dir, manifest.Databases[0].File)); err != nil {
+		t.Fatalf("failed to replace snapshot: %v", err)
+	}
+
+	if _, err := conn.Exec("DELETE FROM items WHERE id < 5"); err != nil {
+		t.Fatalf("failed to delete rows: %v", err)
+	}
+	conn.Close()
+
+	if _, err := Restore(ctx, dir, []Database{relay}); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected error %v, got %v", ErrChecksumMismatch, err)
+	}
+	if n := count(t, relay.Path); n != 5 {
+		t.Errorf("expected the database to be left untouched with 5 rows, got %d", n)
+	}
+}
+
+func TestRestoreInUse(t *testing.T) {
+	relay, conn := newDatabase(t, "relay", 10)
+	conn.Close()
+	dir, _, err := Snapshot(ctx, []Database{relay}, t.TempDir(), "v1.2.3")
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	unlock, err := Lock([]Database{relay})
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	if _, err := Lock([]Database{relay}); !errors.Is(err, ErrDatabaseInUse) {
+		t.Fatalf("expected error %v, got %v", ErrDatabaseInUse, err)
+	}
+	if _, err := Restore(ctx, dir, []Database{relay}); !errors.Is(err, ErrDatabaseInUse) {
+		t.Fatalf("expected error %v, got %v", ErrDatabaseInUse, err)
+	}
+
+	unlock()
+	if _, err := Restore(ctx, dir, []Database{relay}); err != nil {
+		t.Fatalf("Restore() error = %v", err)
+	}
+}
+
+func TestRestoreRevert(t *testing.T) {
+	relay, relayConn := newDatabase(t, "relay", 10)
+	blossom, blossomConn := newDatabase(t, "blossom", 10)
+	dbs := []Database{relay, blossom}
+
+	dir, _, err := Snapshot(ctx, dbs, t.TempDir(), "v1.2.3")
+	if err != nil {
+		t.Fatalf("Snapshot() error = %v", err)
+	}
+
+	if _, err := relayConn.Exec("DELETE FROM items WHERE id < 5"); err != nil {
+		t.Fatalf("failed to delete rows: %v", err)
+	}
+	relayConn.Close()
+	blossomConn.Close()
+
+	// the blossom database can't be moved aside, so it fails after the relay database has been replaced
+	if err := os.MkdirAll(filepath.Join(blossom.Path+".orig", "file"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	if _, err := Restore(ctx, dir, dbs); err == nil {
+		t.Fatal("expected an error")
+	}
+	if n := count(t, relay.Path); n != 5 {
+		t.Errorf("expected the relay database to be reverted with 5 rows, got %d", n)
+	}
+	if n := count(t, blossom.Path); n != 10 {
+		t.Errorf("expected the blossom database to be left untouched with 10 rows, got %d", n)
+	}
+	if _, err := os.Stat(relay.Path + ".orig"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the original relay database to be moved back, got %v", err)
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		version int
+		err     bool
+	}{
+		{name: "versioned", schema: `CREATE TABLE schema_version (version INTEGER); INSERT INTO schema_version VALUES (1), (3);`, version: 3},
+		{name: "before versioned migrations", schema: `CREATE TABLE items (id INTEGER)`, version: 0},
+		{name: "invalid schema_version", schema: `CREATE TABLE schema_version (name TEXT)`, err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			conn, err := sql.Open("sqlite3", path)
+			if err != nil {
+				t.Fatalf("failed to open database: %v", err)
+			}
+			defer conn.Close()
+			if _, err := conn.Exec(test.schema); err != nil {
+				t.Fatalf("failed to create schema: %v", err)
+			}
+
+			version, err := schemaVersion(ctx, path)
+			if (err != nil) != test.err {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if version != test.version {
+				t.Fatalf("expected version %d, got %d", test.version, version)
+			}
+		})
+	}
+}
+
+func TestSnapshotInProgress(t *testing.T) {
+	relay, _ := newDatabase(t, "relay", 10)
+	dir := t.TempDir()
+
+	// the temporary directories of snapshots in progress, for every generation the snapshot could be named after
+	now := time.Now().UTC()
+	var tmps []string
+	for i := range 3 {
+		tmp := filepath.Join(dir, now.Add(time.Duration(i)*time.Second).Format(generationLayout)+".tmp")
+		if err := os.Mkdir(tmp, 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		tmps = append(tmps, tmp)
+	}
+
+	if _, _, err := Snapshot(ctx, []Database{relay}, dir, "v1.2.3"); !errors.Is(err, ErrSnapshotInProgress) {
+		t.Fatalf("expected error %v, got %v", ErrSnapshotInProgress, err)
+	}
+	for _, tmp := range tmps {
+		if _, err := os.Stat(tmp); err != nil {
+			t.Errorf("expected the directory of the other snapshot to be left untouched, got %v", err)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Resolve(dir); !errors.Is(err, ErrNoSnapshot) {
+		t.Fatalf("expected error %v, got %v", ErrNoSnapshot, err)
+	}
+
+	for _, name := range []string{"20260101T000000Z", "20260301T000000Z", "20260201T000000Z"} {
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name, ManifestFile), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// incomplete snapshots are ignored
+	if err := os.MkdirAll(filepath.Join(dir, "20260401T000000Z.tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "20260501T000000Z"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := Resolve(dir)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if expected := filepath.Join(dir, "20260301T000000Z"); latest != expected {
+		t.Errorf("expected %s, got %s", expected, latest)
+	}
+
+	snapshot, err := Resolve(filepath.Join(dir, "20260101T000000Z"))
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if expected := filepath.Join(dir, "20260101T000000Z"); snapshot != expected {
+		t.Errorf("expected %s, got %s", expected, snapshot)
+	}
+}
+
+type mockUploader struct {
+	files map[string][]byte
+}
+
+func (m *mockUploader) Upload(ctx context.Context, data io.Reader, path string, sha256 string) error {
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, data); err != nil {
+		return err
+	}
+	m.files[path] = buf.Bytes()
+	return nil
+}
+
+func (m *mockUploader) Delete(ctx context.Context, path string) error {
+	delete(m.files, path)
+	return nil
+}
+
+func TestSchedulerBackup(t *testing.T) {
+	relay, _ := newDatabase(t, "relay", 10)
+	config := NewConfig()
+	config.Dir = t.TempDir()
+	config.Generations = 2
+	config.BunnyPath = "backups"
+
+	uploader := &mockUploader{files: make(map[string][]byte)}
+	scheduler := NewScheduler(config, []Database{relay}, "v1.2.3", uploader)
+
+	var dirs []string
+	for range 3 {
+		dir, err := scheduler.Backup(ctx)
+		if err != nil {
+			t.Fatalf("Backup() error = %v", err)
+		}
+		dirs = append(dirs, filepath.Base(dir))
+		time.Sleep(time.Second) // generations are named after the second they were taken
+	}
+
+	generations, err := Generations(config.Dir)
+	if err != nil {
+		t.Fatalf("Generations() error = %v", err)
+	}
+	if !slices.Equal(generations, dirs[1:]) {
+		t.Fatalf("expected generations %v, got %v", dirs[1:], generations)
+	}
+
+	var remote []string
+	for path := range uploader.files {
+		remote = append(remote, path)
+	}
+	slices.Sort(remote)
+
+	expected := []string{
+		"backups/" + dirs[1] + "/manifest.json",
+		"backups/" + dirs[1] + "/relay.db.gz",
+		"backups/" + dirs[2] + "/manifest.json",
+		"backups/" + dirs[2] + "/relay.db.gz",
+	}
+	if !slices.Equal(remote, expected) {
+		t.Errorf("expected remote files %v, got %v", expected, remote)
+	}
+}
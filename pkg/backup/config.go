@@ -0,0 +1,71 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	// Dir is the directory where scheduled backups are written, one sub-directory per generation.
+	// Scheduled backups are disabled when empty, which is the default.
+	Dir string `env:"BACKUP_DIRECTORY_PATH"`
+
+	// Interval is the time between two scheduled backups. Default is 24 hours.
+	Interval time.Duration `env:"BACKUP_INTERVAL"`
+
+	// Generations is the number of scheduled backups to keep. Older ones are deleted. Default is 7.
+	Generations int `env:"BACKUP_GENERATIONS"`
+
+	// BunnyPath is the path on the Bunny storage zone where scheduled backups are also uploaded
+	// (e.g. "backups/relay"). Uploads are disabled when empty, which is the default.
+	BunnyPath string `env:"BACKUP_BUNNY_PATH"`
+}
+
+func NewConfig() Config {
+	return Config{
+		Interval:    24 * time.Hour,
+		Generations: 7,
+	}
+}
+
+// Enabled returns whether scheduled backups are enabled.
+func (c Config) Enabled() bool {
+	return c.Dir != ""
+}
+
+func (c Config) Validate() error {
+	if c.Interval < time.Minute {
+		return errors.New("interval must be at least 1 minute")
+	}
+	if c.Generations < 1 {
+		return errors.New("generations must be at least 1")
+	}
+	if strings.HasPrefix(c.BunnyPath, "/") || strings.HasSuffix(c.BunnyPath, "/") {
+		return errors.New("bunny path must not include a leading or trailing slash")
+	}
+	return nil
+}
+
+func (c Config) String() string {
+	dir := c.Dir
+	if dir == "" {
+		dir = "[disabled]"
+	}
+	bunnyPath := c.BunnyPath
+	if bunnyPath == "" {
+		bunnyPath = "[disabled]"
+	}
+
+	return fmt.Sprintf("Backup:\n"+
+		"\tDirectory Path: %s\n"+
+		"\tInterval: %s\n"+
+		"\tGenerations: %d\n"+
+		"\tBunny Path: %s\n",
+		dir,
+		c.Interval,
+		c.Generations,
+		bunnyPath,
+	)
+}
@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Lock acquires an exclusive lock on each database, until unlock is called or the process exits.
+// The server holds the locks while running, so that the databases can't be restored under it.
+// It returns [ErrDatabaseInUse] if any database is already locked, in which case no lock is held.
+//
+// The lock of a database is an advisory lock on the file with the ".lock" suffix next to it,
+// because the sqlite locks of a database in WAL mode don't reveal idle connections.
+func Lock(dbs []Database) (unlock func(), err error) {
+	var files []*os.File
+	unlock = func() {
+		for _, file := range files {
+			syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+			file.Close()
+		}
+	}
+
+	for _, db := range dbs {
+		if err := os.MkdirAll(filepath.Dir(db.Path), 0755); err != nil {
+			unlock()
+			return nil, fmt.Errorf("%s: failed to create directory: %w", db.Name, err)
+		}
+
+		file, err := os.OpenFile(db.Path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			unlock()
+			return nil, fmt.Errorf("%s: failed to open lock file: %w", db.Name, err)
+		}
+
+		err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			file.Close()
+			unlock()
+			return nil, fmt.Errorf("%s: %w", db.Name, ErrDatabaseInUse)
+		}
+		if err != nil {
+			file.Close()
+			unlock()
+			return nil, fmt.Errorf("%s: failed to lock: %w", db.Name, err)
+		}
+		files = append(files, file)
+	}
+	return unlock, nil
+}
@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// Uploader stores snapshots remotely. It's implemented by the bunny client.
+type Uploader interface {
+	Upload(ctx context.Context, data io.Reader, path string, sha256 string) error
+	Delete(ctx context.Context, path string) error
+}
+
+// Scheduler takes a snapshot of the databases at regular intervals, keeping only the latest generations.
+type Scheduler struct {
+	config   Config
+	dbs      []Database
+	version  string
+	uploader Uploader // nil if uploads are disabled
+}
+
+// NewScheduler returns a scheduler from the provided [Config], which is assumed to have been validated.
+// The uploader is only used if the config specifies a Bunny path, and can be nil otherwise.
+func NewScheduler(c Config, dbs []Database, version string, uploader Uploader) *Scheduler {
+	s := &Scheduler{
+		config:  c,
+		dbs:     dbs,
+		version: version,
+	}
+	if c.BunnyPath != "" {
+		s.uploader = uploader
+	}
+	return s
+}
+
+// Run takes a snapshot every interval until the context is cancelled.
+// Failed backups are logged and retried at the next interval.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			start := time.Now()
+			dir, err := s.Backup(ctx)
+			if err != nil {
+				slog.Error("backup: failed to take scheduled backup", "error", err)
+				continue
+			}
+			slog.Info("backup: scheduled backup completed", "path", dir, "took", time.Since(start))
+		}
+	}
+}
+
+// Backup takes a snapshot, uploads it if enabled, and deletes the generations exceeding the limit.
+// It returns the path of the snapshot.
+func (s *Scheduler) Backup(ctx context.Context) (string, error) {
+	dir, manifest, err := Snapshot(ctx, s.dbs, s.config.Dir, s.version)
+	if err != nil {
+		return "", err
+	}
+
+	if s.uploader != nil {
+		if err := s.upload(ctx, dir, manifest); err != nil {
+			return dir, err
+		}
+	}
+
+	if err := s.prune(ctx); err != nil {
+		return dir, err
+	}
+	return dir, nil
+}
+
+// upload uploads the snapshot files, then its manifest, so that a remote snapshot with
+// a manifest is always complete.
+func (s *Scheduler) upload(ctx context.Context, dir string, manifest Manifest) error {
+	generation := filepath.Base(dir)
+	for _, entry := range manifest.Databases {
+		file, err := os.Open(filepath.Join(dir, entry.File))
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", entry.File, err)
+		}
+
+		err = s.uploader.Upload(ctx, file, path.Join(s.config.BunnyPath, generation, entry.File), entry.CompressedSHA256)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", entry.File, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+	sum := sha256.Sum256(data)
+
+	err = s.uploader.Upload(ctx, bytes.NewReader(data), path.Join(s.config.BunnyPath, generation, ManifestFile), hex.EncodeToString(sum[:]))
+	if err != nil {
+		return fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	return nil
+}
+
+// prune deletes the oldest generations, locally and remotely, so that only the configured number is kept.
+func (s *Scheduler) prune(ctx context.Context) error {
+	generations, err := Generations(s.config.Dir)
+	if err != nil {
+		return err
+	}
+	if len(generations) <= s.config.Generations {
+		return nil
+	}
+
+	for _, generation := range generations[:len(generations)-s.config.Generations] {
+		dir := filepath.Join(s.config.Dir, generation)
+
+		if s.uploader != nil {
+			manifest, err := ReadManifest(dir)
+			if err != nil {
+				return err
+			}
+
+			for _, entry := range manifest.Databases {
+				if err := s.uploader.Delete(ctx, path.Join(s.config.BunnyPath, generation, entry.File)); err != nil {
+					return fmt.Errorf("failed to delete remote %s: %w", entry.File, err)
+				}
+			}
+			if err := s.uploader.Delete(ctx, path.Join(s.config.BunnyPath, generation, ManifestFile)); err != nil {
+				return fmt.Errorf("failed to delete remote manifest: %w", err)
+			}
+		}
+
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("failed to delete generation %s: %w", generation, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// The apk package is responsible for inspecting Android application packages.
+// It exposes an [Inspect] function that extracts the [Manifest] of an APK and verifies its signature,
+// without any Android tooling.
+package apk
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+const (
+	MimeType = "application/vnd.android.package-archive"
+
+	manifestPath = "AndroidManifest.xml"
+
+	// maxManifestSize is the maximum size of the binary AndroidManifest.xml, which is rarely above 100 KB.
+	maxManifestSize = 4 << 20
+)
+
+var (
+	ErrNotZip          = errors.New("not a zip archive")
+	ErrMissingManifest = errors.New("missing AndroidManifest.xml")
+	ErrInvalidManifest = errors.New("invalid AndroidManifest.xml")
+)
+
+// Manifest holds the information about an APK extracted from its AndroidManifest.xml, file list and signature.
+type Manifest struct {
+	Package     string
+	VersionCode int64
+	VersionName string // empty if it's a reference to a resource
+	MinSDK      int    // defaults to 1 if not declared
+	TargetSDK   int    // defaults to MinSDK if not declared
+	Permissions []string
+	ABIs        []string // native ABIs of the libraries under lib/, empty if the APK has no native code
+
+	Scheme       int      // the signature scheme that was verified, one of SchemeV1, SchemeV2 or SchemeV3
+	Certificates []string // hex-encoded SHA-256 of the certificate of each signer
+}
+
+// Inspect extracts the [Manifest] of the APK of the given size, and verifies its signature.
+// It returns an error wrapping [ErrUnsigned] or [ErrInvalidSignature] if the signature can't be verified.
+func Inspect(r io.ReaderAt, size int64) (Manifest, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%w: %w", ErrNotZip, err)
+	}
+
+	var manifest *zip.File
+	var abis []string
+	for _, file := range archive.File {
+		if file.Name == manifestPath {
+			manifest = file
+		}
+		if abi, ok := nativeABI(file.Name); ok && !slices.Contains(abis, abi) {
+			abis = append(abis, abi)
+		}
+	}
+	if manifest == nil {
+		return Manifest{}, ErrMissingManifest
+	}
+	if manifest.UncompressedSize64 > maxManifestSize {
+		return Manifest{}, fmt.Errorf("%w: larger than %d bytes", ErrInvalidManifest, maxManifestSize)
+	}
+
+	data, err := readFile(manifest)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read %s: %w", manifestPath, err)
+	}
+
+	m, err := parseManifest(data)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("%w: %w", ErrInvalidManifest, err)
+	}
+
+	slices.Sort(abis)
+	m.ABIs = abis
+
+	m.Scheme, m.Certificates, err = verifySignature(r, size, archive)
+	if err != nil {
+		return Manifest{}, err
+	}
+	return m, nil
+}
+
+// nativeABI returns the ABI of the native library at the path, e.g. "arm64-v8a" for "lib/arm64-v8a/libfoo.so".
+func nativeABI(path string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, "lib/")
+	if !ok {
+		return "", false
+	}
+	abi, name, ok := strings.Cut(rest, "/")
+	if !ok || abi == "" || !strings.HasSuffix(name, ".so") {
+		return "", false
+	}
+	return abi, true
+}
+
+func readFile(file *zip.File) ([]byte, error) {
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxManifestSize))
+}
+
+// Platform returns the NIP-82 platform identifier of the Android ABI, e.g. "android-arm64-v8a".
+func Platform(abi string) string {
+	return "android-" + abi
+}
+
+// Supports returns whether the APK can run on the NIP-82 platform identifier.
+// An APK without native code runs on every Android platform.
+func (m Manifest) Supports(platform string) bool {
+	abi, ok := strings.CutPrefix(platform, "android-")
+	if !ok {
+		return false
+	}
+	return len(m.ABIs) == 0 || slices.Contains(m.ABIs, abi)
+}
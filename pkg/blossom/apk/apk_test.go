@@ -0,0 +1,297 @@
+package apk
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestInspect(t *testing.T) {
+	manifest := []element{
+		{name: "manifest", attrs: []attr{
+			{name: "versionCode", resID: 0x0101021b, integer: 42, isInt: true},
+			{name: "versionName", resID: 0x0101021c, value: "1.4.2"},
+			{name: "package", value: "com.example.app"},
+		}},
+		{name: "uses-sdk", attrs: []attr{
+			{name: "minSdkVersion", resID: 0x0101020c, integer: 24, isInt: true},
+			{name: "targetSdkVersion", resID: 0x01010270, integer: 34, isInt: true},
+		}},
+		{name: "uses-permission", attrs: []attr{
+			{name: "name", resID: 0x01010003, value: "android.permission.INTERNET"},
+		}},
+		{name: "uses-permission-sdk-23", attrs: []attr{
+			{name: "name", resID: 0x01010003, value: "android.permission.CAMERA"},
+		}},
+	}
+
+	signer := newTestSigner(t, "ecdsa")
+	tests := []struct {
+		name     string
+		files    map[string][]byte
+		expected Manifest
+		err      error
+	}{
+		{
+			name: "utf16 with native code",
+			files: map[string][]byte{
+				"AndroidManifest.xml":           encodeManifest(manifest, false),
+				"lib/arm64-v8a/libapp.so":       nil,
+				"lib/x86_64/libapp.so":          nil,
+				"lib/arm64-v8a/libflutter.so":   nil,
+				"lib/arm64-v8a/notes.txt":       nil,
+				"assets/lib/armeabi/libfake.so": nil,
+			},
+			expected: Manifest{
+				Package:     "com.example.app",
+				VersionCode: 42,
+				VersionName: "1.4.2",
+				MinSDK:      24,
+				TargetSDK:   34,
+				Permissions: []string{"android.permission.INTERNET", "android.permission.CAMERA"},
+				ABIs:        []string{"arm64-v8a", "x86_64"},
+			},
+		},
+		{
+			name:  "utf8 without native code",
+			files: map[string][]byte{"AndroidManifest.xml": encodeManifest(manifest, true)},
+			expected: Manifest{
+				Package:     "com.example.app",
+				VersionCode: 42,
+				VersionName: "1.4.2",
+				MinSDK:      24,
+				TargetSDK:   34,
+				Permissions: []string{"android.permission.INTERNET", "android.permission.CAMERA"},
+			},
+		},
+		{
+			name: "obfuscated names and no uses-sdk",
+			files: map[string][]byte{"AndroidManifest.xml": encodeManifest([]element{
+				{name: "manifest", attrs: []attr{
+					{name: "", resID: 0x0101021b, value: "7"},
+					{name: "package", value: "com.example.tiny"},
+				}},
+			}, false)},
+			expected: Manifest{Package: "com.example.tiny", VersionCode: 7, MinSDK: 1, TargetSDK: 1},
+		},
+		{
+			name:  "missing manifest",
+			files: map[string][]byte{"classes.dex": []byte("dex")},
+			err:   ErrMissingManifest,
+		},
+		{
+			name:  "text manifest",
+			files: map[string][]byte{"AndroidManifest.xml": []byte(`<manifest package="com.example.app"/>`)},
+			err:   ErrInvalidManifest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := signV2(t, zipFiles(t, test.files), SchemeV2, signer)
+			if test.err == nil {
+				test.expected.Scheme = SchemeV2
+				test.expected.Certificates = []string{signer.hash}
+			}
+
+			m, err := Inspect(bytes.NewReader(data), int64(len(data)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if !reflect.DeepEqual(m, test.expected) {
+				t.Fatalf("expected manifest %+v, got %+v", test.expected, m)
+			}
+		})
+	}
+}
+
+func TestInspectNotZip(t *testing.T) {
+	data := []byte("definitely not a zip archive")
+	if _, err := Inspect(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrNotZip) {
+		t.Fatalf("expected error %v, got %v", ErrNotZip, err)
+	}
+}
+
+func TestInspectUnsigned(t *testing.T) {
+	data := zipFiles(t, map[string][]byte{"AndroidManifest.xml": encodeManifest([]element{
+		{name: "manifest", attrs: []attr{{name: "package", value: "com.example.app"}}},
+	}, false)})
+
+	if _, err := Inspect(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrUnsigned) {
+		t.Fatalf("expected error %v, got %v", ErrUnsigned, err)
+	}
+}
+
+func TestSupports(t *testing.T) {
+	tests := []struct {
+		abis     []string
+		platform string
+		expected bool
+	}{
+		{abis: nil, platform: "android-arm64-v8a", expected: true},
+		{abis: nil, platform: "linux-x86_64", expected: false},
+		{abis: []string{"arm64-v8a"}, platform: "android-arm64-v8a", expected: true},
+		{abis: []string{"arm64-v8a"}, platform: "android-x86_64", expected: false},
+	}
+
+	for _, test := range tests {
+		if got := (Manifest{ABIs: test.abis}).Supports(test.platform); got != test.expected {
+			t.Errorf("ABIs %v, platform %s: expected %v, got %v", test.abis, test.platform, test.expected, got)
+		}
+	}
+}
+
+func zipFiles(t *testing.T, files map[string][]byte) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", name, err)
+		}
+		f.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// element and attr describe a binary XML document to encode, like the ones produced by aapt2.
+type element struct {
+	name  string
+	attrs []attr
+}
+
+type attr struct {
+	name    string
+	resID   uint32 // resource id of android attributes, 0 otherwise
+	value   string
+	integer int32
+	isInt   bool
+}
+
+// encodeManifest encodes the elements in the Android binary XML format.
+// The names of the android attributes come first in the string pool, to line up with the resource map.
+func encodeManifest(elements []element, utf8 bool) []byte {
+	var strings []string
+	var resourceIDs []uint32
+	index := func(s string) uint32 {
+		for i, existing := range strings {
+			if existing == s {
+				return uint32(i)
+			}
+		}
+		strings = append(strings, s)
+		return uint32(len(strings) - 1)
+	}
+
+	for _, e := range elements {
+		for _, a := range e.attrs {
+			if a.resID != 0 {
+				strings = append(strings, a.name) // obfuscated names can repeat
+				resourceIDs = append(resourceIDs, a.resID)
+			}
+		}
+	}
+
+	type encodedAttr struct{ name, raw, dataType, data uint32 }
+	var body []byte
+	resIndex := 0
+	for _, e := range elements {
+		var attrs []encodedAttr
+		for _, a := range e.attrs {
+			encoded := encodedAttr{raw: noIndex}
+			if a.resID != 0 {
+				encoded.name = uint32(resIndex)
+				resIndex++
+			} else {
+				encoded.name = index(a.name)
+			}
+
+			if a.isInt {
+				encoded.dataType, encoded.data = typeIntDec, uint32(a.integer)
+			} else {
+				encoded.raw = index(a.value)
+				encoded.dataType, encoded.data = typeString, encoded.raw
+			}
+			attrs = append(attrs, encoded)
+		}
+
+		element := le32(noIndex, noIndex) // line number and comment are not used
+		element = append(element, le32(noIndex, index(e.name))...)
+		element = append(element, le16(20, 20, uint16(len(attrs)), 0, 0, 0)...)
+		for _, a := range attrs {
+			element = append(element, le32(noIndex, a.name, a.raw)...)
+			element = append(element, le16(8)...)
+			element = append(element, 0, byte(a.dataType))
+			element = append(element, le32(a.data)...)
+		}
+		body = append(body, chunk(chunkStartElement, 16, element)...)
+	}
+
+	var resourceMap []byte
+	for _, id := range resourceIDs {
+		resourceMap = append(resourceMap, le32(id)...)
+	}
+
+	doc := append(stringPool(strings, utf8), chunk(chunkResourceMap, 8, resourceMap)...)
+	doc = append(doc, body...)
+	return chunk(chunkXML, 8, doc)
+}
+
+func stringPool(strings []string, utf8 bool) []byte {
+	var offsets, data []byte
+	for _, s := range strings {
+		offsets = append(offsets, le32(uint32(len(data)))...)
+		if utf8 {
+			data = append(data, byte(len(s)), byte(len(s)))
+			data = append(data, s...)
+			data = append(data, 0)
+		} else {
+			units := utf16.Encode([]rune(s))
+			data = append(data, le16(uint16(len(units)))...)
+			data = append(data, le16(units...)...)
+			data = append(data, 0, 0)
+		}
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+
+	var flags uint32
+	if utf8 {
+		flags = stringPoolUTF8
+	}
+	header := le32(uint32(len(strings)), 0, flags, uint32(28+len(offsets)), 0)
+	return chunk(chunkStringPool, 28, append(append(header, offsets...), data...))
+}
+
+// chunk prefixes the body with the chunk header, where the header size includes the
+// header fields at the start of the body.
+func chunk(kind uint16, headerSize uint16, body []byte) []byte {
+	out := le16(kind, headerSize)
+	out = append(out, le32(uint32(8+len(body)))...)
+	return append(out, body...)
+}
+
+func le16(values ...uint16) []byte {
+	out := make([]byte, 0, 2*len(values))
+	for _, v := range values {
+		out = binary.LittleEndian.AppendUint16(out, v)
+	}
+	return out
+}
+
+func le32(values ...uint32) []byte {
+	out := make([]byte, 0, 4*len(values))
+	for _, v := range values {
+		out = binary.LittleEndian.AppendUint32(out, v)
+	}
+	return out
+}
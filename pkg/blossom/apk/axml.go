@@ -0,0 +1,358 @@
+package apk
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode/utf16"
+)
+
+// The AndroidManifest.xml of an APK is compiled to the Android binary XML format: a sequence of chunks,
+// each starting with its type, header size and total size. The ones needed to read the manifest are:
+//   - the string pool, holding every string of the document (element names, attribute names and values)
+//   - the resource map, holding the resource ids of the attribute names, which can be obfuscated
+//   - the start elements, holding the name and attributes of each element
+//
+// See frameworks/base/libs/androidfw/include/androidfw/ResourceTypes.h in AOSP.
+
+const (
+	chunkStringPool   = 0x0001
+	chunkXML          = 0x0003
+	chunkResourceMap  = 0x0180
+	chunkStartElement = 0x0102
+
+	stringPoolUTF8 = 1 << 8
+
+	typeReference = 0x01
+	typeString    = 0x03
+	typeIntDec    = 0x10
+	typeIntHex    = 0x11
+
+	noIndex = 0xFFFFFFFF
+)
+
+// attributeIDs are the resource ids of the android attributes read from the manifest.
+var attributeIDs = map[uint32]string{
+	0x01010003: "name",
+	0x0101020c: "minSdkVersion",
+	0x0101021b: "versionCode",
+	0x0101021c: "versionName",
+	0x01010270: "targetSdkVersion",
+}
+
+var le = binary.LittleEndian
+
+// attribute is an attribute of an element, with its value as a string, and as an integer if it's one.
+type attribute struct {
+	name    string
+	value   string
+	integer int64
+	isInt   bool
+	isRef   bool
+}
+
+// document is the binary XML being parsed.
+type document struct {
+	strings     []string
+	resourceIDs []uint32
+
+	manifest     Manifest
+	sawManifest  bool
+	declaredSDK  bool
+	targetSDKSet bool
+}
+
+// parseManifest parses the binary AndroidManifest.xml.
+func parseManifest(data []byte) (Manifest, error) {
+	if len(data) < 8 || le.Uint16(data) != chunkXML {
+		return Manifest{}, errors.New("not a binary XML document")
+	}
+
+	doc := &document{}
+	offset := int(le.Uint16(data[2:]))
+	for offset+8 <= len(data) {
+		kind := le.Uint16(data[offset:])
+		size := int(le.Uint32(data[offset+4:]))
+		if size < 8 || offset+size > len(data) {
+			return Manifest{}, fmt.Errorf("chunk at offset %d has invalid size %d", offset, size)
+		}
+
+		chunk := data[offset : offset+size]
+		switch kind {
+		case chunkStringPool:
+			strings, err := parseStringPool(chunk)
+			if err != nil {
+				return Manifest{}, fmt.Errorf("failed to parse string pool: %w", err)
+			}
+			doc.strings = strings
+
+		case chunkResourceMap:
+			header := int(le.Uint16(chunk[2:]))
+			for i := header; i+4 <= len(chunk); i += 4 {
+				doc.resourceIDs = append(doc.resourceIDs, le.Uint32(chunk[i:]))
+			}
+
+		case chunkStartElement:
+			if err := doc.startElement(chunk); err != nil {
+				return Manifest{}, fmt.Errorf("failed to parse element at offset %d: %w", offset, err)
+			}
+		}
+		offset += size
+	}
+
+	if !doc.sawManifest {
+		return Manifest{}, errors.New("missing manifest element")
+	}
+	if doc.manifest.Package == "" {
+		return Manifest{}, errors.New("missing package attribute")
+	}
+
+	m := doc.manifest
+	if !doc.declaredSDK || m.MinSDK == 0 {
+		m.MinSDK = 1
+	}
+	if !doc.targetSDKSet {
+		m.TargetSDK = m.MinSDK
+	}
+	return m, nil
+}
+
+// startElement reads the attributes of the manifest elements into the manifest.
+func (d *document) startElement(chunk []byte) error {
+	header := int(le.Uint16(chunk[2:]))
+	if header < 16 || len(chunk) < header+20 {
+		return errors.New("element is too short")
+	}
+
+	ext := chunk[header:]
+	name, err := d.string(le.Uint32(ext[4:]))
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "manifest", "uses-sdk", "uses-permission", "uses-permission-sdk-23":
+	default:
+		return nil
+	}
+
+	attributes, err := d.attributes(chunk, header)
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "manifest":
+		d.sawManifest = true
+		for _, attr := range attributes {
+			switch attr.name {
+			case "package":
+				d.manifest.Package = attr.value
+			case "versionCode":
+				code, err := attr.asInt()
+				if err != nil {
+					return fmt.Errorf("invalid versionCode: %w", err)
+				}
+				d.manifest.VersionCode = code
+			case "versionName":
+				if !attr.isRef {
+					d.manifest.VersionName = attr.value
+				}
+			}
+		}
+
+	case "uses-sdk":
+		d.declaredSDK = true
+		for _, attr := range attributes {
+			// preview SDKs are declared with a codename instead of a number, and are ignored
+			switch attr.name {
+			case "minSdkVersion":
+				if sdk, err := attr.asInt(); err == nil {
+					d.manifest.MinSDK = int(sdk)
+				}
+			case "targetSdkVersion":
+				if sdk, err := attr.asInt(); err == nil {
+					d.manifest.TargetSDK = int(sdk)
+					d.targetSDKSet = true
+				}
+			}
+		}
+
+	case "uses-permission", "uses-permission-sdk-23":
+		for _, attr := range attributes {
+			if attr.name == "name" && attr.value != "" {
+				d.manifest.Permissions = append(d.manifest.Permissions, attr.value)
+			}
+		}
+	}
+	return nil
+}
+
+// attributes parses the attributes of the start element chunk.
+func (d *document) attributes(chunk []byte, header int) ([]attribute, error) {
+	ext := chunk[header:]
+	start := int(le.Uint16(ext[8:]))
+	size := int(le.Uint16(ext[10:]))
+	count := int(le.Uint16(ext[12:]))
+	if size < 20 {
+		return nil, fmt.Errorf("invalid attribute size %d", size)
+	}
+	if header+start+count*size > len(chunk) {
+		return nil, errors.New("attributes exceed the element")
+	}
+
+	attributes := make([]attribute, 0, count)
+	for i := range count {
+		raw := chunk[header+start+i*size:]
+		name, err := d.attributeName(le.Uint32(raw[4:]))
+		if err != nil {
+			return nil, err
+		}
+
+		attr := attribute{name: name}
+		rawValue := le.Uint32(raw[8:])
+		dataType := raw[15]
+		data := le.Uint32(raw[16:])
+
+		switch dataType {
+		case typeString:
+			if attr.value, err = d.string(data); err != nil {
+				return nil, err
+			}
+		case typeIntDec, typeIntHex:
+			attr.integer, attr.isInt = int64(int32(data)), true
+			attr.value = strconv.FormatInt(attr.integer, 10)
+		case typeReference:
+			attr.isRef = true
+		default:
+			if rawValue != noIndex {
+				if attr.value, err = d.string(rawValue); err != nil {
+					return nil, err
+				}
+			}
+		}
+		attributes = append(attributes, attr)
+	}
+	return attributes, nil
+}
+
+// attributeName returns the name of the attribute, using the resource map for android attributes
+// because their names in the string pool can be obfuscated.
+func (d *document) attributeName(index uint32) (string, error) {
+	if int(index) < len(d.resourceIDs) {
+		if name, ok := attributeIDs[d.resourceIDs[index]]; ok {
+			return name, nil
+		}
+	}
+	return d.string(index)
+}
+
+func (d *document) string(index uint32) (string, error) {
+	if index == noIndex {
+		return "", nil
+	}
+	if int(index) >= len(d.strings) {
+		return "", fmt.Errorf("string index %d out of range", index)
+	}
+	return d.strings[index], nil
+}
+
+// asInt returns the attribute as an integer, parsing it if it's stored as a string.
+func (a attribute) asInt() (int64, error) {
+	if a.isInt {
+		return a.integer, nil
+	}
+	return strconv.ParseInt(a.value, 10, 64)
+}
+
+// parseStringPool parses the strings of the string pool chunk.
+func parseStringPool(chunk []byte) ([]string, error) {
+	if len(chunk) < 28 {
+		return nil, errors.New("string pool is too short")
+	}
+
+	header := int(le.Uint16(chunk[2:]))
+	count := int(le.Uint32(chunk[8:]))
+	flags := le.Uint32(chunk[16:])
+	start := int(le.Uint32(chunk[20:]))
+	if count > len(chunk)/4 || header+count*4 > len(chunk) || start > len(chunk) {
+		return nil, errors.New("string pool exceeds the chunk")
+	}
+
+	strings := make([]string, count)
+	for i := range count {
+		offset := start + int(le.Uint32(chunk[header+i*4:]))
+		if offset >= len(chunk) {
+			return nil, fmt.Errorf("string %d exceeds the chunk", i)
+		}
+
+		var err error
+		if flags&stringPoolUTF8 != 0 {
+			strings[i], err = decodeUTF8(chunk[offset:])
+		} else {
+			strings[i], err = decodeUTF16(chunk[offset:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("string %d: %w", i, err)
+		}
+	}
+	return strings, nil
+}
+
+// decodeUTF8 decodes a string of an UTF-8 pool, prefixed by its length in characters and then in bytes.
+func decodeUTF8(data []byte) (string, error) {
+	_, n, err := utf8Length(data)
+	if err != nil {
+		return "", err
+	}
+	length, m, err := utf8Length(data[n:])
+	if err != nil {
+		return "", err
+	}
+
+	start := n + m
+	if start+length > len(data) {
+		return "", errors.New("string exceeds the pool")
+	}
+	return string(data[start : start+length]), nil
+}
+
+// utf8Length decodes a length of one byte, or two if the high bit of the first is set.
+func utf8Length(data []byte) (length, size int, err error) {
+	if len(data) < 1 {
+		return 0, 0, errors.New("string exceeds the pool")
+	}
+	if data[0]&0x80 == 0 {
+		return int(data[0]), 1, nil
+	}
+	if len(data) < 2 {
+		return 0, 0, errors.New("string exceeds the pool")
+	}
+	return int(data[0]&0x7F)<<8 | int(data[1]), 2, nil
+}
+
+// decodeUTF16 decodes a string of an UTF-16 pool, prefixed by its length in code units,
+// on two units if the high bit of the first is set.
+func decodeUTF16(data []byte) (string, error) {
+	if len(data) < 2 {
+		return "", errors.New("string exceeds the pool")
+	}
+
+	length, start := int(le.Uint16(data)), 2
+	if length&0x8000 != 0 {
+		if len(data) < 4 {
+			return "", errors.New("string exceeds the pool")
+		}
+		length, start = (length&0x7FFF)<<16|int(le.Uint16(data[2:])), 4
+	}
+	if start+2*length > len(data) {
+		return "", errors.New("string exceeds the pool")
+	}
+
+	units := make([]uint16, length)
+	for i := range units {
+		units[i] = le.Uint16(data[start+2*i:])
+	}
+	return string(utf16.Decode(units)), nil
+}
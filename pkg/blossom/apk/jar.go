@@ -0,0 +1,450 @@
+package apk
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"slices"
+	"strings"
+)
+
+// The v1 scheme is JAR signing:
+//   - META-INF/MANIFEST.MF lists the digest of every entry of the archive
+//   - each META-INF/<NAME>.SF lists the digest of the manifest, or of each of its sections
+//   - each META-INF/<NAME>.RSA, .DSA or .EC is a PKCS #7 signature of the .SF file, with the signer certificate
+//
+// See https://docs.oracle.com/javase/8/docs/technotes/guides/jar/jar.html#Signed_JAR_File
+
+const (
+	jarManifestPath = "META-INF/MANIFEST.MF"
+
+	// maxMetaFileSize is the maximum size of the manifest and signature files,
+	// which grow with the number of entries of the archive.
+	maxMetaFileSize = 32 << 20
+)
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	pkcs7Digests = map[string]crypto.Hash{
+		"1.3.14.3.2.26":          crypto.SHA1,
+		"2.16.840.1.101.3.4.2.1": crypto.SHA256,
+		"2.16.840.1.101.3.4.2.2": crypto.SHA384,
+		"2.16.840.1.101.3.4.2.3": crypto.SHA512,
+	}
+)
+
+// jarDigests are the digest attributes of JAR manifests, in order of preference.
+var jarDigests = []struct {
+	name string
+	hash crypto.Hash
+}{
+	{name: "SHA-512", hash: crypto.SHA512},
+	{name: "SHA-384", hash: crypto.SHA384},
+	{name: "SHA-256", hash: crypto.SHA256},
+	{name: "SHA1", hash: crypto.SHA1},
+	{name: "SHA-1", hash: crypto.SHA1},
+}
+
+// verifyJAR verifies the v1 signature of the APK, returning the hex-encoded SHA-256 of the certificate of each signer.
+func verifyJAR(archive *zip.Reader) ([]string, error) {
+	files := make(map[string]*zip.File, len(archive.File))
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	manifestFile, ok := files[jarManifestPath]
+	if !ok {
+		return nil, ErrUnsigned
+	}
+	manifestData, err := readMetaFile(manifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: v1: %w", ErrInvalidSignature, err)
+	}
+	manifest := parseJARManifest(manifestData)
+
+	var certificates []string
+	for _, file := range archive.File {
+		base, ok := strings.CutSuffix(file.Name, ".SF")
+		if !ok || !strings.HasPrefix(base, "META-INF/") || strings.Contains(base[len("META-INF/"):], "/") {
+			continue
+		}
+
+		certificate, err := verifySignatureFile(files, file, base, manifestData, manifest)
+		if err != nil {
+			return nil, fmt.Errorf("%w: v1: %s: %w", ErrInvalidSignature, file.Name, err)
+		}
+		if !slices.Contains(certificates, certificate) {
+			certificates = append(certificates, certificate)
+		}
+	}
+	if len(certificates) == 0 {
+		return nil, ErrUnsigned
+	}
+
+	if err := verifyEntries(archive, manifest); err != nil {
+		return nil, fmt.Errorf("%w: v1: %w", ErrInvalidSignature, err)
+	}
+	return certificates, nil
+}
+
+// verifySignatureFile verifies the .SF file against its signature block and the manifest,
+// returning the hex-encoded SHA-256 of the signer certificate.
+func verifySignatureFile(files map[string]*zip.File, file *zip.File, base string, manifestData []byte, manifest jarManifest) (string, error) {
+	var block *zip.File
+	for _, ext := range []string{".RSA", ".EC", ".DSA"} {
+		if b, ok := files[base+ext]; ok {
+			block = b
+			break
+		}
+	}
+	if block == nil {
+		return "", errors.New("missing signature block")
+	}
+
+	sf, err := readMetaFile(file)
+	if err != nil {
+		return "", err
+	}
+	signature, err := readMetaFile(block)
+	if err != nil {
+		return "", err
+	}
+
+	certificate, err := verifyPKCS7(signature, sf)
+	if err != nil {
+		return "", err
+	}
+	if err := verifyManifestDigests(parseJARManifest(sf), manifestData, manifest); err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(certificate.Raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// verifyManifestDigests verifies the digests in the .SF file: the digest of the whole manifest if present and matching,
+// otherwise the digest of each of its sections.
+func verifyManifestDigests(sf jarManifest, manifestData []byte, manifest jarManifest) error {
+	for _, digest := range jarDigests {
+		if expected, ok := sf.main[digest.name+"-Digest-Manifest"]; ok {
+			if digestMatches(digest.hash, bytes.NewReader(manifestData), expected) {
+				return nil
+			}
+			break
+		}
+	}
+
+	if len(sf.entries) == 0 {
+		return errors.New("manifest digest doesn't match")
+	}
+	for name, entry := range sf.entries {
+		section, ok := manifest.entries[name]
+		if !ok {
+			return fmt.Errorf("entry %q is not in the manifest", name)
+		}
+
+		hash, expected, ok := entry.digest()
+		if !ok {
+			return fmt.Errorf("entry %q has no supported digest", name)
+		}
+		if !digestMatches(hash, bytes.NewReader(section.raw), expected) {
+			return fmt.Errorf("manifest section of %q doesn't match", name)
+		}
+	}
+	return nil
+}
+
+// verifyEntries verifies that every entry of the archive outside META-INF is in the manifest with a matching digest.
+func verifyEntries(archive *zip.Reader, manifest jarManifest) error {
+	for _, file := range archive.File {
+		if strings.HasSuffix(file.Name, "/") || strings.HasPrefix(file.Name, "META-INF/") {
+			continue
+		}
+
+		entry, ok := manifest.entries[file.Name]
+		if !ok {
+			return fmt.Errorf("entry %q is not signed", file.Name)
+		}
+		hash, expected, ok := entry.digest()
+		if !ok {
+			return fmt.Errorf("entry %q has no supported digest", file.Name)
+		}
+
+		rc, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %q: %w", file.Name, err)
+		}
+		matches := digestMatches(hash, rc, expected)
+		rc.Close()
+		if !matches {
+			return fmt.Errorf("entry %q doesn't match its digest", file.Name)
+		}
+	}
+	return nil
+}
+
+// digestMatches returns whether the digest of the data is the base64-encoded expected one.
+func digestMatches(hash crypto.Hash, data io.Reader, expected string) bool {
+	want, err := base64.StdEncoding.DecodeString(expected)
+	if err != nil {
+		return false
+	}
+
+	h := hash.New()
+	if _, err := io.Copy(h, data); err != nil {
+		return false
+	}
+	return bytes.Equal(h.Sum(nil), want)
+}
+
+func readMetaFile(file *zip.File) ([]byte, error) {
+	if file.UncompressedSize64 > maxMetaFileSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", file.Name, maxMetaFileSize)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxMetaFileSize))
+}
+
+// jarManifest is a parsed manifest or .SF file: the attributes of the main section,
+// and the sections of each entry by name with their raw bytes.
+type jarManifest struct {
+	main    map[string]string
+	entries map[string]jarSection
+}
+
+type jarSection struct {
+	attributes map[string]string
+	raw        []byte // the bytes of the section, including the empty line that ends it
+}
+
+// digest returns the preferred digest of the section, with its hash.
+func (s jarSection) digest() (crypto.Hash, string, bool) {
+	for _, digest := range jarDigests {
+		if value, ok := s.attributes[digest.name+"-Digest"]; ok {
+			return digest.hash, value, true
+		}
+	}
+	return 0, "", false
+}
+
+// parseJARManifest parses the sections of a manifest, which are separated by empty lines.
+// Lines longer than 72 bytes continue on the next lines, which start with a space.
+func parseJARManifest(data []byte) jarManifest {
+	m := jarManifest{main: map[string]string{}, entries: map[string]jarSection{}}
+	var lines []string
+	start, first := 0, true
+
+	flush := func(end int) {
+		if len(lines) == 0 {
+			return
+		}
+		attributes := parseAttributes(lines)
+		if first {
+			m.main, first = attributes, false
+		} else if name := attributes["Name"]; name != "" {
+			m.entries[name] = jarSection{attributes: attributes, raw: data[start:end]}
+		}
+		lines = nil
+	}
+
+	for pos := 0; pos < len(data); {
+		end, next := lineEnd(data, pos)
+		if end == pos {
+			flush(next)
+			start = next
+		} else {
+			lines = append(lines, string(data[pos:end]))
+		}
+		pos = next
+	}
+	flush(len(data))
+	return m
+}
+
+// lineEnd returns the end of the line starting at pos, and the start of the next one.
+func lineEnd(data []byte, pos int) (end, next int) {
+	i := bytes.IndexAny(data[pos:], "\r\n")
+	if i < 0 {
+		return len(data), len(data)
+	}
+
+	end = pos + i
+	if data[end] == '\r' && end+1 < len(data) && data[end+1] == '\n' {
+		return end, end + 2
+	}
+	return end, end + 1
+}
+
+func parseAttributes(lines []string) map[string]string {
+	var joined []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, " ") && len(joined) > 0 {
+			joined[len(joined)-1] += line[1:]
+			continue
+		}
+		joined = append(joined, line)
+	}
+
+	attributes := make(map[string]string, len(joined))
+	for _, line := range joined {
+		if key, value, ok := strings.Cut(line, ": "); ok {
+			attributes[key] = value
+		}
+	}
+	return attributes
+}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerial           pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer asn1.RawValue
+	Serial *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// verifyPKCS7 verifies the detached PKCS #7 signature of the content, returning the certificate of the signer.
+func verifyPKCS7(signature, content []byte) (*x509.Certificate, error) {
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(signature, &info); err != nil {
+		return nil, fmt.Errorf("invalid signature block: %w", err)
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("signature block is not signed data")
+	}
+
+	var data pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &data); err != nil {
+		return nil, fmt.Errorf("invalid signed data: %w", err)
+	}
+	if len(data.SignerInfos) != 1 {
+		return nil, fmt.Errorf("expected one signer, got %d", len(data.SignerInfos))
+	}
+	signer := data.SignerInfos[0]
+
+	certificates, err := x509.ParseCertificates(data.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificates: %w", err)
+	}
+
+	var certificate *x509.Certificate
+	for _, c := range certificates {
+		if bytes.Equal(c.RawIssuer, signer.IssuerAndSerial.Issuer.FullBytes) && c.SerialNumber.Cmp(signer.IssuerAndSerial.Serial) == 0 {
+			certificate = c
+			break
+		}
+	}
+	if certificate == nil {
+		return nil, errors.New("missing signer certificate")
+	}
+
+	hash, ok := pkcs7Digests[signer.DigestAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, fmt.Errorf("unsupported digest algorithm %s", signer.DigestAlgorithm.Algorithm)
+	}
+
+	h := hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+
+	// With authenticated attributes, the signature covers them instead of the content,
+	// and they include the digest of the content.
+	signed := content
+	if len(signer.AuthenticatedAttributes.FullBytes) > 0 {
+		if err := checkMessageDigest(signer.AuthenticatedAttributes, digest); err != nil {
+			return nil, err
+		}
+
+		signed = slices.Clone(signer.AuthenticatedAttributes.FullBytes)
+		signed[0] = 0x31 // the attributes are signed as a SET, not with their implicit [0] tag
+	}
+
+	h = hash.New()
+	h.Write(signed)
+	hashed := h.Sum(nil)
+
+	switch key := certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, hash, hashed, signer.EncryptedDigest); err != nil {
+			return nil, fmt.Errorf("signature verification failed: %w", err)
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hashed, signer.EncryptedDigest) {
+			return nil, errors.New("signature verification failed")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+	return certificate, nil
+}
+
+// checkMessageDigest checks that the message digest in the authenticated attributes is the digest of the content.
+func checkMessageDigest(raw asn1.RawValue, digest []byte) error {
+	set := slices.Clone(raw.FullBytes)
+	set[0] = 0x31
+
+	var attributes []pkcs7Attribute
+	if _, err := asn1.UnmarshalWithParams(set, &attributes, "set"); err != nil {
+		return fmt.Errorf("invalid authenticated attributes: %w", err)
+	}
+
+	for _, attribute := range attributes {
+		if !attribute.Type.Equal(oidMessageDigest) {
+			continue
+		}
+
+		var value []byte
+		if _, err := asn1.Unmarshal(attribute.Values.Bytes, &value); err != nil {
+			return fmt.Errorf("invalid message digest: %w", err)
+		}
+		if !bytes.Equal(value, digest) {
+			return errors.New("message digest doesn't match the signature file")
+		}
+		return nil
+	}
+	return errors.New("missing message digest")
+}
@@ -0,0 +1,466 @@
+package apk
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// APKs are signed with the APK Signature Scheme v2 or v3 in the APK Signing Block, located right before
+// the ZIP central directory, which signs the whole file. Older APKs are signed with the v1 scheme,
+// which is JAR signing (see jar.go) and signs each entry of the archive.
+//
+// See https://source.android.com/docs/security/features/apksigning/v2 and the v3 page.
+
+const (
+	SchemeV1 = 1
+	SchemeV2 = 2
+	SchemeV3 = 3
+
+	signingBlockMagic = "APK Sig Block 42"
+	blockIDv2         = 0x7109871a
+	blockIDv3         = 0xf05368c0
+
+	// maxSigningBlockSize is the maximum size of the APK Signing Block, which is usually a few KB.
+	maxSigningBlockSize = 16 << 20
+
+	eocdSignature   = 0x06054b50
+	eocdMinSize     = 22
+	maxCommentSize  = 0xFFFF
+	digestChunkSize = 1 << 20
+)
+
+var (
+	ErrUnsigned         = errors.New("APK is not signed")
+	ErrInvalidSignature = errors.New("invalid APK signature")
+)
+
+// signatureAlgorithm is a signature algorithm of the v2 and v3 schemes, with the hash of its content digest.
+type signatureAlgorithm struct {
+	hash crypto.Hash
+	pss  bool
+}
+
+// signatureAlgorithms are the supported algorithms by id, in order of preference.
+// The verity variants are not supported because apksigner always pairs them with a regular one.
+var (
+	signatureAlgorithms = map[uint32]signatureAlgorithm{
+		0x0101: {hash: crypto.SHA256, pss: true},
+		0x0102: {hash: crypto.SHA512, pss: true},
+		0x0103: {hash: crypto.SHA256},
+		0x0104: {hash: crypto.SHA512},
+		0x0201: {hash: crypto.SHA256},
+		0x0202: {hash: crypto.SHA512},
+	}
+	preferredAlgorithms = []uint32{0x0102, 0x0104, 0x0202, 0x0101, 0x0103, 0x0201}
+)
+
+// verifySignature verifies the signature of the APK, using the v3 or v2 scheme if the APK has an
+// APK Signing Block, or the v1 scheme otherwise. It returns the scheme and the hex-encoded SHA-256
+// of the certificate of each signer.
+func verifySignature(r io.ReaderAt, size int64, archive *zip.Reader) (scheme int, certificates []string, err error) {
+	layout, err := findLayout(r, size)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	blocks, err := layout.signingBlock(r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+	}
+
+	for _, scheme := range []int{SchemeV3, SchemeV2} {
+		id := uint32(blockIDv2)
+		if scheme == SchemeV3 {
+			id = blockIDv3
+		}
+
+		block, ok := blocks[id]
+		if !ok {
+			continue
+		}
+
+		certificates, err := verifySigners(r, layout, block, scheme)
+		if err != nil {
+			return 0, nil, fmt.Errorf("%w: v%d: %w", ErrInvalidSignature, scheme, err)
+		}
+		return scheme, certificates, nil
+	}
+
+	certificates, err = verifyJAR(archive)
+	if err != nil {
+		return 0, nil, err
+	}
+	return SchemeV1, certificates, nil
+}
+
+// layout is the position of the sections of the APK covered by the v2 and v3 signatures.
+type layout struct {
+	size        int64
+	blockOffset int64 // offset of the APK Signing Block, equal to cdOffset if there is none
+	cdOffset    int64
+	cdSize      int64
+	eocdOffset  int64
+}
+
+// findLayout locates the central directory and the end of central directory record of the APK.
+func findLayout(r io.ReaderAt, size int64) (layout, error) {
+	tailSize := min(size, eocdMinSize+maxCommentSize)
+	tail := make([]byte, tailSize)
+	if _, err := r.ReadAt(tail, size-tailSize); err != nil {
+		return layout{}, fmt.Errorf("failed to read end of central directory: %w", err)
+	}
+
+	// the record is followed by a comment of variable length, so it's searched backwards
+	for i := len(tail) - eocdMinSize; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(tail[i:]) != eocdSignature {
+			continue
+		}
+		if comment := int(binary.LittleEndian.Uint16(tail[i+20:])); i+eocdMinSize+comment != len(tail) {
+			continue
+		}
+
+		l := layout{
+			size:       size,
+			cdSize:     int64(binary.LittleEndian.Uint32(tail[i+12:])),
+			cdOffset:   int64(binary.LittleEndian.Uint32(tail[i+16:])),
+			eocdOffset: size - tailSize + int64(i),
+		}
+		if l.cdOffset+l.cdSize != l.eocdOffset {
+			return layout{}, errors.New("central directory is not right before the end of central directory record")
+		}
+		l.blockOffset = l.cdOffset
+		return l, nil
+	}
+	return layout{}, errors.New("end of central directory record not found")
+}
+
+// signingBlock returns the values of the APK Signing Block by id, or none if the APK doesn't have one.
+// It sets the offset of the block in the layout.
+func (l *layout) signingBlock(r io.ReaderAt) (map[uint32][]byte, error) {
+	if l.cdOffset < 32 {
+		return nil, nil
+	}
+
+	footer := make([]byte, 24)
+	if _, err := r.ReadAt(footer, l.cdOffset-24); err != nil {
+		return nil, fmt.Errorf("failed to read signing block footer: %w", err)
+	}
+	if string(footer[8:]) != signingBlockMagic {
+		return nil, nil
+	}
+
+	size := binary.LittleEndian.Uint64(footer)
+	if size < 24 || size > maxSigningBlockSize || int64(size)+8 > l.cdOffset {
+		return nil, fmt.Errorf("invalid signing block size %d", size)
+	}
+
+	l.blockOffset = l.cdOffset - int64(size) - 8
+	block := make([]byte, size+8)
+	if _, err := r.ReadAt(block, l.blockOffset); err != nil {
+		return nil, fmt.Errorf("failed to read signing block: %w", err)
+	}
+	if binary.LittleEndian.Uint64(block) != size {
+		return nil, errors.New("signing block sizes don't match")
+	}
+
+	values := make(map[uint32][]byte)
+	pairs := block[8 : len(block)-24]
+	for len(pairs) > 0 {
+		if len(pairs) < 8 {
+			return nil, errors.New("truncated signing block pair")
+		}
+		length := binary.LittleEndian.Uint64(pairs)
+		if length < 4 || length > uint64(len(pairs)-8) {
+			return nil, fmt.Errorf("invalid signing block pair length %d", length)
+		}
+
+		pair := pairs[8 : 8+length]
+		values[binary.LittleEndian.Uint32(pair)] = pair[4:]
+		pairs = pairs[8+length:]
+	}
+	return values, nil
+}
+
+// verifySigners verifies every signer of the v2 or v3 block, returning the hex-encoded SHA-256 of their certificates.
+func verifySigners(r io.ReaderAt, l layout, block []byte, scheme int) ([]string, error) {
+	data, err := lengthPrefixed(block)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signers: %w", err)
+	}
+
+	signers, err := sequence(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signers: %w", err)
+	}
+	if len(signers) == 0 {
+		return nil, errors.New("no signers")
+	}
+
+	digests := make(map[crypto.Hash][]byte)
+	var certificates []string
+	for i, signer := range signers {
+		certificate, err := verifySigner(r, l, signer, scheme, digests)
+		if err != nil {
+			return nil, fmt.Errorf("signer %d: %w", i, err)
+		}
+		if !slices.Contains(certificates, certificate) {
+			certificates = append(certificates, certificate)
+		}
+	}
+	return certificates, nil
+}
+
+// verifySigner verifies the signer, returning the hex-encoded SHA-256 of its certificate.
+// The content digests of the APK are computed once, and cached in digests.
+func verifySigner(r io.ReaderAt, l layout, signer []byte, scheme int, digests map[crypto.Hash][]byte) (string, error) {
+	fields := &reader{data: signer}
+	signedData := fields.lengthPrefixed()
+	if scheme == SchemeV3 {
+		fields.uint32() // min SDK
+		fields.uint32() // max SDK
+	}
+	signatures := fields.lengthPrefixed()
+	publicKey := fields.lengthPrefixed()
+	if fields.err != nil {
+		return "", fields.err
+	}
+
+	id, signature, err := preferredSignature(signatures)
+	if err != nil {
+		return "", err
+	}
+	algorithm := signatureAlgorithms[id]
+
+	key, err := x509.ParsePKIXPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	if err := verify(key, algorithm, signedData, signature); err != nil {
+		return "", err
+	}
+
+	data := &reader{data: signedData}
+	digestRecords := data.lengthPrefixed()
+	certificateRecords := data.lengthPrefixed()
+	if data.err != nil {
+		return "", fmt.Errorf("invalid signed data: %w", data.err)
+	}
+
+	expected, err := findDigest(digestRecords, id)
+	if err != nil {
+		return "", err
+	}
+
+	certificates, err := sequence(certificateRecords)
+	if err != nil || len(certificates) == 0 {
+		return "", errors.New("missing certificates")
+	}
+	certificate, err := x509.ParseCertificate(certificates[0])
+	if err != nil {
+		return "", fmt.Errorf("invalid certificate: %w", err)
+	}
+	if !bytes.Equal(certificate.RawSubjectPublicKeyInfo, publicKey) {
+		return "", errors.New("public key doesn't match the certificate")
+	}
+
+	digest, ok := digests[algorithm.hash]
+	if !ok {
+		if digest, err = contentDigest(r, l, algorithm.hash); err != nil {
+			return "", fmt.Errorf("failed to compute content digest: %w", err)
+		}
+		digests[algorithm.hash] = digest
+	}
+	if !bytes.Equal(digest, expected) {
+		return "", errors.New("content digest doesn't match, the APK has been modified after signing")
+	}
+
+	sum := sha256.Sum256(certificates[0])
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// preferredSignature returns the signature with the preferred supported algorithm.
+func preferredSignature(records []byte) (id uint32, signature []byte, err error) {
+	signatures, err := sequence(records)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid signatures: %w", err)
+	}
+
+	found := make(map[uint32][]byte, len(signatures))
+	for _, record := range signatures {
+		fields := &reader{data: record}
+		id := fields.uint32()
+		signature := fields.lengthPrefixed()
+		if fields.err != nil {
+			return 0, nil, fmt.Errorf("invalid signature: %w", fields.err)
+		}
+		found[id] = signature
+	}
+
+	for _, id := range preferredAlgorithms {
+		if signature, ok := found[id]; ok {
+			return id, signature, nil
+		}
+	}
+	return 0, nil, errors.New("no supported signature algorithm")
+}
+
+// findDigest returns the content digest of the signed data made with the signature algorithm.
+func findDigest(records []byte, id uint32) ([]byte, error) {
+	digests, err := sequence(records)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digests: %w", err)
+	}
+
+	for _, record := range digests {
+		fields := &reader{data: record}
+		algorithm := fields.uint32()
+		digest := fields.lengthPrefixed()
+		if fields.err != nil {
+			return nil, fmt.Errorf("invalid digest: %w", fields.err)
+		}
+		if algorithm == id {
+			return digest, nil
+		}
+	}
+	return nil, fmt.Errorf("missing digest for signature algorithm %#x", id)
+}
+
+// verify verifies the signature of the data with the public key.
+func verify(key crypto.PublicKey, algorithm signatureAlgorithm, data, signature []byte) error {
+	h := algorithm.hash.New()
+	h.Write(data)
+	hashed := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm.pss {
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: algorithm.hash}
+			return rsa.VerifyPSS(key, algorithm.hash, hashed, signature, opts)
+		}
+		return rsa.VerifyPKCS1v15(key, algorithm.hash, hashed, signature)
+
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hashed, signature) {
+			return errors.New("ecdsa verification failed")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// contentDigest computes the digest of the APK signed by the v2 and v3 schemes: the entries, the central
+// directory and the end of central directory record pointing to the signing block, split into 1 MB chunks.
+func contentDigest(r io.ReaderAt, l layout, hash crypto.Hash) ([]byte, error) {
+	eocd := make([]byte, l.size-l.eocdOffset)
+	if _, err := r.ReadAt(eocd, l.eocdOffset); err != nil {
+		return nil, err
+	}
+	binary.LittleEndian.PutUint32(eocd[16:], uint32(l.blockOffset))
+
+	sections := []io.Reader{
+		io.NewSectionReader(r, 0, l.blockOffset),
+		io.NewSectionReader(r, l.cdOffset, l.cdSize),
+		bytes.NewReader(eocd),
+	}
+
+	var chunkDigests []byte
+	var count uint32
+	buf := make([]byte, digestChunkSize)
+	for _, section := range sections {
+		for {
+			n, err := io.ReadFull(section, buf)
+			if n > 0 {
+				h := hash.New()
+				h.Write([]byte{0xa5})
+				h.Write(binary.LittleEndian.AppendUint32(nil, uint32(n)))
+				h.Write(buf[:n])
+				chunkDigests = h.Sum(chunkDigests)
+				count++
+			}
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	h := hash.New()
+	h.Write([]byte{0x5a})
+	h.Write(binary.LittleEndian.AppendUint32(nil, count))
+	h.Write(chunkDigests)
+	return h.Sum(nil), nil
+}
+
+// reader reads little-endian fields, recording the first error.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) uint32() uint32 {
+	if r.err != nil {
+		return 0
+	}
+	if len(r.data) < 4 {
+		r.err = errors.New("truncated field")
+		return 0
+	}
+	v := binary.LittleEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+func (r *reader) lengthPrefixed() []byte {
+	if r.err != nil {
+		return nil
+	}
+	value, err := lengthPrefixed(r.data)
+	if err != nil {
+		r.err = err
+		return nil
+	}
+	r.data = r.data[4+len(value):]
+	return value
+}
+
+// lengthPrefixed returns the value prefixed by its uint32 length at the start of data.
+func lengthPrefixed(data []byte) ([]byte, error) {
+	if len(data) < 4 {
+		return nil, errors.New("truncated length prefix")
+	}
+	length := binary.LittleEndian.Uint32(data)
+	if uint64(length) > uint64(len(data)-4) {
+		return nil, fmt.Errorf("length %d exceeds the %d bytes available", length, len(data)-4)
+	}
+	return data[4 : 4+length], nil
+}
+
+// sequence splits data into a sequence of length-prefixed values.
+func sequence(data []byte) ([][]byte, error) {
+	var values [][]byte
+	for len(data) > 0 {
+		value, err := lengthPrefixed(data)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		data = data[4+len(value):]
+	}
+	return values, nil
+}
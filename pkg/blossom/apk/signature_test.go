@@ -0,0 +1,331 @@
+package apk
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignature(t *testing.T) {
+	rsaSigner := newTestSigner(t, "rsa")
+	ecSigner := newTestSigner(t, "ecdsa")
+	files := map[string][]byte{
+		"classes.dex":             []byte("dex\n035"),
+		"res/layout/main.xml":     []byte("layout"),
+		"lib/arm64-v8a/libapp.so": bytes.Repeat([]byte{0x7f}, 3*digestChunkSize/2),
+	}
+
+	tests := []struct {
+		name         string
+		apk          func(t *testing.T) []byte
+		scheme       int
+		certificates []string
+		err          error
+	}{
+		{
+			name:         "v2 rsa",
+			apk:          func(t *testing.T) []byte { return signV2(t, zipFiles(t, files), SchemeV2, rsaSigner) },
+			scheme:       SchemeV2,
+			certificates: []string{rsaSigner.hash},
+		},
+		{
+			name:         "v3 ecdsa",
+			apk:          func(t *testing.T) []byte { return signV2(t, zipFiles(t, files), SchemeV3, ecSigner) },
+			scheme:       SchemeV3,
+			certificates: []string{ecSigner.hash},
+		},
+		{
+			name:         "v2 with two signers",
+			apk:          func(t *testing.T) []byte { return signV2(t, zipFiles(t, files), SchemeV2, rsaSigner, ecSigner) },
+			scheme:       SchemeV2,
+			certificates: []string{rsaSigner.hash, ecSigner.hash},
+		},
+		{
+			name:         "v1 rsa",
+			apk:          func(t *testing.T) []byte { return zipFiles(t, signV1(t, files, rsaSigner)) },
+			scheme:       SchemeV1,
+			certificates: []string{rsaSigner.hash},
+		},
+		{
+			name:         "v1 ecdsa",
+			apk:          func(t *testing.T) []byte { return zipFiles(t, signV1(t, files, ecSigner)) },
+			scheme:       SchemeV1,
+			certificates: []string{ecSigner.hash},
+		},
+		{
+			name: "unsigned",
+			apk:  func(t *testing.T) []byte { return zipFiles(t, files) },
+			err:  ErrUnsigned,
+		},
+		{
+			name: "v2 modified after signing",
+			apk: func(t *testing.T) []byte {
+				apk := signV2(t, zipFiles(t, files), SchemeV2, rsaSigner)
+				i := bytes.Index(apk, []byte("dex\n035"))
+				apk[i] = 'D'
+				return apk
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name: "v1 entry modified after signing",
+			apk: func(t *testing.T) []byte {
+				signed := signV1(t, files, rsaSigner)
+				signed["classes.dex"] = []byte("malware")
+				return zipFiles(t, signed)
+			},
+			err: ErrInvalidSignature,
+		},
+		{
+			name: "v1 entry added after signing",
+			apk: func(t *testing.T) []byte {
+				signed := signV1(t, files, rsaSigner)
+				signed["classes2.dex"] = []byte("malware")
+				return zipFiles(t, signed)
+			},
+			err: ErrInvalidSignature,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apk := test.apk(t)
+			archive, err := zip.NewReader(bytes.NewReader(apk), int64(len(apk)))
+			if err != nil {
+				t.Fatalf("failed to open the APK: %v", err)
+			}
+
+			scheme, certificates, err := verifySignature(bytes.NewReader(apk), int64(len(apk)), archive)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if scheme != test.scheme {
+				t.Errorf("expected scheme %d, got %d", test.scheme, scheme)
+			}
+			if !reflect.DeepEqual(certificates, test.certificates) {
+				t.Errorf("expected certificates %v, got %v", test.certificates, certificates)
+			}
+		})
+	}
+}
+
+// testSigner is a key with its self-signed certificate, and the hex-encoded SHA-256 of the certificate.
+type testSigner struct {
+	key         crypto.Signer
+	certificate []byte
+	hash        string
+}
+
+func newTestSigner(t *testing.T, kind string) testSigner {
+	t.Helper()
+	var key crypto.Signer
+	var err error
+	switch kind {
+	case "rsa":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ecdsa":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatalf("failed to generate %s key: %v", kind, err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "test " + kind},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	sum := sha256.Sum256(certificate)
+	return testSigner{key: key, certificate: certificate, hash: hex.EncodeToString(sum[:])}
+}
+
+// algorithm returns the v2 signature algorithm id used by the signer.
+func (s testSigner) algorithm() uint32 {
+	if _, ok := s.key.(*rsa.PrivateKey); ok {
+		return 0x0103
+	}
+	return 0x0201
+}
+
+func (s testSigner) sign(t *testing.T, data []byte) []byte {
+	t.Helper()
+	hashed := sha256.Sum256(data)
+	signature, err := s.key.Sign(rand.Reader, hashed[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	return signature
+}
+
+// signV2 inserts an APK Signing Block with a v2 or v3 block, signed by the signers, before the central directory.
+func signV2(t *testing.T, apk []byte, scheme int, signers ...testSigner) []byte {
+	t.Helper()
+	l, err := findLayout(bytes.NewReader(apk), int64(len(apk)))
+	if err != nil {
+		t.Fatalf("failed to find layout: %v", err)
+	}
+	digest, err := contentDigest(bytes.NewReader(apk), l, crypto.SHA256)
+	if err != nil {
+		t.Fatalf("failed to compute content digest: %v", err)
+	}
+
+	var records []byte
+	for _, signer := range signers {
+		publicKey, err := x509.MarshalPKIXPublicKey(signer.key.Public())
+		if err != nil {
+			t.Fatalf("failed to marshal public key: %v", err)
+		}
+
+		digests := prefixed(prefixed(append(binary.LittleEndian.AppendUint32(nil, signer.algorithm()), prefixed(digest)...)))
+		certificates := prefixed(prefixed(signer.certificate))
+		signedData := slices.Concat(digests, certificates, prefixed(nil))
+		signatures := prefixed(append(binary.LittleEndian.AppendUint32(nil, signer.algorithm()), prefixed(signer.sign(t, signedData))...))
+
+		record := prefixed(signedData)
+		if scheme == SchemeV3 {
+			record = binary.LittleEndian.AppendUint32(record, 24)
+			record = binary.LittleEndian.AppendUint32(record, 0x7fffffff)
+		}
+		record = slices.Concat(record, prefixed(signatures), prefixed(publicKey))
+		records = append(records, prefixed(record)...)
+	}
+
+	id := uint32(blockIDv2)
+	if scheme == SchemeV3 {
+		id = blockIDv3
+	}
+	value := prefixed(records)
+	pair := binary.LittleEndian.AppendUint64(nil, uint64(4+len(value)))
+	pair = binary.LittleEndian.AppendUint32(pair, id)
+	pair = append(pair, value...)
+
+	size := uint64(len(pair) + 24)
+	block := binary.LittleEndian.AppendUint64(nil, size)
+	block = append(block, pair...)
+	block = binary.LittleEndian.AppendUint64(block, size)
+	block = append(block, signingBlockMagic...)
+
+	signed := slices.Concat(apk[:l.cdOffset], block, apk[l.cdOffset:])
+	binary.LittleEndian.PutUint32(signed[l.eocdOffset+int64(len(block))+16:], uint32(l.cdOffset)+uint32(len(block)))
+	return signed
+}
+
+func prefixed(value []byte) []byte {
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(value))), value...)
+}
+
+// signV1 returns the files with the META-INF files of a JAR signature by the signer.
+// The signature block has authenticated attributes, like the ones produced by apksigner.
+func signV1(t *testing.T, files map[string][]byte, signer testSigner) map[string][]byte {
+	t.Helper()
+	digest := func(data []byte) string {
+		sum := sha256.Sum256(data)
+		return base64.StdEncoding.EncodeToString(sum[:])
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	// the .SF lists the digest of each section of the manifest rather than of the whole manifest
+	manifest := &strings.Builder{}
+	sf := &strings.Builder{}
+	manifest.WriteString("Manifest-Version: 1.0\r\nCreated-By: test\r\n\r\n")
+	sf.WriteString("Signature-Version: 1.0\r\nCreated-By: test\r\n\r\n")
+	for _, name := range names {
+		section := fmt.Sprintf("Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", name, digest(files[name]))
+		manifest.WriteString(section)
+		fmt.Fprintf(sf, "Name: %s\r\nSHA-256-Digest: %s\r\n\r\n", name, digest([]byte(section)))
+	}
+
+	signed := make(map[string][]byte, len(files)+3)
+	for name, content := range files {
+		signed[name] = content
+	}
+	signed[jarManifestPath] = []byte(manifest.String())
+	signed["META-INF/CERT.SF"] = []byte(sf.String())
+
+	ext := ".RSA"
+	if _, ok := signer.key.(*ecdsa.PrivateKey); ok {
+		ext = ".EC"
+	}
+	signed["META-INF/CERT"+ext] = signPKCS7(t, []byte(sf.String()), signer)
+	return signed
+}
+
+// signPKCS7 returns a detached PKCS #7 signature of the content with authenticated attributes.
+func signPKCS7(t *testing.T, content []byte, signer testSigner) []byte {
+	t.Helper()
+	marshal := func(v any, params ...string) []byte {
+		data, err := asn1.MarshalWithParams(v, strings.Join(params, ","))
+		if err != nil {
+			t.Fatalf("failed to marshal %T: %v", v, err)
+		}
+		return data
+	}
+
+	certificate, err := x509.ParseCertificate(signer.certificate)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+
+	sha256OID := asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	dataOID := asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	contentDigest := sha256.Sum256(content)
+	attributes := []pkcs7Attribute{
+		{Type: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}, Values: asn1.RawValue{FullBytes: marshal([]asn1.ObjectIdentifier{dataOID}, "set")}},
+		{Type: oidMessageDigest, Values: asn1.RawValue{FullBytes: marshal([][]byte{contentDigest[:]}, "set")}},
+	}
+	set := marshal(attributes, "set")
+	implicit := slices.Clone(set)
+	implicit[0] = 0xa0
+
+	signerInfo := pkcs7SignerInfo{
+		Version:                   1,
+		IssuerAndSerial:           pkcs7IssuerAndSerial{Issuer: asn1.RawValue{FullBytes: certificate.RawIssuer}, Serial: certificate.SerialNumber},
+		DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: sha256OID},
+		AuthenticatedAttributes:   asn1.RawValue{FullBytes: implicit},
+		DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}},
+		EncryptedDigest:           signer.sign(t, set),
+	}
+
+	signedData := pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{FullBytes: marshal([]pkix.AlgorithmIdentifier{{Algorithm: sha256OID}}, "set")},
+		ContentInfo:      asn1.RawValue{FullBytes: marshal(struct{ ContentType asn1.ObjectIdentifier }{dataOID})},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signer.certificate},
+		SignerInfos:      []pkcs7SignerInfo{signerInfo},
+	}
+
+	return marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: marshal(signedData)},
+	})
+}
@@ -3,22 +3,27 @@
 package blossom
 
 import (
+	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"os"
+	"runtime/debug"
 	"slices"
 	"strings"
 	"time"
 
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/pippellia-btc/blossom"
 	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
 	defender "github.com/zapstore/defender/pkg/client"
 	"github.com/zapstore/defender/pkg/models"
 	"github.com/zapstore/relay/pkg/analytics"
-	"github.com/zapstore/relay/pkg/blossom/bunny"
+	"github.com/zapstore/relay/pkg/blossom/sanitize"
 	"github.com/zapstore/relay/pkg/blossom/store"
 	"github.com/zapstore/relay/pkg/rate"
 )
@@ -29,6 +34,8 @@ var (
 	ErrInternal    = blossom.ErrInternal("internal error, please contact the Zapstore team.")
 	ErrNotAllowed  = blossom.ErrForbidden("authenticated pubkey is not allowed. Visit https://zapstore.dev/docs/publish for more information.")
 	ErrRateLimited = blossom.ErrTooMany("rate-limited: slow down chief")
+	ErrNotOwner    = blossom.ErrForbidden("only the pubkey that uploaded the blob can delete it")
+	ErrReferenced  = &blossom.Error{Code: http.StatusConflict, Reason: "blob is referenced by a published asset, delete the asset first"}
 )
 
 type Hash = blossom.Hash
@@ -45,12 +52,22 @@ func NewDB(path string) (DB, error) {
 type T struct {
 	server *blossy.Server
 	config Config
+	paths  Paths
 
 	limiter   rate.Limiter
-	bunny     bunny.Client
+	defender  defender.T
+	storage   Storage  // the primary backend, where blobs are uploaded
+	backends  Backends // the primary backend, followed by the replicas
 	store     *store.T
 	relay     Relay
 	analytics *analytics.Engine
+
+	fetcher     *http.Client        // SSRF-safe client for user-supplied URLs
+	replication chan store.BlobMeta // blobs to copy to the replicas, nil without replicas
+	thumbnails  chan store.BlobMeta // images whose variants to generate, nil without thumbnail widths
+	mediaSlots  chan struct{}       // media requests generating variants, nil without thumbnail widths
+
+	sessionLocks *sessionLocks // upload sessions with a request in progress
 }
 
 // Relay is an interface that represents the subset of the relay functionalities needed by the blossoms server.
@@ -60,15 +77,26 @@ type Relay interface {
 
 	// NotifyUpload notifies the relay that an upload has been completed.
 	NotifyUpload(hash blossom.Hash, mime string) error
+
+	// AssetsReferencing returns the kind 3063 assets referencing the SHA-256 hash in their "x" tag.
+	AssetsReferencing(ctx context.Context, hash blossom.Hash) ([]nostr.Event, error)
+
+	// ExternalAssets returns the kind 3063 assets with at least one "url" tag.
+	ExternalAssets(ctx context.Context) ([]nostr.Event, error)
+
+	// RecordVerification records that the content of the external URL matches the SHA-256 hash.
+	RecordVerification(ctx context.Context, url string, hash blossom.Hash) error
 }
 
 func Setup(
 	config Config,
+	paths Paths,
 	limiter rate.Limiter,
 	defender defender.T,
 	store *store.T,
 	relay Relay,
 	analytics *analytics.Engine,
+	fetcher *http.Client,
 ) (*T, error) {
 
 	server, err := blossy.NewServer(
@@ -87,38 +115,136 @@ func Setup(
 		RateDownloadIP(limiter),
 	)
 
+	server.Reject.Delete.Append(
+		RateDeleteIP(limiter),
+		MissingDeleteAuth(),
+	)
+
 	server.Reject.Upload.Append(
 		RateUploadIP(limiter),
 		MissingAuth(),
 		MissingHints(),
 		MediaNotAllowed(config.AllowedMedia),
+		MediaTooLarge(config),
 		NotAllowed(defender),
+		QuotaExceeded(config, store, defender),
 	)
 
+	server.Reject.Media.Append(
+		RateUploadIP(limiter),
+		MissingAuth(),
+		MissingHints(),
+		MediaNotAllowed(config.AllowedMedia),
+		MediaNotImage(),
+		MediaTooLarge(config),
+		NotAllowed(defender),
+		QuotaExceeded(config, store, defender),
+	)
+
+	backends, err := NewBackends(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage: %w", err)
+	}
+
+	if err := os.MkdirAll(paths.Chunks, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create chunks directory: %w", err)
+	}
+
 	blossom := T{
 		server:    server,
 		config:    config,
+		paths:     paths,
 		limiter:   limiter,
-		bunny:     bunny.NewClient(config.Bunny),
+		defender:  defender,
+		storage:   backends.Primary().Storage,
+		backends:  backends,
 		store:     store,
 		relay:     relay,
 		analytics: analytics,
+
+		fetcher:      fetcher,
+		sessionLocks: newSessionLocks(),
+	}
+
+	if len(backends.Replicas()) > 0 {
+		blossom.replication = newReplicationQueue()
+	}
+	if len(config.ThumbnailWidths) > 0 {
+		blossom.thumbnails = newThumbnailQueue()
+		blossom.mediaSlots = newMediaSlots()
 	}
 
 	server.On.Check = blossom.check
 	server.On.Download = blossom.download
 	server.On.Upload = blossom.upload
+	server.On.Media = blossom.media
+	server.On.Delete = blossom.delete
 	return &blossom, nil
 }
 
 // StartAndServe starts the blossom server, listens to the provided address and handles http requests.
 // It’s a blocking operation, that stops only when the context gets cancelled.
 func (b *T) StartAndServe(ctx context.Context, addr string) error {
-	return b.server.StartAndServe(ctx, addr)
+	if b.config.MirrorInterval > 0 {
+		go b.runMirrorAssets(ctx)
+	}
+	go b.runSessionCleanup(ctx)
+	if b.thumbnails != nil {
+		go b.runThumbnails(ctx)
+	}
+	if len(b.backends.Replicas()) > 0 {
+		go b.runHealthProbes(ctx)
+		go b.runReplication(ctx)
+		if b.config.RepairInterval > 0 {
+			go b.runRepair(ctx)
+		}
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           b.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       time.Minute,
+	}
+
+	exit := make(chan error, 1)
+	go func() {
+		slog.Info("serving the blossom server", "address", addr)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			exit <- err
+		}
+	}()
+
+	select {
+	case err := <-exit:
+		return err
+	case <-ctx.Done():
+		shutCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		return server.Shutdown(shutCtx)
+	}
+}
+
+// Handler returns the http handler of the blossom server, which serves the endpoints
+// that blossy doesn't implement, like GET /list/<pubkey>, PUT /mirror and the resumable uploads,
+// and routes all the others to blossy.
+func (b *T) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /list/{pubkey}", b.list)
+	mux.HandleFunc("PUT /mirror", b.mirror)
+	mux.HandleFunc("HEAD /upload", b.uploadCheck)
+	mux.HandleFunc("POST /upload", b.createSession)
+	mux.HandleFunc("OPTIONS /upload", b.sessionPreflight)
+	mux.HandleFunc("HEAD /upload/{id}", b.sessionStatus)
+	mux.HandleFunc("PATCH /upload/{id}", b.appendChunk)
+	mux.HandleFunc("DELETE /upload/{id}", b.cancelSession)
+	mux.HandleFunc("OPTIONS /upload/{id}", b.sessionPreflight)
+	mux.Handle("/", b.server)
+	return mux
 }
 
 func (b *T) check(r blossy.Request, hash blossom.Hash, _ string) (blossy.MetaDelivery, *blossom.Error) {
-	// We can check the local store for the blob metadata instead of redirecting to Bunny.
+	// We can check the local store for the blob metadata instead of asking the storage.
 	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
 	defer cancel()
 
@@ -139,8 +265,8 @@ func (b *T) check(r blossy.Request, hash blossom.Hash, _ string) (blossy.MetaDel
 }
 
 func (b *T) download(r blossy.Request, hash blossom.Hash, _ string) (blossy.BlobDelivery, *blossom.Error) {
-	// In the Bunny CDN files are defined by their name (hash) and extension (ext).
-	// If the extension is not provided, or if it's different (e.g. .jpg instead of .jpeg), Bunny won't find the file.
+	// In the storage, blobs are defined by their name (hash) and extension (ext).
+	// If the extension is not provided, or if it's different (e.g. .jpg instead of .jpeg), the file won't be found.
 	// To find the correct extension, we check the store for that hash and use the type to get the extension.
 	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
 	defer cancel()
@@ -177,9 +303,22 @@ func (b *T) download(r blossy.Request, hash blossom.Hash, _ string) (blossy.Blob
 		return blossy.Redirect(assetURL, http.StatusTemporaryRedirect), nil
 	}
 
+	if redirect := b.redirectToVariant(r, meta); redirect != nil {
+		return redirect, nil
+	}
+
+	delivery, err := b.deliver(r.Context(), meta)
+	if errors.Is(err, ErrBlobMissing) {
+		slog.Error("blossom: blob missing from storage", "hash", hash)
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		slog.Error("blossom: failed to deliver blob", "error", err, "hash", hash)
+		return nil, ErrInternal
+	}
+
 	b.analytics.RecordDownload(r, hash)
-	url := b.bunny.CDNURL(BlobPath(hash, meta.Type))
-	return blossy.Redirect(url, http.StatusTemporaryRedirect), nil
+	return delivery, nil
 }
 
 func (b *T) upload(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
@@ -188,7 +327,7 @@ func (b *T) upload(r blossy.Request, hints blossy.UploadHints, data io.Reader) (
 		return blossom.BlobDescriptor{}, blossom.ErrBadRequest("body is empty")
 	}
 
-	// To avoid wasting bandwidth and Bunny credits,
+	// To avoid wasting bandwidth and storage credits,
 	// we check if the blob exists in the store before uploading it.
 	meta, err := b.store.Query(r.Context(), *hints.Hash)
 	if err == nil {
@@ -209,14 +348,40 @@ func (b *T) upload(r blossy.Request, hints blossy.UploadHints, data io.Reader) (
 		return blossom.BlobDescriptor{}, ErrInternal
 	}
 
-	name := BlobPath(*hints.Hash, hints.Type)
-	sha256 := hints.Hash.Hex()
-
 	reader := newStallReader(r.Context(), data, b.config.StallTimeout)
 	defer reader.Stop()
 
-	err = b.bunny.Upload(reader.Context(), reader, name, sha256)
-	if errors.Is(err, bunny.ErrInvalidChecksum) {
+	// The type is detected before any byte reaches the storage, so that the CDN
+	// never serves content (e.g. HTML or scripts) under a type it doesn't have.
+	buffered := bufio.NewReaderSize(reader, sniffLen)
+	mediaType, err := sniff(buffered, hints.Type)
+	if errors.Is(err, ErrTypeMismatch) {
+		// punish the client for lying about the content type
+		cost := 200.0
+		b.limiter.Penalize(r.IP().Group(), cost)
+		return blossom.BlobDescriptor{}, blossom.ErrUnsupportedMedia(err.Error())
+	}
+	if err != nil {
+		// the body can only fail to be read because of the client, or a stalled or cancelled reader
+		return blossom.BlobDescriptor{}, &blossom.Error{Code: 499, Reason: err.Error()}
+	}
+
+	if sanitize.Supports(mediaType) {
+		return b.uploadImage(r, hints, reader, buffered, mediaType)
+	}
+
+	name := BlobPath(*hints.Hash, mediaType)
+	sha256 := hints.Hash.Hex()
+
+	inspection, err := newInspection(mediaType)
+	if err != nil {
+		slog.Error("blossom: failed to start inspection", "error", err, "hash", hints.Hash)
+		return blossom.BlobDescriptor{}, ErrInternal
+	}
+	defer inspection.Close()
+
+	err = b.storage.Upload(reader.Context(), inspection.Reader(buffered), hints.Size, name, sha256)
+	if errors.Is(err, ErrChecksumMismatch) {
 		// punish the client for providing a bad hash
 		cost := 200.0
 		b.limiter.Penalize(r.IP().Group(), cost)
@@ -231,12 +396,12 @@ func (b *T) upload(r blossy.Request, hints blossy.UploadHints, data io.Reader) (
 		return blossom.BlobDescriptor{}, ErrInternal
 	}
 
-	// Use a fresh context for the remaining operations to avoid orphaning blobs in Bunny
+	// Use a fresh context for the remaining operations to avoid orphaning blobs in the storage
 	// if the client disconnects after the upload completes, but before the metadata is saved.
 	saveCtx, saveCancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer saveCancel()
 
-	_, size, err := b.bunny.Check(saveCtx, name)
+	size, err := b.storage.Check(saveCtx, name)
 	if err != nil {
 		slog.Error("blossom: failed to check blob", "error", err, "name", name)
 		return blossom.BlobDescriptor{}, ErrInternal
@@ -250,18 +415,28 @@ func (b *T) upload(r blossy.Request, hints blossy.UploadHints, data io.Reader) (
 
 	meta = store.BlobMeta{
 		Hash:       *hints.Hash,
-		Type:       hints.Type,
+		Type:       mediaType,
 		Size:       size,
 		CreatedAt:  time.Now().UTC(),
 		AuthPubkey: r.Pubkey(),
 	}
 
+	// inspect before notifying the relay, which checks the pending assets against the results
+	if err := b.inspect(saveCtx, inspection, name, meta); err != nil {
+		// punish the client for uploading an APK that can't be installed
+		cost := 100.0
+		b.limiter.Penalize(r.IP().Group(), cost)
+		return blossom.BlobDescriptor{}, err
+	}
+
 	_, err = b.store.Save(saveCtx, meta)
 	if err != nil {
 		slog.Error("blossom: failed to save blob metadata", "error", err, "hash", hints.Hash)
 		return blossom.BlobDescriptor{}, ErrInternal
 	}
 
+	b.enqueueReplication(meta)
+	b.enqueueThumbnails(meta)
 	if err := b.relay.NotifyUpload(meta.Hash, meta.Type); err != nil {
 		slog.Error("blossom: failed to notify relay of upload", "error", err, "hash", meta.Hash)
 	}
@@ -269,17 +444,152 @@ func (b *T) upload(r blossy.Request, hints blossy.UploadHints, data io.Reader) (
 	b.analytics.RecordUpload(r, hints)
 	return blossom.BlobDescriptor{
 		Hash:     *hints.Hash,
-		Type:     hints.Type,
+		Type:     meta.Type,
 		Size:     size,
 		Uploaded: meta.CreatedAt.Unix(),
 	}, nil
 }
 
+// uploadCheck handles HEAD /upload as per BUD-06, telling the client whether the blob of the X-SHA-256,
+// X-Content-Type and X-Content-Length headers would be accepted before it sends the body.
+// It runs the same checks of PUT /upload that don't need the body, and writes the reason of a rejection in X-Reason.
+// It replaces the blossy handler, which doesn't know about existing blobs and the limits of the images.
+func (b *T) uploadCheck(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Reason")
+
+	// checking is cheap, so it costs a flat token instead of the cost of the upload charged by [RateUploadIP]
+	if !b.limiter.Allow(blossy.GetIP(r).Group(), 1) {
+		blossom.WriteError(w, ErrRateLimited)
+		return
+	}
+
+	hints, bErr := parseSessionHints(r)
+	if bErr != nil {
+		blossom.WriteError(w, bErr)
+		return
+	}
+
+	pubkey, err := auth.Authenticate(r, b.config.Hostname, hints.Hash)
+	if err != nil {
+		blossom.WriteError(w, blossom.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	req := rawRequest{ip: blossy.GetIP(r), pubkey: pubkey, raw: r}
+	for _, reject := range []func(blossy.Request, blossy.UploadHints) *blossom.Error{
+		MissingAuth(),
+		MediaNotAllowed(b.config.AllowedMedia),
+		MediaTooLarge(b.config),
+		NotAllowed(b.defender),
+		QuotaExceeded(b.config, b.store, b.defender),
+	} {
+		if err := reject(req, hints); err != nil {
+			blossom.WriteError(w, err)
+			return
+		}
+	}
+
+	_, err = b.store.Query(r.Context(), *hints.Hash)
+	if err == nil {
+		// blob already exists, so the upload would return its descriptor
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if !errors.Is(err, store.ErrBlobNotFound) {
+		slog.Error("blossom: failed to query blob metadata", "error", err, "hash", hints.Hash)
+		blossom.WriteError(w, ErrInternal)
+		return
+	}
+
+	if sanitize.Supports(hints.Type) && hints.Size > b.config.ImageMaxSize {
+		reason := fmt.Sprintf("%v of %d bytes", errImageTooLarge, b.config.ImageMaxSize)
+		blossom.WriteError(w, blossom.ErrTooLarge(reason))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// delete removes the blob from the storage and its metadata from the store, as per BUD-02.
+// Only the pubkey that uploaded the blob or an operator can delete it, and only if no asset references it.
+func (b *T) delete(r blossy.Request, hash blossom.Hash) *blossom.Error {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	meta, err := b.store.Query(ctx, hash)
+	if errors.Is(err, context.Canceled) {
+		return ErrClientGone
+	}
+	if errors.Is(err, store.ErrBlobNotFound) {
+		return ErrNotFound
+	}
+	if err != nil {
+		slog.Error("blossom: failed to query blob metadata", "error", err, "hash", hash)
+		return ErrInternal
+	}
+
+	isOwner := meta.AuthPubkey != "" && meta.AuthPubkey == r.Pubkey()
+	if !isOwner && !slices.Contains(b.config.OperatorPubkeys, r.Pubkey()) {
+		return ErrNotOwner
+	}
+
+	// the hash may be an alias, and assets may reference the blob by any of its aliases
+	aliases, err := b.store.AliasesOf(ctx, meta.Hash)
+	if err != nil {
+		slog.Error("blossom: failed to query blob aliases", "error", err, "hash", meta.Hash)
+		return ErrInternal
+	}
+
+	for _, ref := range append(aliases, meta.Hash) {
+		assets, err := b.relay.AssetsReferencing(ctx, ref)
+		if err != nil {
+			slog.Error("blossom: failed to query assets referencing blob", "error", err, "hash", ref)
+			return ErrInternal
+		}
+		if len(assets) > 0 {
+			return ErrReferenced
+		}
+	}
+
+	// Use a fresh context to avoid deleting the blob from the storage but not from the store
+	// if the client disconnects in between.
+	deleteCtx, deleteCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer deleteCancel()
+
+	name := BlobPath(meta.Hash, meta.Type)
+	if err := b.backends.Delete(deleteCtx, name); err != nil {
+		slog.Error("blossom: failed to delete blob", "error", err, "name", name)
+		return ErrInternal
+	}
+	if err := b.store.Delete(deleteCtx, meta.Hash); err != nil {
+		slog.Error("blossom: failed to delete blob metadata", "error", err, "hash", hash)
+		return ErrInternal
+	}
+
+	slog.Info("blossom: blob deleted", "hash", hash, "pubkey", r.Pubkey())
+	return nil
+}
+
 // BlobPath returns the path to the blob on the blossom server, based on the hash and mime type.
 func BlobPath(hash blossom.Hash, mime string) string {
 	return "blobs/" + hash.Hex() + "." + blossom.ExtFromType(mime)
 }
 
+// safely runs a job of a background worker, returning a panic as an error.
+// Background workers parse untrusted blobs outside of the http server, which would otherwise
+// recover the panic, so a malformed blob must not crash the process.
+func safely(job func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+	}()
+	return job()
+}
+
 func MissingAuth() func(r blossy.Request, _ blossy.UploadHints) *blossom.Error {
 	return func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
 		if !r.IsAuthed() {
@@ -289,6 +599,15 @@ func MissingAuth() func(r blossy.Request, _ blossy.UploadHints) *blossom.Error {
 	}
 }
 
+func MissingDeleteAuth() func(r blossy.Request, hash blossom.Hash) *blossom.Error {
+	return func(r blossy.Request, hash blossom.Hash) *blossom.Error {
+		if !r.IsAuthed() {
+			return blossom.ErrUnauthorized("authentication is required")
+		}
+		return nil
+	}
+}
+
 func MissingHints() func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
 	return func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
 		if hints.Hash == nil {
@@ -370,6 +689,19 @@ func RateDownloadIP(limiter rate.Limiter) func(r blossy.Request, hash blossom.Ha
 	}
 }
 
+func RateDeleteIP(limiter rate.Limiter) func(r blossy.Request, hash blossom.Hash) *blossom.Error {
+	return func(r blossy.Request, hash blossom.Hash) *blossom.Error {
+		cost := 10.0
+		ip := r.IP().Group()
+
+		if !limiter.Allow(ip, cost) {
+			slog.Debug("blossom: rejecting delete", "ip", ip)
+			return ErrRateLimited
+		}
+		return nil
+	}
+}
+
 func RateCheckIP(limiter rate.Limiter) func(r blossy.Request, hash blossom.Hash, ext string) *blossom.Error {
 	return func(r blossy.Request, hash blossom.Hash, ext string) *blossom.Error {
 		cost := 1.0
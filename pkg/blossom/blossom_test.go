@@ -0,0 +1,276 @@
+package blossom
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/blossom/apk"
+	"github.com/zapstore/relay/pkg/blossom/exe"
+	"github.com/zapstore/relay/pkg/blossom/store"
+	"github.com/zapstore/relay/pkg/rate"
+)
+
+func TestUploadCheck(t *testing.T) {
+	b, _ := newSessionServer(t)
+	b.config.AllowedMedia = []string{"application/vnd.android.package-archive", "image/png"}
+	b.config.ImageMaxSize = 1000
+	b.config.QuotaDefault = 10_000
+	handler := b.Handler()
+
+	existing := store.BlobMeta{
+		Hash:      blossom.ComputeHash([]byte("existing")),
+		Type:      "application/vnd.android.package-archive",
+		Size:      20_000,
+		CreatedAt: time.Now().UTC(),
+	}
+	if _, err := b.store.Save(ctx, existing); err != nil {
+		t.Fatalf("failed to save blob: %v", err)
+	}
+
+	apk := "application/vnd.android.package-archive"
+	tests := []struct {
+		name   string
+		hash   blossom.Hash
+		mime   string
+		size   int64
+		noAuth bool
+		code   int
+	}{
+		{name: "accepted", mime: apk, size: 5000, code: http.StatusOK},
+		{name: "already stored", hash: existing.Hash, mime: apk, size: existing.Size, code: http.StatusOK},
+		{name: "media not allowed", mime: "image/gif", size: 500, code: http.StatusUnsupportedMediaType},
+		{name: "quota exceeded", mime: apk, size: 20_000, code: http.StatusRequestEntityTooLarge},
+		{name: "image too large", mime: "image/png", size: 2000, code: http.StatusRequestEntityTooLarge},
+		{name: "missing hints", code: http.StatusBadRequest},
+		{name: "missing auth", mime: apk, size: 5000, noAuth: true, code: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash := test.hash
+			if hash.IsZero() {
+				hash = blossom.ComputeHash([]byte(test.name))
+			}
+
+			req := httptest.NewRequest(http.MethodHead, "/upload", nil)
+			if !test.noAuth {
+				req.Header.Set("Authorization", uploadAuth(t, hash))
+			}
+			if test.mime != "" {
+				req.Header.Set("X-SHA-256", hash.Hex())
+				req.Header.Set("X-Content-Type", test.mime)
+				req.Header.Set("X-Content-Length", strconv.FormatInt(test.size, 10))
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != test.code {
+				t.Fatalf("expected status %d, got %d: %s", test.code, rec.Code, rec.Header().Get("X-Reason"))
+			}
+			if rec.Code != http.StatusOK && rec.Header().Get("X-Reason") == "" {
+				t.Fatal("expected the reason of the rejection in X-Reason")
+			}
+		})
+	}
+}
+
+func TestUploadCheckCost(t *testing.T) {
+	b, _ := newSessionServer(t)
+	b.limiter = rate.NewLimiter(rate.Config{InitialTokens: 2, MaxTokens: 2, TokensPerInterval: 1, Interval: time.Hour})
+	handler := b.Handler()
+
+	// a check of a large upload costs a flat token, not the cost of the upload
+	hash := blossom.ComputeHash([]byte("large"))
+	for i, code := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		req := httptest.NewRequest(http.MethodHead, "/upload", nil)
+		req.Header.Set("Authorization", uploadAuth(t, hash))
+		req.Header.Set("X-SHA-256", hash.Hex())
+		req.Header.Set("X-Content-Type", "application/vnd.android.package-archive")
+		req.Header.Set("X-Content-Length", "1000000000")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != code {
+			t.Fatalf("check %d: expected status %d, got %d: %s", i, code, rec.Code, rec.Header().Get("X-Reason"))
+		}
+	}
+}
+
+func TestSafely(t *testing.T) {
+	err := safely(func() error {
+		var data []byte
+		_ = data[0]
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "index out of range") {
+		t.Fatalf("expected the panic as an error, got %v", err)
+	}
+
+	if err := safely(func() error { return ErrInternal }); err != ErrInternal {
+		t.Fatalf("expected the error of the job, got %v", err)
+	}
+}
+
+// deleteAuth returns the Authorization header of a kind 24242 delete event for the hash, signed by sk.
+func deleteAuth(t *testing.T, sk string, hash blossom.Hash) string {
+	t.Helper()
+	event := nostr.Event{
+		Kind:      24242,
+		CreatedAt: nostr.Now(),
+		Content:   "delete",
+		Tags: nostr.Tags{
+			{"t", "delete"},
+			{"x", hash.Hex()},
+			{"expiration", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)},
+		},
+	}
+	if err := event.Sign(sk); err != nil {
+		t.Fatalf("failed to sign event: %v", err)
+	}
+
+	data, _ := json.Marshal(event)
+	return "Nostr " + base64.StdEncoding.EncodeToString(data)
+}
+
+func TestDelete(t *testing.T) {
+	owner, operator, other := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	ownerPubkey, _ := nostr.GetPublicKey(owner)
+	operatorPubkey, _ := nostr.GetPublicKey(operator)
+
+	mime := "application/vnd.android.package-archive"
+	referenced := blossom.ComputeHash([]byte("referenced"))
+	aliased := blossom.ComputeHash([]byte("aliased"))
+	alias := blossom.ComputeHash([]byte("alias of aliased"))
+
+	tests := []struct {
+		name string
+		sk   string
+		hash blossom.Hash
+		code int
+	}{
+		{name: "owner", sk: owner, hash: blossom.ComputeHash([]byte("owned")), code: http.StatusNoContent},
+		{name: "operator", sk: operator, hash: blossom.ComputeHash([]byte("operated")), code: http.StatusNoContent},
+		{name: "not owner", sk: other, hash: blossom.ComputeHash([]byte("not owned")), code: ErrNotOwner.Code},
+		{name: "referenced", sk: owner, hash: referenced, code: ErrReferenced.Code},
+		{name: "referenced by alias", sk: owner, hash: aliased, code: ErrReferenced.Code},
+		{name: "not found", sk: owner, hash: blossom.ComputeHash([]byte("missing")), code: ErrNotFound.Code},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, primary := newSessionServer(t)
+			b.config.OperatorPubkeys = []string{operatorPubkey}
+			b.relay = mockRelay{
+				referenced.Hex(): {{ID: "asset"}},
+				alias.Hex():      {{ID: "asset"}},
+			}
+			b.server.On.Delete = b.delete
+
+			replica := newMemStorage()
+			b.backends = append(b.backends, &Backend{Name: "replica", Storage: replica})
+
+			if test.code != ErrNotFound.Code {
+				meta := store.BlobMeta{Hash: test.hash, Type: mime, Size: 3, AuthPubkey: ownerPubkey, CreatedAt: time.Now().UTC()}
+				if _, err := b.store.Save(ctx, meta); err != nil {
+					t.Fatalf("failed to save blob: %v", err)
+				}
+				if err := b.store.SaveAlias(ctx, alias, aliased); err != nil {
+					t.Fatalf("failed to save alias: %v", err)
+				}
+				primary.blobs[BlobPath(test.hash, mime)] = []byte("apk")
+				replica.blobs[BlobPath(test.hash, mime)] = []byte("apk")
+			}
+
+			req := httptest.NewRequest(http.MethodDelete, "/"+test.hash.Hex(), nil)
+			req.Header.Set("Authorization", deleteAuth(t, test.sk, test.hash))
+			rec := httptest.NewRecorder()
+			b.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != test.code {
+				t.Fatalf("expected status %d, got %d: %s", test.code, rec.Code, rec.Header().Get("X-Reason"))
+			}
+
+			if test.code == ErrNotFound.Code {
+				return
+			}
+
+			deleted := rec.Code == http.StatusNoContent
+			if exists, _ := b.store.Has(ctx, test.hash); exists == deleted {
+				t.Errorf("expected the blob to exist in the store: %v, got %v", !deleted, exists)
+			}
+			for _, storage := range []*memStorage{primary, replica} {
+				if _, exists := storage.blobs[BlobPath(test.hash, mime)]; exists == deleted {
+					t.Errorf("expected the blob to exist in every backend: %v, got %v", !deleted, exists)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteCascade(t *testing.T) {
+	owner := nostr.GeneratePrivateKey()
+	ownerPubkey, _ := nostr.GetPublicKey(owner)
+
+	b, primary := newSessionServer(t)
+	b.server.On.Delete = b.delete
+
+	mime := "application/vnd.android.package-archive"
+	hash := blossom.ComputeHash([]byte("apk"))
+	alias := blossom.ComputeHash([]byte("alias"))
+	variant := blossom.ComputeHash([]byte("variant"))
+
+	meta := store.BlobMeta{Hash: hash, Type: mime, Size: 3, AuthPubkey: ownerPubkey, CreatedAt: time.Now().UTC()}
+	if _, err := b.store.Save(ctx, meta); err != nil {
+		t.Fatalf("failed to save blob: %v", err)
+	}
+	primary.blobs[BlobPath(hash, mime)] = []byte("apk")
+
+	if err := b.store.SaveAlias(ctx, alias, hash); err != nil {
+		t.Fatalf("failed to save alias: %v", err)
+	}
+	if err := b.store.SaveReplica(ctx, hash, "replica", time.Now()); err != nil {
+		t.Fatalf("failed to save replica: %v", err)
+	}
+	if err := b.store.SaveManifest(ctx, hash, apk.Manifest{}); err != nil {
+		t.Fatalf("failed to save manifest: %v", err)
+	}
+	if err := b.store.SaveReport(ctx, hash, exe.Report{}); err != nil {
+		t.Fatalf("failed to save report: %v", err)
+	}
+	if err := b.store.SaveVariant(ctx, hash, 64, variant); err != nil {
+		t.Fatalf("failed to save variant: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/"+hash.Hex(), nil)
+	req.Header.Set("Authorization", deleteAuth(t, owner, hash))
+	rec := httptest.NewRecorder()
+	b.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusNoContent, rec.Code, rec.Header().Get("X-Reason"))
+	}
+
+	if aliases, _ := b.store.AliasesOf(ctx, hash); len(aliases) != 0 {
+		t.Errorf("expected the aliases to be deleted, got %v", aliases)
+	}
+	if replicas, _ := b.store.Replicas(ctx, hash); len(replicas) != 0 {
+		t.Errorf("expected the replicas to be deleted, got %v", replicas)
+	}
+	if _, err := b.store.QueryManifest(ctx, hash); !errors.Is(err, store.ErrManifestNotFound) {
+		t.Errorf("expected error %v, got %v", store.ErrManifestNotFound, err)
+	}
+	if _, err := b.store.QueryReport(ctx, hash); !errors.Is(err, store.ErrReportNotFound) {
+		t.Errorf("expected error %v, got %v", store.ErrReportNotFound, err)
+	}
+	if variants, _ := b.store.Variants(ctx, hash); len(variants) != 0 {
+		t.Errorf("expected the variants to be deleted, got %v", variants)
+	}
+}
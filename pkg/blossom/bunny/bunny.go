@@ -5,16 +5,21 @@ package bunny
 import (
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pippellia-btc/blossom"
 )
 
+// dateLayout is the layout of the dates of the listing API, which are in UTC without a timezone.
+const dateLayout = "2006-01-02T15:04:05.999"
+
 var (
 	ErrEmptyData        = errors.New("empty data")
 	ErrEmptyPath        = errors.New("empty path")
@@ -23,6 +28,16 @@ var (
 	ErrFileNotFound     = errors.New("file not found")
 )
 
+// File is an entry of a directory of the storage zone, as returned by [Client.List].
+type File struct {
+	Name        string // the name of the file in its directory, e.g. "<sha256>.apk"
+	Size        int64
+	Checksum    string // uppercase hex sha256 computed by Bunny, empty if not computed
+	IsDirectory bool
+	CreatedAt   time.Time
+	ChangedAt   time.Time
+}
+
 type Client struct {
 	http   http.Client
 	config Config
@@ -97,6 +112,58 @@ func (c Client) Download(ctx context.Context, path string) (io.ReadCloser, error
 	return nil, fmt.Errorf("bunny: failed to download: status %s", res.Status)
 }
 
+// List the files and directories of the directory at the specified path, which is not recursive.
+// Returns an empty list if the directory doesn't exist.
+func (c Client) List(ctx context.Context, dir string) ([]File, error) {
+	req, err := http.NewRequestWithContext(
+		ctx, http.MethodGet, c.StorageURL(dir)+"/", nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("bunny: failed to create request: %w", err)
+	}
+
+	c.setHeaders(req)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("bunny: failed to list: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bunny: failed to list: status %s", res.Status)
+	}
+
+	var objects []struct {
+		ObjectName  string
+		Length      int64
+		Checksum    string
+		IsDirectory bool
+		DateCreated string
+		LastChanged string
+	}
+	if err := json.NewDecoder(res.Body).Decode(&objects); err != nil {
+		return nil, fmt.Errorf("bunny: failed to list: failed to decode response: %w", err)
+	}
+
+	files := make([]File, len(objects))
+	for i, o := range objects {
+		files[i] = File{
+			Name:        o.ObjectName,
+			Size:        o.Length,
+			Checksum:    o.Checksum,
+			IsDirectory: o.IsDirectory,
+		}
+		// dates that fail to parse are left zero
+		files[i].CreatedAt, _ = time.Parse(dateLayout, o.DateCreated)
+		files[i].ChangedAt, _ = time.Parse(dateLayout, o.LastChanged)
+	}
+	return files, nil
+}
+
 // Check returns the metadata of the file at the specified path on the CDN.
 func (c Client) Check(ctx context.Context, path string) (mime string, size int64, err error) {
 	if path == "" {
@@ -189,6 +189,50 @@ func TestCheck(t *testing.T) {
 	}
 }
 
+func TestList(t *testing.T) {
+	tests := []struct {
+		name  string
+		dir   string
+		files []string // files expected in the directory
+	}{
+		{
+			name:  "root directory",
+			dir:   "/",
+			files: []string{"file_exists.txt"},
+		},
+		{
+			name: "directory does not exist",
+			dir:  "/dir_does_not_exist",
+		},
+	}
+
+	client := NewClient(config)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := client.List(ctx, test.dir)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			for _, name := range test.files {
+				found := false
+				for _, file := range files {
+					if file.Name == name && !file.IsDirectory {
+						found = true
+					}
+				}
+				if !found {
+					t.Fatalf("expected file %s in %v", name, files)
+				}
+			}
+			if len(test.files) == 0 && len(files) > 0 {
+				t.Fatalf("expected no files, got %v", files)
+			}
+		})
+	}
+}
+
 func TestDelete(t *testing.T) {
 	tests := []struct {
 		name string
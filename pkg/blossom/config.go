@@ -3,11 +3,21 @@ package blossom
 import (
 	"fmt"
 	"net"
+	"slices"
+	"strings"
 	"time"
 
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/zapstore/relay/pkg/blossom/bunny"
+	"github.com/zapstore/relay/pkg/blossom/local"
+	"github.com/zapstore/relay/pkg/blossom/s3"
 )
 
+// Paths holds filesystem paths for the blossom server.
+type Paths struct {
+	Chunks string // directory of the chunks of resumable uploads
+}
+
 type Config struct {
 	// Hostname is the hostname of the blossom server, used to validate authorization
 	// events and for the "url" field in blob descriptors.
@@ -20,10 +30,91 @@ type Config struct {
 	// Default is "application/vnd.android.package-archive" and common image types.
 	AllowedMedia []string `env:"BLOSSOM_ALLOWED_MEDIA"`
 
+	// OperatorPubkeys are the hex pubkeys allowed to delete any blob, in addition to the pubkey that uploaded it.
+	// Default is empty.
+	OperatorPubkeys []string `env:"BLOSSOM_OPERATOR_PUBKEYS"`
+
+	// MediaMaxSizes are the maximum sizes in bytes of the blobs of a media type, either exact (e.g. "image/png")
+	// or a wildcard for the subtypes (e.g. "image/*"). Types without a maximum are only limited by the quota.
+	// Default is 10 MB for images.
+	MediaMaxSizes map[string]int64 `env:"BLOSSOM_MEDIA_MAX_SIZES"`
+
+	// QuotaDefault is the maximum total size in bytes of the blobs uploaded by a pubkey.
+	// Zero disables the quota. Default is 5 GB.
+	QuotaDefault int64 `env:"BLOSSOM_QUOTA_DEFAULT"`
+
+	// QuotaAllowed is the quota of the pubkeys explicitly allowed by a defender policy, which replaces
+	// the default quota. Zero disables the quota of those pubkeys. Default is 50 GB.
+	QuotaAllowed int64 `env:"BLOSSOM_QUOTA_ALLOWED"`
+
 	// The no-progress timeout for streaming uploads. Default is 30 seconds.
 	StallTimeout time.Duration `env:"BLOSSOM_STALL_TIMEOUT"`
 
+	// MirrorMaxSize is the maximum size in bytes of a blob mirrored from another server. Default is 1 GB.
+	MirrorMaxSize int64 `env:"BLOSSOM_MIRROR_MAX_SIZE"`
+
+	// MirrorInterval is the interval between mirrorings of the blobs of assets that are only hosted externally,
+	// so that they are served by our CDN. Zero disables the mirroring. Default is 10 minutes.
+	MirrorInterval time.Duration `env:"BLOSSOM_MIRROR_INTERVAL"`
+
+	// GCInterval is the interval between garbage collections of blobs not referenced by any event.
+	// Zero disables the garbage collection. Default is 6 hours.
+	GCInterval time.Duration `env:"BLOSSOM_GC_INTERVAL"`
+
+	// GCMarkAfter is how old an unreferenced blob must be before it gets marked for deletion,
+	// leaving publishers time to reference it after the upload. Default is 24 hours.
+	GCMarkAfter time.Duration `env:"BLOSSOM_GC_MARK_AFTER"`
+
+	// GCDeleteAfter is how long a blob must stay marked and unreferenced before it gets deleted.
+	// Default is 7 days.
+	GCDeleteAfter time.Duration `env:"BLOSSOM_GC_DELETE_AFTER"`
+
+	// ReconcileInterval is the interval between reconciliations of the Bunny storage zone with the blobs metadata,
+	// which re-create the metadata of the blobs uploaded without it. Zero disables the reconciliation,
+	// which only runs with the "bunny" backend. Default is 24 hours.
+	ReconcileInterval time.Duration `env:"BLOSSOM_RECONCILE_INTERVAL"`
+
+	// SessionExpiry is how long a resumable upload can stay without receiving chunks before it's deleted.
+	// Default is 24 hours.
+	SessionExpiry time.Duration `env:"BLOSSOM_SESSION_EXPIRY"`
+
+	// SessionMaxSize is the maximum size in bytes of a blob uploaded with a resumable upload. Default is 4 GB.
+	SessionMaxSize int64 `env:"BLOSSOM_SESSION_MAX_SIZE"`
+
+	// SessionsPerPubkey is the maximum number of resumable uploads in progress per pubkey. Default is 4.
+	SessionsPerPubkey int `env:"BLOSSOM_SESSIONS_PER_PUBKEY"`
+
+	// ChunkMaxSize is the maximum size in bytes of a chunk of a resumable upload. Default is 64 MB.
+	ChunkMaxSize int64 `env:"BLOSSOM_CHUNK_MAX_SIZE"`
+
+	// ImageMaxSize is the maximum size in bytes of an image that is sanitized before being stored,
+	// which is held in memory while it's processed. Default is 20 MB.
+	ImageMaxSize int64 `env:"BLOSSOM_IMAGE_MAX_SIZE"`
+
+	// ImageMaxDimension is the maximum width and height in pixels of a sanitized image. Default is 8192.
+	ImageMaxDimension int `env:"BLOSSOM_IMAGE_MAX_DIMENSION"`
+
+	// ThumbnailWidths are the widths in pixels of the variants generated for the JPEG, PNG and GIF images,
+	// which are downloaded with the "w" query parameter. Empty disables the thumbnails. Default is 128, 256 and 512.
+	ThumbnailWidths []int `env:"BLOSSOM_THUMBNAIL_WIDTHS"`
+
+	// Backend is the storage backend of the blobs, either "bunny", "local" or "s3". Default is "bunny".
+	Backend string `env:"BLOSSOM_BACKEND"`
+
+	// Replicas are the secondary storage backends where blobs are copied after being uploaded,
+	// and from which they are downloaded when the primary backend is unhealthy. Default is empty.
+	Replicas []string `env:"BLOSSOM_REPLICAS"`
+
+	// HealthInterval is the interval between health probes of the storage backends. Default is 30 seconds.
+	HealthInterval time.Duration `env:"BLOSSOM_HEALTH_INTERVAL"`
+
+	// RepairInterval is the interval between repairs of the replicas, which copy again the blobs
+	// that are missing from a replica. Zero disables the repair. Default is 1 hour.
+	RepairInterval time.Duration `env:"BLOSSOM_REPAIR_INTERVAL"`
+
 	Bunny bunny.Config
+	Local local.Config
+	S3    s3.Config
 }
 
 func NewConfig() Config {
@@ -41,8 +132,31 @@ func NewConfig() Config {
 			"image/heif",
 			"image/svg+xml",
 		},
-		StallTimeout: 30 * time.Second,
-		Bunny:        bunny.NewConfig(),
+		MediaMaxSizes: map[string]int64{
+			"image/*": 10_000_000,
+		},
+		QuotaDefault:      5_000_000_000,
+		QuotaAllowed:      50_000_000_000,
+		StallTimeout:      30 * time.Second,
+		MirrorMaxSize:     1_000_000_000,
+		MirrorInterval:    10 * time.Minute,
+		GCInterval:        6 * time.Hour,
+		GCMarkAfter:       24 * time.Hour,
+		GCDeleteAfter:     7 * 24 * time.Hour,
+		ReconcileInterval: 24 * time.Hour,
+		SessionExpiry:     24 * time.Hour,
+		SessionMaxSize:    4_000_000_000,
+		SessionsPerPubkey: 4,
+		ChunkMaxSize:      64 << 20,
+		ImageMaxSize:      20 << 20,
+		ImageMaxDimension: 8192,
+		ThumbnailWidths:   []int{128, 256, 512},
+		Backend:           BackendBunny,
+		HealthInterval:    30 * time.Second,
+		RepairInterval:    time.Hour,
+		Bunny:             bunny.NewConfig(),
+		Local:             local.NewConfig(),
+		S3:                s3.NewConfig(),
 	}
 }
 
@@ -57,14 +171,135 @@ func (c Config) Validate() error {
 		return fmt.Errorf("stall timeout must be greater than 5s to function reliably")
 	}
 
+	if c.MirrorMaxSize <= 0 {
+		return fmt.Errorf("mirror max size must be greater than 0")
+	}
+	if c.MirrorInterval < 0 {
+		return fmt.Errorf("mirror interval must be non-negative")
+	}
+	if c.GCInterval < 0 {
+		return fmt.Errorf("gc interval must be non-negative")
+	}
+	if c.GCMarkAfter < time.Hour {
+		return fmt.Errorf("gc mark after must be at least 1h, to not mark blobs that are being published")
+	}
+	if c.GCDeleteAfter < time.Hour {
+		return fmt.Errorf("gc delete after must be at least 1h")
+	}
+
+	if c.ReconcileInterval < 0 {
+		return fmt.Errorf("reconcile interval must be non-negative")
+	}
+
+	if c.SessionExpiry < time.Minute {
+		return fmt.Errorf("session expiry must be at least 1m")
+	}
+	if c.SessionMaxSize <= 0 {
+		return fmt.Errorf("session max size must be greater than 0")
+	}
+	if c.SessionsPerPubkey <= 0 {
+		return fmt.Errorf("sessions per pubkey must be greater than 0")
+	}
+	if c.ChunkMaxSize < 1<<20 {
+		return fmt.Errorf("chunk max size must be at least 1 MB")
+	}
+	if c.ImageMaxSize <= 0 {
+		return fmt.Errorf("image max size must be greater than 0")
+	}
+	if c.ImageMaxDimension <= 0 {
+		return fmt.Errorf("image max dimension must be greater than 0")
+	}
+	for i, width := range c.ThumbnailWidths {
+		if width <= 0 || width > c.ImageMaxDimension {
+			return fmt.Errorf("thumbnail width %d must be between 1 and the image max dimension", width)
+		}
+		if slices.Contains(c.ThumbnailWidths[:i], width) {
+			return fmt.Errorf("thumbnail width %d is repeated", width)
+		}
+	}
+
 	for _, mime := range c.AllowedMedia {
 		if mime == "" {
 			return fmt.Errorf("allowed media type is empty")
 		}
 	}
 
-	if err := c.Bunny.Validate(); err != nil {
-		return fmt.Errorf("bunny: %w", err)
+	for mime, size := range c.MediaMaxSizes {
+		if !strings.Contains(mime, "/") {
+			return fmt.Errorf("media type %q of the max sizes must be a type or a wildcard like \"image/*\"", mime)
+		}
+		if size <= 0 {
+			return fmt.Errorf("max size of %q must be greater than 0", mime)
+		}
+	}
+	if c.QuotaDefault < 0 {
+		return fmt.Errorf("default quota must be non-negative")
+	}
+	if c.QuotaAllowed < 0 {
+		return fmt.Errorf("allowed quota must be non-negative")
+	}
+
+	for _, pk := range c.OperatorPubkeys {
+		if !nostr.IsValidPublicKey(pk) {
+			return fmt.Errorf("invalid operator pubkey %q", pk)
+		}
+	}
+
+	if err := c.validateBackend(c.Backend); err != nil {
+		return err
+	}
+
+	for i, replica := range c.Replicas {
+		if replica == c.Backend {
+			return fmt.Errorf("replica %q must be different from the primary backend", replica)
+		}
+		if slices.Contains(c.Replicas[:i], replica) {
+			return fmt.Errorf("replica %q is repeated", replica)
+		}
+		if err := c.validateBackend(replica); err != nil {
+			return fmt.Errorf("replica: %w", err)
+		}
+	}
+
+	if len(c.Replicas) > 0 && c.HealthInterval < time.Second {
+		return fmt.Errorf("health interval must be at least 1s")
+	}
+	if c.RepairInterval < 0 {
+		return fmt.Errorf("repair interval must be non-negative")
+	}
+	return nil
+}
+
+// MediaMaxSize returns the maximum size in bytes of the blobs of the media type, preferring
+// an exact match over a wildcard. It returns false if the type has no maximum.
+func (c Config) MediaMaxSize(mime string) (int64, bool) {
+	if size, ok := c.MediaMaxSizes[mime]; ok {
+		return size, true
+	}
+	if i := strings.IndexByte(mime, '/'); i > 0 {
+		size, ok := c.MediaMaxSizes[mime[:i]+"/*"]
+		return size, ok
+	}
+	return 0, false
+}
+
+// validateBackend validates the config of the named storage backend.
+func (c Config) validateBackend(name string) error {
+	switch name {
+	case BackendBunny:
+		if err := c.Bunny.Validate(); err != nil {
+			return fmt.Errorf("bunny: %w", err)
+		}
+	case BackendLocal:
+		if err := c.Local.Validate(); err != nil {
+			return fmt.Errorf("local: %w", err)
+		}
+	case BackendS3:
+		if err := c.S3.Validate(); err != nil {
+			return fmt.Errorf("s3: %w", err)
+		}
+	default:
+		return fmt.Errorf("backend must be %q, %q or %q, got %q", BackendBunny, BackendLocal, BackendS3, name)
 	}
 	return nil
 }
@@ -74,6 +309,48 @@ func (c Config) String() string {
 		"\tHostname: %s\n"+
 		"\tAddress: %s\n"+
 		"\tAllowed Media: %v\n"+
+		"\tOperator Pubkeys: %v\n"+
+		"\tMedia Max Sizes: %v\n"+
+		"\tQuota Default: %d\n"+
+		"\tQuota Allowed: %d\n"+
 		"\tStall Timeout: %v\n"+
-		c.Bunny.String(), c.Hostname, c.Address, c.AllowedMedia, c.StallTimeout)
+		"\tMirror Max Size: %d\n"+
+		"\tMirror Interval: %v\n"+
+		"\tGC Interval: %v\n"+
+		"\tGC Mark After: %v\n"+
+		"\tGC Delete After: %v\n"+
+		"\tReconcile Interval: %v\n"+
+		"\tSession Expiry: %v\n"+
+		"\tSession Max Size: %d\n"+
+		"\tSessions Per Pubkey: %d\n"+
+		"\tChunk Max Size: %d\n"+
+		"\tImage Max Size: %d\n"+
+		"\tImage Max Dimension: %d\n"+
+		"\tThumbnail Widths: %v\n"+
+		"\tBackend: %s\n"+
+		"\tReplicas: %v\n"+
+		"\tHealth Interval: %v\n"+
+		"\tRepair Interval: %v\n"+
+		c.backendsString(), c.Hostname, c.Address, c.AllowedMedia, c.OperatorPubkeys, c.MediaMaxSizes, c.QuotaDefault, c.QuotaAllowed, c.StallTimeout, c.MirrorMaxSize, c.MirrorInterval, c.GCInterval, c.GCMarkAfter, c.GCDeleteAfter, c.ReconcileInterval, c.SessionExpiry, c.SessionMaxSize, c.SessionsPerPubkey, c.ChunkMaxSize, c.ImageMaxSize, c.ImageMaxDimension, c.ThumbnailWidths, c.Backend, c.Replicas, c.HealthInterval, c.RepairInterval)
+}
+
+// backendsString returns the string representation of the configs of the primary backend and the replicas.
+func (c Config) backendsString() string {
+	s := c.backendString(c.Backend)
+	for _, replica := range c.Replicas {
+		s += c.backendString(replica)
+	}
+	return s
+}
+
+// backendString returns the string representation of the config of the named backend.
+func (c Config) backendString(name string) string {
+	switch name {
+	case BackendLocal:
+		return c.Local.String()
+	case BackendS3:
+		return c.S3.String()
+	default:
+		return c.Bunny.String()
+	}
 }
@@ -0,0 +1,146 @@
+package exe
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+func inspectZip(r io.ReaderAt, size int64) (Report, error) {
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return Report{}, fmt.Errorf("invalid zip archive: %w", err)
+	}
+
+	s := &spool{}
+	defer s.Close()
+
+	report := Report{Format: FormatZip}
+	for _, file := range archive.File {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		if len(report.Files) == maxFiles {
+			break
+		}
+
+		name := cleanPath(file.Name)
+		report.Files = append(report.Files, name)
+
+		rc, err := file.Open()
+		if err != nil {
+			return Report{}, fmt.Errorf("failed to open %s: %w", name, err)
+		}
+		binaries, err := s.inspect(rc, int64(file.UncompressedSize64), name)
+		rc.Close()
+		if err != nil {
+			return Report{}, err
+		}
+		report.Binaries = append(report.Binaries, binaries...)
+	}
+	return report, nil
+}
+
+func inspectTar(r io.Reader, compressed bool) (Report, error) {
+	if compressed {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return Report{}, fmt.Errorf("invalid gzip stream: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	s := &spool{}
+	defer s.Close()
+
+	report := Report{Format: FormatTar}
+	archive := tar.NewReader(r)
+	for len(report.Files) < maxFiles {
+		header, err := archive.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Report{}, fmt.Errorf("invalid tar archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := cleanPath(header.Name)
+		report.Files = append(report.Files, name)
+
+		binaries, err := s.inspect(archive, header.Size, name)
+		if err != nil {
+			return Report{}, err
+		}
+		report.Binaries = append(report.Binaries, binaries...)
+	}
+	return report, nil
+}
+
+// cleanPath returns the path of the file relative to the root of the archive, without leading "./" or "/".
+func cleanPath(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+// spool inspects the files of an archive that look like binaries, which are parsed from a temporary file
+// because the parsers require random access. The file is created lazily, and reused for every binary.
+type spool struct {
+	file *os.File
+}
+
+// inspect returns the binaries of the file at the path of the archive, or none if it doesn't look like a binary,
+// can't be parsed, or is larger than [maxBinarySize]. Errors are returned only if the spool fails.
+func (s *spool) inspect(data io.Reader, size int64, path string) ([]Binary, error) {
+	buffered := bufio.NewReaderSize(data, 512)
+	header, _ := buffered.Peek(4)
+
+	format := Sniff(header)
+	if format != FormatELF && format != FormatMachO && format != FormatPE {
+		return nil, nil
+	}
+	if size > maxBinarySize {
+		return nil, nil
+	}
+
+	if s.file == nil {
+		file, err := os.CreateTemp("", "exe-inspect-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create spool file: %w", err)
+		}
+		s.file = file
+	}
+	if err := s.file.Truncate(0); err != nil {
+		return nil, fmt.Errorf("failed to truncate spool file: %w", err)
+	}
+
+	n, err := io.Copy(io.NewOffsetWriter(s.file, 0), io.LimitReader(buffered, maxBinarySize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to spool %s: %w", path, err)
+	}
+
+	binaries, err := parse(io.NewSectionReader(s.file, 0, n), format, path)
+	if err != nil {
+		// e.g. a Java class file, or a text file starting with "MZ"
+		return nil, nil
+	}
+	return binaries, nil
+}
+
+// Close removes the spool file, if it was created.
+func (s *spool) Close() {
+	if s.file == nil {
+		return
+	}
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
@@ -0,0 +1,162 @@
+package exe
+
+import (
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// Mach-O load commands that declare the platform, which are not parsed by debug/macho.
+	loadBuildVersion     = 0x32
+	loadVersionMinIPhone = 0x25
+
+	platformIOS               = 2
+	platformIOSSimulator      = 7
+	platformVisionOS          = 11
+	platformVisionOSSimulator = 12
+)
+
+var (
+	elfArches = map[elf.Machine]string{
+		elf.EM_X86_64:  "x86_64",
+		elf.EM_AARCH64: "aarch64",
+		elf.EM_ARM:     "armv7l",
+		elf.EM_386:     "i686",
+	}
+
+	machoArches = map[macho.Cpu]string{
+		macho.CpuAmd64: "x86_64",
+		macho.CpuArm64: "arm64",
+		macho.Cpu386:   "i386",
+		macho.CpuArm:   "arm",
+	}
+
+	peArches = map[uint16]string{
+		pe.IMAGE_FILE_MACHINE_AMD64: "x86_64",
+		pe.IMAGE_FILE_MACHINE_ARM64: "aarch64",
+		pe.IMAGE_FILE_MACHINE_I386:  "x86",
+		pe.IMAGE_FILE_MACHINE_ARMNT: "armv7",
+	}
+)
+
+// parse returns the binaries of the file in the given format, which are more than one for Mach-O universal binaries.
+// Files that are not executables or shared libraries (e.g. object files) have no binaries.
+func parse(r io.ReaderAt, format, path string) ([]Binary, error) {
+	switch format {
+	case FormatELF:
+		return parseELF(r, path)
+	case FormatMachO:
+		return parseMachO(r, path)
+	case FormatPE:
+		return parsePE(r, path)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+func parseELF(r io.ReaderAt, path string) ([]Binary, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return nil, nil
+	}
+
+	arch, ok := elfArches[f.Machine]
+	switch {
+	case ok:
+	case f.Machine == elf.EM_RISCV && f.Class == elf.ELFCLASS64:
+		arch = "riscv64"
+	default:
+		arch = strings.ToLower(strings.TrimPrefix(f.Machine.String(), "EM_"))
+	}
+
+	os := "linux"
+	switch f.OSABI {
+	case elf.ELFOSABI_FREEBSD:
+		os = "freebsd"
+	case elf.ELFOSABI_NETBSD:
+		os = "netbsd"
+	case elf.ELFOSABI_OPENBSD:
+		os = "openbsd"
+	}
+	return []Binary{{Path: path, OS: os, Arch: arch}}, nil
+}
+
+func parseMachO(r io.ReaderAt, path string) ([]Binary, error) {
+	fat, err := macho.NewFatFile(r)
+	if err == nil {
+		var binaries []Binary
+		for _, arch := range fat.Arches {
+			if b, ok := machoBinary(arch.File, path); ok {
+				binaries = append(binaries, b)
+			}
+		}
+		return binaries, nil
+	}
+	if !errors.Is(err, macho.ErrNotFat) {
+		return nil, err
+	}
+
+	f, err := macho.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := machoBinary(f, path); ok {
+		return []Binary{b}, nil
+	}
+	return nil, nil
+}
+
+// machoBinary returns the binary of the Mach-O file, or false if it's not an executable or a library.
+func machoBinary(f *macho.File, path string) (Binary, bool) {
+	if f.Type != macho.TypeExec && f.Type != macho.TypeDylib && f.Type != macho.TypeBundle {
+		return Binary{}, false
+	}
+
+	arch, ok := machoArches[f.Cpu]
+	if !ok {
+		arch = strings.ToLower(strings.TrimPrefix(f.Cpu.String(), "Cpu"))
+	}
+	return Binary{Path: path, OS: machoOS(f), Arch: arch}, true
+}
+
+// machoOS returns "ios" for binaries built for iOS or visionOS, and "darwin" otherwise.
+func machoOS(f *macho.File) string {
+	for _, load := range f.Loads {
+		raw := load.Raw()
+		if len(raw) < 12 {
+			continue
+		}
+
+		switch f.ByteOrder.Uint32(raw) {
+		case loadVersionMinIPhone:
+			return "ios"
+		case loadBuildVersion:
+			switch f.ByteOrder.Uint32(raw[8:]) {
+			case platformIOS, platformIOSSimulator, platformVisionOS, platformVisionOSSimulator:
+				return "ios"
+			}
+		}
+	}
+	return "darwin"
+}
+
+func parsePE(r io.ReaderAt, path string) ([]Binary, error) {
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+
+	arch, ok := peArches[f.Machine]
+	if !ok {
+		arch = fmt.Sprintf("%#x", f.Machine)
+	}
+	return []Binary{{Path: path, OS: "windows", Arch: arch}}, nil
+}
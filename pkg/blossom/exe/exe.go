@@ -0,0 +1,149 @@
+// The exe package is responsible for inspecting desktop and CLI binaries.
+// It exposes an [Inspect] function that finds the ELF, Mach-O and PE binaries of a blob, which is either
+// a binary or a zip or tar archive, and reports their OS and architecture along with the archive file list.
+package exe
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+const (
+	FormatELF   = "elf"
+	FormatMachO = "macho"
+	FormatPE    = "pe"
+	FormatZip   = "zip"
+	FormatTar   = "tar"
+
+	// maxFiles is the maximum number of files of an archive that are listed and inspected.
+	maxFiles = 100_000
+
+	// maxBinarySize is the maximum size of a binary inside an archive that is inspected.
+	maxBinarySize = 512 << 20
+)
+
+var ErrUnknownFormat = errors.New("not a binary or a supported archive")
+
+// MimeTypes are the types of the blobs that are inspected.
+var MimeTypes = []string{
+	"application/x-executable",
+	"application/x-elf",
+	"application/x-sharedlib",
+	"application/x-mach-binary",
+	"application/vnd.microsoft.portable-executable",
+	"application/x-msdownload",
+	"application/x-dosexec",
+	"application/zip",
+	"application/x-tar",
+	"application/gzip",
+	"application/x-gzip",
+	"application/x-gtar",
+	"application/x-compressed-tar",
+}
+
+// Binary is an executable or a shared library.
+type Binary struct {
+	Path string `json:"path,omitempty"` // path in the archive, empty if the blob is the binary itself
+	OS   string `json:"os"`             // e.g. "linux", "darwin" or "windows"
+	Arch string `json:"arch"`           // named like in the NIP-82 platform identifiers of the OS, e.g. "aarch64" on linux but "arm64" on darwin
+}
+
+// Platform returns the NIP-82 platform identifier of the binary, e.g. "linux-x86_64".
+func (b Binary) Platform() string {
+	return b.OS + "-" + b.Arch
+}
+
+// Report holds the binaries found in a blob and, if it's an archive, its file list.
+type Report struct {
+	Format   string
+	Binaries []Binary
+	Files    []string // regular files of the archive, empty if the blob is not an archive
+}
+
+// IsArchive returns whether the blob is an archive.
+func (r Report) IsArchive() bool {
+	return r.Format == FormatZip || r.Format == FormatTar
+}
+
+// Platforms returns the sorted NIP-82 platform identifiers of the binaries.
+func (r Report) Platforms() []string {
+	var platforms []string
+	for _, b := range r.Binaries {
+		if p := b.Platform(); !slices.Contains(platforms, p) {
+			platforms = append(platforms, p)
+		}
+	}
+	slices.Sort(platforms)
+	return platforms
+}
+
+// Inspect returns the [Report] of the blob of the given size, whose format is detected from its content.
+// Files of an archive that look like binaries but can't be parsed are listed, but ignored.
+func Inspect(r io.ReaderAt, size int64) (Report, error) {
+	header := make([]byte, 512)
+	n, err := r.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return Report{}, fmt.Errorf("failed to read header: %w", err)
+	}
+	header = header[:n]
+
+	switch format := Sniff(header); format {
+	case FormatZip:
+		return inspectZip(r, size)
+
+	case FormatTar:
+		return inspectTar(io.NewSectionReader(r, 0, size), isGzip(header))
+
+	case FormatELF, FormatMachO, FormatPE:
+		binaries, err := parse(r, format, "")
+		if err != nil {
+			return Report{}, fmt.Errorf("invalid %s binary: %w", format, err)
+		}
+		return Report{Format: format, Binaries: binaries}, nil
+
+	default:
+		return Report{}, ErrUnknownFormat
+	}
+}
+
+// Sniff returns the format of the data from its first bytes, or an empty string if it's not recognized.
+// Gzip compressed data is assumed to be a tar archive.
+func Sniff(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("\x7fELF")):
+		return FormatELF
+	case isMachO(header):
+		return FormatMachO
+	case bytes.HasPrefix(header, []byte("MZ")):
+		return FormatPE
+	case bytes.HasPrefix(header, []byte("PK\x03\x04")), bytes.HasPrefix(header, []byte("PK\x05\x06")):
+		return FormatZip
+	case isGzip(header):
+		return FormatTar
+	case len(header) >= 262 && string(header[257:262]) == "ustar":
+		return FormatTar
+	default:
+		return ""
+	}
+}
+
+func isGzip(header []byte) bool {
+	return bytes.HasPrefix(header, []byte{0x1f, 0x8b})
+}
+
+// isMachO returns whether the data starts with the magic of a 32 or 64 bit Mach-O file, in either byte order,
+// or of a universal binary. The latter is shared with Java class files, which then fail to parse.
+func isMachO(header []byte) bool {
+	if len(header) < 4 {
+		return false
+	}
+	switch string(header[:4]) {
+	case "\xfe\xed\xfa\xce", "\xce\xfa\xed\xfe", "\xfe\xed\xfa\xcf", "\xcf\xfa\xed\xfe", "\xca\xfe\xba\xbe":
+		return true
+	default:
+		return false
+	}
+}
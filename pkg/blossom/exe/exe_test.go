@@ -0,0 +1,255 @@
+package exe
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestInspect(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected Report
+		err      error
+	}{
+		{
+			name:     "elf x86_64",
+			data:     elfFile(elf.EM_X86_64, elf.ET_DYN, elf.ELFOSABI_NONE),
+			expected: Report{Format: FormatELF, Binaries: []Binary{{OS: "linux", Arch: "x86_64"}}},
+		},
+		{
+			name:     "elf riscv64",
+			data:     elfFile(elf.EM_RISCV, elf.ET_EXEC, elf.ELFOSABI_NONE),
+			expected: Report{Format: FormatELF, Binaries: []Binary{{OS: "linux", Arch: "riscv64"}}},
+		},
+		{
+			name:     "elf freebsd",
+			data:     elfFile(elf.EM_AARCH64, elf.ET_EXEC, elf.ELFOSABI_FREEBSD),
+			expected: Report{Format: FormatELF, Binaries: []Binary{{OS: "freebsd", Arch: "aarch64"}}},
+		},
+		{
+			name:     "elf object file",
+			data:     elfFile(elf.EM_X86_64, elf.ET_REL, elf.ELFOSABI_NONE),
+			expected: Report{Format: FormatELF},
+		},
+		{
+			name:     "mach-o arm64",
+			data:     machoFile(macho.CpuArm64, 0),
+			expected: Report{Format: FormatMachO, Binaries: []Binary{{OS: "darwin", Arch: "arm64"}}},
+		},
+		{
+			name:     "mach-o ios",
+			data:     machoFile(macho.CpuArm64, platformIOS),
+			expected: Report{Format: FormatMachO, Binaries: []Binary{{OS: "ios", Arch: "arm64"}}},
+		},
+		{
+			name: "mach-o universal",
+			data: fatFile(machoFile(macho.CpuAmd64, 1), machoFile(macho.CpuArm64, 1)),
+			expected: Report{Format: FormatMachO, Binaries: []Binary{
+				{OS: "darwin", Arch: "x86_64"},
+				{OS: "darwin", Arch: "arm64"},
+			}},
+		},
+		{
+			name:     "pe arm64",
+			data:     peFile(pe.IMAGE_FILE_MACHINE_ARM64),
+			expected: Report{Format: FormatPE, Binaries: []Binary{{OS: "windows", Arch: "aarch64"}}},
+		},
+		{
+			name: "zip",
+			data: zipFiles(t, []file{
+				{name: "app/bin/app", data: elfFile(elf.EM_AARCH64, elf.ET_EXEC, elf.ELFOSABI_NONE)},
+				{name: "app/README.md", data: []byte("# app")},
+				{name: "app/MZ.txt", data: []byte("MZ is not a binary")},
+			}),
+			expected: Report{
+				Format:   FormatZip,
+				Binaries: []Binary{{Path: "app/bin/app", OS: "linux", Arch: "aarch64"}},
+				Files:    []string{"app/bin/app", "app/README.md", "app/MZ.txt"},
+			},
+		},
+		{
+			name: "tar.gz",
+			data: tarFiles(t, true, []file{
+				{name: "./app", data: machoFile(macho.CpuAmd64, 0)},
+				{name: "./lib/app.dll", data: peFile(pe.IMAGE_FILE_MACHINE_AMD64)},
+			}),
+			expected: Report{
+				Format: FormatTar,
+				Binaries: []Binary{
+					{Path: "app", OS: "darwin", Arch: "x86_64"},
+					{Path: "lib/app.dll", OS: "windows", Arch: "x86_64"},
+				},
+				Files: []string{"app", "lib/app.dll"},
+			},
+		},
+		{
+			name:     "tar without binaries",
+			data:     tarFiles(t, false, []file{{name: "app.py", data: []byte("print('hi')")}}),
+			expected: Report{Format: FormatTar, Files: []string{"app.py"}},
+		},
+		{
+			name: "not a binary",
+			data: []byte("#!/bin/sh\necho hi\n"),
+			err:  ErrUnknownFormat,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			report, err := Inspect(bytes.NewReader(test.data), int64(len(test.data)))
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if !reflect.DeepEqual(report, test.expected) {
+				t.Fatalf("expected report %+v, got %+v", test.expected, report)
+			}
+		})
+	}
+}
+
+func TestPlatforms(t *testing.T) {
+	report := Report{Binaries: []Binary{
+		{Path: "x86_64/app", OS: "linux", Arch: "x86_64"},
+		{Path: "arm64/app", OS: "linux", Arch: "aarch64"},
+		{Path: "x86_64/libapp.so", OS: "linux", Arch: "x86_64"},
+	}}
+
+	expected := []string{"linux-aarch64", "linux-x86_64"}
+	if platforms := report.Platforms(); !reflect.DeepEqual(platforms, expected) {
+		t.Fatalf("expected platforms %v, got %v", expected, platforms)
+	}
+}
+
+// elfFile returns a 64-bit little-endian ELF header without program or section headers.
+func elfFile(machine elf.Machine, kind elf.Type, abi elf.OSABI) []byte {
+	header := elf.Header64{
+		Type:    uint16(kind),
+		Machine: uint16(machine),
+		Version: uint32(elf.EV_CURRENT),
+		Ehsize:  64,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	header.Ident[elf.EI_OSABI] = byte(abi)
+	return encode(header)
+}
+
+// machoFile returns a 64-bit Mach-O executable, with an LC_BUILD_VERSION load command if the platform is not 0.
+func machoFile(cpu macho.Cpu, platform uint32) []byte {
+	var loads []byte
+	if platform != 0 {
+		loads = encode([]uint32{loadBuildVersion, 24, platform, 0, 0, 0})
+	}
+
+	header := macho.FileHeader{
+		Magic: macho.Magic64,
+		Cpu:   cpu,
+		Type:  macho.TypeExec,
+		Cmdsz: uint32(len(loads)),
+	}
+	if platform != 0 {
+		header.Ncmd = 1
+	}
+	return append(append(encode(header), 0, 0, 0, 0), loads...) // the 64-bit header has a reserved field
+}
+
+// fatFile returns a universal binary of the Mach-O files.
+func fatFile(files ...[]byte) []byte {
+	const align = 12 // 4096 bytes
+	header := binary.BigEndian.AppendUint32(nil, macho.MagicFat)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(files)))
+
+	var body []byte
+	offset := uint32(1 << align)
+	for _, f := range files {
+		cpu := binary.LittleEndian.Uint32(f[4:])
+		header = binary.BigEndian.AppendUint32(header, cpu)
+		header = binary.BigEndian.AppendUint32(header, 0)
+		header = binary.BigEndian.AppendUint32(header, offset)
+		header = binary.BigEndian.AppendUint32(header, uint32(len(f)))
+		header = binary.BigEndian.AppendUint32(header, align)
+
+		padded := append(f, make([]byte, 1<<align-len(f))...)
+		body = append(body, padded...)
+		offset += 1 << align
+	}
+	return append(append(header, make([]byte, 1<<align-len(header))...), body...)
+}
+
+// peFile returns a PE file with a DOS header and a COFF header, without sections or optional header.
+func peFile(machine uint16) []byte {
+	data := make([]byte, 0x40)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[0x3c:], 0x40)
+	data = append(data, "PE\x00\x00"...)
+	data = append(data, encode(pe.FileHeader{Machine: machine})...)
+	return append(data, make([]byte, 64)...) // padding read by the parser after the headers
+}
+
+func encode(v any) []byte {
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+type file struct {
+	name string
+	data []byte
+}
+
+func zipFiles(t *testing.T, files []file) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for _, f := range files {
+		fw, err := w.Create(f.name)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", f.name, err)
+		}
+		fw.Write(f.data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func tarFiles(t *testing.T, compressed bool, files []file) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	var gz *gzip.Writer
+	w := tar.NewWriter(buf)
+	if compressed {
+		gz = gzip.NewWriter(buf)
+		w = tar.NewWriter(gz)
+	}
+
+	w.WriteHeader(&tar.Header{Name: "./lib/", Typeflag: tar.TypeDir, Mode: 0755})
+	for _, f := range files {
+		if err := w.WriteHeader(&tar.Header{Name: f.name, Size: int64(len(f.data)), Mode: 0755}); err != nil {
+			t.Fatalf("failed to write header of %s: %v", f.name, err)
+		}
+		w.Write(f.data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,205 @@
+package blossom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+// ErrNoReferences is returned by [GC.Collect] when no event references any blob, but there are blobs.
+// This is most likely caused by a wrong or empty relay database, so the collection is aborted.
+var ErrNoReferences = errors.New("no blob is referenced by any event")
+
+// References is the source of the blob hashes that are still in use.
+type References interface {
+	// ReferencedHashes returns the set of lowercase hex hashes referenced by events.
+	ReferencedHashes(ctx context.Context) (map[string]struct{}, error)
+}
+
+// Deleter deletes blobs from the storage.
+type Deleter interface {
+	Delete(ctx context.Context, path string) error
+}
+
+// GC is the garbage collector of blobs not referenced by any event.
+//
+// Collection happens in two phases: blobs older than [Config.GCMarkAfter] that are not referenced are marked,
+// and marked blobs that are still not referenced after [Config.GCDeleteAfter] are deleted from the storage
+// and the database. Marked blobs that become referenced again are unmarked.
+type GC struct {
+	config  Config
+	store   *store.T
+	storage Deleter
+	refs    References
+}
+
+// GCReport summarizes the result of a [GC.Collect].
+type GCReport struct {
+	Blobs        int              // blobs in the database when the collection started
+	Referenced   int              // blobs referenced by at least one event
+	Marked       []store.BlobMeta // blobs marked for deletion in this collection
+	Unmarked     int              // marked blobs that became referenced again
+	Deleted      []store.BlobMeta // blobs deleted in this collection
+	DeletedBytes int64            // total size of the deleted blobs
+	Reclaimable  int64            // total size of the blobs that remain marked after the collection
+	Failed       int              // blobs whose deletion failed, and that will be retried
+}
+
+// NewGC creates a new garbage collector of the blobs in the store.
+func NewGC(config Config, store *store.T, storage Deleter, refs References) *GC {
+	return &GC{
+		config:  config,
+		store:   store,
+		storage: storage,
+		refs:    refs,
+	}
+}
+
+// Run collects garbage every [Config.GCInterval], until the context gets cancelled.
+func (g *GC) Run(ctx context.Context) {
+	ticker := time.NewTicker(g.config.GCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			report, err := g.Collect(ctx, false)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("blossom: garbage collection failed", "error", err)
+				continue
+			}
+
+			slog.Info("blossom: garbage collection completed",
+				"blobs", report.Blobs,
+				"marked", len(report.Marked),
+				"unmarked", report.Unmarked,
+				"deleted", len(report.Deleted),
+				"deleted_bytes", report.DeletedBytes,
+				"reclaimable_bytes", report.Reclaimable,
+				"failed", report.Failed,
+			)
+		}
+	}
+}
+
+// Collect marks, unmarks and deletes blobs according to the events referencing them.
+// In dry-run mode nothing is changed, and the report describes what would have been done.
+func (g *GC) Collect(ctx context.Context, dryRun bool) (GCReport, error) {
+	var report GCReport
+	blobs, err := g.store.All(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	// references are fetched after the blobs, so that a blob uploaded and referenced
+	// in between is never considered unreferenced.
+	refs, err := g.refs.ReferencedHashes(ctx)
+	if err != nil {
+		return report, fmt.Errorf("failed to fetch references: %w", err)
+	}
+	if len(refs) == 0 && len(blobs) > 0 {
+		return report, ErrNoReferences
+	}
+
+	// events published before a blob was rewritten (e.g. an image without its metadata)
+	// may reference it by one of its aliases.
+	aliases, err := g.store.Aliases(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	// variants are never referenced by events, so they are kept as long as their original image is referenced.
+	origins, err := g.store.VariantOrigins(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	now := time.Now().UTC()
+	report.Blobs = len(blobs)
+
+	for _, blob := range blobs {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		referenced := isReferenced(refs, blob.Hash, aliases[blob.Hash])
+		if origin, ok := origins[blob.Hash]; ok && !referenced {
+			referenced = isReferenced(refs, origin, aliases[origin])
+		}
+		marked := !blob.MarkedAt.IsZero()
+
+		switch {
+		case referenced:
+			report.Referenced++
+			if marked {
+				report.Unmarked++
+				if !dryRun {
+					if err := g.store.Unmark(ctx, blob.Hash); err != nil {
+						return report, err
+					}
+				}
+			}
+
+		case !marked:
+			if now.Sub(blob.CreatedAt) < g.config.GCMarkAfter {
+				continue
+			}
+
+			report.Marked = append(report.Marked, blob)
+			report.Reclaimable += blob.Size
+			if !dryRun {
+				if err := g.store.Mark(ctx, blob.Hash, now); err != nil {
+					return report, err
+				}
+			}
+
+		case now.Sub(blob.MarkedAt) < g.config.GCDeleteAfter:
+			report.Reclaimable += blob.Size
+
+		default:
+			if !dryRun {
+				if err := g.delete(ctx, blob); err != nil {
+					slog.Error("blossom: failed to delete unreferenced blob", "error", err, "hash", blob.Hash)
+					report.Reclaimable += blob.Size
+					report.Failed++
+					continue
+				}
+			}
+
+			report.Deleted = append(report.Deleted, blob)
+			report.DeletedBytes += blob.Size
+		}
+	}
+	return report, nil
+}
+
+// isReferenced returns whether the hash or any of its aliases is referenced.
+func isReferenced(refs map[string]struct{}, hash Hash, aliases []Hash) bool {
+	if _, ok := refs[hash.Hex()]; ok {
+		return true
+	}
+	for _, alias := range aliases {
+		if _, ok := refs[alias.Hex()]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// delete removes the blob from the storage first, then from the database,
+// so that a failure never leaves a stored blob without its metadata.
+func (g *GC) delete(ctx context.Context, blob store.BlobMeta) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	if err := g.storage.Delete(ctx, BlobPath(blob.Hash, blob.Type)); err != nil {
+		return fmt.Errorf("failed to delete blob from storage: %w", err)
+	}
+	return g.store.Delete(ctx, blob.Hash)
+}
@@ -0,0 +1,168 @@
+package blossom
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+var ctx = context.Background()
+
+type mockReferences map[string]struct{}
+
+func (m mockReferences) ReferencedHashes(ctx context.Context) (map[string]struct{}, error) {
+	return m, nil
+}
+
+type mockDeleter struct {
+	deleted []string
+}
+
+func (m *mockDeleter) Delete(ctx context.Context, path string) error {
+	m.deleted = append(m.deleted, path)
+	return nil
+}
+
+func hashes(blobs []store.BlobMeta) []string {
+	var hexes []string
+	for _, blob := range blobs {
+		hexes = append(hexes, blob.Hash.Hex())
+	}
+	slices.Sort(hexes)
+	return hexes
+}
+
+func TestGCCollect(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "blossom.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now().UTC()
+	blob := func(content string, age time.Duration, markedAgo time.Duration) blossom.Hash {
+		hash := blossom.ComputeHash([]byte(content))
+		meta := store.BlobMeta{Hash: hash, Type: "image/png", Size: 100, CreatedAt: now.Add(-age)}
+		if _, err := db.Save(ctx, meta); err != nil {
+			t.Fatalf("failed to save blob: %v", err)
+		}
+		if markedAgo > 0 {
+			if err := db.Mark(ctx, hash, now.Add(-markedAgo)); err != nil {
+				t.Fatalf("failed to mark blob: %v", err)
+			}
+		}
+		return hash
+	}
+
+	day := 24 * time.Hour
+	referenced := blob("referenced", 30*day, 0)
+	rereferenced := blob("referenced again", 30*day, 2*day)
+	recent := blob("recent", time.Hour, 0)
+	unreferenced := blob("unreferenced", 2*day, 0)
+	expired := blob("expired", 30*day, 8*day)
+	marked := blob("marked", 30*day, 2*day)
+	sanitized := blob("sanitized", 30*day, 0)
+
+	// the sanitized blob is referenced by the hash of the original upload
+	original := blossom.ComputeHash([]byte("original"))
+	if err := db.SaveAlias(ctx, original, sanitized); err != nil {
+		t.Fatalf("failed to save alias: %v", err)
+	}
+
+	// the thumbnail is referenced through its original image
+	thumbnail := blob("thumbnail", 30*day, 0)
+	if err := db.SaveVariant(ctx, referenced, 128, thumbnail); err != nil {
+		t.Fatalf("failed to save variant: %v", err)
+	}
+
+	refs := mockReferences{referenced.Hex(): {}, rereferenced.Hex(): {}, original.Hex(): {}}
+	deleter := &mockDeleter{}
+	gc := NewGC(NewConfig(), db, deleter, refs)
+
+	for _, dryRun := range []bool{true, false} {
+		report, err := gc.Collect(ctx, dryRun)
+		if err != nil {
+			t.Fatalf("Collect(%v) error = %v", dryRun, err)
+		}
+
+		if report.Blobs != 8 || report.Referenced != 4 || report.Unmarked != 1 {
+			t.Errorf("Collect(%v): unexpected report %+v", dryRun, report)
+		}
+		if expected := hashes([]store.BlobMeta{{Hash: unreferenced}}); !slices.Equal(hashes(report.Marked), expected) {
+			t.Errorf("Collect(%v): expected marked %v, got %v", dryRun, expected, hashes(report.Marked))
+		}
+		if expected := hashes([]store.BlobMeta{{Hash: expired}}); !slices.Equal(hashes(report.Deleted), expected) {
+			t.Errorf("Collect(%v): expected deleted %v, got %v", dryRun, expected, hashes(report.Deleted))
+		}
+		if report.DeletedBytes != 100 || report.Reclaimable != 200 {
+			t.Errorf("Collect(%v): expected 100 deleted and 200 reclaimable bytes, got %d and %d",
+				dryRun, report.DeletedBytes, report.Reclaimable)
+		}
+
+		if dryRun {
+			if len(deleter.deleted) > 0 {
+				t.Fatalf("dry run deleted %v", deleter.deleted)
+			}
+			if count, _, _ := db.Reclaimable(ctx); count != 3 {
+				t.Fatalf("dry run changed the marked blobs: expected 3, got %d", count)
+			}
+		}
+	}
+
+	if expected := []string{BlobPath(expired, "image/png")}; !slices.Equal(deleter.deleted, expected) {
+		t.Errorf("expected deleted paths %v, got %v", expected, deleter.deleted)
+	}
+	if _, err := db.Query(ctx, expired); !errors.Is(err, store.ErrBlobNotFound) {
+		t.Errorf("expected the expired blob to be deleted, got %v", err)
+	}
+
+	count, bytes, err := db.Reclaimable(ctx)
+	if err != nil {
+		t.Fatalf("Reclaimable() error = %v", err)
+	}
+	if count != 2 || bytes != 200 {
+		t.Errorf("expected 2 marked blobs of 200 bytes, got %d of %d bytes", count, bytes)
+	}
+
+	for _, hash := range []blossom.Hash{referenced, rereferenced, recent, sanitized, thumbnail} {
+		meta, err := db.Query(ctx, hash)
+		if err != nil {
+			t.Fatalf("failed to query blob: %v", err)
+		}
+		if !meta.MarkedAt.IsZero() {
+			t.Errorf("expected blob %s to not be marked", hash)
+		}
+	}
+
+	meta, err := db.Query(ctx, marked)
+	if err != nil {
+		t.Fatalf("failed to query blob: %v", err)
+	}
+	if !meta.MarkedAt.Equal(now.Add(-2 * day).Truncate(time.Second)) {
+		t.Errorf("expected the mark of a marked blob to be preserved, got %v", meta.MarkedAt)
+	}
+}
+
+func TestGCNoReferences(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "blossom.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	meta := store.BlobMeta{Hash: blossom.ComputeHash([]byte("blob")), Type: "image/png", Size: 100}
+	if _, err := db.Save(ctx, meta); err != nil {
+		t.Fatalf("failed to save blob: %v", err)
+	}
+
+	gc := NewGC(NewConfig(), db, &mockDeleter{}, mockReferences{})
+	if _, err := gc.Collect(ctx, false); !errors.Is(err, ErrNoReferences) {
+		t.Fatalf("expected error %v, got %v", ErrNoReferences, err)
+	}
+}
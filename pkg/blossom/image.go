@@ -0,0 +1,149 @@
+package blossom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/zapstore/relay/pkg/blossom/sanitize"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+var errImageTooLarge = errors.New("image exceeds the maximum size")
+
+// uploadImage reads the uploaded image, verifies its hash and stores it sanitized.
+func (b *T) uploadImage(
+	r blossy.Request,
+	hints blossy.UploadHints,
+	reader *stallReader,
+	data io.Reader,
+	mediaType string,
+) (blossom.BlobDescriptor, *blossom.Error) {
+
+	image, err := b.readImage(data)
+	if rErr := reader.Err(); rErr != nil {
+		// check if the error was caused by a context cancelled or stalled reader
+		return blossom.BlobDescriptor{}, &blossom.Error{Code: 499, Reason: rErr.Error()}
+	}
+	if errors.Is(err, errImageTooLarge) {
+		return blossom.BlobDescriptor{}, blossom.ErrTooLarge(err.Error())
+	}
+	if err != nil {
+		return blossom.BlobDescriptor{}, &blossom.Error{Code: 499, Reason: err.Error()}
+	}
+
+	if blossom.ComputeHash(image) != *hints.Hash {
+		// punish the client for providing a bad hash
+		cost := 200.0
+		b.limiter.Penalize(r.IP().Group(), cost)
+		return blossom.BlobDescriptor{}, blossom.ErrBadRequest("checksum mismatch")
+	}
+
+	// punish the client for providing bad hints.
+	if hints.Size < int64(len(image)) {
+		cost := 100.0
+		b.limiter.Penalize(r.IP().Group(), cost)
+	}
+
+	// Use a fresh context for the remaining operations to avoid orphaning blobs in the storage
+	// if the client disconnects after the upload completes, but before the metadata is saved.
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer saveCancel()
+
+	meta, bErr := b.storeImage(saveCtx, *hints.Hash, mediaType, image, r.Pubkey())
+	if bErr != nil {
+		return blossom.BlobDescriptor{}, bErr
+	}
+
+	if err := b.relay.NotifyUpload(meta.Hash, meta.Type); err != nil {
+		slog.Error("blossom: failed to notify relay of upload", "error", err, "hash", meta.Hash)
+	}
+
+	b.analytics.RecordUpload(r, hints)
+	return blossom.BlobDescriptor{
+		Hash:     meta.Hash,
+		Type:     meta.Type,
+		Size:     meta.Size,
+		Uploaded: meta.CreatedAt.Unix(),
+	}, nil
+}
+
+// readImage reads the whole image, which is sanitized in memory.
+// It returns [errImageTooLarge] if the image is larger than [Config.ImageMaxSize].
+func (b *T) readImage(data io.Reader) ([]byte, error) {
+	image, err := io.ReadAll(io.LimitReader(data, b.config.ImageMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(image)) > b.config.ImageMaxSize {
+		return nil, fmt.Errorf("%w of %d bytes", errImageTooLarge, b.config.ImageMaxSize)
+	}
+	return image, nil
+}
+
+// storeImage sanitizes the image whose hash is the original hash, which must have been verified,
+// and stores it with its metadata, unless a blob with the same content already exists.
+// Sanitizing changes the bytes of the image, so the blob is stored under the hash of the sanitized image,
+// and the original hash is saved as its alias, which still resolves to the blob when downloading it
+// or checking the events that reference it.
+func (b *T) storeImage(
+	ctx context.Context,
+	original blossom.Hash,
+	mediaType string,
+	image []byte,
+	pubkey string,
+) (store.BlobMeta, *blossom.Error) {
+
+	sanitized, err := sanitize.Image(mediaType, image, b.config.ImageMaxDimension)
+	if errors.Is(err, sanitize.ErrTooLarge) {
+		return store.BlobMeta{}, blossom.ErrTooLarge(err.Error())
+	}
+	if err != nil {
+		return store.BlobMeta{}, blossom.ErrBadRequest(err.Error())
+	}
+
+	meta := store.BlobMeta{
+		Hash:       blossom.ComputeHash(sanitized),
+		Type:       mediaType,
+		Size:       int64(len(sanitized)),
+		CreatedAt:  time.Now().UTC(),
+		AuthPubkey: pubkey,
+	}
+
+	existing, err := b.store.Query(ctx, meta.Hash)
+	switch {
+	case err == nil:
+		meta = existing
+
+	case errors.Is(err, store.ErrBlobNotFound):
+		name := BlobPath(meta.Hash, meta.Type)
+		if err := b.storage.Upload(ctx, bytes.NewReader(sanitized), meta.Size, name, meta.Hash.Hex()); err != nil {
+			slog.Error("blossom: failed to upload sanitized image", "error", err, "name", name)
+			return store.BlobMeta{}, ErrInternal
+		}
+		if _, err := b.store.Save(ctx, meta); err != nil {
+			slog.Error("blossom: failed to save blob metadata", "error", err, "hash", meta.Hash)
+			return store.BlobMeta{}, ErrInternal
+		}
+		b.enqueueReplication(meta)
+		b.enqueueThumbnails(meta)
+
+	default:
+		slog.Error("blossom: failed to query blob metadata", "error", err, "hash", meta.Hash)
+		return store.BlobMeta{}, ErrInternal
+	}
+
+	if meta.Hash != original {
+		if err := b.store.SaveAlias(ctx, original, meta.Hash); err != nil {
+			slog.Error("blossom: failed to save blob alias", "error", err, "alias", original, "hash", meta.Hash)
+			return store.BlobMeta{}, ErrInternal
+		}
+	}
+	return meta, nil
+}
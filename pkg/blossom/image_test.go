@@ -0,0 +1,63 @@
+package blossom
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+func TestStoreImage(t *testing.T) {
+	b, primary := newSessionServer(t)
+
+	clean := []byte(`<svg xmlns="http://www.w3.org/2000/svg"><rect width="1" height="1"></rect></svg>`)
+	dirty := []byte(`<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"><script>alert(2)</script><rect width="1" height="1"></rect></svg>`)
+
+	tests := []struct {
+		name   string
+		image  []byte
+		stored []byte
+		status int
+	}{
+		{name: "clean", image: clean, stored: clean},
+		{name: "sanitized", image: dirty, stored: clean},
+		{name: "invalid", image: []byte(`<svg><g></svg>`), status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			original := blossom.ComputeHash(test.image)
+			meta, err := b.storeImage(ctx, original, "image/svg+xml", test.image, "")
+			if test.status != 0 {
+				if err == nil || err.Code != test.status {
+					t.Fatalf("expected status %d, got %v", test.status, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("failed to store image: %v", err)
+			}
+
+			if expected := blossom.ComputeHash(test.stored); meta.Hash != expected {
+				t.Fatalf("expected the blob to be stored as %s, got %s", expected, meta.Hash)
+			}
+			if stored := primary.blobs[BlobPath(meta.Hash, meta.Type)]; !bytes.Equal(stored, test.stored) {
+				t.Fatalf("expected the storage to hold %s, got %s", test.stored, stored)
+			}
+
+			// the original hash resolves to the stored blob
+			resolved, qErr := b.store.Query(ctx, original)
+			if qErr != nil {
+				t.Fatalf("failed to query the original hash: %v", qErr)
+			}
+			if resolved.Hash != meta.Hash {
+				t.Fatalf("expected the original hash to resolve to %s, got %s", meta.Hash, resolved.Hash)
+			}
+		})
+	}
+
+	if len(primary.blobs) != 1 {
+		t.Fatalf("expected the sanitized image to be deduplicated, got %d blobs", len(primary.blobs))
+	}
+}
@@ -0,0 +1,110 @@
+package blossom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/blossom/apk"
+	"github.com/zapstore/relay/pkg/blossom/exe"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+// inspection holds a copy of a blob being uploaded, whose content is inspected once the upload succeeds
+// and before its metadata is saved.
+// Inspection requires random access (e.g. to the central directory of a zip), so the blob is copied
+// to a temporary file while it's streamed to the storage, instead of being downloaded again.
+// A nil inspection is valid, and does nothing.
+type inspection struct {
+	file *os.File
+}
+
+// newInspection returns the inspection of a blob with the given type, or nil if blobs of that type are not inspected.
+func newInspection(mime string) (*inspection, error) {
+	if mime != apk.MimeType && !slices.Contains(exe.MimeTypes, mime) {
+		return nil, nil
+	}
+
+	file, err := os.CreateTemp("", "blossom-inspect-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create inspection file: %w", err)
+	}
+	return &inspection{file: file}, nil
+}
+
+// Reader returns a reader that copies the data to the inspection file as it's read.
+func (i *inspection) Reader(data io.Reader) io.Reader {
+	if i == nil {
+		return data
+	}
+	return io.TeeReader(data, i.file)
+}
+
+// Close removes the inspection file.
+func (i *inspection) Close() {
+	if i == nil {
+		return
+	}
+	i.file.Close()
+	os.Remove(i.file.Name())
+}
+
+// inspect inspects the uploaded blob at the path and saves the results in the store.
+// APKs whose signature is missing or invalid can't be installed, and their certificate can't be checked against
+// the asset events, so they are deleted from the storage and rejected with an error.
+// Other failures are only logged, because a blob that can't be inspected is still stored, without the results.
+func (b *T) inspect(ctx context.Context, i *inspection, path string, meta store.BlobMeta) *blossom.Error {
+	err := inspectBlob(ctx, b.store, i, meta)
+	if err != nil {
+		if err := b.storage.Delete(ctx, path); err != nil {
+			slog.Error("blossom: failed to delete rejected APK", "error", err, "name", path)
+		}
+		return blossom.ErrBadRequest(err.Error())
+	}
+	return nil
+}
+
+// inspectBlob inspects the blob and saves the results in the store.
+// It returns an error wrapping [apk.ErrUnsigned] or [apk.ErrInvalidSignature] if the blob is an APK
+// that can't be installed. Other failures are only logged.
+func inspectBlob(ctx context.Context, store *store.T, i *inspection, meta store.BlobMeta) error {
+	if i == nil {
+		return nil
+	}
+	if meta.Type != apk.MimeType {
+		inspectBinary(ctx, store, i, meta)
+		return nil
+	}
+
+	manifest, err := apk.Inspect(i.file, meta.Size)
+	if errors.Is(err, apk.ErrUnsigned) || errors.Is(err, apk.ErrInvalidSignature) {
+		return err
+	}
+	if err != nil {
+		slog.Warn("blossom: failed to inspect APK", "error", err, "hash", meta.Hash)
+		return nil
+	}
+
+	if err := store.SaveManifest(ctx, meta.Hash, manifest); err != nil {
+		slog.Error("blossom: failed to save APK manifest", "error", err, "hash", meta.Hash)
+	}
+	return nil
+}
+
+// inspectBinary finds the binaries of the uploaded binary or archive, and saves the report in the store.
+// Failures are only logged, because the relay checks the assets only against the blobs that have a report.
+func inspectBinary(ctx context.Context, store *store.T, i *inspection, meta store.BlobMeta) {
+	report, err := exe.Inspect(i.file, meta.Size)
+	if err != nil {
+		slog.Warn("blossom: failed to inspect binary", "error", err, "hash", meta.Hash, "type", meta.Type)
+		return
+	}
+	if err := store.SaveReport(ctx, meta.Hash, report); err != nil {
+		slog.Error("blossom: failed to save binary report", "error", err, "hash", meta.Hash)
+	}
+}
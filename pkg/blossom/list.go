@@ -0,0 +1,157 @@
+package blossom
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/zapstore/relay/pkg/blossom/store"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 500
+)
+
+// AssetRef is a kind 3063 asset referencing a blob, returned in the "assets" field of listed blob descriptors.
+type AssetRef struct {
+	ID      string `json:"id"`
+	Pubkey  string `json:"pubkey"`
+	App     string `json:"app,omitempty"`
+	Version string `json:"version,omitempty"`
+}
+
+// list handles GET /list/<pubkey> as per BUD-02, returning the descriptors of the blobs uploaded by the pubkey
+// from the newest to the oldest. It supports the "since" and "until" unix timestamps, and the "cursor" and "limit"
+// parameters for pagination, where the cursor is the hash of the last blob of the previous page.
+func (b *T) list(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	ip := blossy.GetIP(r).Group()
+	if !b.limiter.Allow(ip, 5) {
+		slog.Debug("blossom: rejecting list", "ip", ip)
+		blossom.WriteError(w, ErrRateLimited)
+		return
+	}
+
+	filter, err := parseListFilter(r.PathValue("pubkey"), r.URL.Query())
+	if err != nil {
+		blossom.WriteError(w, blossom.ErrBadRequest(err.Error()))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	blobs, err := b.store.List(ctx, filter)
+	if errors.Is(err, store.ErrCursorNotFound) {
+		blossom.WriteError(w, blossom.ErrBadRequest("cursor blob not found"))
+		return
+	}
+	if errors.Is(err, context.Canceled) {
+		blossom.WriteError(w, ErrClientGone)
+		return
+	}
+	if err != nil {
+		slog.Error("blossom: failed to list blobs", "error", err, "pubkey", filter.Pubkey)
+		blossom.WriteError(w, ErrInternal)
+		return
+	}
+
+	descriptors := make([]blossom.BlobDescriptor, 0, len(blobs))
+	for _, blob := range blobs {
+		descriptor, err := b.listDescriptor(ctx, blob)
+		if err != nil {
+			slog.Error("blossom: failed to annotate blob", "error", err, "hash", blob.Hash)
+			blossom.WriteError(w, ErrInternal)
+			return
+		}
+		descriptors = append(descriptors, descriptor)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(descriptors); err != nil {
+		slog.Error("blossom: failed to write list response", "error", err)
+	}
+}
+
+// listDescriptor returns the descriptor of the blob, with the assets referencing it in the "assets" field.
+func (b *T) listDescriptor(ctx context.Context, blob store.BlobMeta) (blossom.BlobDescriptor, error) {
+	assets, err := b.relay.AssetsReferencing(ctx, blob.Hash)
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	refs := make([]AssetRef, 0, len(assets))
+	for _, asset := range assets {
+		app, _ := events.Find(asset.Tags, "i")
+		version, _ := events.Find(asset.Tags, "version")
+		refs = append(refs, AssetRef{ID: asset.ID, Pubkey: asset.PubKey, App: app, Version: version})
+	}
+
+	raw, err := json.Marshal(refs)
+	if err != nil {
+		return blossom.BlobDescriptor{}, fmt.Errorf("failed to marshal assets: %w", err)
+	}
+
+	return blossom.BlobDescriptor{
+		URL:      fmt.Sprintf("https://%s/%s.%s", b.config.Hostname, blob.Hash.Hex(), blossom.ExtFromType(blob.Type)),
+		Hash:     blob.Hash,
+		Type:     blob.Type,
+		Size:     blob.Size,
+		Uploaded: blob.CreatedAt.Unix(),
+		Extra:    map[string]json.RawMessage{"assets": raw},
+	}, nil
+}
+
+// parseListFilter parses the pubkey and query parameters of a list request into a filter.
+func parseListFilter(pubkey string, params url.Values) (store.ListFilter, error) {
+	if !nostr.IsValidPublicKey(pubkey) {
+		return store.ListFilter{}, errors.New("pubkey must be a 64 characters hex string")
+	}
+
+	filter := store.ListFilter{Pubkey: pubkey, Limit: defaultListLimit}
+	for _, param := range []string{"since", "until"} {
+		value := params.Get(param)
+		if value == "" {
+			continue
+		}
+
+		unix, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || unix < 0 {
+			return store.ListFilter{}, fmt.Errorf("'%s' must be a unix timestamp", param)
+		}
+		if param == "since" {
+			filter.Since = time.Unix(unix, 0)
+		} else {
+			filter.Until = time.Unix(unix, 0)
+		}
+	}
+
+	if cursor := params.Get("cursor"); cursor != "" {
+		hash, err := blossom.ParseHash(cursor)
+		if err != nil {
+			return store.ListFilter{}, fmt.Errorf("'cursor' is invalid: %w", err)
+		}
+		filter.Cursor = &hash
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return store.ListFilter{}, errors.New("'limit' must be a positive integer")
+		}
+		filter.Limit = min(n, maxListLimit)
+	}
+	return filter, nil
+}
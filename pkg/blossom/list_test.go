@@ -0,0 +1,138 @@
+package blossom
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/blossom/store"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/rate"
+)
+
+// mockRelay returns the assets referencing a hash.
+type mockRelay map[string][]nostr.Event
+
+func (m mockRelay) ResolveAssetURL(ctx context.Context, hash blossom.Hash) (string, error) {
+	return "", nil
+}
+
+func (m mockRelay) NotifyUpload(hash blossom.Hash, mime string) error { return nil }
+
+func (m mockRelay) AssetsReferencing(ctx context.Context, hash blossom.Hash) ([]nostr.Event, error) {
+	return m[hash.Hex()], nil
+}
+
+func (m mockRelay) ExternalAssets(ctx context.Context) ([]nostr.Event, error) { return nil, nil }
+
+func (m mockRelay) RecordVerification(ctx context.Context, url string, hash blossom.Hash) error {
+	return nil
+}
+
+type listedBlob struct {
+	SHA256   string     `json:"sha256"`
+	Uploaded int64      `json:"uploaded"`
+	Assets   []AssetRef `json:"assets"`
+}
+
+func TestList(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "blossom.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	pubkey := strings.Repeat("a", 64)
+	other := strings.Repeat("b", 64)
+	start := time.Unix(1700000000, 0).UTC()
+
+	var hashes []string
+	for i := range 5 {
+		meta := store.BlobMeta{
+			Hash:       blossom.ComputeHash([]byte{byte(i)}),
+			Type:       "application/vnd.android.package-archive",
+			Size:       100,
+			CreatedAt:  start.Add(time.Duration(i) * time.Hour),
+			AuthPubkey: pubkey,
+		}
+		if _, err := db.Save(ctx, meta); err != nil {
+			t.Fatalf("failed to save blob: %v", err)
+		}
+		hashes = append(hashes, meta.Hash.Hex())
+	}
+	slices.Reverse(hashes) // newest first
+
+	if _, err := db.Save(ctx, store.BlobMeta{Hash: blossom.ComputeHash([]byte("other")), Type: "image/png", Size: 1, AuthPubkey: other}); err != nil {
+		t.Fatalf("failed to save blob: %v", err)
+	}
+
+	asset := nostr.Event{ID: "asset", PubKey: pubkey, Kind: events.KindAsset, Tags: nostr.Tags{{"i", "com.example"}, {"version", "1.0.0"}}}
+	config := NewConfig()
+	config.Hostname = "cdn.example.com"
+
+	b := &T{
+		config:  config,
+		limiter: rate.NewLimiter(rate.NewConfig()),
+		store:   db,
+		relay:   mockRelay{hashes[0]: {asset}},
+	}
+	handler := b.Handler()
+
+	list := func(query string) (int, []listedBlob) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/list/"+pubkey+query, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var blobs []listedBlob
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&blobs); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+		}
+		return rec.Code, blobs
+	}
+
+	listed := func(blobs []listedBlob) []string {
+		var hexes []string
+		for _, blob := range blobs {
+			hexes = append(hexes, blob.SHA256)
+		}
+		return hexes
+	}
+
+	code, page := list("?limit=2")
+	if code != http.StatusOK || !slices.Equal(listed(page), hashes[:2]) {
+		t.Fatalf("expected first page %v, got %d %v", hashes[:2], code, listed(page))
+	}
+	if expected := []AssetRef{{ID: "asset", Pubkey: pubkey, App: "com.example", Version: "1.0.0"}}; !slices.Equal(page[0].Assets, expected) {
+		t.Errorf("expected assets %v, got %v", expected, page[0].Assets)
+	}
+	if page[1].Assets == nil || len(page[1].Assets) != 0 {
+		t.Errorf("expected an empty assets list, got %v", page[1].Assets)
+	}
+
+	code, page = list("?limit=2&cursor=" + hashes[1])
+	if code != http.StatusOK || !slices.Equal(listed(page), hashes[2:4]) {
+		t.Fatalf("expected second page %v, got %d %v", hashes[2:4], code, listed(page))
+	}
+
+	code, page = list("?since=1700003600&until=1700007200")
+	if code != http.StatusOK || !slices.Equal(listed(page), hashes[2:4]) {
+		t.Fatalf("expected %v within since and until, got %d %v", hashes[2:4], code, listed(page))
+	}
+
+	for _, query := range []string{"?cursor=" + strings.Repeat("f", 64), "?limit=0", "?since=yesterday"} {
+		if code, _ := list(query); code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusBadRequest, code)
+		}
+	}
+}
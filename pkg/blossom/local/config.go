@@ -0,0 +1,28 @@
+package local
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Config struct {
+	// Dir is the directory where blobs are stored. It's created if it doesn't exist.
+	Dir string `env:"LOCAL_STORAGE_DIRECTORY_PATH"`
+}
+
+func NewConfig() Config {
+	return Config{}
+}
+
+func (c Config) Validate() error {
+	if c.Dir == "" {
+		return errors.New("directory path must be specified")
+	}
+	return nil
+}
+
+func (c Config) String() string {
+	return fmt.Sprintf("Local Storage:\n"+
+		"\tDirectory Path: %s\n",
+		c.Dir)
+}
@@ -0,0 +1,182 @@
+// The local package is responsible for storing blobs on the local filesystem, for development
+// and self-hosted instances without a Bunny account.
+// It exposes a [New] function to create a new storage with the given config.
+package local
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+var (
+	ErrEmptyData        = errors.New("empty data")
+	ErrInvalidPath      = errors.New("invalid path")
+	ErrInvalidChecksum  = errors.New("invalid sha256 checksum")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrFileNotFound     = errors.New("file not found")
+)
+
+// tmpDir is the directory, relative to the storage directory, where uploads are written before
+// being verified and moved to their path. It's on the same filesystem so that the move is atomic.
+const tmpDir = ".tmp"
+
+type Storage struct {
+	dir string
+}
+
+// New returns a storage from the provided [Config], which is assumed to have been validated.
+// It creates the storage directory if it doesn't exist.
+func New(c Config) (Storage, error) {
+	dir, err := filepath.Abs(c.Dir)
+	if err != nil {
+		return Storage{}, fmt.Errorf("local: invalid directory: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, tmpDir), 0755); err != nil {
+		return Storage{}, fmt.Errorf("local: failed to create directory: %w", err)
+	}
+	return Storage{dir: dir}, nil
+}
+
+// filePath returns the path on the filesystem of the provided path, which must be relative
+// and stay within the storage directory.
+func (s Storage) filePath(path string) (string, error) {
+	path = filepath.FromSlash(strings.TrimPrefix(path, "/"))
+	if !filepath.IsLocal(path) || path == tmpDir || strings.HasPrefix(path, tmpDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPath, path)
+	}
+	return filepath.Join(s.dir, path), nil
+}
+
+// Upload writes the data to the specified path, which is content-addressed (e.g. "blobs/<sha256>.apk").
+//
+// The data is written to a temporary file while being hashed, and moved to its path only if the
+// sha256 matches, otherwise [ErrChecksumMismatch] is returned. An empty sha256 skips the verification.
+func (s Storage) Upload(ctx context.Context, data io.Reader, path string, sha256 string) error {
+	if data == nil {
+		return fmt.Errorf("local: failed to upload: %w", ErrEmptyData)
+	}
+	if sha256 != "" {
+		if err := blossom.ValidateHash(sha256); err != nil {
+			return fmt.Errorf("local: failed to upload: %w: %w", ErrInvalidChecksum, err)
+		}
+	}
+
+	dst, err := s.filePath(path)
+	if err != nil {
+		return fmt.Errorf("local: failed to upload: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(s.dir, tmpDir), "upload-*")
+	if err != nil {
+		return fmt.Errorf("local: failed to upload: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename
+	defer tmp.Close()
+
+	hash, err := copyHashing(ctx, tmp, data)
+	if err != nil {
+		return fmt.Errorf("local: failed to upload: %w", err)
+	}
+	if sha256 != "" && !strings.EqualFold(hash, sha256) {
+		return fmt.Errorf("local: failed to upload: %w", ErrChecksumMismatch)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("local: failed to upload: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("local: failed to upload: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return fmt.Errorf("local: failed to upload: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return fmt.Errorf("local: failed to upload: %w", err)
+	}
+	return nil
+}
+
+// copyHashing copies the data to the file, returning its hex-encoded sha256.
+// It stops early if the context is cancelled.
+func copyHashing(ctx context.Context, dst io.Writer, data io.Reader) (string, error) {
+	hasher := sha256.New()
+	buf := make([]byte, 32*1024)
+	for {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		n, err := data.Read(buf)
+		if n > 0 {
+			hasher.Write(buf[:n])
+			if _, wErr := dst.Write(buf[:n]); wErr != nil {
+				return "", wErr
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return hex.EncodeToString(hasher.Sum(nil)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// Check returns the size of the file at the specified path.
+func (s Storage) Check(ctx context.Context, path string) (size int64, err error) {
+	file, err := s.filePath(path)
+	if err != nil {
+		return 0, fmt.Errorf("local: failed to check: %w", err)
+	}
+
+	info, err := os.Stat(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, fmt.Errorf("local: failed to check: %w", ErrFileNotFound)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("local: failed to check: %w", err)
+	}
+	return info.Size(), nil
+}
+
+// Open returns the file at the specified path, which supports seeking for Range requests.
+// The caller is responsible for closing the file.
+func (s Storage) Open(ctx context.Context, path string) (*os.File, error) {
+	file, err := s.filePath(path)
+	if err != nil {
+		return nil, fmt.Errorf("local: failed to open: %w", err)
+	}
+
+	f, err := os.Open(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("local: failed to open: %w", ErrFileNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("local: failed to open: %w", err)
+	}
+	return f, nil
+}
+
+// Delete the file at the specified path.
+// Returns nil if the file was deleted successfully, or if the file did not exist.
+func (s Storage) Delete(ctx context.Context, path string) error {
+	file, err := s.filePath(path)
+	if err != nil {
+		return fmt.Errorf("local: failed to delete: %w", err)
+	}
+
+	if err := os.Remove(file); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("local: failed to delete: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var ctx = context.Background()
+
+func TestUpload(t *testing.T) {
+	storage, err := New(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	data := []byte("hello world")
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	path := "blobs/" + hash + ".txt"
+
+	if err := storage.Upload(ctx, bytes.NewReader(data), path, hash); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	size, err := storage.Check(ctx, path)
+	if err != nil || size != int64(len(data)) {
+		t.Fatalf("expected size %d, got %d (%v)", len(data), size, err)
+	}
+
+	f, err := storage.Open(ctx, path)
+	if err != nil {
+		t.Fatalf("failed to open: %v", err)
+	}
+	defer f.Close()
+
+	stored, err := io.ReadAll(f)
+	if err != nil || !bytes.Equal(stored, data) {
+		t.Fatalf("expected %q, got %q (%v)", data, stored, err)
+	}
+}
+
+func TestUploadChecksumMismatch(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := New(Config{Dir: dir})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	sum := sha256.Sum256([]byte("expected"))
+	hash := hex.EncodeToString(sum[:])
+	path := "blobs/" + hash + ".txt"
+
+	err = storage.Upload(ctx, bytes.NewReader([]byte("different")), path, hash)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected error %v, got %v", ErrChecksumMismatch, err)
+	}
+	if _, err := storage.Check(ctx, path); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("expected the mismatched blob to not be stored, got %v", err)
+	}
+
+	leftovers, err := os.ReadDir(filepath.Join(dir, tmpDir))
+	if err != nil {
+		t.Fatalf("failed to read the temporary directory: %v", err)
+	}
+	if len(leftovers) > 0 {
+		t.Errorf("expected no temporary files, got %d", len(leftovers))
+	}
+}
+
+func TestInvalidPaths(t *testing.T) {
+	storage, err := New(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	paths := []string{
+		"../outside",
+		"blobs/../../outside",
+		".tmp/upload-1",
+		"",
+	}
+
+	for _, path := range paths {
+		t.Run(path, func(t *testing.T) {
+			if err := storage.Upload(ctx, bytes.NewReader([]byte("data")), path, ""); !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("Upload: expected error %v, got %v", ErrInvalidPath, err)
+			}
+			if _, err := storage.Check(ctx, path); !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("Check: expected error %v, got %v", ErrInvalidPath, err)
+			}
+			if err := storage.Delete(ctx, path); !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("Delete: expected error %v, got %v", ErrInvalidPath, err)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	storage, err := New(Config{Dir: t.TempDir()})
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	path := "blobs/file.txt"
+	if err := storage.Upload(ctx, bytes.NewReader([]byte("data")), path, ""); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	if err := storage.Delete(ctx, path); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, err := storage.Check(ctx, path); !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("expected error %v, got %v", ErrFileNotFound, err)
+	}
+	if err := storage.Delete(ctx, path); err != nil {
+		t.Errorf("expected deleting a missing file to succeed, got %v", err)
+	}
+}
@@ -0,0 +1,399 @@
+package blossom
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/pippellia-btc/blossy/utils"
+	"github.com/zapstore/relay/pkg/blossom/sanitize"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+var errTooLarge = errors.New("blob exceeds the maximum mirror size")
+
+// rawRequest is a [blossy.Request] built from an http request that blossy doesn't handle
+// (e.g. a mirror), used to run the upload rejection hooks.
+type rawRequest struct {
+	ip     blossy.IP
+	pubkey string
+	raw    *http.Request
+}
+
+func (r rawRequest) ID() int64                { return 0 }
+func (r rawRequest) IP() blossy.IP            { return r.ip }
+func (r rawRequest) Pubkey() string           { return r.pubkey }
+func (r rawRequest) IsAuthed() bool           { return r.pubkey != "" }
+func (r rawRequest) Context() context.Context { return r.raw.Context() }
+func (r rawRequest) Raw() *http.Request       { return r.raw }
+
+// mirror handles PUT /mirror as per BUD-04, downloading the blob at the URL of the JSON body and storing it.
+//
+// The expected hash is the one in the URL path for blossom URLs, or the single "x" tag of the authorization event
+// for any other URL (e.g. GitHub releases). The blob is streamed to the storage while being hashed,
+// so it's never buffered in memory, and the storage rejects it if the checksum doesn't match.
+// The blossy mirror hook can't be used because it only accepts blossom URLs.
+func (b *T) mirror(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	body, rErr := utils.ReadNoMore(r.Body, 4096)
+	if rErr != nil {
+		blossom.WriteError(w, rErr)
+		return
+	}
+
+	var payload struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		blossom.WriteError(w, blossom.ErrBadRequest("failed to parse JSON body: "+err.Error()))
+		return
+	}
+
+	source, err := url.Parse(payload.URL)
+	if err != nil || source.Host == "" || source.Scheme != "https" {
+		blossom.WriteError(w, blossom.ErrBadRequest("URL is invalid: must be a valid HTTPS URL"))
+		return
+	}
+
+	hash, bErr := mirrorHash(r, source)
+	if bErr != nil {
+		blossom.WriteError(w, bErr)
+		return
+	}
+
+	pubkey, err := auth.Authenticate(r, b.config.Hostname, &hash)
+	if err != nil {
+		blossom.WriteError(w, blossom.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	req := rawRequest{ip: blossy.GetIP(r), pubkey: pubkey, raw: r}
+	hints := blossy.UploadHints{Hash: &hash, Size: -1}
+
+	// the type and size are not known until the remote server responds,
+	// so hooks that depend on them run after the download has started.
+	for _, reject := range []func(blossy.Request, blossy.UploadHints) *blossom.Error{
+		RateUploadIP(b.limiter),
+		MissingAuth(),
+	} {
+		if err := reject(req, hints); err != nil {
+			blossom.WriteError(w, err)
+			return
+		}
+	}
+
+	desc, bErr := b.mirrorBlob(req, source, hints)
+	if bErr != nil {
+		blossom.WriteError(w, bErr)
+		return
+	}
+
+	desc.URL = fmt.Sprintf("https://%s/%s.%s", b.config.Hostname, desc.Hash.Hex(), blossom.ExtFromType(desc.Type))
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(desc); err != nil {
+		slog.Error("blossom: failed to encode blob descriptor", "error", err, "hash", desc.Hash)
+	}
+}
+
+// mirrorHash returns the expected hash of the blob to mirror, which is the one in the URL path for
+// blossom URLs, or the single "x" tag of the authorization event otherwise.
+func mirrorHash(r *http.Request, source *url.URL) (blossom.Hash, *blossom.Error) {
+	if hash, _, err := utils.ParseHashExt(source.Path); err == nil {
+		return hash, nil
+	}
+
+	event, err := auth.ExtractEvent(r)
+	if errors.Is(err, auth.ErrMissingHeader) {
+		return blossom.Hash{}, blossom.ErrUnauthorized("authentication is required")
+	}
+	if err != nil {
+		return blossom.Hash{}, blossom.ErrUnauthorized(err.Error())
+	}
+
+	authorization, err := auth.ParseBlossomAuth(event)
+	if err != nil {
+		return blossom.Hash{}, blossom.ErrUnauthorized(err.Error())
+	}
+	if len(authorization.Hashes) != 1 {
+		return blossom.Hash{}, blossom.ErrBadRequest("the authorization event must have exactly one 'x' tag with the expected hash")
+	}
+	return authorization.Hashes[0], nil
+}
+
+// mirrorBlob downloads the blob at the source URL and uploads it to the storage, verifying its hash.
+func (b *T) mirrorBlob(r rawRequest, source *url.URL, hints blossy.UploadHints) (blossom.BlobDescriptor, *blossom.Error) {
+	meta, err := b.store.Query(r.Context(), *hints.Hash)
+	if err == nil {
+		// blob already exists
+		return blossom.BlobDescriptor{
+			Hash:     meta.Hash,
+			Type:     meta.Type,
+			Size:     meta.Size,
+			Uploaded: meta.CreatedAt.Unix(),
+		}, nil
+	}
+	if errors.Is(err, context.Canceled) {
+		return blossom.BlobDescriptor{}, ErrClientGone
+	}
+	if !errors.Is(err, store.ErrBlobNotFound) {
+		slog.Error("blossom: failed to query blob metadata", "error", err, "hash", hints.Hash)
+		return blossom.BlobDescriptor{}, ErrInternal
+	}
+
+	check := func(hints blossy.UploadHints) *blossom.Error {
+		for _, reject := range []func(blossy.Request, blossy.UploadHints) *blossom.Error{
+			MediaNotAllowed(b.config.AllowedMedia),
+			MediaTooLarge(b.config),
+			NotAllowed(b.defender),
+			QuotaExceeded(b.config, b.store, b.defender),
+		} {
+			if err := reject(r, hints); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	meta, bErr := b.fetchBlob(r.Context(), source, *hints.Hash, r.Pubkey(), check)
+	if bErr != nil {
+		return blossom.BlobDescriptor{}, bErr
+	}
+
+	if err := b.relay.NotifyUpload(meta.Hash, meta.Type); err != nil {
+		slog.Error("blossom: failed to notify relay of upload", "error", err, "hash", meta.Hash)
+	}
+
+	hints.Type, hints.Size = meta.Type, meta.Size
+	b.analytics.RecordUpload(r, hints)
+	return blossom.BlobDescriptor{
+		Hash:     meta.Hash,
+		Type:     meta.Type,
+		Size:     meta.Size,
+		Uploaded: meta.CreatedAt.Unix(),
+	}, nil
+}
+
+// fetchBlob downloads the blob at the source URL, streams it to the storage while verifying its hash,
+// and saves its metadata with the given pubkey. The check is called with the type and size
+// announced by the remote server before the blob is streamed, and can reject it.
+// If the server doesn't announce the size, the check is called again with the size of the downloaded blob,
+// and a rejected blob is deleted from the storage before its metadata is saved.
+func (b *T) fetchBlob(
+	ctx context.Context,
+	source *url.URL,
+	hash blossom.Hash,
+	pubkey string,
+	check func(blossy.UploadHints) *blossom.Error,
+) (store.BlobMeta, *blossom.Error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, source.String(), nil)
+	if err != nil {
+		return store.BlobMeta{}, blossom.ErrBadRequest("URL is invalid: " + err.Error())
+	}
+
+	res, err := b.fetcher.Do(request)
+	if err != nil {
+		return store.BlobMeta{}, blossom.ErrBadRequest("failed to fetch URL: " + err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return store.BlobMeta{}, blossom.ErrBadRequest("failed to fetch URL: status " + res.Status)
+	}
+	if res.ContentLength > b.config.MirrorMaxSize {
+		return store.BlobMeta{}, blossom.ErrTooLarge(fmt.Sprintf("blob exceeds the maximum size of %d bytes", b.config.MirrorMaxSize))
+	}
+
+	hints := blossy.UploadHints{
+		Hash: &hash,
+		Type: mirrorType(res, source),
+		Size: res.ContentLength,
+	}
+	if err := check(hints); err != nil {
+		return store.BlobMeta{}, err
+	}
+
+	data := &hashingReader{data: res.Body, hash: sha256.New(), max: b.config.MirrorMaxSize}
+
+	// the size limits and the quota are checked again once the size of a response without Content-Length is known
+	checkSize := func() *blossom.Error {
+		if res.ContentLength >= 0 {
+			return nil
+		}
+		hints.Size = data.size
+		return check(hints)
+	}
+
+	reader := newStallReader(ctx, data, b.config.StallTimeout)
+	defer reader.Stop()
+
+	// closing the body unblocks a stalled read of the remote server
+	stop := context.AfterFunc(reader.Context(), func() { res.Body.Close() })
+	defer stop()
+
+	buffered := bufio.NewReaderSize(reader, sniffLen)
+	mediaType, err := sniff(buffered, hints.Type)
+	if errors.Is(data.err, errTooLarge) {
+		return store.BlobMeta{}, blossom.ErrTooLarge(fmt.Sprintf("blob exceeds the maximum size of %d bytes", b.config.MirrorMaxSize))
+	}
+	if errors.Is(err, ErrTypeMismatch) {
+		return store.BlobMeta{}, blossom.ErrUnsupportedMedia(err.Error())
+	}
+	if err != nil {
+		return store.BlobMeta{}, blossom.ErrBadRequest("failed to fetch URL: " + err.Error())
+	}
+
+	if sanitize.Supports(mediaType) {
+		return b.fetchImage(reader, data, buffered, hash, mediaType, pubkey, checkSize)
+	}
+
+	inspection, err := newInspection(mediaType)
+	if err != nil {
+		slog.Error("blossom: failed to start inspection", "error", err, "hash", hash)
+		return store.BlobMeta{}, ErrInternal
+	}
+	defer inspection.Close()
+
+	name := BlobPath(hash, mediaType)
+	err = b.storage.Upload(reader.Context(), inspection.Reader(buffered), res.ContentLength, name, hash.Hex())
+	if errors.Is(data.err, errTooLarge) {
+		return store.BlobMeta{}, blossom.ErrTooLarge(fmt.Sprintf("blob exceeds the maximum size of %d bytes", b.config.MirrorMaxSize))
+	}
+	if errors.Is(err, ErrChecksumMismatch) {
+		return store.BlobMeta{}, blossom.ErrBadRequest("the hash of the mirrored blob doesn't match the expected hash")
+	}
+	if rErr := reader.Err(); rErr != nil {
+		return store.BlobMeta{}, blossom.ErrBadRequest("failed to fetch URL: " + rErr.Error())
+	}
+	if err != nil {
+		slog.Error("blossom: failed to upload mirrored blob", "error", err, "name", name)
+		return store.BlobMeta{}, ErrInternal
+	}
+
+	// Use a fresh context for the remaining operations to avoid orphaning blobs in the storage
+	// if the client disconnects after the upload completes, but before the metadata is saved.
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer saveCancel()
+
+	if computed := blossom.Hash(data.hash.Sum(nil)); computed != hash {
+		// The storage should have rejected it already, this is a safety net.
+		if err := b.storage.Delete(saveCtx, name); err != nil {
+			slog.Error("blossom: failed to delete mismatched mirrored blob", "error", err, "name", name)
+		}
+		return store.BlobMeta{}, blossom.ErrBadRequest("the hash of the mirrored blob doesn't match the expected hash")
+	}
+
+	if err := checkSize(); err != nil {
+		if err := b.storage.Delete(saveCtx, name); err != nil {
+			slog.Error("blossom: failed to delete rejected mirrored blob", "error", err, "name", name)
+		}
+		return store.BlobMeta{}, err
+	}
+
+	meta := store.BlobMeta{
+		Hash:       hash,
+		Type:       mediaType,
+		Size:       data.size,
+		CreatedAt:  time.Now().UTC(),
+		AuthPubkey: pubkey,
+	}
+
+	if err := b.inspect(saveCtx, inspection, name, meta); err != nil {
+		return store.BlobMeta{}, err
+	}
+
+	if _, err := b.store.Save(saveCtx, meta); err != nil {
+		slog.Error("blossom: failed to save blob metadata", "error", err, "hash", hash)
+		return store.BlobMeta{}, ErrInternal
+	}
+
+	b.enqueueReplication(meta)
+	b.enqueueThumbnails(meta)
+	return meta, nil
+}
+
+// fetchImage reads the image being downloaded, verifies its hash and stores it sanitized.
+func (b *T) fetchImage(
+	reader *stallReader,
+	data *hashingReader,
+	buffered io.Reader,
+	hash blossom.Hash,
+	mediaType string,
+	pubkey string,
+	checkSize func() *blossom.Error,
+) (store.BlobMeta, *blossom.Error) {
+
+	image, err := b.readImage(buffered)
+	if errors.Is(data.err, errTooLarge) {
+		return store.BlobMeta{}, blossom.ErrTooLarge(fmt.Sprintf("blob exceeds the maximum size of %d bytes", b.config.MirrorMaxSize))
+	}
+	if errors.Is(err, errImageTooLarge) {
+		return store.BlobMeta{}, blossom.ErrTooLarge(err.Error())
+	}
+	if rErr := reader.Err(); rErr != nil {
+		return store.BlobMeta{}, blossom.ErrBadRequest("failed to fetch URL: " + rErr.Error())
+	}
+	if err != nil {
+		return store.BlobMeta{}, blossom.ErrBadRequest("failed to fetch URL: " + err.Error())
+	}
+	if computed := blossom.Hash(data.hash.Sum(nil)); computed != hash {
+		return store.BlobMeta{}, blossom.ErrBadRequest("the hash of the mirrored blob doesn't match the expected hash")
+	}
+	if err := checkSize(); err != nil {
+		return store.BlobMeta{}, err
+	}
+
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer saveCancel()
+	return b.storeImage(saveCtx, hash, mediaType, image, pubkey)
+}
+
+// mirrorType returns the content type of the response, falling back to the type
+// implied by the URL extension when the server returns a generic or no type.
+func mirrorType(res *http.Response, source *url.URL) string {
+	mediaType, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err == nil && mediaType != "application/octet-stream" {
+		return mediaType
+	}
+	return blossom.TypeFromExt(path.Ext(source.Path))
+}
+
+// hashingReader hashes and counts the bytes read from data,
+// failing with errTooLarge once more than max bytes have been read.
+type hashingReader struct {
+	data io.Reader
+	hash hash.Hash
+	size int64
+	max  int64
+	err  error
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.data.Read(p)
+	h.hash.Write(p[:n])
+	h.size += int64(n)
+
+	if h.size > h.max {
+		h.err = errTooLarge
+		return n, h.err
+	}
+	return n, err
+}
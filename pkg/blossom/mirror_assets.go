@@ -0,0 +1,175 @@
+package blossom
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+const (
+	// maxMirrorsPerRun is the maximum number of blobs mirrored in a single run,
+	// to avoid saturating the bandwidth when many external assets are published at once.
+	maxMirrorsPerRun = 20
+
+	minMirrorBackoff = time.Hour
+	maxMirrorBackoff = 24 * time.Hour
+)
+
+// mirrorFailure tracks the failed attempts to mirror a blob.
+type mirrorFailure struct {
+	attempts int
+	next     time.Time
+}
+
+// mirrorBackoff delays the next attempt to mirror blobs that failed, exponentially
+// from [minMirrorBackoff] up to [maxMirrorBackoff]. It's only used by a single goroutine.
+type mirrorBackoff map[blossom.Hash]mirrorFailure
+
+// Ready returns whether the blob can be mirrored at the given time.
+func (m mirrorBackoff) Ready(hash blossom.Hash, now time.Time) bool {
+	failure, ok := m[hash]
+	return !ok || !now.Before(failure.next)
+}
+
+// Fail records a failed attempt to mirror the blob at the given time.
+func (m mirrorBackoff) Fail(hash blossom.Hash, now time.Time) {
+	failure := m[hash]
+	delay := minMirrorBackoff << min(failure.attempts, 5)
+	m[hash] = mirrorFailure{
+		attempts: failure.attempts + 1,
+		next:     now.Add(min(delay, maxMirrorBackoff)),
+	}
+}
+
+// Succeed forgets the failed attempts to mirror the blob.
+func (m mirrorBackoff) Succeed(hash blossom.Hash) {
+	delete(m, hash)
+}
+
+// runMirrorAssets periodically mirrors the blobs of assets that are only hosted externally,
+// until the context is cancelled. Once mirrored, the blobs are served by our CDN on download
+// instead of redirecting to the external URLs, which can disappear or change content.
+func (b *T) runMirrorAssets(ctx context.Context) {
+	ticker := time.NewTicker(b.config.MirrorInterval)
+	defer ticker.Stop()
+
+	backoff := make(mirrorBackoff)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			mirrored, failed, err := b.mirrorAssets(ctx, backoff)
+			if err != nil {
+				slog.Error("blossom: failed to mirror external assets", "error", err)
+				continue
+			}
+			if mirrored > 0 || failed > 0 {
+				slog.Info("blossom: mirrored external assets", "mirrored", mirrored, "failed", failed)
+			}
+		}
+	}
+}
+
+// mirrorAssets mirrors the blobs of the assets with "url" tags that are not in our storage yet,
+// trying each URL in order until one succeeds. It returns the number of blobs mirrored and failed.
+func (b *T) mirrorAssets(ctx context.Context, backoff mirrorBackoff) (mirrored, failed int, err error) {
+	assets, err := b.relay.ExternalAssets(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// the blossy request is not used by the media check
+	check := func(hints blossy.UploadHints) *blossom.Error {
+		return MediaNotAllowed(b.config.AllowedMedia)(nil, hints)
+	}
+
+	now := time.Now()
+	for _, asset := range assets {
+		if mirrored+failed >= maxMirrorsPerRun || ctx.Err() != nil {
+			break
+		}
+
+		x, _ := events.Find(asset.Tags, "x")
+		hash, err := blossom.ParseHash(x)
+		if err != nil || !backoff.Ready(hash, now) {
+			continue
+		}
+
+		exists, err := b.store.Has(ctx, hash)
+		if err != nil {
+			return mirrored, failed, err
+		}
+		if exists {
+			continue
+		}
+
+		var ok bool
+		err = safely(func() error {
+			ok = b.mirrorAsset(ctx, asset, hash, check)
+			return nil
+		})
+		if err != nil {
+			slog.Error("blossom: failed to mirror external asset", "error", err, "hash", hash)
+		}
+
+		if ok {
+			backoff.Succeed(hash)
+			mirrored++
+		} else {
+			backoff.Fail(hash, now)
+			failed++
+		}
+	}
+	return mirrored, failed, nil
+}
+
+// mirrorAsset tries to mirror the blob of the asset from each of its external URLs.
+func (b *T) mirrorAsset(ctx context.Context, asset nostr.Event, hash blossom.Hash, check func(blossy.UploadHints) *blossom.Error) bool {
+	for _, source := range b.externalURLs(asset) {
+		meta, err := b.fetchBlob(ctx, source, hash, asset.PubKey, check)
+		if err != nil {
+			slog.Warn("blossom: failed to mirror external asset", "error", err.Reason, "hash", hash, "url", source)
+			continue
+		}
+
+		// the relay doesn't need to download the URL again to verify it
+		if err := b.relay.RecordVerification(ctx, source.String(), hash); err != nil {
+			slog.Error("blossom: failed to record verification", "error", err, "hash", hash, "url", source)
+		}
+		if err := b.relay.NotifyUpload(meta.Hash, meta.Type); err != nil {
+			slog.Error("blossom: failed to notify relay of upload", "error", err, "hash", meta.Hash)
+		}
+		slog.Info("blossom: mirrored external asset", "hash", hash, "url", source, "size", meta.Size)
+		return true
+	}
+	return false
+}
+
+// externalURLs returns the https URLs in the "url" tags of the asset that don't point to this server.
+func (b *T) externalURLs(asset nostr.Event) []*url.URL {
+	var urls []*url.URL
+	for _, tag := range asset.Tags {
+		if len(tag) < 2 || tag[0] != "url" {
+			continue
+		}
+
+		source, err := url.Parse(tag[1])
+		if err != nil || source.Scheme != "https" || source.Host == "" {
+			continue
+		}
+		if strings.EqualFold(source.Hostname(), b.config.Hostname) {
+			continue
+		}
+		urls = append(urls, source)
+	}
+	return urls
+}
@@ -0,0 +1,127 @@
+package blossom
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/rate"
+)
+
+// verifyingRelay is a [Relay] without assets that records the verified URLs.
+type verifyingRelay struct {
+	fakeRelay
+	verified map[string]blossom.Hash
+}
+
+func (r verifyingRelay) RecordVerification(ctx context.Context, url string, hash blossom.Hash) error {
+	r.verified[url] = hash
+	return nil
+}
+
+func TestMirrorBackoff(t *testing.T) {
+	backoff := make(mirrorBackoff)
+	hash := blossom.ComputeHash([]byte("blob"))
+	now := time.Unix(1700000000, 0)
+
+	if !backoff.Ready(hash, now) {
+		t.Fatal("expected a new blob to be ready")
+	}
+
+	tests := []struct {
+		delay time.Duration
+	}{
+		{time.Hour},
+		{2 * time.Hour},
+		{4 * time.Hour},
+		{8 * time.Hour},
+		{16 * time.Hour},
+		{24 * time.Hour},
+		{24 * time.Hour},
+	}
+
+	for i, test := range tests {
+		backoff.Fail(hash, now)
+		if backoff.Ready(hash, now.Add(test.delay-time.Second)) {
+			t.Errorf("attempt %d: expected the blob to not be ready before %v", i+1, test.delay)
+		}
+		if !backoff.Ready(hash, now.Add(test.delay)) {
+			t.Errorf("attempt %d: expected the blob to be ready after %v", i+1, test.delay)
+		}
+	}
+
+	backoff.Succeed(hash)
+	if !backoff.Ready(hash, now) {
+		t.Error("expected the blob to be ready after a success")
+	}
+}
+
+func TestExternalURLs(t *testing.T) {
+	config := NewConfig()
+	config.Hostname = "cdn.zapstore.dev"
+	b := &T{config: config}
+
+	asset := nostr.Event{Tags: nostr.Tags{
+		{"url", "https://github.com/example/app/releases/download/v1.0.0/app.apk"},
+		{"url", "http://example.com/app.apk"},
+		{"url", "https://CDN.zapstore.dev/" + blossom.ComputeHash([]byte("blob")).Hex()},
+		{"url", "not a url"},
+		{"url"},
+		{"x", "https://example.com/app.apk"},
+		{"url", "https://example.com/app.apk"},
+	}}
+
+	var urls []string
+	for _, u := range b.externalURLs(asset) {
+		urls = append(urls, u.String())
+	}
+
+	expected := []string{
+		"https://github.com/example/app/releases/download/v1.0.0/app.apk",
+		"https://example.com/app.apk",
+	}
+	if len(urls) != len(expected) || urls[0] != expected[0] || urls[1] != expected[1] {
+		t.Errorf("expected %v, got %v", expected, urls)
+	}
+}
+
+func TestMirrorAssetRecordsVerification(t *testing.T) {
+	data := append([]byte("PK\x03\x04"), "the apk content"...)
+	hash := blossom.ComputeHash(data)
+
+	b, _, origin := newMirrorServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app.apk" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.android.package-archive")
+		w.Write(data)
+	})
+	b.limiter = rate.NewLimiter(rate.Config{InitialTokens: 1000, MaxTokens: 1000, TokensPerInterval: 100, Interval: time.Minute})
+	relay := verifyingRelay{verified: make(map[string]blossom.Hash)}
+	b.relay = relay
+
+	asset := nostr.Event{
+		PubKey: strings.Repeat("a", 64),
+		Kind:   events.KindAsset,
+		Tags: nostr.Tags{
+			{"x", hash.Hex()},
+			{"url", origin.URL + "/missing.apk"},
+			{"url", origin.URL + "/app.apk"},
+		},
+	}
+	accept := func(blossy.UploadHints) *blossom.Error { return nil }
+
+	if !b.mirrorAsset(ctx, asset, hash, accept) {
+		t.Fatal("expected the asset to be mirrored")
+	}
+	if len(relay.verified) != 1 || relay.verified[origin.URL+"/app.apk"] != hash {
+		t.Errorf("expected only the mirrored url to be verified, got %v", relay.verified)
+	}
+}
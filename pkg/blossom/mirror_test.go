@@ -0,0 +1,165 @@
+package blossom
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/blossom/store"
+	"github.com/zapstore/relay/pkg/rate"
+)
+
+// newMirrorServer returns a blossom server whose fetcher trusts the TLS origin serving the handler.
+func newMirrorServer(t *testing.T, origin http.HandlerFunc) (*T, *memStorage, *httptest.Server) {
+	t.Helper()
+	b, primary := newSessionServer(t)
+	server := httptest.NewTLSServer(origin)
+	t.Cleanup(server.Close)
+
+	b.fetcher = server.Client()
+	return b, primary, server
+}
+
+// mirrorBlob sends a PUT /mirror request for the URL, authorized for the hash.
+func mirrorBlob(t *testing.T, handler http.Handler, url string, hash blossom.Hash) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPut, "/mirror", strings.NewReader(`{"url":"`+url+`"}`))
+	req.Header.Set("Authorization", uploadAuth(t, hash))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMirrorUnknownSize(t *testing.T) {
+	data := append([]byte("PK\x03\x04"), bytes.Repeat([]byte("a"), 200)...)
+	hash := blossom.ComputeHash(data)
+
+	b, primary, origin := newMirrorServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.android.package-archive")
+		w.Write(data[:100])
+		w.(http.Flusher).Flush() // no Content-Length, the response is chunked
+		w.Write(data[100:])
+	})
+	b.config.QuotaDefault = 100
+
+	rec := mirrorBlob(t, b.Handler(), origin.URL+"/app.apk", hash)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusRequestEntityTooLarge, rec.Code, rec.Header().Get("X-Reason"))
+	}
+	if exists, _ := b.store.Has(ctx, hash); exists {
+		t.Error("expected the metadata of the rejected blob to not be saved")
+	}
+	if _, ok := primary.blobs[BlobPath(hash, "application/vnd.android.package-archive")]; ok {
+		t.Error("expected the rejected blob to be deleted from the storage")
+	}
+}
+
+func TestHashingReader(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 100)
+
+	reader := &hashingReader{data: bytes.NewReader(data), hash: sha256.New(), max: 100}
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if expected := sha256.Sum256(data); !bytes.Equal(reader.hash.Sum(nil), expected[:]) {
+		t.Errorf("expected hash %x, got %x", expected, reader.hash.Sum(nil))
+	}
+	if reader.size != 100 {
+		t.Errorf("expected size 100, got %d", reader.size)
+	}
+
+	reader = &hashingReader{data: bytes.NewReader(data), hash: sha256.New(), max: 99}
+	if _, err := io.Copy(io.Discard, reader); !errors.Is(err, errTooLarge) {
+		t.Fatalf("expected error %v, got %v", errTooLarge, err)
+	}
+}
+
+func TestMirror(t *testing.T) {
+	apk := "application/vnd.android.package-archive"
+	data := append([]byte("PK\x03\x04"), bytes.Repeat([]byte("a"), 100)...)
+	hash := blossom.ComputeHash(data)
+	stored := blossom.ComputeHash([]byte("stored"))
+
+	b, primary, origin := newMirrorServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+stored.Hex()+".apk" {
+			t.Error("expected a stored blob to not be fetched")
+		}
+		w.Header().Set("Content-Type", apk)
+		if strings.HasPrefix(r.URL.Path, "/chunked/") {
+			w.(http.Flusher).Flush() // no Content-Length, the response is chunked
+		}
+		if strings.HasSuffix(r.URL.Path, "/other.apk") {
+			w.Write(append([]byte("PK\x03\x04"), "other"...))
+			return
+		}
+		w.Write(data)
+	})
+	b.limiter = rate.NewLimiter(rate.Config{InitialTokens: 1000, MaxTokens: 1000, TokensPerInterval: 100, Interval: time.Minute})
+	handler := b.Handler()
+
+	meta := store.BlobMeta{Hash: stored, Type: apk, Size: 6, CreatedAt: time.Now().UTC()}
+	if _, err := b.store.Save(ctx, meta); err != nil {
+		t.Fatalf("failed to save blob: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		hash     blossom.Hash // of the authorization
+		maxSize  int64
+		code     int
+		expected blossom.Hash // of the stored blob
+	}{
+		{name: "blossom URL", url: origin.URL + "/" + hash.Hex() + ".apk", hash: hash, code: http.StatusOK, expected: hash},
+		{name: "x tag", url: origin.URL + "/app.apk", hash: hash, code: http.StatusOK, expected: hash},
+		{name: "blossom URL of another blob", url: origin.URL + "/" + hash.Hex() + ".apk", hash: stored, code: http.StatusUnauthorized},
+		{name: "not https", url: strings.Replace(origin.URL, "https", "http", 1) + "/app.apk", hash: hash, code: http.StatusBadRequest},
+		{name: "too large", url: origin.URL + "/app.apk", hash: hash, maxSize: 50, code: http.StatusRequestEntityTooLarge},
+		{name: "too large chunked", url: origin.URL + "/chunked/app.apk", hash: hash, maxSize: 50, code: http.StatusRequestEntityTooLarge},
+		{name: "checksum mismatch", url: origin.URL + "/other.apk", hash: hash, code: http.StatusBadRequest},
+		{name: "already stored", url: origin.URL + "/" + stored.Hex() + ".apk", hash: stored, code: http.StatusOK, expected: stored},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b.config.MirrorMaxSize = 1000
+			if test.maxSize > 0 {
+				b.config.MirrorMaxSize = test.maxSize
+			}
+			// every case starts without the mirrored blob
+			b.store.Delete(ctx, hash)
+			delete(primary.blobs, BlobPath(hash, apk))
+
+			rec := mirrorBlob(t, handler, test.url, test.hash)
+			if rec.Code != test.code {
+				t.Fatalf("expected status %d, got %d: %s", test.code, rec.Code, rec.Header().Get("X-Reason"))
+			}
+			if rec.Code != http.StatusOK {
+				if exists, _ := b.store.Has(ctx, hash); exists {
+					t.Fatal("expected the metadata of the rejected blob to not be saved")
+				}
+				return
+			}
+
+			var desc blossom.BlobDescriptor
+			if err := json.NewDecoder(rec.Body).Decode(&desc); err != nil {
+				t.Fatalf("failed to decode descriptor: %v", err)
+			}
+			if desc.Hash != test.expected || desc.Type != apk {
+				t.Fatalf("expected blob %s of type %s, got %s of type %s", test.expected, apk, desc.Hash, desc.Type)
+			}
+			if exists, _ := b.store.Has(ctx, test.expected); !exists {
+				t.Fatal("expected the metadata of the mirrored blob to be saved")
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package blossom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	defender "github.com/zapstore/defender/pkg/client"
+	"github.com/zapstore/defender/pkg/models"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+// MediaTooLarge rejects the blobs larger than the maximum size of their media type, see [Config.MediaMaxSizes].
+func MediaTooLarge(config Config) func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+	return func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+		limit, ok := config.MediaMaxSize(hints.Type)
+		if ok && hints.Size > limit {
+			reason := fmt.Sprintf("blobs of type %s can be at most %d bytes, got %d bytes", hints.Type, limit, hints.Size)
+			return blossom.ErrTooLarge(reason)
+		}
+		return nil
+	}
+}
+
+// QuotaExceeded rejects the uploads that would bring the storage used by the pubkey over its quota.
+// The quota is [Config.QuotaAllowed] for the pubkeys allowed by a defender policy, and [Config.QuotaDefault] otherwise.
+// Operators have no quota, and blobs that are already stored are not rejected, because they don't use more storage.
+func QuotaExceeded(config Config, store *store.T, policies defender.T) func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+	return func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+		if slices.Contains(config.OperatorPubkeys, r.Pubkey()) {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+		defer cancel()
+
+		if hints.Hash != nil {
+			exists, err := store.Has(ctx, *hints.Hash)
+			if err != nil {
+				slog.Error("blossom: failed to check blob existence", "error", err, "hash", hints.Hash)
+				return ErrInternal
+			}
+			if exists {
+				return nil
+			}
+		}
+
+		usage, err := store.UsageOf(ctx, r.Pubkey())
+		if err != nil {
+			slog.Error("blossom: failed to query usage", "error", err, "pubkey", r.Pubkey())
+			return ErrInternal
+		}
+
+		size := max(hints.Size, 0)
+		exceeds := func(quota int64) bool {
+			return quota > 0 && usage.Total()+size > quota
+		}
+
+		quota := config.QuotaDefault
+		if !exceeds(quota) {
+			return nil
+		}
+
+		// the default quota is exceeded, so the pubkey needs the quota of an allowed pubkey
+		entity := models.Entity{ID: r.Pubkey(), Platform: models.PlatformNostr}
+		policy, err := policies.GetPolicy(ctx, entity)
+		if err != nil && !errors.Is(err, defender.ErrPolicyNotFound) {
+			slog.Error("defender: failed to get policy", "err", err, "pubkey", r.Pubkey())
+			return ErrInternal
+		}
+
+		if err == nil && policy.Status == models.StatusAllowed {
+			quota = config.QuotaAllowed
+			if !exceeds(quota) {
+				return nil
+			}
+		}
+
+		slog.Info("blossom: quota exceeded", "pubkey", r.Pubkey(), "usage", usage.Total(), "size", size, "quota", quota)
+		return ErrQuotaExceeded(usage, size, quota)
+	}
+}
+
+// ErrQuotaExceeded returns the error of an upload of size bytes that would exceed the quota.
+func ErrQuotaExceeded(usage store.Usage, size, quota int64) *blossom.Error {
+	reason := fmt.Sprintf("storage quota exceeded: %d of %d bytes are used, and the blob needs %d more. "+
+		"Delete unreferenced blobs or visit https://zapstore.dev/docs/publish to request a larger quota.",
+		usage.Total(), quota, size)
+	return blossom.ErrTooLarge(reason)
+}
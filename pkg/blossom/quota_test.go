@@ -0,0 +1,124 @@
+package blossom
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	defender "github.com/zapstore/defender/pkg/client"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+func TestMediaTooLarge(t *testing.T) {
+	config := NewConfig()
+	config.MediaMaxSizes = map[string]int64{
+		"image/*":   1000,
+		"image/png": 2000,
+		"application/vnd.android.package-archive": 5000,
+	}
+	reject := MediaTooLarge(config)
+
+	tests := []struct {
+		mime     string
+		size     int64
+		rejected bool
+	}{
+		{mime: "image/jpeg", size: 1000},
+		{mime: "image/jpeg", size: 1001, rejected: true},
+		{mime: "image/png", size: 1500},
+		{mime: "image/png", size: 2001, rejected: true},
+		{mime: "application/vnd.android.package-archive", size: 5001, rejected: true},
+		{mime: "application/x-executable", size: 1_000_000},
+		{mime: "image/jpeg", size: -1},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s %d", test.mime, test.size), func(t *testing.T) {
+			err := reject(nil, blossy.UploadHints{Type: test.mime, Size: test.size})
+			if rejected := err != nil; rejected != test.rejected {
+				t.Fatalf("expected rejected %v, got %v", test.rejected, err)
+			}
+		})
+	}
+}
+
+func TestQuotaExceeded(t *testing.T) {
+	alice, allowed, operator := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64)
+
+	policies := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/policies/nostr/"+allowed {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":%q,"platform":"nostr","status":"allowed","added_by":"test","created_at":1}`, allowed)
+	}))
+	defer policies.Close()
+
+	b, _ := newSessionServer(t)
+	def, err := defender.Default(policies.URL)
+	if err != nil {
+		t.Fatalf("failed to create defender: %v", err)
+	}
+
+	config := b.config
+	config.QuotaDefault = 1000
+	config.QuotaAllowed = 5000
+	config.OperatorPubkeys = []string{operator}
+	reject := QuotaExceeded(config, b.store, def)
+
+	stored := blossom.ComputeHash([]byte("stored"))
+	for i, pubkey := range []string{alice, allowed} {
+		meta := store.BlobMeta{
+			Hash:       blossom.ComputeHash([]byte{byte(i)}),
+			Type:       "image/png",
+			Size:       900,
+			CreatedAt:  time.Now().UTC(),
+			AuthPubkey: pubkey,
+		}
+		if i == 0 {
+			meta.Hash = stored
+		}
+		if _, err := b.store.Save(ctx, meta); err != nil {
+			t.Fatalf("failed to save blob: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		pubkey   string
+		hash     blossom.Hash
+		size     int64
+		rejected bool
+	}{
+		{name: "within the default quota", pubkey: alice, size: 100},
+		{name: "over the default quota", pubkey: alice, size: 101, rejected: true},
+		{name: "already stored", pubkey: alice, hash: stored, size: 900},
+		{name: "allowed within its quota", pubkey: allowed, size: 4100},
+		{name: "allowed over its quota", pubkey: allowed, size: 4101, rejected: true},
+		{name: "operator", pubkey: operator, size: 1_000_000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash := test.hash
+			if hash.IsZero() {
+				hash = blossom.ComputeHash([]byte(test.name))
+			}
+
+			req := rawRequest{pubkey: test.pubkey, raw: httptest.NewRequest(http.MethodPut, "/upload", nil)}
+			err := reject(req, blossy.UploadHints{Hash: &hash, Type: "image/png", Size: test.size})
+			if rejected := err != nil; rejected != test.rejected {
+				t.Fatalf("expected rejected %v, got %v", test.rejected, err)
+			}
+			if err != nil && err.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("expected status %d, got %d", http.StatusRequestEntityTooLarge, err.Code)
+			}
+		})
+	}
+}
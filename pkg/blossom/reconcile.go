@@ -0,0 +1,261 @@
+package blossom
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/blossom/bunny"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+// reconcileGrace is how old a file without metadata must be before it's considered an orphan,
+// leaving the uploads in progress the time to save their metadata.
+const reconcileGrace = time.Hour
+
+// errInvalidOrphan is returned when the content of an orphan file doesn't match its name, or can't be installed.
+var errInvalidOrphan = errors.New("invalid orphan")
+
+// Lister lists and downloads the files of a storage, like the Bunny storage zone.
+type Lister interface {
+	List(ctx context.Context, dir string) ([]bunny.File, error)
+	Download(ctx context.Context, path string) (io.ReadCloser, error)
+}
+
+// Notifier notifies the relay of the blobs that became available, see [Relay.NotifyUpload].
+type Notifier interface {
+	NotifyUpload(hash blossom.Hash, mime string) error
+}
+
+// Reconciler reconciles the blobs in the storage with their metadata in the store.
+//
+// A blob can be left in the storage without metadata (an orphan) if the process dies between the upload
+// and the save of its metadata, and metadata can be left without a blob (dangling) if the file is removed manually.
+// Orphans older than an hour are downloaded to recompute their hash and size and to inspect them like on upload,
+// and their metadata is re-created without the pubkey of the uploader. Dangling metadata is only reported, because the blob may be recovered from a replica.
+type Reconciler struct {
+	config   Config
+	store    *store.T
+	storage  Lister
+	notifier Notifier // nil when the relay is not running, which then finds the recovered blobs on its periodic reconciliation
+}
+
+// ReconcileReport summarizes the result of a [Reconciler.Reconcile].
+type ReconcileReport struct {
+	Files     int              // files in the blobs directory of the storage
+	Blobs     int              // blobs in the database when the reconciliation started
+	Recovered []store.BlobMeta // orphan files whose metadata was re-created
+	Invalid   []string         // orphan files whose content doesn't match their name or that can't be installed, which are left untouched
+	Dangling  []store.BlobMeta // blobs in the database whose file is missing from the storage
+	Recent    int              // files without metadata that may still be uploads in progress
+	Failed    int              // orphan files that couldn't be recovered, and that will be retried
+}
+
+// NewReconciler creates a new reconciler of the blobs in the storage with the store.
+// The notifier is notified of the recovered blobs, and can be nil.
+func NewReconciler(config Config, store *store.T, storage Lister, notifier Notifier) *Reconciler {
+	return &Reconciler{
+		config:   config,
+		store:    store,
+		storage:  storage,
+		notifier: notifier,
+	}
+}
+
+// Run reconciles the storage with the store every [Config.ReconcileInterval], until the context gets cancelled.
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.ReconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			report, err := r.Reconcile(ctx, false)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("blossom: reconciliation failed", "error", err)
+				continue
+			}
+
+			if len(report.Dangling) > 0 {
+				hashes := make([]string, len(report.Dangling))
+				for i, blob := range report.Dangling {
+					hashes[i] = blob.Hash.Hex()
+				}
+				slog.Warn("blossom: blobs missing from the storage", "hashes", hashes)
+			}
+
+			slog.Info("blossom: reconciliation completed",
+				"files", report.Files,
+				"blobs", report.Blobs,
+				"recovered", len(report.Recovered),
+				"invalid", len(report.Invalid),
+				"dangling", len(report.Dangling),
+				"recent", report.Recent,
+				"failed", report.Failed,
+			)
+		}
+	}
+}
+
+// Reconcile diffs the files in the blobs directory of the storage with the blobs in the store,
+// re-creating the metadata of the orphan files and reporting the dangling blobs.
+// In dry-run mode nothing is downloaded or changed, and the orphans are reported as recovered
+// with the hash, type and size of their name and listing.
+func (r *Reconciler) Reconcile(ctx context.Context, dryRun bool) (ReconcileReport, error) {
+	var report ReconcileReport
+	blobs, err := r.store.All(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	// files are listed after the blobs are fetched, so that the file of every blob saved before is listed,
+	// and a blob uploaded in between is a recent file without metadata.
+	files, err := r.storage.List(ctx, "blobs")
+	if err != nil {
+		return report, fmt.Errorf("failed to list blobs: %w", err)
+	}
+
+	known := make(map[string]struct{}, len(blobs))
+	for _, blob := range blobs {
+		known[BlobPath(blob.Hash, blob.Type)] = struct{}{}
+	}
+
+	listed := make(map[string]struct{}, len(files))
+	now := time.Now().UTC()
+	report.Blobs = len(blobs)
+
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		if file.IsDirectory {
+			continue
+		}
+
+		report.Files++
+		path := "blobs/" + file.Name
+		listed[path] = struct{}{}
+
+		if _, ok := known[path]; ok {
+			continue
+		}
+		if now.Sub(file.CreatedAt) < reconcileGrace {
+			report.Recent++
+			continue
+		}
+
+		var meta store.BlobMeta
+		err := safely(func() (err error) {
+			meta, err = r.recover(ctx, file, dryRun)
+			return err
+		})
+		switch {
+		case errors.Is(err, errInvalidOrphan):
+			slog.Warn("blossom: invalid orphan file", "error", err, "path", path)
+			report.Invalid = append(report.Invalid, path)
+
+		case err != nil:
+			if ctx.Err() != nil {
+				return report, ctx.Err()
+			}
+			slog.Error("blossom: failed to recover orphan file", "error", err, "path", path)
+			report.Failed++
+
+		default:
+			report.Recovered = append(report.Recovered, meta)
+		}
+	}
+
+	for _, blob := range blobs {
+		if _, ok := listed[BlobPath(blob.Hash, blob.Type)]; !ok {
+			report.Dangling = append(report.Dangling, blob)
+		}
+	}
+	return report, nil
+}
+
+// recover re-creates the metadata of the orphan file, after verifying that its content matches its name
+// and inspecting it like on upload. It returns an error wrapping [errInvalidOrphan] if the content doesn't match,
+// or if the orphan is an APK that can't be installed.
+func (r *Reconciler) recover(ctx context.Context, file bunny.File, dryRun bool) (store.BlobMeta, error) {
+	name, ext, _ := strings.Cut(file.Name, ".")
+	hash, err := blossom.ParseHash(name)
+	if err != nil {
+		return store.BlobMeta{}, fmt.Errorf("%w: %w", errInvalidOrphan, err)
+	}
+
+	meta := store.BlobMeta{
+		Hash:      hash,
+		Type:      blossom.TypeFromExt(ext),
+		Size:      file.Size,
+		CreatedAt: file.CreatedAt,
+	}
+	if dryRun {
+		return meta, nil
+	}
+
+	path := "blobs/" + file.Name
+	data, err := r.storage.Download(ctx, path)
+	if err != nil {
+		return store.BlobMeta{}, err
+	}
+	defer data.Close()
+
+	// the type is detected like on upload, because some types share their extension (e.g. "bin"),
+	// and when the extension doesn't tell the type, the detected type is checked against the name instead.
+	buffered := bufio.NewReaderSize(data, sniffLen)
+	if meta.Type == unknownType {
+		header, _ := buffered.Peek(sniffLen)
+		meta.Type = detectType(header)
+	}
+	meta.Type, err = sniff(buffered, meta.Type)
+	if errors.Is(err, ErrTypeMismatch) {
+		return store.BlobMeta{}, fmt.Errorf("%w: %w", errInvalidOrphan, err)
+	}
+	if err != nil {
+		return store.BlobMeta{}, fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	inspection, err := newInspection(meta.Type)
+	if err != nil {
+		return store.BlobMeta{}, err
+	}
+	defer inspection.Close()
+
+	hasher := blossom.NewHasher()
+	meta.Size, err = io.Copy(hasher, inspection.Reader(buffered))
+	if err != nil {
+		return store.BlobMeta{}, fmt.Errorf("failed to read blob: %w", err)
+	}
+
+	if computed := blossom.Hash(hasher.Sum(nil)); computed != hash {
+		return store.BlobMeta{}, fmt.Errorf("%w: the hash is %s", errInvalidOrphan, computed)
+	}
+	if BlobPath(meta.Hash, meta.Type) != path {
+		return store.BlobMeta{}, fmt.Errorf("%w: the type is %s", errInvalidOrphan, meta.Type)
+	}
+
+	// inspect before notifying the relay, which checks the pending assets against the results
+	if err := inspectBlob(ctx, r.store, inspection, meta); err != nil {
+		return store.BlobMeta{}, fmt.Errorf("%w: %w", errInvalidOrphan, err)
+	}
+
+	if _, err := r.store.Save(ctx, meta); err != nil {
+		return store.BlobMeta{}, err
+	}
+
+	if r.notifier != nil {
+		if err := r.notifier.NotifyUpload(meta.Hash, meta.Type); err != nil {
+			slog.Error("blossom: failed to notify relay of upload", "error", err, "hash", meta.Hash)
+		}
+	}
+	return meta, nil
+}
@@ -0,0 +1,238 @@
+package blossom
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/blossom/apk"
+	"github.com/zapstore/relay/pkg/blossom/bunny"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+type mockLister struct {
+	files []bunny.File
+	data  map[string][]byte // by path
+}
+
+func (m *mockLister) add(path string, data []byte, createdAt time.Time) {
+	m.files = append(m.files, bunny.File{Name: filepath.Base(path), Size: int64(len(data)), CreatedAt: createdAt})
+	m.data[path] = data
+}
+
+func (m *mockLister) List(ctx context.Context, dir string) ([]bunny.File, error) {
+	return m.files, nil
+}
+
+func (m *mockLister) Download(ctx context.Context, path string) (io.ReadCloser, error) {
+	data, ok := m.data[path]
+	if !ok {
+		return nil, bunny.ErrFileNotFound
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// mockNotifier records the hashes it's notified of.
+type mockNotifier struct {
+	hashes []string
+}
+
+func (m *mockNotifier) NotifyUpload(hash blossom.Hash, mime string) error {
+	m.hashes = append(m.hashes, hash.Hex())
+	return nil
+}
+
+func TestReconcile(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "blossom.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	old := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Second)
+	apk := "application/vnd.android.package-archive"
+
+	// starts like a zip to match the APK extension
+	apkData := func(s string) []byte { return append([]byte("PK\x03\x04"), s...) }
+
+	stored := apkData("stored")
+	dangling := apkData("dangling")
+	orphan := apkData("orphan")
+	recent := apkData("recent")
+	corrupted := apkData("corrupted")
+
+	lister := &mockLister{data: make(map[string][]byte)}
+	lister.add(BlobPath(blossom.ComputeHash(stored), apk), stored, old)
+	lister.add(BlobPath(blossom.ComputeHash(orphan), apk), orphan, old)
+	lister.add(BlobPath(blossom.ComputeHash(recent), apk), recent, time.Now().UTC())
+	lister.add(BlobPath(blossom.ComputeHash([]byte("original")), apk), corrupted, old)
+	lister.files = append(lister.files, bunny.File{Name: "thumbnails", IsDirectory: true, CreatedAt: old})
+
+	for _, data := range [][]byte{stored, dangling} {
+		meta := store.BlobMeta{Hash: blossom.ComputeHash(data), Type: apk, Size: int64(len(data)), CreatedAt: old}
+		if _, err := db.Save(ctx, meta); err != nil {
+			t.Fatalf("failed to save blob: %v", err)
+		}
+	}
+
+	notifier := &mockNotifier{}
+	reconciler := NewReconciler(NewConfig(), db, lister, notifier)
+
+	// a dry run reports the orphans without saving their metadata
+	report, err := reconciler.Reconcile(ctx, true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if len(report.Recovered) != 2 || len(report.Invalid) != 0 {
+		t.Fatalf("expected the two old orphans to be recoverable, got %v and invalid %v", hashes(report.Recovered), report.Invalid)
+	}
+	if exists, _ := db.Has(ctx, blossom.ComputeHash(orphan)); exists {
+		t.Fatal("expected the dry run to not save the orphan")
+	}
+	if len(notifier.hashes) != 0 {
+		t.Fatalf("expected the dry run to not notify the relay, got %v", notifier.hashes)
+	}
+
+	report, err = reconciler.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatalf("reconciliation failed: %v", err)
+	}
+
+	if report.Files != 4 || report.Blobs != 2 || report.Recent != 1 || report.Failed != 0 {
+		t.Errorf("expected 4 files, 2 blobs, 1 recent and 0 failed, got %+v", report)
+	}
+	if want := []string{blossom.ComputeHash(orphan).Hex()}; !slices.Equal(hashes(report.Recovered), want) {
+		t.Errorf("expected recovered %v, got %v", want, hashes(report.Recovered))
+	}
+	if want := []string{BlobPath(blossom.ComputeHash([]byte("original")), apk)}; !slices.Equal(report.Invalid, want) {
+		t.Errorf("expected invalid %v, got %v", want, report.Invalid)
+	}
+	if want := []string{blossom.ComputeHash(dangling).Hex()}; !slices.Equal(hashes(report.Dangling), want) {
+		t.Errorf("expected dangling %v, got %v", want, hashes(report.Dangling))
+	}
+
+	if want := []string{blossom.ComputeHash(orphan).Hex()}; !slices.Equal(notifier.hashes, want) {
+		t.Errorf("expected the relay to be notified of %v, got %v", want, notifier.hashes)
+	}
+
+	meta, err := db.Query(ctx, blossom.ComputeHash(orphan))
+	if err != nil {
+		t.Fatalf("expected the orphan metadata to be saved, got %v", err)
+	}
+	if meta.Type != apk || meta.Size != int64(len(orphan)) || !meta.CreatedAt.Equal(old) || meta.AuthPubkey != "" {
+		t.Errorf("unexpected metadata of the recovered orphan: %+v", meta)
+	}
+}
+
+func TestReconcileInspection(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "blossom.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	old := time.Now().UTC().Add(-2 * time.Hour).Truncate(time.Second)
+
+	// an APK without signature, which can't be installed
+	buf := &bytes.Buffer{}
+	archive := zip.NewWriter(buf)
+	manifest, err := archive.Create("AndroidManifest.xml")
+	if err != nil {
+		t.Fatalf("failed to create zip entry: %v", err)
+	}
+	manifest.Write(binaryManifest("com.example.app"))
+	if err := archive.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	unsigned := buf.Bytes()
+
+	// an ELF header for linux on aarch64
+	header := elf.Header64{Type: uint16(elf.ET_EXEC), Machine: uint16(elf.EM_AARCH64), Version: uint32(elf.EV_CURRENT), Ehsize: 64}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	elfData := &bytes.Buffer{}
+	if err := binary.Write(elfData, binary.LittleEndian, header); err != nil {
+		t.Fatalf("failed to encode ELF header: %v", err)
+	}
+	executable := elfData.Bytes()
+
+	lister := &mockLister{data: make(map[string][]byte)}
+	lister.add(BlobPath(blossom.ComputeHash(unsigned), apk.MimeType), unsigned, old)
+	lister.add(BlobPath(blossom.ComputeHash(executable), "application/x-executable"), executable, old)
+
+	notifier := &mockNotifier{}
+	report, err := NewReconciler(NewConfig(), db, lister, notifier).Reconcile(ctx, false)
+	if err != nil {
+		t.Fatalf("reconciliation failed: %v", err)
+	}
+
+	if want := []string{BlobPath(blossom.ComputeHash(unsigned), apk.MimeType)}; !slices.Equal(report.Invalid, want) {
+		t.Errorf("expected invalid %v, got %v", want, report.Invalid)
+	}
+	if exists, _ := db.Has(ctx, blossom.ComputeHash(unsigned)); exists {
+		t.Error("expected the unsigned APK to not be recovered")
+	}
+
+	if want := []string{blossom.ComputeHash(executable).Hex()}; !slices.Equal(hashes(report.Recovered), want) {
+		t.Errorf("expected recovered %v, got %v", want, hashes(report.Recovered))
+	}
+	if want := []string{blossom.ComputeHash(executable).Hex()}; !slices.Equal(notifier.hashes, want) {
+		t.Errorf("expected the relay to be notified of %v, got %v", want, notifier.hashes)
+	}
+	if _, err := db.QueryReport(ctx, blossom.ComputeHash(executable)); err != nil {
+		t.Errorf("expected the report of the recovered binary to be saved, got %v", err)
+	}
+	if _, err := db.QueryManifest(ctx, blossom.ComputeHash(unsigned)); !errors.Is(err, store.ErrManifestNotFound) {
+		t.Errorf("expected no manifest for the unsigned APK, got %v", err)
+	}
+}
+
+// binaryManifest returns an Android binary XML manifest with only the package name.
+func binaryManifest(pkg string) []byte {
+	le := binary.LittleEndian
+	chunk := func(kind, headerSize uint16, body []byte) []byte {
+		out := le.AppendUint16(le.AppendUint16(nil, kind), headerSize)
+		return append(le.AppendUint32(out, uint32(8+len(body))), body...)
+	}
+
+	// utf8 string pool with "manifest", "package" and the package name
+	var offsets, data []byte
+	for _, s := range []string{"manifest", "package", pkg} {
+		offsets = le.AppendUint32(offsets, uint32(len(data)))
+		data = append(append(append(data, byte(len(s)), byte(len(s))), s...), 0)
+	}
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+	pool := le.AppendUint32(nil, 3)
+	pool = le.AppendUint32(pool, 0)
+	pool = le.AppendUint32(pool, 1<<8)
+	pool = le.AppendUint32(pool, uint32(28+len(offsets)))
+	pool = le.AppendUint32(pool, 0)
+	pool = append(append(pool, offsets...), data...)
+
+	// <manifest package="..."> with a string attribute
+	element := make([]byte, 0, 56)
+	for _, v := range []uint32{0xFFFFFFFF, 0xFFFFFFFF, 0xFFFFFFFF, 0} {
+		element = le.AppendUint32(element, v)
+	}
+	for _, v := range []uint16{20, 20, 1, 0, 0, 0} {
+		element = le.AppendUint16(element, v)
+	}
+	element = le.AppendUint32(le.AppendUint32(le.AppendUint32(element, 0xFFFFFFFF), 1), 2)
+	element = append(le.AppendUint16(element, 8), 0, 0x03)
+	element = le.AppendUint32(element, 2)
+
+	return chunk(0x0003, 8, append(chunk(0x0001, 28, pool), chunk(0x0102, 16, element)...))
+}
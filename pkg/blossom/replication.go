@@ -0,0 +1,333 @@
+package blossom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pippellia-btc/blossy"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+const (
+	// healthProbePath is checked by the health probes. It doesn't need to exist,
+	// as a backend that answers "not found" is healthy.
+	healthProbePath = "health/probe"
+	probeTimeout    = 10 * time.Second
+
+	// replicationQueueSize is the number of blobs waiting to be replicated after their upload.
+	// When the queue is full, blobs are left to the repair job.
+	replicationQueueSize = 1000
+	replicationTimeout   = 30 * time.Minute
+
+	// repairBatchSize is the number of blobs repaired or checked at once, per replica.
+	repairBatchSize = 100
+)
+
+// Backend is a named storage backend, whose health is probed periodically.
+type Backend struct {
+	Name    string
+	Storage Storage
+	healthy atomic.Bool
+}
+
+// Healthy returns whether the last health probe of the backend succeeded.
+func (b *Backend) Healthy() bool {
+	return b.healthy.Load()
+}
+
+// probe checks whether the backend is reachable, logging when its health changes.
+func (b *Backend) probe(ctx context.Context) {
+	probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	_, err := b.Storage.Check(probeCtx, healthProbePath)
+	if ctx.Err() != nil {
+		// the probe was cancelled, not failed
+		return
+	}
+	healthy := err == nil || errors.Is(err, ErrBlobMissing)
+
+	if was := b.healthy.Swap(healthy); was != healthy {
+		if healthy {
+			slog.Info("blossom: storage backend is healthy again", "backend", b.Name)
+		} else {
+			slog.Error("blossom: storage backend is unhealthy", "backend", b.Name, "error", err)
+		}
+	}
+}
+
+// Backends are the primary storage backend, followed by the replicas.
+type Backends []*Backend
+
+// NewBackends returns the primary storage backend and the replicas selected by the config,
+// which is assumed to have been validated. All backends start as healthy.
+func NewBackends(c Config) (Backends, error) {
+	backends := make(Backends, 0, 1+len(c.Replicas))
+	for _, name := range append([]string{c.Backend}, c.Replicas...) {
+		storage, err := newBackend(c, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+
+		backend := &Backend{Name: name, Storage: storage}
+		backend.healthy.Store(true)
+		backends = append(backends, backend)
+	}
+	return backends, nil
+}
+
+// Primary returns the backend where blobs are uploaded.
+func (bs Backends) Primary() *Backend {
+	return bs[0]
+}
+
+// Replicas returns the backends where blobs are copied after being uploaded to the primary.
+func (bs Backends) Replicas() Backends {
+	return bs[1:]
+}
+
+// Delete removes the blob at the path from all the backends.
+// It fails if any backend fails, so that no copy is left behind once the metadata is deleted.
+func (bs Backends) Delete(ctx context.Context, path string) error {
+	var errs []error
+	for _, backend := range bs {
+		if err := backend.Storage.Delete(ctx, path); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", backend.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runHealthProbes probes the health of all backends every [Config.HealthInterval], until the context is cancelled.
+func (b *T) runHealthProbes(ctx context.Context) {
+	ticker := time.NewTicker(b.config.HealthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			var wg sync.WaitGroup
+			for _, backend := range b.backends {
+				wg.Go(func() { backend.probe(ctx) })
+			}
+			wg.Wait()
+		}
+	}
+}
+
+// deliver returns the delivery of the blob from the first healthy backend holding it,
+// trying the primary first and then the replicas. If no backend is healthy, the primary is used anyway.
+func (b *T) deliver(ctx context.Context, meta store.BlobMeta) (blossy.BlobDelivery, error) {
+	path := BlobPath(meta.Hash, meta.Type)
+	primary := b.backends.Primary()
+	if primary.Healthy() || len(b.backends) == 1 {
+		return primary.Storage.Deliver(ctx, path, meta.Type)
+	}
+
+	holders, err := b.store.Replicas(ctx, meta.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, replica := range b.backends.Replicas() {
+		if !replica.Healthy() || !slices.Contains(holders, replica.Name) {
+			continue
+		}
+
+		delivery, err := replica.Storage.Deliver(ctx, path, meta.Type)
+		if err != nil {
+			slog.Warn("blossom: failed to deliver blob from replica", "backend", replica.Name, "error", err, "hash", meta.Hash)
+			continue
+		}
+		return delivery, nil
+	}
+	return primary.Storage.Deliver(ctx, path, meta.Type)
+}
+
+func newReplicationQueue() chan store.BlobMeta {
+	return make(chan store.BlobMeta, replicationQueueSize)
+}
+
+// enqueueReplication schedules the copy of the blob to the replicas, without blocking.
+// If the queue is full, the blob is copied by the next repair.
+func (b *T) enqueueReplication(meta store.BlobMeta) {
+	if b.replication == nil {
+		return
+	}
+
+	select {
+	case b.replication <- meta:
+	default:
+		slog.Warn("blossom: replication queue is full, leaving the blob to the repair", "hash", meta.Hash)
+	}
+}
+
+// runReplication copies the blobs in the replication queue to the replicas, until the context is cancelled.
+func (b *T) runReplication(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case meta := <-b.replication:
+			for _, replica := range b.backends.Replicas() {
+				if !replica.Healthy() {
+					continue
+				}
+				if err := b.replicate(ctx, meta, replica); err != nil && ctx.Err() == nil {
+					slog.Error("blossom: failed to replicate blob", "backend", replica.Name, "error", err, "hash", meta.Hash)
+				}
+			}
+		}
+	}
+}
+
+// replicate copies the blob to the replica, reading it from the primary or from another healthy replica
+// holding it if the primary is unhealthy, and records the copy.
+func (b *T) replicate(ctx context.Context, meta store.BlobMeta, replica *Backend) error {
+	ctx, cancel := context.WithTimeout(ctx, replicationTimeout)
+	defer cancel()
+
+	source, err := b.replicationSource(ctx, meta, replica)
+	if err != nil {
+		return err
+	}
+
+	path := BlobPath(meta.Hash, meta.Type)
+	data, err := source.Storage.Open(ctx, path)
+	if err != nil {
+		return fmt.Errorf("failed to read from %s: %w", source.Name, err)
+	}
+	defer data.Close()
+
+	if err := replica.Storage.Upload(ctx, data, meta.Size, path, meta.Hash.Hex()); err != nil {
+		return fmt.Errorf("failed to copy from %s: %w", source.Name, err)
+	}
+	return b.store.SaveReplica(ctx, meta.Hash, replica.Name, time.Now().UTC())
+}
+
+// replicationSource returns the backend to read the blob from to copy it to the replica.
+func (b *T) replicationSource(ctx context.Context, meta store.BlobMeta, replica *Backend) (*Backend, error) {
+	primary := b.backends.Primary()
+	if primary.Healthy() {
+		return primary, nil
+	}
+
+	holders, err := b.store.Replicas(ctx, meta.Hash)
+	if err != nil {
+		return nil, err
+	}
+	for _, backend := range b.backends.Replicas() {
+		if backend != replica && backend.Healthy() && slices.Contains(holders, backend.Name) {
+			return backend, nil
+		}
+	}
+	return nil, errors.New("no healthy backend holds the blob")
+}
+
+// runRepair repairs the replicas every [Config.RepairInterval], until the context is cancelled.
+func (b *T) runRepair(ctx context.Context) {
+	ticker := time.NewTicker(b.config.RepairInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			for _, replica := range b.backends.Replicas() {
+				if !replica.Healthy() {
+					continue
+				}
+
+				repaired, failed, err := b.repair(ctx, replica)
+				if err != nil && !errors.Is(err, context.Canceled) {
+					slog.Error("blossom: failed to repair replica", "backend", replica.Name, "error", err)
+				}
+				if repaired > 0 || failed > 0 {
+					slog.Info("blossom: repaired replica", "backend", replica.Name, "repaired", repaired, "failed", failed)
+				}
+			}
+		}
+	}
+}
+
+// repair copies to the replica the blobs that are missing from it: the ones that were never copied
+// (e.g. because the replica was unhealthy), and the ones whose copy is no longer found when checked
+// again after [Config.RepairInterval]. It stops at the first batch with failures, to not insist
+// on a failing replica. It returns the number of blobs copied and failed.
+func (b *T) repair(ctx context.Context, replica *Backend) (repaired, failed int, err error) {
+	if err := b.checkReplica(ctx, replica); err != nil {
+		return 0, 0, err
+	}
+
+	// blobs uploaded recently are left to the replication queue
+	before := time.Now().Add(-b.config.RepairInterval)
+	for {
+		blobs, err := b.store.Unreplicated(ctx, replica.Name, before, repairBatchSize)
+		if err != nil {
+			return repaired, failed, err
+		}
+
+		for _, blob := range blobs {
+			if err := b.replicate(ctx, blob, replica); err != nil {
+				if ctx.Err() != nil {
+					return repaired, failed, ctx.Err()
+				}
+				slog.Warn("blossom: failed to repair blob", "backend", replica.Name, "error", err, "hash", blob.Hash)
+				failed++
+				continue
+			}
+			repaired++
+		}
+
+		if failed > 0 || len(blobs) < repairBatchSize {
+			return repaired, failed, nil
+		}
+	}
+}
+
+// checkReplica checks whether the copies in the replica last checked more than [Config.RepairInterval] ago
+// still exist, forgetting the ones that don't so that they are copied again.
+func (b *T) checkReplica(ctx context.Context, replica *Backend) error {
+	now := time.Now().UTC()
+	blobs, err := b.store.StaleReplicas(ctx, replica.Name, now.Add(-b.config.RepairInterval), repairBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range blobs {
+		checkCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		_, err := replica.Storage.Check(checkCtx, BlobPath(blob.Hash, blob.Type))
+		cancel()
+
+		switch {
+		case err == nil:
+			if err := b.store.TouchReplica(ctx, blob.Hash, replica.Name, now); err != nil {
+				return err
+			}
+
+		case errors.Is(err, ErrBlobMissing):
+			slog.Warn("blossom: blob missing from replica", "backend", replica.Name, "hash", blob.Hash)
+			if err := b.store.DeleteReplica(ctx, blob.Hash, replica.Name); err != nil {
+				return err
+			}
+
+		default:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// the replica is unreachable, the copies are checked again on the next repair
+			return fmt.Errorf("failed to check blob %s: %w", blob.Hash, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package blossom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+var errDown = errors.New("backend is down")
+
+// memStorage is an in-memory [Storage] that can be taken down.
+type memStorage struct {
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	down      bool
+	delivered int
+}
+
+func newMemStorage() *memStorage {
+	return &memStorage{blobs: make(map[string][]byte)}
+}
+
+func (m *memStorage) Upload(ctx context.Context, data io.Reader, size int64, path, sha256 string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return errDown
+	}
+
+	content, err := io.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	if blossom.ComputeHash(content).Hex() != sha256 {
+		return ErrChecksumMismatch
+	}
+	m.blobs[path] = content
+	return nil
+}
+
+func (m *memStorage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return nil, errDown
+	}
+
+	content, ok := m.blobs[path]
+	if !ok {
+		return nil, ErrBlobMissing
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+func (m *memStorage) Check(ctx context.Context, path string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return 0, errDown
+	}
+
+	content, ok := m.blobs[path]
+	if !ok {
+		return 0, ErrBlobMissing
+	}
+	return int64(len(content)), nil
+}
+
+func (m *memStorage) Delete(ctx context.Context, path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.down {
+		return errDown
+	}
+
+	delete(m.blobs, path)
+	return nil
+}
+
+func (m *memStorage) Deliver(ctx context.Context, path, mime string) (blossy.BlobDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delivered++
+	return blossy.Redirect("https://example.com/"+path, http.StatusTemporaryRedirect), nil
+}
+
+func (m *memStorage) has(path string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.blobs[path]
+	return ok
+}
+
+// newReplicated returns a blossom server with a primary and a replica memStorage.
+func newReplicated(t *testing.T) (*T, *memStorage, *memStorage) {
+	db, err := store.New(filepath.Join(t.TempDir(), "blossom.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	primary, replica := newMemStorage(), newMemStorage()
+	backends := Backends{
+		{Name: "primary", Storage: primary},
+		{Name: "replica", Storage: replica},
+	}
+	for _, backend := range backends {
+		backend.healthy.Store(true)
+	}
+
+	config := NewConfig()
+	config.RepairInterval = time.Hour
+	return &T{config: config, storage: primary, backends: backends, store: db}, primary, replica
+}
+
+// saveBlob stores the blob in the primary and its metadata in the store, as if uploaded at the given time.
+func saveBlob(t *testing.T, b *T, primary *memStorage, content string, createdAt time.Time) store.BlobMeta {
+	data := []byte(content)
+	meta := store.BlobMeta{Hash: blossom.ComputeHash(data), Type: "image/png", Size: int64(len(data)), CreatedAt: createdAt}
+
+	if err := primary.Upload(ctx, bytes.NewReader(data), meta.Size, BlobPath(meta.Hash, meta.Type), meta.Hash.Hex()); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+	if _, err := b.store.Save(ctx, meta); err != nil {
+		t.Fatalf("failed to save blob: %v", err)
+	}
+	return meta
+}
+
+func TestReplicate(t *testing.T) {
+	b, primary, replica := newReplicated(t)
+	meta := saveBlob(t, b, primary, "hello", time.Now().UTC())
+	path := BlobPath(meta.Hash, meta.Type)
+
+	if err := b.replicate(ctx, meta, b.backends[1]); err != nil {
+		t.Fatalf("failed to replicate: %v", err)
+	}
+	if !replica.has(path) {
+		t.Fatal("expected the blob to be copied to the replica")
+	}
+
+	holders, err := b.store.Replicas(ctx, meta.Hash)
+	if err != nil || len(holders) != 1 || holders[0] != "replica" {
+		t.Fatalf("expected the copy to be recorded, got %v (%v)", holders, err)
+	}
+
+	// deleting the blob removes every copy
+	if err := b.backends.Delete(ctx, path); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	if primary.has(path) || replica.has(path) {
+		t.Fatal("expected the blob to be deleted from all backends")
+	}
+}
+
+func TestDeliverFailover(t *testing.T) {
+	b, primary, replica := newReplicated(t)
+	replicated := saveBlob(t, b, primary, "replicated", time.Now().UTC())
+	unreplicated := saveBlob(t, b, primary, "unreplicated", time.Now().UTC())
+
+	if err := b.replicate(ctx, replicated, b.backends[1]); err != nil {
+		t.Fatalf("failed to replicate: %v", err)
+	}
+
+	tests := []struct {
+		name           string
+		meta           store.BlobMeta
+		primaryHealthy bool
+		replicaHealthy bool
+		fromPrimary    bool
+	}{
+		{name: "healthy primary", meta: replicated, primaryHealthy: true, replicaHealthy: true, fromPrimary: true},
+		{name: "unhealthy primary", meta: replicated, primaryHealthy: false, replicaHealthy: true, fromPrimary: false},
+		{name: "not replicated", meta: unreplicated, primaryHealthy: false, replicaHealthy: true, fromPrimary: true},
+		{name: "all unhealthy", meta: replicated, primaryHealthy: false, replicaHealthy: false, fromPrimary: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b.backends[0].healthy.Store(test.primaryHealthy)
+			b.backends[1].healthy.Store(test.replicaHealthy)
+			primary.delivered, replica.delivered = 0, 0
+
+			if _, err := b.deliver(ctx, test.meta); err != nil {
+				t.Fatalf("failed to deliver: %v", err)
+			}
+			if got := primary.delivered == 1; got != test.fromPrimary {
+				t.Fatalf("expected delivery from primary %v, got primary %d, replica %d", test.fromPrimary, primary.delivered, replica.delivered)
+			}
+		})
+	}
+}
+
+func TestProbe(t *testing.T) {
+	storage := newMemStorage()
+	backend := &Backend{Name: "mem", Storage: storage}
+	backend.healthy.Store(true)
+
+	storage.down = true
+	backend.probe(ctx)
+	if backend.Healthy() {
+		t.Fatal("expected the backend to be unhealthy")
+	}
+
+	// the probe path doesn't exist, but the backend answers
+	storage.down = false
+	backend.probe(ctx)
+	if !backend.Healthy() {
+		t.Fatal("expected the backend to be healthy")
+	}
+}
+
+func TestRepair(t *testing.T) {
+	b, primary, replica := newReplicated(t)
+	old := saveBlob(t, b, primary, "old", time.Now().UTC().Add(-2*time.Hour))
+	recent := saveBlob(t, b, primary, "recent", time.Now().UTC())
+	oldPath, recentPath := BlobPath(old.Hash, old.Type), BlobPath(recent.Hash, recent.Type)
+
+	// the replica is down: the copy fails and is retried on the next repair
+	replica.down = true
+	repaired, failed, err := b.repair(ctx, b.backends[1])
+	if err != nil || repaired != 0 || failed != 1 {
+		t.Fatalf("expected 0 repaired and 1 failed, got %d and %d (%v)", repaired, failed, err)
+	}
+
+	replica.down = false
+	repaired, failed, err = b.repair(ctx, b.backends[1])
+	if err != nil || repaired != 1 || failed != 0 {
+		t.Fatalf("expected 1 repaired and 0 failed, got %d and %d (%v)", repaired, failed, err)
+	}
+	if !replica.has(oldPath) {
+		t.Fatal("expected the old blob to be copied to the replica")
+	}
+	if replica.has(recentPath) {
+		t.Fatal("expected the recent blob to be left to the replication queue")
+	}
+
+	// the copy disappears from the replica, and is found missing once checked again
+	replica.Delete(ctx, oldPath)
+	if err := b.store.TouchReplica(ctx, old.Hash, "replica", time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatalf("failed to touch replica: %v", err)
+	}
+
+	repaired, failed, err = b.repair(ctx, b.backends[1])
+	if err != nil || repaired != 1 || failed != 0 {
+		t.Fatalf("expected 1 repaired and 0 failed, got %d and %d (%v)", repaired, failed, err)
+	}
+	if !replica.has(oldPath) {
+		t.Fatal("expected the missing blob to be copied again to the replica")
+	}
+}
@@ -0,0 +1,95 @@
+package s3
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+type Config struct {
+	// Endpoint is the URL of the S3-compatible API, including the scheme
+	// (e.g. "https://s3.eu-central-1.amazonaws.com", "https://<account>.r2.cloudflarestorage.com",
+	// or "http://localhost:3900" for a local Garage).
+	Endpoint string `env:"S3_ENDPOINT"`
+
+	// Region is the region used to sign requests. Default is "us-east-1".
+	// R2 uses "auto", and Garage the name configured as its s3_region.
+	Region string `env:"S3_REGION"`
+
+	// Bucket is the name of the bucket where blobs are stored.
+	Bucket string `env:"S3_BUCKET"`
+
+	// The credentials of an access key with read and write permissions on the bucket.
+	AccessKeyID     string `env:"S3_ACCESS_KEY_ID"`
+	SecretAccessKey string `env:"S3_SECRET_ACCESS_KEY"`
+
+	// PathStyle addresses the bucket in the path (endpoint/bucket/key) instead of the
+	// hostname (bucket.endpoint/key). Most self-hosted servers require it. Default is true.
+	PathStyle bool `env:"S3_PATH_STYLE"`
+
+	// PresignExpiry is how long the presigned download URLs are valid. Default is 1 hour.
+	PresignExpiry time.Duration `env:"S3_PRESIGN_EXPIRY"`
+
+	// The hard ceiling for requests to S3. Default is 20 minutes.
+	RequestTimeout time.Duration `env:"S3_REQUEST_TIMEOUT"`
+}
+
+func NewConfig() Config {
+	return Config{
+		Region:         "us-east-1",
+		PathStyle:      true,
+		PresignExpiry:  time.Hour,
+		RequestTimeout: 20 * time.Minute,
+	}
+}
+
+func (c Config) Validate() error {
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return fmt.Errorf("endpoint %q must be a valid URL", c.Endpoint)
+	}
+	if endpoint.Scheme != "https" && endpoint.Scheme != "http" {
+		return fmt.Errorf("endpoint scheme must be https or http, got %q", endpoint.Scheme)
+	}
+	if endpoint.Path != "" && endpoint.Path != "/" {
+		return errors.New("endpoint must not include a path")
+	}
+	if c.Region == "" {
+		return errors.New("region must be specified")
+	}
+	if c.Bucket == "" {
+		return errors.New("bucket must be specified")
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return errors.New("access key id and secret access key must be specified")
+	}
+	if c.PresignExpiry < time.Minute || c.PresignExpiry > 7*24*time.Hour {
+		return errors.New("presign expiry must be between 1 minute and 7 days")
+	}
+	if c.RequestTimeout < 20*time.Second {
+		return errors.New("request timeout must be greater than 20s to function reliably")
+	}
+	return nil
+}
+
+func (c Config) String() string {
+	return fmt.Sprintf("S3:\n"+
+		"\tEndpoint: %s\n"+
+		"\tRegion: %s\n"+
+		"\tBucket: %s\n"+
+		"\tAccess Key ID: %s\n"+
+		"\tSecret Access Key: %s\n"+
+		"\tPath Style: %v\n"+
+		"\tPresign Expiry: %v\n"+
+		"\tRequest Timeout: %v\n",
+		c.Endpoint, c.Region, c.Bucket, c.AccessKeyID, redact(c.SecretAccessKey), c.PathStyle, c.PresignExpiry, c.RequestTimeout)
+}
+
+// redact hides the secret, showing only its first and last 4 characters when it's long enough.
+func redact(secret string) string {
+	if len(secret) < 16 {
+		return "___REDACTED___"
+	}
+	return secret[:4] + "___REDACTED___" + secret[len(secret)-4:]
+}
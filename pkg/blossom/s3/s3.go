@@ -0,0 +1,284 @@
+// The s3 package is responsible for interacting with S3-compatible object stores (AWS S3, R2, MinIO, Garage).
+// It exposes a [NewClient] function to create a new client with the given config.
+package s3
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+)
+
+var (
+	ErrEmptyData        = errors.New("empty data")
+	ErrEmptyPath        = errors.New("empty path")
+	ErrInvalidChecksum  = errors.New("invalid sha256 checksum")
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrFileNotFound     = errors.New("file not found")
+)
+
+// checksumErrors are the error codes returned by S3-compatible servers when
+// the content doesn't match the x-amz-checksum-sha256 or x-amz-content-sha256 headers.
+var checksumErrors = []string{"BadDigest", "XAmzContentSHA256Mismatch", "InvalidDigest"}
+
+type Client struct {
+	http     http.Client
+	config   Config
+	endpoint *url.URL
+	signer   signer
+}
+
+// NewClient returns a client from the provided [Config], which is assumed to have been validated.
+func NewClient(c Config) Client {
+	endpoint, _ := url.Parse(c.Endpoint)
+	return Client{
+		http:     http.Client{Timeout: c.RequestTimeout},
+		config:   c,
+		endpoint: endpoint,
+		signer: signer{
+			accessKeyID:     c.AccessKeyID,
+			secretAccessKey: c.SecretAccessKey,
+			region:          c.Region,
+		},
+	}
+}
+
+// ObjectURL returns the URL of the object at the provided path in the bucket.
+func (c Client) ObjectURL(path string) *url.URL {
+	path = strings.Trim(path, "/")
+	u := *c.endpoint
+	if c.config.PathStyle {
+		u.Path = "/" + c.config.Bucket + "/" + path
+	} else {
+		u.Host = c.config.Bucket + "." + u.Host
+		u.Path = "/" + path
+	}
+	return &u
+}
+
+// Upload the data to the bucket with the specified path.
+//
+// S3 requires the size of the data, so if it's unknown (-1), the data is first written to a temporary file.
+// If the hex-encoded sha256 is not empty (not ""), it's used to set the "x-amz-checksum-sha256" header
+// and to sign the payload. If the sha256(data) != sha256, the server rejects the upload
+// and [ErrChecksumMismatch] is returned.
+func (c Client) Upload(ctx context.Context, data io.Reader, size int64, path string, sha256 string) error {
+	if data == nil {
+		return fmt.Errorf("s3: failed to upload: %w", ErrEmptyData)
+	}
+	if path == "" {
+		return fmt.Errorf("s3: failed to upload: %w", ErrEmptyPath)
+	}
+
+	payloadHash := unsignedPayload
+	if sha256 != "" {
+		if err := blossom.ValidateHash(sha256); err != nil {
+			return fmt.Errorf("s3: failed to upload: %w: %w", ErrInvalidChecksum, err)
+		}
+		payloadHash = strings.ToLower(sha256)
+	}
+
+	if size < 0 {
+		spooled, n, err := spool(data)
+		if err != nil {
+			return fmt.Errorf("s3: failed to upload: %w", err)
+		}
+		defer spooled.Close()
+		data, size = spooled, n
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.ObjectURL(path).String(), io.NopCloser(data))
+	if err != nil {
+		return fmt.Errorf("s3: failed to create request: %w", err)
+	}
+
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	if sha256 != "" {
+		sum, _ := hex.DecodeString(sha256)
+		req.Header.Set("X-Amz-Checksum-Sha256", base64.StdEncoding.EncodeToString(sum))
+	}
+	c.signer.Sign(req, payloadHash, time.Now())
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3: failed to upload: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	apiErr := parseError(res)
+	for _, code := range checksumErrors {
+		if apiErr.Code == code {
+			return fmt.Errorf("s3: failed to upload: %w: %s", ErrChecksumMismatch, apiErr.Message)
+		}
+	}
+	return fmt.Errorf("s3: failed to upload: status %s: %s", res.Status, apiErr)
+}
+
+// spool writes the data to a temporary file, returning it rewound with its size.
+// The file is removed when closed.
+func spool(data io.Reader) (io.ReadCloser, int64, error) {
+	f, err := os.CreateTemp("", "s3-upload-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	file := removeOnClose{f}
+
+	size, err := io.Copy(f, data)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, size, nil
+}
+
+type removeOnClose struct {
+	*os.File
+}
+
+func (f removeOnClose) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+// Download the object at the specified path.
+// Returns the reader for the object, or an error if it does not exist.
+// The caller is responsible for closing the reader.
+func (c Client) Download(ctx context.Context, path string) (io.ReadCloser, error) {
+	if path == "" {
+		return nil, fmt.Errorf("s3: failed to download: %w", ErrEmptyPath)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.ObjectURL(path).String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("s3: failed to create request: %w", err)
+	}
+	c.signer.Sign(req, emptySHA256, time.Now())
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3: failed to download: %w", err)
+	}
+
+	if res.StatusCode == http.StatusOK {
+		return res.Body, nil
+	}
+
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("s3: failed to download: %w", ErrFileNotFound)
+	}
+	return nil, fmt.Errorf("s3: failed to download: status %s: %s", res.Status, parseError(res))
+}
+
+// Check returns the size of the object at the specified path, with a HEAD request.
+func (c Client) Check(ctx context.Context, path string) (size int64, err error) {
+	if path == "" {
+		return 0, fmt.Errorf("s3: failed to check: %w", ErrEmptyPath)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.ObjectURL(path).String(), nil)
+	if err != nil {
+		return 0, fmt.Errorf("s3: failed to check: failed to create request: %w", err)
+	}
+	c.signer.Sign(req, emptySHA256, time.Now())
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("s3: failed to check: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		if res.ContentLength < 0 {
+			return 0, errors.New("s3: failed to check: missing content length")
+		}
+		return res.ContentLength, nil
+
+	case http.StatusNotFound:
+		return 0, fmt.Errorf("s3: failed to check: %w", ErrFileNotFound)
+
+	default:
+		return 0, fmt.Errorf("s3: failed to check: status %s", res.Status)
+	}
+}
+
+// Delete the object at the specified path.
+// Returns nil if the object was deleted successfully, or if it did not exist.
+func (c Client) Delete(ctx context.Context, path string) error {
+	if path == "" {
+		return fmt.Errorf("s3: failed to delete: %w", ErrEmptyPath)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.ObjectURL(path).String(), nil)
+	if err != nil {
+		return fmt.Errorf("s3: failed to create request: %w", err)
+	}
+	c.signer.Sign(req, emptySHA256, time.Now())
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3: failed to delete: %w", err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return fmt.Errorf("s3: failed to delete: status %s: %s", res.Status, parseError(res))
+	}
+}
+
+// PresignedURL returns a URL to download the object at the specified path without credentials,
+// valid for [Config.PresignExpiry].
+func (c Client) PresignedURL(path string) string {
+	req := &http.Request{Method: http.MethodGet, URL: c.ObjectURL(path)}
+	return c.signer.Presign(req, c.config.PresignExpiry, time.Now())
+}
+
+// apiError is the XML error returned by S3-compatible servers.
+type apiError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e apiError) String() string {
+	if e.Code == "" {
+		return "no error details"
+	}
+	return e.Code + ": " + e.Message
+}
+
+// parseError parses the error in the body of the response, returning an empty error if it can't.
+func parseError(res *http.Response) apiError {
+	var e apiError
+	body, err := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if err != nil {
+		return e
+	}
+	xml.Unmarshal(body, &e)
+	return e
+}
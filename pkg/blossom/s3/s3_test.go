@@ -0,0 +1,347 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// =========================== TESTS ============================
+// The tests run against fakeS3, an in-memory S3-compatible server that verifies the
+// signatures and the x-amz-checksum-sha256 header like a real one.
+// ================================================================
+
+var ctx = context.Background()
+
+func TestUpload(t *testing.T) {
+	data := []byte("hello world")
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	tests := []struct {
+		name   string
+		path   string
+		data   io.Reader
+		size   int64
+		sha256 string
+		err    error
+	}{
+		{
+			name: "nil data",
+			path: "blobs/nil.txt",
+			err:  ErrEmptyData,
+		},
+		{
+			name: "empty path",
+			data: bytes.NewReader(data),
+			err:  ErrEmptyPath,
+		},
+		{
+			name:   "invalid checksum",
+			path:   "blobs/invalid.txt",
+			data:   bytes.NewReader(data),
+			size:   int64(len(data)),
+			sha256: "not-a-hash",
+			err:    ErrInvalidChecksum,
+		},
+		{
+			name:   "checksum mismatch",
+			path:   "blobs/mismatch.txt",
+			data:   strings.NewReader("another content"),
+			size:   15,
+			sha256: hash,
+			err:    ErrChecksumMismatch,
+		},
+		{
+			name:   "valid",
+			path:   "blobs/" + hash + ".txt",
+			data:   bytes.NewReader(data),
+			size:   int64(len(data)),
+			sha256: hash,
+		},
+		{
+			name:   "unknown size",
+			path:   "blobs/unknown-size.txt",
+			data:   io.MultiReader(bytes.NewReader(data)),
+			size:   -1,
+			sha256: hash,
+		},
+		{
+			name: "no checksum",
+			path: "blobs/no-checksum.txt",
+			data: bytes.NewReader(data),
+			size: int64(len(data)),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fake, client := newFakeS3(t)
+			err := client.Upload(ctx, test.data, test.size, test.path, test.sha256)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			stored, ok := fake.object(test.path)
+			if test.err != nil && ok {
+				t.Fatalf("expected nothing stored at %s", test.path)
+			}
+			if test.err == nil && !bytes.Equal(stored, data) {
+				t.Fatalf("expected %q stored at %s, got %q", data, test.path, stored)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	fake, client := newFakeS3(t)
+	fake.put("blobs/exists.txt", []byte("hello world"))
+
+	size, err := client.Check(ctx, "blobs/exists.txt")
+	if err != nil || size != 11 {
+		t.Fatalf("expected size 11, got %d (%v)", size, err)
+	}
+
+	if _, err := client.Check(ctx, "blobs/missing.txt"); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrFileNotFound, err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	fake, client := newFakeS3(t)
+	fake.put("blobs/exists.txt", []byte("hello world"))
+
+	reader, err := client.Download(ctx, "blobs/exists.txt")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil || string(data) != "hello world" {
+		t.Fatalf("expected %q, got %q (%v)", "hello world", data, err)
+	}
+
+	if _, err := client.Download(ctx, "blobs/missing.txt"); !errors.Is(err, ErrFileNotFound) {
+		t.Fatalf("expected error %v, got %v", ErrFileNotFound, err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	fake, client := newFakeS3(t)
+	fake.put("blobs/exists.txt", []byte("hello world"))
+
+	if err := client.Delete(ctx, "blobs/exists.txt"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := fake.object("blobs/exists.txt"); ok {
+		t.Fatal("expected the object to be deleted")
+	}
+
+	if err := client.Delete(ctx, "blobs/missing.txt"); err != nil {
+		t.Fatalf("expected no error deleting a missing object, got %v", err)
+	}
+}
+
+func TestPresignedURL(t *testing.T) {
+	fake, client := newFakeS3(t)
+	fake.put("blobs/exists.txt", []byte("hello world"))
+
+	res, err := http.Get(client.PresignedURL("blobs/exists.txt"))
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || string(body) != "hello world" {
+		t.Fatalf("expected 200 with the content, got %s: %q", res.Status, body)
+	}
+
+	// tampering with the URL invalidates the signature
+	tampered := strings.Replace(client.PresignedURL("blobs/exists.txt"), "exists", "other", 1)
+	res, err = http.Get(tampered)
+	if err != nil {
+		t.Fatalf("failed to download: %v", err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected status 403, got %s", res.Status)
+	}
+}
+
+func TestObjectURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		pathStyle bool
+		expected  string
+	}{
+		{name: "path style", pathStyle: true, expected: "https://s3.example.com/zapstore/blobs/abc.apk"},
+		{name: "virtual hosted", pathStyle: false, expected: "https://zapstore.s3.example.com/blobs/abc.apk"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := NewConfig()
+			config.Endpoint = "https://s3.example.com"
+			config.Bucket = "zapstore"
+			config.PathStyle = test.pathStyle
+
+			u := NewClient(config).ObjectURL("/blobs/abc.apk")
+			if u.String() != test.expected {
+				t.Fatalf("expected %s, got %s", test.expected, u)
+			}
+		})
+	}
+}
+
+// fakeS3 is an in-memory S3-compatible server with a single bucket.
+type fakeS3 struct {
+	t       *testing.T
+	bucket  string
+	signer  signer
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+// newFakeS3 starts a fakeS3 server, returning it with a client configured to use it.
+func newFakeS3(t *testing.T) (*fakeS3, Client) {
+	fake := &fakeS3{
+		t:       t,
+		bucket:  "zapstore",
+		signer:  signer{accessKeyID: "test-key", secretAccessKey: "test-secret", region: "garage"},
+		objects: make(map[string][]byte),
+	}
+
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	config := NewConfig()
+	config.Endpoint = server.URL
+	config.Region = fake.signer.region
+	config.Bucket = fake.bucket
+	config.AccessKeyID = fake.signer.accessKeyID
+	config.SecretAccessKey = fake.signer.secretAccessKey
+	if err := config.Validate(); err != nil {
+		t.Fatalf("invalid config: %v", err)
+	}
+	return fake, NewClient(config)
+}
+
+func (f *fakeS3) put(key string, data []byte) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[key] = data
+}
+
+func (f *fakeS3) object(key string) ([]byte, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	data, ok := f.objects[key]
+	return data, ok
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if !f.authorized(r) {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+
+		sum := sha256.Sum256(data)
+		if payload := r.Header.Get("X-Amz-Content-Sha256"); payload != unsignedPayload && payload != hex.EncodeToString(sum[:]) {
+			writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch")
+			return
+		}
+		if checksum := r.Header.Get("X-Amz-Checksum-Sha256"); checksum != "" && checksum != base64.StdEncoding.EncodeToString(sum[:]) {
+			writeError(w, http.StatusBadRequest, "BadDigest")
+			return
+		}
+		f.put(key, data)
+
+	case http.MethodHead, http.MethodGet:
+		data, ok := f.object(key)
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+
+	case http.MethodDelete:
+		f.mu.Lock()
+		delete(f.objects, key)
+		f.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// authorized verifies the signature of the request, in the headers or in the query for presigned URLs.
+func (f *fakeS3) authorized(r *http.Request) bool {
+	u := *r.URL
+	u.Scheme, u.Host = "http", r.Host
+
+	query := u.Query()
+	if signature := query.Get("X-Amz-Signature"); signature != "" {
+		date, err := time.Parse(timeFormat, query.Get("X-Amz-Date"))
+		if err != nil {
+			return false
+		}
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || time.Since(date) > time.Duration(expires)*time.Second {
+			return false
+		}
+
+		u.RawQuery = ""
+		presigned, _ := url.Parse(f.signer.Presign(&http.Request{Method: r.Method, URL: &u}, time.Duration(expires)*time.Second, date))
+		return presigned.Query().Get("X-Amz-Signature") == signature
+	}
+
+	date, err := time.Parse(timeFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	expected := &http.Request{Method: r.Method, URL: &u, Header: make(http.Header)}
+	for name, values := range r.Header {
+		if name != "Authorization" {
+			expected.Header[name] = values
+		}
+	}
+	f.signer.Sign(expected, r.Header.Get("X-Amz-Content-Sha256"), date)
+	return expected.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>`+code+`</Code><Message>`+code+`</Message></Error>`)
+}
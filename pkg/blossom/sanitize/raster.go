@@ -0,0 +1,247 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+const (
+	pngSignature = "\x89PNG\r\n\x1a\n"
+
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+var (
+	// pngAncillary are the ancillary PNG chunks that affect how the image is displayed, which are kept.
+	// Other ancillary chunks, like tEXt, iTXt, zTXt, eXIf and tIME, are metadata and are dropped.
+	pngAncillary = []string{
+		"tRNS", "cHRM", "gAMA", "iCCP", "sBIT", "sRGB", "cICP", "mDCv", "cLLi",
+		"bKGD", "hIST", "pHYs", "sPLT", "acTL", "fcTL", "fdAT",
+	}
+
+	// webpChunks are the WebP chunks that are kept. Others, like EXIF and XMP, are dropped.
+	webpChunks = []string{"VP8 ", "VP8L", "VP8X", "ALPH", "ANIM", "ANMF", "ICCP"}
+)
+
+// sanitizeJPEG drops the APPn segments except JFIF, ICC profiles and Adobe color information, the comments
+// and any data after the end of the image. The orientation in EXIF is lost, which affects only photos
+// taken with a rotated camera, not icons and screenshots.
+func sanitizeJPEG(data []byte) (out []byte, width, height int, err error) {
+	if !bytes.HasPrefix(data, []byte{0xff, 0xd8}) {
+		return nil, 0, 0, errors.New("missing JPEG start of image")
+	}
+
+	out = append(make([]byte, 0, len(data)), 0xff, 0xd8)
+	for i := 2; i < len(data); {
+		if data[i] != 0xff {
+			return nil, 0, 0, fmt.Errorf("expected JPEG marker at offset %d", i)
+		}
+		for i < len(data) && data[i] == 0xff {
+			i++ // fill bytes
+		}
+		if i == len(data) {
+			break
+		}
+
+		marker := data[i]
+		i++
+		switch {
+		case marker == 0xd9: // end of image
+			if width == 0 || height == 0 {
+				return nil, 0, 0, errors.New("missing JPEG frame header")
+			}
+			return append(out, 0xff, 0xd9), width, height, nil
+
+		case marker >= 0xd0 && marker <= 0xd7, marker == 0x01: // markers without a length
+			out = append(out, 0xff, marker)
+			continue
+		}
+
+		if i+2 > len(data) {
+			return nil, 0, 0, errors.New("truncated JPEG segment")
+		}
+		length := int(binary.BigEndian.Uint16(data[i:]))
+		if length < 2 || i+length > len(data) {
+			return nil, 0, 0, fmt.Errorf("invalid JPEG segment length %d", length)
+		}
+		segment := data[i : i+length]
+		next := i + length
+
+		if isFrameHeader(marker) {
+			if length < 8 {
+				return nil, 0, 0, errors.New("truncated JPEG frame header")
+			}
+			height = int(binary.BigEndian.Uint16(segment[3:]))
+			width = int(binary.BigEndian.Uint16(segment[5:]))
+		}
+
+		if marker == 0xda {
+			// the start of scan is followed by the entropy-coded data, which ends at the next marker
+			// that is not a stuffed byte (0xff00) or a restart marker.
+			next = scanEnd(data, next)
+		}
+
+		if keepJPEGSegment(marker, segment[2:]) {
+			out = append(out, 0xff, marker)
+			out = append(out, data[i:next]...)
+		}
+		i = next
+	}
+	return nil, 0, 0, errors.New("missing JPEG end of image")
+}
+
+// isFrameHeader returns whether the marker is a start of frame, which holds the dimensions of the image.
+func isFrameHeader(marker byte) bool {
+	return marker >= 0xc0 && marker <= 0xcf && marker != 0xc4 && marker != 0xc8 && marker != 0xcc
+}
+
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xe0: // JFIF
+		return true
+	case marker == 0xe2:
+		return bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == 0xee:
+		return bytes.HasPrefix(payload, []byte("Adobe"))
+	case marker >= 0xe1 && marker <= 0xef, marker == 0xfe: // other APPn and comments
+		return false
+	default:
+		return true
+	}
+}
+
+// scanEnd returns the offset of the marker that ends the entropy-coded data starting at i.
+func scanEnd(data []byte, i int) int {
+	for ; i+1 < len(data); i++ {
+		if data[i] != 0xff {
+			continue
+		}
+		if next := data[i+1]; next != 0x00 && next != 0xff && (next < 0xd0 || next > 0xd7) {
+			return i
+		}
+	}
+	return len(data)
+}
+
+// sanitizePNG drops the ancillary chunks that are metadata, and any data after the end of the image.
+func sanitizePNG(data []byte) (out []byte, width, height int, err error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, 0, 0, errors.New("missing PNG signature")
+	}
+
+	out = append(make([]byte, 0, len(data)), pngSignature...)
+	for i := len(pngSignature); i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		if length < 0 || i+12+length > len(data) {
+			return nil, 0, 0, fmt.Errorf("invalid PNG chunk length %d", length)
+		}
+
+		kind := string(data[i+4 : i+8])
+		chunk := data[i : i+12+length]
+		i += 12 + length
+
+		if kind == "IHDR" {
+			if length < 8 {
+				return nil, 0, 0, errors.New("truncated PNG header")
+			}
+			width = int(binary.BigEndian.Uint32(chunk[8:]))
+			height = int(binary.BigEndian.Uint32(chunk[12:]))
+		}
+
+		// critical chunks start with an uppercase letter
+		if isUpper(kind[0]) || slices.Contains(pngAncillary, kind) {
+			out = append(out, chunk...)
+		}
+		if kind == "IEND" {
+			if width == 0 || height == 0 {
+				return nil, 0, 0, errors.New("missing PNG header")
+			}
+			return out, width, height, nil
+		}
+	}
+	return nil, 0, 0, errors.New("missing PNG end of image")
+}
+
+func isUpper(b byte) bool {
+	return b >= 'A' && b <= 'Z'
+}
+
+// sanitizeWebP drops the EXIF, XMP and unknown chunks, and any data after the RIFF container.
+func sanitizeWebP(data []byte) (out []byte, width, height int, err error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, 0, 0, errors.New("missing WebP header")
+	}
+	size := int(binary.LittleEndian.Uint32(data[4:]))
+	if size < 4 || 8+size > len(data) {
+		return nil, 0, 0, fmt.Errorf("invalid RIFF size %d", size)
+	}
+
+	body := []byte("WEBP")
+	for i := 12; i+8 <= 8+size; {
+		kind := string(data[i : i+4])
+		length := int(binary.LittleEndian.Uint32(data[i+4:]))
+		padded := length + length%2
+		if length < 0 || i+8+length > 8+size {
+			return nil, 0, 0, fmt.Errorf("invalid WebP chunk length %d", length)
+		}
+
+		chunk := slices.Clone(data[i:min(i+8+padded, 8+size)])
+		payload := chunk[8 : 8+length]
+		i += 8 + padded
+
+		if !slices.Contains(webpChunks, kind) {
+			continue
+		}
+		if width == 0 {
+			if width, height, err = webpDimensions(kind, payload); err != nil {
+				return nil, 0, 0, err
+			}
+		}
+		if kind == "VP8X" {
+			// the dimensions are only read from the first image chunk, so the length is checked here too
+			if len(payload) < 10 {
+				return nil, 0, 0, errors.New("truncated VP8X chunk")
+			}
+			payload[0] &^= webpFlagEXIF | webpFlagXMP
+		}
+		body = append(body, chunk...)
+	}
+	if width == 0 || height == 0 {
+		return nil, 0, 0, errors.New("missing WebP image")
+	}
+
+	out = append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(out, body...), width, height, nil
+}
+
+// webpDimensions returns the dimensions of the image from the VP8X, VP8 or VP8L chunk, or zero for other chunks.
+func webpDimensions(kind string, payload []byte) (width, height int, err error) {
+	switch kind {
+	case "VP8X":
+		if len(payload) < 10 {
+			return 0, 0, errors.New("truncated VP8X chunk")
+		}
+		width = 1 + int(uint32(payload[4])|uint32(payload[5])<<8|uint32(payload[6])<<16)
+		height = 1 + int(uint32(payload[7])|uint32(payload[8])<<8|uint32(payload[9])<<16)
+
+	case "VP8 ":
+		if len(payload) < 10 || !bytes.Equal(payload[3:6], []byte{0x9d, 0x01, 0x2a}) {
+			return 0, 0, errors.New("invalid VP8 chunk")
+		}
+		width = int(binary.LittleEndian.Uint16(payload[6:]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(payload[8:]) & 0x3fff)
+
+	case "VP8L":
+		if len(payload) < 5 || payload[0] != 0x2f {
+			return 0, 0, errors.New("invalid VP8L chunk")
+		}
+		bits := binary.LittleEndian.Uint32(payload[1:])
+		width = 1 + int(bits&0x3fff)
+		height = 1 + int(bits>>14&0x3fff)
+	}
+	return width, height, nil
+}
@@ -0,0 +1,53 @@
+// The sanitize package is responsible for removing from images what shouldn't be served by the CDN:
+// the metadata of JPEG, PNG and WebP images (e.g. the GPS position in EXIF), and the scripts, event handlers
+// and external references of SVG images. Raster images are sanitized by dropping their metadata segments,
+// so their pixels are never decoded nor re-encoded.
+package sanitize
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	ErrInvalidImage = errors.New("invalid image")
+	ErrTooLarge     = errors.New("image dimensions are too large")
+)
+
+// MimeTypes are the types of the images that are sanitized.
+var MimeTypes = []string{"image/jpeg", "image/png", "image/webp", "image/svg+xml"}
+
+// Supports returns whether images of the type are sanitized.
+func Supports(mime string) bool {
+	return slices.Contains(MimeTypes, mime)
+}
+
+// Image returns the sanitized image of the given type. It returns an error wrapping [ErrInvalidImage] if the image
+// can't be parsed, or [ErrTooLarge] if its width or height is larger than maxDimension. SVG images have no dimensions.
+func Image(mime string, data []byte, maxDimension int) ([]byte, error) {
+	var sanitized []byte
+	var width, height int
+	var err error
+
+	switch mime {
+	case "image/jpeg":
+		sanitized, width, height, err = sanitizeJPEG(data)
+	case "image/png":
+		sanitized, width, height, err = sanitizePNG(data)
+	case "image/webp":
+		sanitized, width, height, err = sanitizeWebP(data)
+	case "image/svg+xml":
+		sanitized, err = sanitizeSVG(data)
+	default:
+		return nil, fmt.Errorf("unsupported image type %q", mime)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImage, err)
+	}
+	if width > maxDimension || height > maxDimension {
+		return nil, fmt.Errorf("%w: %dx%d, the maximum is %d pixels per side", ErrTooLarge, width, height, maxDimension)
+	}
+	return sanitized, nil
+}
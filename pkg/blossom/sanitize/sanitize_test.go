@@ -0,0 +1,173 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+const gps = "GPSLatitude 45.4642"
+
+func TestImage(t *testing.T) {
+	tests := []struct {
+		name   string
+		mime   string
+		data   []byte
+		absent []string
+		err    error
+	}{
+		{name: "jpeg exif", mime: "image/jpeg", data: jpegFile(t, 16, 16), absent: []string{gps, "a comment"}},
+		{name: "png text", mime: "image/png", data: pngFile(t, 16, 16), absent: []string{gps, "trailing"}},
+		{name: "webp exif", mime: "image/webp", data: webpFile(16, 16), absent: []string{gps}},
+		{name: "jpeg too large", mime: "image/jpeg", data: jpegFile(t, 65, 16), err: ErrTooLarge},
+		{name: "png too large", mime: "image/png", data: pngFile(t, 16, 65), err: ErrTooLarge},
+		{name: "webp too large", mime: "image/webp", data: webpFile(100, 16), err: ErrTooLarge},
+		{name: "truncated jpeg", mime: "image/jpeg", data: jpegFile(t, 16, 16)[:200], err: ErrInvalidImage},
+		{name: "png as jpeg", mime: "image/jpeg", data: pngFile(t, 16, 16), err: ErrInvalidImage},
+		{name: "empty webp VP8X after VP8", mime: "image/webp", data: riffFile(
+			webpChunk("VP8 ", []byte{0, 0, 0, 0x9d, 0x01, 0x2a, 16, 0, 16, 0}),
+			webpChunk("VP8X", nil),
+		), err: ErrInvalidImage},
+		{
+			name: "svg",
+			mime: "image/svg+xml",
+			data: []byte(`<?xml version="1.0"?>
+<!DOCTYPE svg [<!ENTITY x "y">]>
+<!-- a comment -->
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" onload="alert(1)">
+	<script>alert(2)</script>
+	<foreignObject><iframe src="https://example.com"/></foreignObject>
+	<defs><linearGradient id="g"/></defs>
+	<style>@import url(https://example.com/track.css);</style>
+	<rect fill="url(#g)" style="background: url(https://example.com/pixel.png)" onclick="alert(3)"/>
+	<use xlink:href="#g"/>
+	<a href="javascript:alert(4)"><text>link</text></a>
+	<image href="https://example.com/pixel.png"/>
+	<set attributeName="href" to="javascript:alert(5)"/>
+</svg>`),
+			absent: []string{"alert", "example.com", "DOCTYPE", "a comment", "foreignObject"},
+		},
+		{name: "html as svg", mime: "image/svg+xml", data: []byte(`<html><script>alert(1)</script></html>`), err: ErrInvalidImage},
+		{name: "malformed svg", mime: "image/svg+xml", data: []byte(`<svg><g></svg>`), err: ErrInvalidImage},
+		{name: "svg with entity", mime: "image/svg+xml", data: []byte(`<svg>&x;</svg>`), err: ErrInvalidImage},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sanitized, err := Image(test.mime, test.data, 64)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+
+			for _, s := range test.absent {
+				if bytes.Contains(sanitized, []byte(s)) {
+					t.Fatalf("expected %q to be removed, got %q", s, sanitized)
+				}
+			}
+
+			switch test.mime {
+			case "image/jpeg", "image/png":
+				if _, _, err := image.Decode(bytes.NewReader(sanitized)); err != nil {
+					t.Fatalf("failed to decode the sanitized image: %v", err)
+				}
+			case "image/webp":
+				if bytes.Contains(sanitized, []byte("EXIF")) || sanitized[20]&webpFlagEXIF != 0 {
+					t.Fatalf("expected the EXIF chunk and flag to be removed, got %q", sanitized)
+				}
+				if size := binary.LittleEndian.Uint32(sanitized[4:]); int(size) != len(sanitized)-8 {
+					t.Fatalf("expected RIFF size %d, got %d", len(sanitized)-8, size)
+				}
+			case "image/svg+xml":
+				for _, s := range []string{`fill="url(#g)"`, `xlink:href="#g"`, `xmlns:xlink=`, "<text>link</text>"} {
+					if !strings.Contains(string(sanitized), s) {
+						t.Fatalf("expected %q to be kept, got %s", s, sanitized)
+					}
+				}
+			}
+		})
+	}
+}
+
+func jpegFile(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// insert an EXIF segment and a comment after the start of image
+	data := buf.Bytes()
+	var out []byte
+	out = append(out, data[:2]...)
+	out = append(out, jpegSegment(0xe1, "Exif\x00\x00"+gps)...)
+	out = append(out, jpegSegment(0xfe, "a comment")...)
+	return append(out, data[2:]...)
+}
+
+func jpegSegment(marker byte, payload string) []byte {
+	segment := []byte{0xff, marker}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+func pngFile(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+
+	// insert a text chunk after the header, and data after the end of the image
+	data := buf.Bytes()
+	header := len(pngSignature) + 12 + 13
+	var out []byte
+	out = append(out, data[:header]...)
+	out = append(out, pngChunk("tEXt", "Comment\x00"+gps)...)
+	out = append(out, data[header:]...)
+	return append(out, "trailing"...)
+}
+
+func pngChunk(kind, payload string) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(payload)))
+	chunk = append(chunk, kind...)
+	chunk = append(chunk, payload...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func webpFile(width, height int) []byte {
+	vp8x := []byte{webpFlagEXIF, 0, 0, 0}
+	vp8x = append(vp8x, byte(width-1), byte((width-1)>>8), byte((width-1)>>16))
+	vp8x = append(vp8x, byte(height-1), byte((height-1)>>8), byte((height-1)>>16))
+
+	bits := uint32(width-1) | uint32(height-1)<<14
+	vp8l := append([]byte{0x2f}, binary.LittleEndian.AppendUint32(nil, bits)...)
+
+	return riffFile(webpChunk("VP8X", vp8x), webpChunk("VP8L", vp8l), webpChunk("EXIF", []byte(gps)))
+}
+
+// riffFile returns the WebP RIFF container of the chunks.
+func riffFile(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, chunk := range chunks {
+		body = append(body, chunk...)
+	}
+
+	data := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	return append(data, body...)
+}
+
+func webpChunk(kind string, payload []byte) []byte {
+	chunk := append([]byte(kind), binary.LittleEndian.AppendUint32(nil, uint32(len(payload)))...)
+	chunk = append(chunk, payload...)
+	if len(payload)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
@@ -0,0 +1,182 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	// svgElements are the elements that are dropped with their content, because they can run scripts
+	// or embed other documents.
+	svgElements = []string{"script", "foreignobject", "iframe", "embed", "object", "audio", "video", "handler", "listener"}
+
+	// dataImages are the data URIs that can be referenced, because the embedded images can't run scripts.
+	dataImages = []string{"data:image/png", "data:image/jpeg", "data:image/gif", "data:image/webp"}
+
+	// externalURL matches the CSS url() functions whose target is not a fragment of the document.
+	externalURL = regexp.MustCompile(`(?i)url\(\s*['"]?\s*[^#'"\s)]`)
+)
+
+// sanitizeSVG drops the comments, doctype, processing instructions and dangerous elements of the document,
+// the event handler attributes, and the references and styles that point outside the document.
+// It returns an error if the document is not well-formed XML with a root svg element.
+func sanitizeSVG(data []byte) ([]byte, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = true
+
+	var out bytes.Buffer
+	encoder := xml.NewEncoder(&out)
+
+	var root bool
+	var skip int            // depth inside a dropped element
+	var style *bytes.Buffer // content of the current style element
+
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SVG: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := localName(t.Name)
+			if !root {
+				if name != "svg" {
+					return nil, fmt.Errorf("root element is %q, not svg", name)
+				}
+				root = true
+			}
+			if skip > 0 || dropElement(name, t.Attr) {
+				skip++
+				continue
+			}
+			if name == "style" {
+				style = &bytes.Buffer{}
+			}
+			if err := encoder.EncodeToken(xml.StartElement{Name: flatten(t.Name), Attr: sanitizeAttributes(t.Attr)}); err != nil {
+				return nil, err
+			}
+
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			if style != nil {
+				if !unsafeCSS(style.String()) {
+					if err := encoder.EncodeToken(xml.CharData(style.Bytes())); err != nil {
+						return nil, err
+					}
+				}
+				style = nil
+			}
+			if err := encoder.EncodeToken(xml.EndElement{Name: flatten(t.Name)}); err != nil {
+				return nil, err
+			}
+
+		case xml.CharData:
+			switch {
+			case skip > 0 || !root:
+				continue
+			case style != nil:
+				style.Write(t)
+			default:
+				if err := encoder.EncodeToken(t); err != nil {
+					return nil, err
+				}
+			}
+		}
+		// comments, directives (e.g. the doctype and its entities) and processing instructions are dropped
+	}
+
+	if !root {
+		return nil, errors.New("missing svg element")
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// localName returns the lowercase name of the element or attribute, without its namespace prefix.
+func localName(name xml.Name) string {
+	return strings.ToLower(name.Local)
+}
+
+// flatten returns the name with its namespace prefix in the local part, so that the encoder writes it as is.
+func flatten(name xml.Name) xml.Name {
+	if name.Space == "" {
+		return name
+	}
+	return xml.Name{Local: name.Space + ":" + name.Local}
+}
+
+// dropElement returns whether the element must be dropped with its content.
+// Animations are dropped when they change a reference or an event handler.
+func dropElement(name string, attrs []xml.Attr) bool {
+	if slices.Contains(svgElements, name) {
+		return true
+	}
+	if name == "set" || name == "animate" {
+		for _, attr := range attrs {
+			if localName(attr.Name) != "attributename" {
+				continue
+			}
+			target := strings.ToLower(strings.TrimSpace(attr.Value))
+			if strings.HasSuffix(target, "href") || strings.HasPrefix(target, "on") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sanitizeAttributes returns the attributes without event handlers, external references and unsafe styles.
+func sanitizeAttributes(attrs []xml.Attr) []xml.Attr {
+	sanitized := make([]xml.Attr, 0, len(attrs))
+	for _, attr := range attrs {
+		name := localName(attr.Name)
+		switch {
+		case strings.HasPrefix(name, "on"):
+			continue
+		case name == "href" && !internalReference(attr.Value):
+			continue
+		case unsafeCSS(attr.Value):
+			continue
+		}
+		sanitized = append(sanitized, xml.Attr{Name: flatten(attr.Name), Value: attr.Value})
+	}
+	return sanitized
+}
+
+// internalReference returns whether the reference points to an element of the document or to an embedded raster image.
+func internalReference(ref string) bool {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	if strings.HasPrefix(ref, "#") {
+		return true
+	}
+	for _, prefix := range dataImages {
+		if strings.HasPrefix(ref, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsafeCSS returns whether the style or attribute value loads external resources or runs scripts.
+func unsafeCSS(value string) bool {
+	lower := strings.ToLower(value)
+	return externalURL.MatchString(value) ||
+		strings.Contains(lower, "@import") ||
+		strings.Contains(lower, "javascript:") ||
+		strings.Contains(lower, "expression(")
+}
@@ -0,0 +1,716 @@
+package blossom
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/pippellia-btc/blossy/auth"
+	"github.com/zapstore/relay/pkg/blossom/sanitize"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+// Resumable uploads let clients upload large blobs in chunks, resuming from the last chunk received
+// after a failure instead of starting over:
+//
+//   - POST /upload creates an upload session, with the same authorization of PUT /upload and the
+//     X-SHA-256, X-Content-Type and X-Content-Length headers of HEAD /upload.
+//   - PATCH /upload/<id> appends the chunk in the body at the offset of the Upload-Offset header.
+//   - HEAD /upload/<id> returns the offset of the next chunk in the Upload-Offset header.
+//   - DELETE /upload/<id> cancels the upload.
+//
+// The session id is a secret known only to the uploader, so chunk requests are not authenticated.
+// Chunks are stored on disk until the last one is received, then the SHA-256 of the whole blob
+// is verified before it's uploaded to the storage. Sessions without chunks for [Config.SessionExpiry] are deleted.
+
+const (
+	offsetHeader = "Upload-Offset"
+	lengthHeader = "Upload-Length"
+
+	sessionCleanupInterval = 10 * time.Minute
+	assembleTimeout        = 30 * time.Minute
+)
+
+var (
+	ErrSessionNotFound = blossom.ErrNotFound("upload session not found or expired")
+	ErrSessionBusy     = &blossom.Error{Code: http.StatusConflict, Reason: "another request is in progress on the upload session"}
+	ErrTooManySessions = blossom.ErrTooMany("too many resumable uploads in progress, complete or cancel one first")
+)
+
+// sessionResponse is the JSON body describing an upload session.
+type sessionResponse struct {
+	ID      string `json:"id"`
+	Offset  int64  `json:"offset"`
+	Size    int64  `json:"size"`
+	Expires int64  `json:"expires"`
+}
+
+// sessionLocks serializes the requests on the same upload session.
+type sessionLocks struct {
+	mu     sync.Mutex
+	locked map[string]struct{}
+}
+
+func newSessionLocks() *sessionLocks {
+	return &sessionLocks{locked: make(map[string]struct{})}
+}
+
+// TryLock locks the session, returning false if it's already locked.
+func (l *sessionLocks) TryLock(id string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.locked[id]; ok {
+		return false
+	}
+	l.locked[id] = struct{}{}
+	return true
+}
+
+func (l *sessionLocks) Unlock(id string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.locked, id)
+}
+
+// sessionPreflight answers the CORS preflight requests of the upload endpoints,
+// which use methods and headers that blossy doesn't allow.
+func (b *T) sessionPreflight(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, PUT, POST, PATCH, DELETE")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, *")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createSession handles POST /upload, creating a resumable upload session.
+// If the blob already exists, its descriptor is returned instead, like PUT /upload.
+func (b *T) createSession(w http.ResponseWriter, r *http.Request) {
+	setSessionHeaders(w)
+
+	hints, bErr := parseSessionHints(r)
+	if bErr != nil {
+		blossom.WriteError(w, bErr)
+		return
+	}
+
+	pubkey, err := auth.Authenticate(r, b.config.Hostname, hints.Hash)
+	if err != nil {
+		blossom.WriteError(w, blossom.ErrUnauthorized(err.Error()))
+		return
+	}
+
+	req := rawRequest{ip: blossy.GetIP(r), pubkey: pubkey, raw: r}
+	for _, reject := range []func(blossy.Request, blossy.UploadHints) *blossom.Error{
+		RateUploadIP(b.limiter),
+		MissingAuth(),
+		MediaNotAllowed(b.config.AllowedMedia),
+		MediaTooLarge(b.config),
+		NotAllowed(b.defender),
+		QuotaExceeded(b.config, b.store, b.defender),
+	} {
+		if err := reject(req, hints); err != nil {
+			blossom.WriteError(w, err)
+			return
+		}
+	}
+
+	if hints.Size > b.config.SessionMaxSize {
+		blossom.WriteError(w, blossom.ErrTooLarge(fmt.Sprintf("blob exceeds the maximum size of %d bytes", b.config.SessionMaxSize)))
+		return
+	}
+
+	meta, err := b.store.Query(r.Context(), *hints.Hash)
+	if err == nil {
+		// blob already exists
+		writeDescriptor(w, b.descriptor(meta))
+		return
+	}
+	if !errors.Is(err, store.ErrBlobNotFound) {
+		slog.Error("blossom: failed to query blob metadata", "error", err, "hash", hints.Hash)
+		blossom.WriteError(w, ErrInternal)
+		return
+	}
+
+	count, err := b.store.CountSessions(r.Context(), pubkey)
+	if err != nil {
+		slog.Error("blossom: failed to count upload sessions", "error", err, "pubkey", pubkey)
+		blossom.WriteError(w, ErrInternal)
+		return
+	}
+	if count >= b.config.SessionsPerPubkey {
+		blossom.WriteError(w, ErrTooManySessions)
+		return
+	}
+
+	now := time.Now().UTC()
+	session := store.Session{
+		ID:         rand.Text(),
+		Hash:       *hints.Hash,
+		Type:       hints.Type,
+		Size:       hints.Size,
+		AuthPubkey: pubkey,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(b.config.SessionExpiry),
+	}
+
+	// the session is saved before its directory is created, so that the cleanup never
+	// finds the directory of a session that exists without the session.
+	if err := b.store.SaveSession(r.Context(), session); err != nil {
+		slog.Error("blossom: failed to save upload session", "error", err, "hash", hints.Hash)
+		blossom.WriteError(w, ErrInternal)
+		return
+	}
+	if err := os.Mkdir(b.chunksDir(session.ID), 0700); err != nil {
+		slog.Error("blossom: failed to create upload session directory", "error", err, "id", session.ID)
+		blossom.WriteError(w, ErrInternal)
+		return
+	}
+
+	w.Header().Set("Location", "/upload/"+session.ID)
+	w.Header().Set(offsetHeader, "0")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(sessionResponse{
+		ID:      session.ID,
+		Offset:  session.Received,
+		Size:    session.Size,
+		Expires: session.ExpiresAt.Unix(),
+	})
+}
+
+// sessionStatus handles HEAD /upload/<id>, returning the offset of the next chunk.
+func (b *T) sessionStatus(w http.ResponseWriter, r *http.Request) {
+	setSessionHeaders(w)
+
+	session, bErr := b.querySession(r.Context(), r.PathValue("id"))
+	if bErr != nil {
+		blossom.WriteError(w, bErr)
+		return
+	}
+
+	w.Header().Set(offsetHeader, strconv.FormatInt(session.Received, 10))
+	w.Header().Set(lengthHeader, strconv.FormatInt(session.Size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// appendChunk handles PATCH /upload/<id>, appending the chunk in the body to the upload session.
+// Once the last chunk is received, the blob is verified and uploaded, and its descriptor is returned.
+// If that fails, the client can retry by sending an empty chunk at the final offset.
+func (b *T) appendChunk(w http.ResponseWriter, r *http.Request) {
+	setSessionHeaders(w)
+
+	ip := blossy.GetIP(r)
+	if !b.limiter.Allow(ip.Group(), 1) {
+		blossom.WriteError(w, ErrRateLimited)
+		return
+	}
+
+	id := r.PathValue("id")
+	if !b.sessionLocks.TryLock(id) {
+		blossom.WriteError(w, ErrSessionBusy)
+		return
+	}
+	defer b.sessionLocks.Unlock(id)
+
+	session, bErr := b.querySession(r.Context(), id)
+	if bErr != nil {
+		blossom.WriteError(w, bErr)
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get(offsetHeader), 10, 64)
+	if err != nil {
+		blossom.WriteError(w, blossom.ErrBadRequest("'Upload-Offset' header is missing or invalid"))
+		return
+	}
+	if offset != session.Received {
+		w.Header().Set(offsetHeader, strconv.FormatInt(session.Received, 10))
+		blossom.WriteError(w, &blossom.Error{Code: http.StatusConflict, Reason: fmt.Sprintf("offset must be %d", session.Received)})
+		return
+	}
+
+	req := rawRequest{ip: ip, pubkey: session.AuthPubkey, raw: r}
+	if !session.Complete() {
+		received, bErr := b.receiveChunk(req, session)
+		if bErr != nil {
+			blossom.WriteError(w, bErr)
+			return
+		}
+
+		session.Received += received
+		if !session.Complete() {
+			w.Header().Set(offsetHeader, strconv.FormatInt(session.Received, 10))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	desc, bErr := b.assemble(req, session)
+	if bErr != nil {
+		blossom.WriteError(w, bErr)
+		return
+	}
+	w.Header().Set(offsetHeader, strconv.FormatInt(session.Received, 10))
+	writeDescriptor(w, desc)
+}
+
+// cancelSession handles DELETE /upload/<id>, deleting the upload session and its chunks.
+func (b *T) cancelSession(w http.ResponseWriter, r *http.Request) {
+	setSessionHeaders(w)
+
+	id := r.PathValue("id")
+	if !b.sessionLocks.TryLock(id) {
+		blossom.WriteError(w, ErrSessionBusy)
+		return
+	}
+	defer b.sessionLocks.Unlock(id)
+
+	session, bErr := b.querySession(r.Context(), id)
+	if bErr != nil {
+		blossom.WriteError(w, bErr)
+		return
+	}
+
+	if err := b.deleteSession(r.Context(), session.ID); err != nil {
+		slog.Error("blossom: failed to delete upload session", "error", err, "id", session.ID)
+		blossom.WriteError(w, ErrInternal)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// receiveChunk writes the chunk in the body of the request to the directory of the session,
+// and advances the session offset. It returns the size of the chunk.
+func (b *T) receiveChunk(r rawRequest, session store.Session) (int64, *blossom.Error) {
+	max := min(b.config.ChunkMaxSize, session.Size-session.Received)
+	if r.raw.ContentLength > max {
+		return 0, blossom.ErrTooLarge(fmt.Sprintf("chunk exceeds the maximum size of %d bytes", max))
+	}
+
+	dir := b.chunksDir(session.ID)
+	temp, err := os.CreateTemp(dir, ".chunk-*")
+	if err != nil {
+		slog.Error("blossom: failed to create chunk", "error", err, "id", session.ID)
+		return 0, ErrInternal
+	}
+	defer os.Remove(temp.Name()) // no-op once renamed
+	defer temp.Close()
+
+	reader := newStallReader(r.Context(), r.raw.Body, b.config.StallTimeout)
+	defer reader.Stop()
+
+	received, err := io.Copy(temp, io.LimitReader(reader, max+1))
+	if rErr := reader.Err(); rErr != nil {
+		return 0, &blossom.Error{Code: 499, Reason: rErr.Error()}
+	}
+	if err != nil {
+		return 0, blossom.ErrBadRequest("failed to read chunk: " + err.Error())
+	}
+	if received > max {
+		return 0, blossom.ErrTooLarge(fmt.Sprintf("chunk exceeds the maximum size of %d bytes", max))
+	}
+	if received == 0 {
+		return 0, blossom.ErrBadRequest("chunk is empty")
+	}
+
+	if err := temp.Sync(); err != nil {
+		slog.Error("blossom: failed to sync chunk", "error", err, "id", session.ID)
+		return 0, ErrInternal
+	}
+	if err := os.Rename(temp.Name(), filepath.Join(dir, chunkName(session.Received))); err != nil {
+		slog.Error("blossom: failed to save chunk", "error", err, "id", session.ID)
+		return 0, ErrInternal
+	}
+
+	expiresAt := time.Now().UTC().Add(b.config.SessionExpiry)
+	err = b.store.AdvanceSession(r.Context(), session.ID, session.Received, session.Received+received, expiresAt)
+	if err != nil {
+		slog.Error("blossom: failed to advance upload session", "error", err, "id", session.ID)
+		return 0, ErrInternal
+	}
+	return received, nil
+}
+
+// assemble verifies the SHA-256 of the chunks of the complete session, uploads them to the storage
+// as a single blob, saves its metadata and deletes the session.
+func (b *T) assemble(r rawRequest, session store.Session) (blossom.BlobDescriptor, *blossom.Error) {
+	// Use a context that survives the client disconnecting, to not waste the work of assembling a large blob.
+	// If the client retries, the blob already exists.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), assembleTimeout)
+	defer cancel()
+
+	paths, err := chunkPaths(b.chunksDir(session.ID), session.Size)
+	if err != nil {
+		slog.Error("blossom: upload session is corrupted", "error", err, "id", session.ID)
+		if err := b.deleteSession(ctx, session.ID); err != nil {
+			slog.Error("blossom: failed to delete upload session", "error", err, "id", session.ID)
+		}
+		return blossom.BlobDescriptor{}, blossom.ErrInternal("upload session is corrupted, please start over")
+	}
+
+	hash := sha256.New()
+	chunks := &chunksReader{paths: paths}
+	_, err = io.Copy(hash, chunks)
+	chunks.Close()
+	if err != nil {
+		slog.Error("blossom: failed to read chunks", "error", err, "id", session.ID)
+		return blossom.BlobDescriptor{}, ErrInternal
+	}
+
+	if computed := blossom.Hash(hash.Sum(nil)); computed != session.Hash {
+		// punish the client for providing a bad hash
+		b.limiter.Penalize(r.IP().Group(), 200)
+		if err := b.deleteSession(ctx, session.ID); err != nil {
+			slog.Error("blossom: failed to delete upload session", "error", err, "id", session.ID)
+		}
+		return blossom.BlobDescriptor{}, blossom.ErrBadRequest("checksum mismatch")
+	}
+
+	chunks = &chunksReader{paths: paths}
+	defer chunks.Close()
+
+	buffered := bufio.NewReaderSize(chunks, sniffLen)
+	mediaType, err := sniff(buffered, session.Type)
+	if errors.Is(err, ErrTypeMismatch) {
+		// punish the client for lying about the content type
+		b.limiter.Penalize(r.IP().Group(), 200)
+		if err := b.deleteSession(ctx, session.ID); err != nil {
+			slog.Error("blossom: failed to delete upload session", "error", err, "id", session.ID)
+		}
+		return blossom.BlobDescriptor{}, blossom.ErrUnsupportedMedia(err.Error())
+	}
+	if err != nil {
+		slog.Error("blossom: failed to read chunks", "error", err, "id", session.ID)
+		return blossom.BlobDescriptor{}, ErrInternal
+	}
+
+	if sanitize.Supports(mediaType) {
+		return b.assembleImage(r, session, buffered, mediaType)
+	}
+
+	inspection, err := newInspection(mediaType)
+	if err != nil {
+		slog.Error("blossom: failed to start inspection", "error", err, "hash", session.Hash)
+		return blossom.BlobDescriptor{}, ErrInternal
+	}
+	defer inspection.Close()
+
+	name := BlobPath(session.Hash, mediaType)
+	err = b.storage.Upload(ctx, inspection.Reader(buffered), session.Size, name, session.Hash.Hex())
+	if err != nil {
+		slog.Error("blossom: failed to upload assembled blob", "error", err, "name", name)
+		return blossom.BlobDescriptor{}, ErrInternal
+	}
+
+	meta := store.BlobMeta{
+		Hash:       session.Hash,
+		Type:       mediaType,
+		Size:       session.Size,
+		CreatedAt:  time.Now().UTC(),
+		AuthPubkey: session.AuthPubkey,
+	}
+
+	if err := b.inspect(ctx, inspection, name, meta); err != nil {
+		// punish the client for uploading an APK that can't be installed
+		b.limiter.Penalize(r.IP().Group(), 100)
+		if err := b.deleteSession(ctx, session.ID); err != nil {
+			slog.Error("blossom: failed to delete upload session", "error", err, "id", session.ID)
+		}
+		return blossom.BlobDescriptor{}, err
+	}
+
+	if _, err := b.store.Save(ctx, meta); err != nil {
+		slog.Error("blossom: failed to save blob metadata", "error", err, "hash", meta.Hash)
+		return blossom.BlobDescriptor{}, ErrInternal
+	}
+
+	if err := b.deleteSession(ctx, session.ID); err != nil {
+		slog.Error("blossom: failed to delete upload session", "error", err, "id", session.ID)
+	}
+
+	b.enqueueReplication(meta)
+	b.enqueueThumbnails(meta)
+	if err := b.relay.NotifyUpload(meta.Hash, meta.Type); err != nil {
+		slog.Error("blossom: failed to notify relay of upload", "error", err, "hash", meta.Hash)
+	}
+
+	b.analytics.RecordUpload(r, blossy.UploadHints{Hash: &meta.Hash, Type: meta.Type, Size: meta.Size})
+	return b.descriptor(meta), nil
+}
+
+// assembleImage reads the image of the complete upload session, whose hash was verified, and stores it sanitized.
+func (b *T) assembleImage(r rawRequest, session store.Session, data io.Reader, mediaType string) (blossom.BlobDescriptor, *blossom.Error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), assembleTimeout)
+	defer cancel()
+
+	image, err := b.readImage(data)
+	if errors.Is(err, errImageTooLarge) {
+		if err := b.deleteSession(ctx, session.ID); err != nil {
+			slog.Error("blossom: failed to delete upload session", "error", err, "id", session.ID)
+		}
+		return blossom.BlobDescriptor{}, blossom.ErrTooLarge(err.Error())
+	}
+	if err != nil {
+		slog.Error("blossom: failed to read chunks", "error", err, "id", session.ID)
+		return blossom.BlobDescriptor{}, ErrInternal
+	}
+
+	meta, bErr := b.storeImage(ctx, session.Hash, mediaType, image, session.AuthPubkey)
+	if bErr != nil {
+		if bErr.Code != http.StatusInternalServerError {
+			// the image is rejected, so the session can't be completed
+			if err := b.deleteSession(ctx, session.ID); err != nil {
+				slog.Error("blossom: failed to delete upload session", "error", err, "id", session.ID)
+			}
+		}
+		return blossom.BlobDescriptor{}, bErr
+	}
+
+	if err := b.deleteSession(ctx, session.ID); err != nil {
+		slog.Error("blossom: failed to delete upload session", "error", err, "id", session.ID)
+	}
+	if err := b.relay.NotifyUpload(meta.Hash, meta.Type); err != nil {
+		slog.Error("blossom: failed to notify relay of upload", "error", err, "hash", meta.Hash)
+	}
+
+	b.analytics.RecordUpload(r, blossy.UploadHints{Hash: &meta.Hash, Type: meta.Type, Size: meta.Size})
+	return b.descriptor(meta), nil
+}
+
+// querySession returns the upload session with the given id, if it exists and is not expired.
+func (b *T) querySession(ctx context.Context, id string) (store.Session, *blossom.Error) {
+	session, err := b.store.QuerySession(ctx, id)
+	if errors.Is(err, store.ErrSessionNotFound) {
+		return store.Session{}, ErrSessionNotFound
+	}
+	if errors.Is(err, context.Canceled) {
+		return store.Session{}, ErrClientGone
+	}
+	if err != nil {
+		slog.Error("blossom: failed to query upload session", "error", err, "id", id)
+		return store.Session{}, ErrInternal
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return store.Session{}, ErrSessionNotFound
+	}
+	return session, nil
+}
+
+// deleteSession deletes the upload session and its chunks.
+func (b *T) deleteSession(ctx context.Context, id string) error {
+	if err := b.store.DeleteSession(ctx, id); err != nil {
+		return err
+	}
+	return os.RemoveAll(b.chunksDir(id))
+}
+
+// chunksDir returns the directory of the chunks of the upload session.
+func (b *T) chunksDir(id string) string {
+	return filepath.Join(b.paths.Chunks, id)
+}
+
+// runSessionCleanup deletes the expired upload sessions, until the context is cancelled.
+func (b *T) runSessionCleanup(ctx context.Context) {
+	ticker := time.NewTicker(sessionCleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			deleted, err := b.cleanupSessions(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("blossom: failed to clean up upload sessions", "error", err)
+			}
+			if deleted > 0 {
+				slog.Info("blossom: deleted expired upload sessions", "deleted", deleted)
+			}
+		}
+	}
+}
+
+// cleanupSessions deletes the expired upload sessions, and the chunk directories without a session
+// (e.g. left by a crash). It returns the number of sessions deleted.
+func (b *T) cleanupSessions(ctx context.Context) (int, error) {
+	// the directories are listed before the sessions, so that a session created in between
+	// is never mistaken for a missing one.
+	entries, err := os.ReadDir(b.paths.Chunks)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list chunk directories: %w", err)
+	}
+
+	sessions, err := b.store.Sessions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	existing := make(map[string]struct{}, len(sessions))
+	deleted := 0
+
+	for _, session := range sessions {
+		existing[session.ID] = struct{}{}
+		if now.Before(session.ExpiresAt) || !b.sessionLocks.TryLock(session.ID) {
+			continue
+		}
+
+		err := b.deleteSession(ctx, session.ID)
+		b.sessionLocks.Unlock(session.ID)
+		if err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+
+	for _, entry := range entries {
+		if _, ok := existing[entry.Name()]; !ok && entry.IsDir() {
+			if err := os.RemoveAll(filepath.Join(b.paths.Chunks, entry.Name())); err != nil {
+				return deleted, err
+			}
+		}
+	}
+	return deleted, nil
+}
+
+// chunkName returns the file name of the chunk at the offset, padded so that names sort like offsets.
+func chunkName(offset int64) string {
+	return fmt.Sprintf("%020d", offset)
+}
+
+// chunkPaths returns the paths of the chunks in the directory sorted by offset,
+// verifying that they are contiguous and that their total size is the expected one.
+func chunkPaths(dir string, size int64) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	var total int64
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			// temporary chunk of a failed request
+			continue
+		}
+
+		offset, err := strconv.ParseInt(entry.Name(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk name %q", entry.Name())
+		}
+		if offset != total {
+			return nil, fmt.Errorf("chunk at offset %d, expected %d", offset, total)
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		total += info.Size()
+		paths = append(paths, filepath.Join(dir, entry.Name()))
+	}
+
+	if total != size {
+		return nil, fmt.Errorf("chunks total %d bytes, expected %d", total, size)
+	}
+	return paths, nil
+}
+
+// chunksReader reads the chunks one after the other, opening one file at a time.
+type chunksReader struct {
+	paths   []string
+	current *os.File
+}
+
+func (c *chunksReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.paths) == 0 {
+				return 0, io.EOF
+			}
+
+			file, err := os.Open(c.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			c.current, c.paths = file, c.paths[1:]
+		}
+
+		n, err := c.current.Read(p)
+		if errors.Is(err, io.EOF) {
+			c.current.Close()
+			c.current = nil
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (c *chunksReader) Close() error {
+	if c.current == nil {
+		return nil
+	}
+	return c.current.Close()
+}
+
+// parseSessionHints parses the X-SHA-256, X-Content-Type and X-Content-Length headers of the request.
+func parseSessionHints(r *http.Request) (blossy.UploadHints, *blossom.Error) {
+	hash, err := blossom.ParseHash(r.Header.Get("X-SHA-256"))
+	if err != nil {
+		return blossy.UploadHints{}, blossom.ErrBadRequest("'X-SHA-256' header is missing or invalid")
+	}
+
+	mime := r.Header.Get("X-Content-Type")
+	if mime == "" {
+		return blossy.UploadHints{}, blossom.ErrBadRequest("'X-Content-Type' header is missing or empty")
+	}
+
+	size, err := strconv.ParseInt(r.Header.Get("X-Content-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		return blossy.UploadHints{}, blossom.ErrBadRequest("'X-Content-Length' header is missing or invalid")
+	}
+	return blossy.UploadHints{Hash: &hash, Type: mime, Size: size}, nil
+}
+
+// setSessionHeaders sets the CORS headers of the upload session responses.
+func setSessionHeaders(w http.ResponseWriter) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Expose-Headers", strings.Join([]string{offsetHeader, lengthHeader, "Location", "X-Reason"}, ", "))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// descriptor returns the blob descriptor of the blob.
+func (b *T) descriptor(meta store.BlobMeta) blossom.BlobDescriptor {
+	return blossom.BlobDescriptor{
+		URL:      fmt.Sprintf("https://%s/%s.%s", b.config.Hostname, meta.Hash.Hex(), blossom.ExtFromType(meta.Type)),
+		Hash:     meta.Hash,
+		Type:     meta.Type,
+		Size:     meta.Size,
+		Uploaded: meta.CreatedAt.Unix(),
+	}
+}
+
+func writeDescriptor(w http.ResponseWriter, desc blossom.BlobDescriptor) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(desc); err != nil {
+		slog.Error("blossom: failed to encode blob descriptor", "error", err, "hash", desc.Hash)
+	}
+}
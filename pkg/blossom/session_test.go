@@ -0,0 +1,262 @@
+package blossom
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	defender "github.com/zapstore/defender/pkg/client"
+	"github.com/zapstore/relay/pkg/analytics"
+	"github.com/zapstore/relay/pkg/blossom/store"
+	"github.com/zapstore/relay/pkg/rate"
+)
+
+// fakeRelay is a [Relay] without assets.
+type fakeRelay struct{}
+
+func (fakeRelay) ResolveAssetURL(context.Context, blossom.Hash) (string, error) { return "", nil }
+func (fakeRelay) NotifyUpload(blossom.Hash, string) error                       { return nil }
+func (fakeRelay) AssetsReferencing(context.Context, blossom.Hash) ([]nostr.Event, error) {
+	return nil, nil
+}
+func (fakeRelay) ExternalAssets(context.Context) ([]nostr.Event, error)          { return nil, nil }
+func (fakeRelay) RecordVerification(context.Context, string, blossom.Hash) error { return nil }
+
+// newSessionServer returns a blossom server with a memStorage primary and a defender accepting every blob.
+func newSessionServer(t *testing.T) (*T, *memStorage) {
+	t.Helper()
+	accept := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"decision":"accept"}`))
+	}))
+	t.Cleanup(accept.Close)
+
+	def, err := defender.Default(accept.URL)
+	if err != nil {
+		t.Fatalf("failed to create defender: %v", err)
+	}
+
+	db, err := store.New(filepath.Join(t.TempDir(), "blossom.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	config := NewConfig()
+	config.Hostname = "example.com"
+	config.AllowedMedia = []string{"application/vnd.android.package-archive"}
+
+	primary := newMemStorage()
+	backend := &Backend{Name: "primary", Storage: primary}
+	backend.healthy.Store(true)
+
+	b := &T{
+		config:       config,
+		paths:        Paths{Chunks: t.TempDir()},
+		limiter:      rate.NewLimiter(rate.NewConfig()),
+		defender:     def,
+		storage:      primary,
+		backends:     Backends{backend},
+		store:        db,
+		relay:        fakeRelay{},
+		analytics:    &analytics.Engine{},
+		sessionLocks: newSessionLocks(),
+	}
+
+	b.server, err = blossy.NewServer(blossy.WithHostname(config.Hostname))
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	return b, primary
+}
+
+// uploadAuth returns the Authorization header of a kind 24242 upload event for the hash.
+func uploadAuth(t *testing.T, hash blossom.Hash) string {
+	t.Helper()
+	event := nostr.Event{
+		Kind:      24242,
+		CreatedAt: nostr.Now(),
+		Content:   "upload",
+		Tags: nostr.Tags{
+			{"t", "upload"},
+			{"x", hash.Hex()},
+			{"expiration", strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)},
+		},
+	}
+	if err := event.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatalf("failed to sign event: %v", err)
+	}
+
+	data, _ := json.Marshal(event)
+	return "Nostr " + base64.StdEncoding.EncodeToString(data)
+}
+
+// createSession creates an upload session for the data, returning its id.
+func createSession(t *testing.T, handler http.Handler, data []byte, hash blossom.Hash) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	req.Header.Set("Authorization", uploadAuth(t, hash))
+	req.Header.Set("X-SHA-256", hash.Hex())
+	req.Header.Set("X-Content-Type", "application/vnd.android.package-archive")
+	req.Header.Set("X-Content-Length", strconv.Itoa(len(data)))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected status 201, got %d: %s", rec.Code, rec.Header().Get("X-Reason"))
+	}
+
+	var res sessionResponse
+	if err := json.NewDecoder(rec.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode session: %v", err)
+	}
+	if rec.Header().Get("Location") != "/upload/"+res.ID {
+		t.Fatalf("expected location /upload/%s, got %s", res.ID, rec.Header().Get("Location"))
+	}
+	return res.ID
+}
+
+func sendChunk(handler http.Handler, id string, offset int, chunk []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, "/upload/"+id, bytes.NewReader(chunk))
+	req.Header.Set(offsetHeader, strconv.Itoa(offset))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestResumableUpload(t *testing.T) {
+	b, primary := newSessionServer(t)
+	handler := b.Handler()
+
+	// starts like a zip to match the declared APK type, but is not inspected as one
+	data := bytes.Repeat([]byte("zapstore"), 1000)
+	copy(data, "PK\x03\x04")
+	hash := blossom.ComputeHash(data)
+	id := createSession(t, handler, data, hash)
+
+	if rec := sendChunk(handler, id, 0, data[:3000]); rec.Code != http.StatusNoContent || rec.Header().Get(offsetHeader) != "3000" {
+		t.Fatalf("expected status 204 with offset 3000, got %d with %s", rec.Code, rec.Header().Get(offsetHeader))
+	}
+
+	// the client lost the response and resends the same chunk
+	if rec := sendChunk(handler, id, 0, data[:3000]); rec.Code != http.StatusConflict || rec.Header().Get(offsetHeader) != "3000" {
+		t.Fatalf("expected status 409 with offset 3000, got %d with %s", rec.Code, rec.Header().Get(offsetHeader))
+	}
+
+	// the client asks where to resume from
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "/upload/"+id, nil))
+	if rec.Code != http.StatusOK || rec.Header().Get(offsetHeader) != "3000" || rec.Header().Get(lengthHeader) != "8000" {
+		t.Fatalf("expected status 200 with offset 3000 of 8000, got %d with %s of %s",
+			rec.Code, rec.Header().Get(offsetHeader), rec.Header().Get(lengthHeader))
+	}
+
+	rec = sendChunk(handler, id, 3000, data[3000:])
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Header().Get("X-Reason"))
+	}
+
+	var desc blossom.BlobDescriptor
+	if err := json.NewDecoder(rec.Body).Decode(&desc); err != nil {
+		t.Fatalf("failed to decode descriptor: %v", err)
+	}
+	if desc.Hash != hash || desc.Size != int64(len(data)) {
+		t.Fatalf("expected descriptor of %s with size %d, got %s with size %d", hash, len(data), desc.Hash, desc.Size)
+	}
+
+	if !primary.has(BlobPath(hash, desc.Type)) {
+		t.Fatal("expected the blob to be uploaded to the storage")
+	}
+	if _, err := b.store.Query(ctx, hash); err != nil {
+		t.Fatalf("expected the blob metadata to be saved, got %v", err)
+	}
+
+	// the session is gone with its chunks
+	if rec := sendChunk(handler, id, 8000, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected status 404, got %d", rec.Code)
+	}
+	if _, err := os.Stat(b.chunksDir(id)); !os.IsNotExist(err) {
+		t.Fatalf("expected the chunks to be deleted, got %v", err)
+	}
+}
+
+func TestResumableUploadMismatch(t *testing.T) {
+	b, primary := newSessionServer(t)
+	handler := b.Handler()
+
+	data := []byte("the announced content")
+	hash := blossom.ComputeHash(data)
+	id := createSession(t, handler, data, hash)
+
+	tests := []struct {
+		name   string
+		offset int
+		chunk  []byte
+		status int
+	}{
+		{name: "chunk too large", offset: 0, chunk: append(data, '!'), status: http.StatusRequestEntityTooLarge},
+		{name: "empty chunk", offset: 0, chunk: nil, status: http.StatusBadRequest},
+		{name: "wrong content", offset: 0, chunk: []byte("a different content!!"), status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if rec := sendChunk(handler, id, test.offset, test.chunk); rec.Code != test.status {
+				t.Fatalf("expected status %d, got %d: %s", test.status, rec.Code, rec.Header().Get("X-Reason"))
+			}
+		})
+	}
+
+	if len(primary.blobs) != 0 {
+		t.Fatal("expected nothing uploaded to the storage")
+	}
+	if _, err := b.store.QuerySession(ctx, id); err != store.ErrSessionNotFound {
+		t.Fatalf("expected the mismatched session to be deleted, got %v", err)
+	}
+}
+
+func TestCleanupSessions(t *testing.T) {
+	b, _ := newSessionServer(t)
+	handler := b.Handler()
+
+	data := []byte("hello")
+	hash := blossom.ComputeHash(data)
+	expired := createSession(t, handler, data, hash)
+	active := createSession(t, handler, data, hash)
+
+	if err := b.store.AdvanceSession(ctx, expired, 0, 0, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("failed to expire session: %v", err)
+	}
+
+	// a directory left behind by a crash
+	orphan := b.chunksDir("orphan")
+	if err := os.Mkdir(orphan, 0700); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	deleted, err := b.cleanupSessions(ctx)
+	if err != nil || deleted != 1 {
+		t.Fatalf("expected 1 session deleted, got %d (%v)", deleted, err)
+	}
+
+	for _, dir := range []string{b.chunksDir(expired), orphan} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be deleted, got %v", dir, err)
+		}
+	}
+	if _, err := os.Stat(b.chunksDir(active)); err != nil {
+		t.Fatalf("expected the active session to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package blossom
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+
+	"github.com/zapstore/relay/pkg/blossom/apk"
+	"github.com/zapstore/relay/pkg/blossom/exe"
+)
+
+// sniffLen is the number of bytes used to detect the type of a blob, like [http.DetectContentType].
+const sniffLen = 512
+
+const unknownType = "application/octet-stream"
+
+var ErrTypeMismatch = errors.New("content doesn't match the declared type")
+
+var (
+	// aliases maps the detected types to the other names clients use for them.
+	// Blobs declared with an alias are stored with the detected type.
+	aliases = map[string][]string{
+		"application/x-executable":                      {"application/x-elf", "application/x-sharedlib", "application/x-pie-executable"},
+		"application/vnd.microsoft.portable-executable": {"application/x-msdownload", "application/x-dosexec"},
+		"application/gzip":                              {"application/x-gzip"},
+		"image/heic":                                    {"image/heif"},
+		"image/heif":                                    {"image/heic"},
+	}
+
+	// refinements maps the detected types to the more specific types that can't be told apart from their first bytes.
+	// Blobs declared with a refinement are stored with the declared type.
+	refinements = map[string][]string{
+		"application/zip":  {apk.MimeType, "application/java-archive"},
+		"application/gzip": {"application/x-compressed-tar", "application/x-gtar"},
+	}
+
+	// signed are the types whose content has a signature that is detected. Blobs declared with one of them
+	// are rejected if their content is not recognized, while blobs declared with other types are accepted.
+	signed = []string{
+		"application/x-executable",
+		"application/x-mach-binary",
+		"application/vnd.microsoft.portable-executable",
+		"application/zip",
+		"application/gzip",
+		"application/x-tar",
+		"application/pdf",
+		"image/jpeg",
+		"image/png",
+		"image/gif",
+		"image/webp",
+		"image/bmp",
+		"image/heic",
+		"image/heif",
+		"image/svg+xml",
+		"text/html",
+		"text/xml",
+	}
+)
+
+// sniff detects the type of the blob from the first bytes of the data, without consuming them.
+// It returns the type to store the blob with, or an error wrapping [ErrTypeMismatch] if the content
+// contradicts the declared type. Other errors are returned if the data can't be read.
+func sniff(data *bufio.Reader, declared string) (string, error) {
+	header, err := data.Peek(sniffLen)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, bufio.ErrBufferFull) {
+		return "", err
+	}
+
+	detected := detectType(header)
+	stored, ok := resolveType(declared, detected)
+	if !ok {
+		return "", fmt.Errorf("%w: declared %s but the content is %s", ErrTypeMismatch, declared, detected)
+	}
+	return stored, nil
+}
+
+// resolveType returns the type to store a blob declared with the declared type, whose content was detected
+// as the detected type, or false if they contradict each other.
+func resolveType(declared, detected string) (string, bool) {
+	switch {
+	case declared == detected:
+		return detected, true
+	case slices.Contains(aliases[detected], declared):
+		return detected, true
+	case slices.Contains(refinements[detected], declared):
+		return declared, true
+	case detected == unknownType && !isSigned(declared):
+		return declared, true
+	default:
+		return "", false
+	}
+}
+
+// isSigned returns whether the type, or the type it's an alias or refinement of, has a signature that is detected.
+func isSigned(mediaType string) bool {
+	if slices.Contains(signed, mediaType) {
+		return true
+	}
+	for _, types := range aliases {
+		if slices.Contains(types, mediaType) {
+			return true
+		}
+	}
+	for _, types := range refinements {
+		if slices.Contains(types, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectType returns the media type of a blob from its first bytes, at most [sniffLen].
+// It extends [http.DetectContentType] with binaries, tar archives, HEIF images and SVG images.
+func detectType(header []byte) string {
+	switch exe.Sniff(header) {
+	case exe.FormatELF:
+		return "application/x-executable"
+	case exe.FormatMachO:
+		return "application/x-mach-binary"
+	case exe.FormatPE:
+		return "application/vnd.microsoft.portable-executable"
+	case exe.FormatZip:
+		return "application/zip"
+	case exe.FormatTar:
+		if bytes.HasPrefix(header, []byte{0x1f, 0x8b}) {
+			return "application/gzip"
+		}
+		return "application/x-tar"
+	}
+
+	if heif, ok := detectHEIF(header); ok {
+		return heif
+	}
+	if isSVG(header) {
+		return "image/svg+xml"
+	}
+
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(header))
+	if err != nil {
+		return unknownType
+	}
+	return mediaType
+}
+
+// detectHEIF detects HEIF images from the brand of their ISO BMFF "ftyp" box.
+func detectHEIF(header []byte) (string, bool) {
+	if len(header) < 12 || string(header[4:8]) != "ftyp" {
+		return "", false
+	}
+	switch string(header[8:12]) {
+	case "heic", "heix", "hevc", "hevx", "heim", "heis":
+		return "image/heic", true
+	case "mif1", "msf1", "heif":
+		return "image/heif", true
+	default:
+		return "", false
+	}
+}
+
+// isSVG returns whether the data is an XML document with an svg element,
+// possibly after the XML declaration, comments and the doctype.
+func isSVG(header []byte) bool {
+	text := bytes.ToLower(bytes.TrimSpace(bytes.TrimPrefix(header, []byte("\xef\xbb\xbf"))))
+	for _, prefix := range []string{"<?xml", "<svg", "<!--", "<!doctype svg"} {
+		if bytes.HasPrefix(text, []byte(prefix)) {
+			return bytes.Contains(text, []byte("<svg"))
+		}
+	}
+	return false
+}
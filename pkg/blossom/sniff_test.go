@@ -0,0 +1,55 @@
+package blossom
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/zapstore/relay/pkg/blossom/apk"
+)
+
+func TestSniff(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	elf := []byte("\x7fELF\x02\x01\x01\x00")
+
+	tests := []struct {
+		name     string
+		data     []byte
+		declared string
+		stored   string
+		err      error
+	}{
+		{name: "png", data: png, declared: "image/png", stored: "image/png"},
+		{name: "apk", data: []byte("PK\x03\x04\x14\x00"), declared: apk.MimeType, stored: apk.MimeType},
+		{name: "elf alias", data: elf, declared: "application/x-elf", stored: "application/x-executable"},
+		{name: "tar.gz", data: []byte{0x1f, 0x8b, 0x08, 0x00}, declared: "application/x-compressed-tar", stored: "application/x-compressed-tar"},
+		{name: "heic", data: []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00"), declared: "image/heic", stored: "image/heic"},
+		{name: "heic with heif brand", data: []byte("\x00\x00\x00\x18ftypmif1\x00\x00\x00\x00"), declared: "image/heic", stored: "image/heif"},
+		{name: "svg", data: []byte(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"/>`), declared: "image/svg+xml", stored: "image/svg+xml"},
+		{name: "unrecognized content of an unsigned type", data: []byte{0x00, 0x01, 0x02}, declared: "application/x-custom", stored: "application/x-custom"},
+		{name: "html as png", data: []byte("<!DOCTYPE html><script>alert(1)</script>"), declared: "image/png", err: ErrTypeMismatch},
+		{name: "script as svg", data: []byte("alert(1)"), declared: "image/svg+xml", err: ErrTypeMismatch},
+		{name: "png as apk", data: png, declared: apk.MimeType, err: ErrTypeMismatch},
+		{name: "unrecognized content of a signed type", data: []byte{0x00, 0x01, 0x02}, declared: "application/x-executable", err: ErrTypeMismatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := bufio.NewReaderSize(bytes.NewReader(test.data), sniffLen)
+			stored, err := sniff(data, test.declared)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if stored != test.stored {
+				t.Fatalf("expected type %q, got %q", test.stored, stored)
+			}
+
+			// sniffing doesn't consume the data
+			if read, _ := io.ReadAll(data); !bytes.Equal(read, test.data) {
+				t.Fatalf("expected to read %q, got %q", test.data, read)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package blossom
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/pippellia-btc/blossy"
+	"github.com/zapstore/relay/pkg/blossom/bunny"
+	"github.com/zapstore/relay/pkg/blossom/local"
+	"github.com/zapstore/relay/pkg/blossom/s3"
+)
+
+const (
+	BackendBunny = "bunny"
+	BackendLocal = "local"
+	BackendS3    = "s3"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	ErrBlobMissing      = errors.New("blob missing from storage")
+)
+
+// Storage is a backend where blobs are stored, at the path returned by [BlobPath].
+type Storage interface {
+	// Upload stores the data at the path. The size of the data is -1 if unknown.
+	// If the hex-encoded sha256 is not empty and doesn't match the data, the upload fails
+	// with [ErrChecksumMismatch], and nothing is stored.
+	Upload(ctx context.Context, data io.Reader, size int64, path, sha256 string) error
+
+	// Open returns the content of the blob at the path, or [ErrBlobMissing].
+	// The caller is responsible for closing it.
+	Open(ctx context.Context, path string) (io.ReadCloser, error)
+
+	// Check returns the size of the blob at the path, or [ErrBlobMissing].
+	Check(ctx context.Context, path string) (size int64, err error)
+
+	// Delete removes the blob at the path. It returns nil if the blob didn't exist.
+	Delete(ctx context.Context, path string) error
+
+	// Deliver returns how the blob at the path, with the given content type, is delivered on download.
+	Deliver(ctx context.Context, path, mime string) (blossy.BlobDelivery, error)
+}
+
+// NewStorage returns the primary storage backend selected by the config, which is assumed to have been validated.
+func NewStorage(c Config) (Storage, error) {
+	return newBackend(c, c.Backend)
+}
+
+// newBackend returns the named storage backend, configured by the config.
+func newBackend(c Config, name string) (Storage, error) {
+	switch name {
+	case BackendBunny:
+		return bunnyStorage{client: bunny.NewClient(c.Bunny)}, nil
+
+	case BackendLocal:
+		storage, err := local.New(c.Local)
+		if err != nil {
+			return nil, err
+		}
+		return localStorage{storage: storage}, nil
+
+	case BackendS3:
+		return s3Storage{client: s3.NewClient(c.S3)}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", name)
+	}
+}
+
+// bunnyStorage stores blobs in the Bunny storage zone, and delivers them by redirecting to the CDN.
+type bunnyStorage struct {
+	client bunny.Client
+}
+
+func (b bunnyStorage) Upload(ctx context.Context, data io.Reader, _ int64, path, sha256 string) error {
+	err := b.client.Upload(ctx, data, path, sha256)
+	if errors.Is(err, bunny.ErrChecksumMismatch) {
+		return fmt.Errorf("%w: %w", ErrChecksumMismatch, err)
+	}
+	return err
+}
+
+func (b bunnyStorage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	reader, err := b.client.Download(ctx, path)
+	if errors.Is(err, bunny.ErrFileNotFound) {
+		return nil, fmt.Errorf("%w: %w", ErrBlobMissing, err)
+	}
+	return reader, err
+}
+
+func (b bunnyStorage) Check(ctx context.Context, path string) (int64, error) {
+	_, size, err := b.client.Check(ctx, path)
+	if errors.Is(err, bunny.ErrFileNotFound) {
+		return 0, fmt.Errorf("%w: %w", ErrBlobMissing, err)
+	}
+	return size, err
+}
+
+func (b bunnyStorage) Delete(ctx context.Context, path string) error {
+	return b.client.Delete(ctx, path)
+}
+
+func (b bunnyStorage) Deliver(ctx context.Context, path, mime string) (blossy.BlobDelivery, error) {
+	return blossy.Redirect(b.client.CDNURL(path), http.StatusTemporaryRedirect), nil
+}
+
+// s3Storage stores blobs in an S3-compatible bucket, and delivers them by redirecting to presigned URLs.
+type s3Storage struct {
+	client s3.Client
+}
+
+func (s s3Storage) Upload(ctx context.Context, data io.Reader, size int64, path, sha256 string) error {
+	err := s.client.Upload(ctx, data, size, path, sha256)
+	if errors.Is(err, s3.ErrChecksumMismatch) {
+		return fmt.Errorf("%w: %w", ErrChecksumMismatch, err)
+	}
+	return err
+}
+
+func (s s3Storage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	reader, err := s.client.Download(ctx, path)
+	if errors.Is(err, s3.ErrFileNotFound) {
+		return nil, fmt.Errorf("%w: %w", ErrBlobMissing, err)
+	}
+	return reader, err
+}
+
+func (s s3Storage) Check(ctx context.Context, path string) (int64, error) {
+	size, err := s.client.Check(ctx, path)
+	if errors.Is(err, s3.ErrFileNotFound) {
+		return 0, fmt.Errorf("%w: %w", ErrBlobMissing, err)
+	}
+	return size, err
+}
+
+func (s s3Storage) Delete(ctx context.Context, path string) error {
+	return s.client.Delete(ctx, path)
+}
+
+func (s s3Storage) Deliver(ctx context.Context, path, mime string) (blossy.BlobDelivery, error) {
+	return blossy.Redirect(s.client.PresignedURL(path), http.StatusTemporaryRedirect), nil
+}
+
+// localStorage stores blobs on the local filesystem, and serves them directly with Range support.
+type localStorage struct {
+	storage local.Storage
+}
+
+func (l localStorage) Upload(ctx context.Context, data io.Reader, _ int64, path, sha256 string) error {
+	err := l.storage.Upload(ctx, data, path, sha256)
+	if errors.Is(err, local.ErrChecksumMismatch) {
+		return fmt.Errorf("%w: %w", ErrChecksumMismatch, err)
+	}
+	return err
+}
+
+func (l localStorage) Open(ctx context.Context, path string) (io.ReadCloser, error) {
+	file, err := l.storage.Open(ctx, path)
+	if errors.Is(err, local.ErrFileNotFound) {
+		return nil, fmt.Errorf("%w: %w", ErrBlobMissing, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (l localStorage) Check(ctx context.Context, path string) (int64, error) {
+	size, err := l.storage.Check(ctx, path)
+	if errors.Is(err, local.ErrFileNotFound) {
+		return 0, fmt.Errorf("%w: %w", ErrBlobMissing, err)
+	}
+	return size, err
+}
+
+func (l localStorage) Delete(ctx context.Context, path string) error {
+	return l.storage.Delete(ctx, path)
+}
+
+func (l localStorage) Deliver(ctx context.Context, path, mime string) (blossy.BlobDelivery, error) {
+	file, err := l.storage.Open(ctx, path)
+	if errors.Is(err, local.ErrFileNotFound) {
+		return nil, fmt.Errorf("%w: %w", ErrBlobMissing, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("local: failed to stat: %w", err)
+	}
+	return blossy.Serve(fileBlob{File: file, size: info.Size(), mime: mime}), nil
+}
+
+// fileBlob is a seekable [blossom.Blob], so that blossy serves Range requests.
+type fileBlob struct {
+	*os.File
+	size int64
+	mime string
+}
+
+func (f fileBlob) Size() int64  { return f.size }
+func (f fileBlob) Type() string { return f.mime }
@@ -0,0 +1,66 @@
+package blossom
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+)
+
+func TestLocalStorageServesRanges(t *testing.T) {
+	config := NewConfig()
+	config.Backend = BackendLocal
+	config.Local.Dir = t.TempDir()
+
+	storage, err := NewStorage(config)
+	if err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+
+	data := []byte("0123456789")
+	hash := blossom.ComputeHash(data)
+	path := BlobPath(hash, "image/png")
+
+	if err := storage.Upload(ctx, bytes.NewReader([]byte("tampered")), 8, path, hash.Hex()); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected error %v, got %v", ErrChecksumMismatch, err)
+	}
+	if err := storage.Upload(ctx, bytes.NewReader(data), int64(len(data)), path, hash.Hex()); err != nil {
+		t.Fatalf("failed to upload: %v", err)
+	}
+
+	server, err := blossy.NewServer(blossy.WithHostname("example.com"), blossy.WithRangeSupport())
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+	server.On.Download = func(r blossy.Request, hash blossom.Hash, _ string) (blossy.BlobDelivery, *blossom.Error) {
+		delivery, err := storage.Deliver(r.Context(), BlobPath(hash, "image/png"), "image/png")
+		if err != nil {
+			return nil, ErrNotFound
+		}
+		return delivery, nil
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/"+hash.Hex(), nil)
+	req.Header.Set("Range", "bytes=2-5")
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusPartialContent, rec.Code, rec.Body.String())
+	}
+	if body, _ := io.ReadAll(rec.Body); string(body) != "2345" {
+		t.Errorf("expected body %q, got %q", "2345", body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("expected content type image/png, got %q", ct)
+	}
+
+	if _, err := storage.Check(ctx, BlobPath(blossom.ComputeHash([]byte("missing")), "image/png")); !errors.Is(err, ErrBlobMissing) {
+		t.Errorf("expected error %v, got %v", ErrBlobMissing, err)
+	}
+}
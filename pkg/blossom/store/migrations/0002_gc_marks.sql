@@ -0,0 +1,5 @@
+-- Blobs not referenced by any event are marked by the garbage collector,
+-- and deleted if they remain unreferenced for a grace period.
+ALTER TABLE blobs ADD COLUMN marked_at INTEGER; -- unix timestamp of when the blob was marked, NULL if not marked
+
+CREATE INDEX IF NOT EXISTS idx_blobs_marked_at ON blobs(marked_at);
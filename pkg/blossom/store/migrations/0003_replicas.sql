@@ -0,0 +1,11 @@
+-- Copies of the blobs in the secondary storage backends (replicas).
+-- The primary backend is not tracked, as every blob in the blobs table is stored there.
+CREATE TABLE IF NOT EXISTS replicas (
+    hash          TEXT    NOT NULL,     -- sha256 of the blob as a hexadecimal
+    backend       TEXT    NOT NULL,     -- name of the backend holding the copy e.g. s3
+    replicated_at INTEGER NOT NULL,     -- unix timestamp of when the copy was made
+    checked_at    INTEGER NOT NULL,     -- unix timestamp of when the copy was last found in the backend
+    PRIMARY KEY (hash, backend)
+);
+
+CREATE INDEX IF NOT EXISTS idx_replicas_backend_checked_at ON replicas(backend, checked_at);
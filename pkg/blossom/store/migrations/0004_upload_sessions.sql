@@ -0,0 +1,14 @@
+-- Resumable uploads, whose chunks are stored on disk until the blob is complete.
+CREATE TABLE IF NOT EXISTS upload_sessions (
+    id          TEXT    PRIMARY KEY,    -- random hexadecimal identifier, known only to the uploader
+    hash        TEXT    NOT NULL,       -- expected sha256 of the blob as a hexadecimal
+    type        TEXT    NOT NULL,       -- content type of the blob
+    size        INTEGER NOT NULL,       -- expected size of the blob
+    received    INTEGER NOT NULL,       -- bytes received so far, which is the offset of the next chunk
+    auth_pubkey TEXT    NOT NULL,       -- hex pubkey that authenticated the upload
+    created_at  INTEGER NOT NULL,
+    expires_at  INTEGER NOT NULL        -- unix timestamp after which the session and its chunks are deleted
+);
+
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_auth_pubkey ON upload_sessions(auth_pubkey);
+CREATE INDEX IF NOT EXISTS idx_upload_sessions_expires_at  ON upload_sessions(expires_at);
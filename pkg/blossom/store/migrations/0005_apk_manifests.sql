@@ -0,0 +1,11 @@
+-- Manifests of the uploaded APKs, extracted from their AndroidManifest.xml and file list.
+CREATE TABLE IF NOT EXISTS apk_manifests (
+    hash         TEXT    PRIMARY KEY,   -- sha256 of the blob as a hexadecimal
+    package      TEXT    NOT NULL,      -- application id e.g. com.example.app
+    version_code INTEGER NOT NULL,
+    version_name TEXT    NOT NULL,      -- empty if it's a reference to a resource
+    min_sdk      INTEGER NOT NULL,
+    target_sdk   INTEGER NOT NULL,
+    permissions  TEXT    NOT NULL,      -- JSON array of the requested permissions
+    abis         TEXT    NOT NULL       -- JSON array of the native ABIs, empty if the APK has no native code
+);
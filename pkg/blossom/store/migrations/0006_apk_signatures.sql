@@ -0,0 +1,3 @@
+-- Signatures of the uploaded APKs, verified when they are inspected.
+ALTER TABLE apk_manifests ADD COLUMN signature_scheme INTEGER NOT NULL DEFAULT 0;   -- 1, 2 or 3, 0 if not verified
+ALTER TABLE apk_manifests ADD COLUMN certificates TEXT NOT NULL DEFAULT '[]';       -- JSON array of the sha256 of the signer certificates as hexadecimals
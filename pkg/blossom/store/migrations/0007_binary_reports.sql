@@ -0,0 +1,7 @@
+-- Binaries found in the uploaded desktop and CLI blobs, which are either binaries or archives.
+CREATE TABLE IF NOT EXISTS binary_reports (
+    hash     TEXT PRIMARY KEY,  -- sha256 of the blob as a hexadecimal
+    format   TEXT NOT NULL,     -- elf, macho, pe, zip or tar
+    binaries TEXT NOT NULL,     -- JSON array of the binaries with their path, OS and architecture
+    files    TEXT NOT NULL      -- JSON array of the regular files of the archive, empty if the blob is not an archive
+);
@@ -0,0 +1,9 @@
+-- Hashes of the uploaded blobs that were rewritten before being stored (e.g. images without their metadata),
+-- which resolve to the hash of the stored blob.
+CREATE TABLE IF NOT EXISTS blob_aliases (
+    alias      TEXT PRIMARY KEY, -- sha256 of the uploaded blob as a hexadecimal
+    hash       TEXT NOT NULL,    -- sha256 of the stored blob as a hexadecimal
+    created_at INTEGER NOT NULL  -- unix timestamp of when the alias was recorded
+);
+
+CREATE INDEX IF NOT EXISTS idx_blob_aliases_hash ON blob_aliases(hash);
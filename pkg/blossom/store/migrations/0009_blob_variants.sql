@@ -0,0 +1,9 @@
+-- Resized variants of the image blobs (e.g. thumbnails of icons and screenshots), which are blobs themselves.
+CREATE TABLE IF NOT EXISTS blob_variants (
+    hash    TEXT NOT NULL,    -- sha256 of the original blob as a hexadecimal
+    width   INTEGER NOT NULL, -- width of the variant in pixels
+    variant TEXT NOT NULL,    -- sha256 of the variant blob as a hexadecimal
+    PRIMARY KEY (hash, width)
+);
+
+CREATE INDEX IF NOT EXISTS idx_blob_variants_variant ON blob_variants(variant);
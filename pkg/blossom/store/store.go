@@ -5,20 +5,35 @@ package store
 import (
 	"context"
 	"database/sql"
-	_ "embed"
+	"embed"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/blossom/apk"
+	"github.com/zapstore/relay/pkg/blossom/exe"
+	"github.com/zapstore/relay/pkg/migrate"
 )
 
-//go:embed schema.sql
-var schema string
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrations returns the schema migrations of the blossom database, in order.
+func Migrations() ([]migrate.Migration, error) {
+	return migrate.Load(migrations, "migrations")
+}
 
 var (
-	ErrBlobNotFound = errors.New("blob not found")
+	ErrBlobNotFound     = errors.New("blob not found")
+	ErrCursorNotFound   = errors.New("cursor blob not found")
+	ErrSessionNotFound  = errors.New("upload session not found")
+	ErrOffsetMismatch   = errors.New("upload session offset mismatch")
+	ErrManifestNotFound = errors.New("apk manifest not found")
+	ErrReportNotFound   = errors.New("binary report not found")
 )
 
 type T struct {
@@ -31,7 +46,44 @@ type BlobMeta struct {
 	Type       string // MIME type
 	Size       int64
 	CreatedAt  time.Time
-	AuthPubkey string // hex pubkey that authenticated the upload, empty if unknown
+	AuthPubkey string    // hex pubkey that authenticated the upload, empty if unknown
+	MarkedAt   time.Time // when the blob was marked by the garbage collector, zero if not marked
+}
+
+// Session is a resumable upload, whose chunks are received one after the other.
+type Session struct {
+	ID         string
+	Hash       blossom.Hash
+	Type       string // MIME type
+	Size       int64  // expected size of the blob
+	Received   int64  // bytes received so far, which is the offset of the next chunk
+	AuthPubkey string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// Variant is a resized variant of an image blob, which is a blob itself.
+type Variant struct {
+	Width int // width in pixels
+	Blob  BlobMeta
+}
+
+// Usage is the storage used by a pubkey, which is counted against its quota.
+type Usage struct {
+	Pubkey  string
+	Blobs   int64 // number of blobs uploaded by the pubkey
+	Bytes   int64 // total size of the blobs uploaded by the pubkey
+	Pending int64 // total size of the blobs of the upload sessions in progress of the pubkey
+}
+
+// Total returns the bytes of the stored blobs and of the upload sessions in progress.
+func (u Usage) Total() int64 {
+	return u.Bytes + u.Pending
+}
+
+// Complete returns whether all the bytes of the blob have been received.
+func (s Session) Complete() bool {
+	return s.Received >= s.Size
 }
 
 // New creates a new store with the given path.
@@ -40,8 +92,8 @@ func New(path string) (*T, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to sqlite3 at %s: %w", path, err)
 	}
-	if _, err := db.Exec(schema); err != nil {
-		return nil, fmt.Errorf("failed to apply base schema: %w", err)
+	if err := applyMigrations(db); err != nil {
+		return nil, err
 	}
 	if _, err := db.Exec("PRAGMA journal_mode = WAL;"); err != nil {
 		return nil, fmt.Errorf("failed to set WAL mode: %w", err)
@@ -58,6 +110,18 @@ func New(path string) (*T, error) {
 	return &T{DB: db}, nil
 }
 
+// applyMigrations applies the pending schema migrations to the database.
+func applyMigrations(db *sql.DB) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	if _, err := migrate.Up(context.Background(), db, migrations); err != nil {
+		return fmt.Errorf("failed to migrate: %w", err)
+	}
+	return nil
+}
+
 func (s *T) Close() error {
 	return s.DB.Close()
 }
@@ -88,15 +152,21 @@ func (s *T) Save(ctx context.Context, b BlobMeta) (inserted bool, err error) {
 	return n > 0, nil
 }
 
+// resolveAlias is the SQL expression of the hash of the stored blob, given a hash that may be one of its aliases.
+const resolveAlias = `COALESCE((SELECT hash FROM blob_aliases WHERE alias = ?), ?)`
+
 // Query retrieves the metadata of a blob from the database.
+// If the hash is an alias, it returns the metadata of the blob it resolves to, whose hash is different.
 func (s *T) Query(ctx context.Context, hash blossom.Hash) (BlobMeta, error) {
+	var stored blossom.Hash
 	var mime string
 	var size int64
 	var createdAt int64
 	var authPubkey sql.NullString
+	var markedAt sql.NullInt64
 
-	query := `SELECT type, size, created_at, auth_pubkey FROM blobs WHERE hash = ?`
-	err := s.DB.QueryRowContext(ctx, query, hash).Scan(&mime, &size, &createdAt, &authPubkey)
+	query := `SELECT hash, type, size, created_at, auth_pubkey, marked_at FROM blobs WHERE hash = ` + resolveAlias
+	err := s.DB.QueryRowContext(ctx, query, hash, hash).Scan(&stored, &mime, &size, &createdAt, &authPubkey, &markedAt)
 	if errors.Is(err, sql.ErrNoRows) {
 		return BlobMeta{}, ErrBlobNotFound
 	}
@@ -105,7 +175,7 @@ func (s *T) Query(ctx context.Context, hash blossom.Hash) (BlobMeta, error) {
 	}
 
 	meta := BlobMeta{
-		Hash:      hash,
+		Hash:      stored,
 		Type:      mime,
 		Size:      size,
 		CreatedAt: time.Unix(createdAt, 0).UTC(),
@@ -113,25 +183,640 @@ func (s *T) Query(ctx context.Context, hash blossom.Hash) (BlobMeta, error) {
 	if authPubkey.Valid {
 		meta.AuthPubkey = authPubkey.String
 	}
+	if markedAt.Valid {
+		meta.MarkedAt = time.Unix(markedAt.Int64, 0).UTC()
+	}
 	return meta, nil
 }
 
-// Delete removes a blob's metadata record from the database.
+// All returns the metadata of all blobs in the database, from the oldest to the newest.
+func (s *T) All(ctx context.Context) ([]BlobMeta, error) {
+	query := `SELECT hash, type, size, created_at, auth_pubkey, marked_at FROM blobs ORDER BY created_at ASC`
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blobs: %w", err)
+	}
+	defer rows.Close()
+
+	return scanBlobs(rows)
+}
+
+// ListFilter selects the blobs uploaded by a pubkey, from the newest to the oldest.
+type ListFilter struct {
+	Pubkey string
+	Since  time.Time     // blobs created at or after, zero for no lower bound
+	Until  time.Time     // blobs created at or before, zero for no upper bound
+	Cursor *blossom.Hash // the last blob of the previous page, nil for the first page
+	Limit  int
+}
+
+// List returns the metadata of the blobs uploaded by the pubkey of the filter, from the newest to the oldest.
+// It returns [ErrCursorNotFound] if the cursor blob doesn't exist.
+func (s *T) List(ctx context.Context, f ListFilter) ([]BlobMeta, error) {
+	conditions := []string{"auth_pubkey = ?"}
+	args := []any{f.Pubkey}
+
+	if !f.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, f.Since.Unix())
+	}
+	if !f.Until.IsZero() {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, f.Until.Unix())
+	}
+	if f.Cursor != nil {
+		cursor, err := s.Query(ctx, *f.Cursor)
+		if errors.Is(err, ErrBlobNotFound) {
+			return nil, ErrCursorNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		conditions = append(conditions, "(created_at, hash) < (?, ?)")
+		args = append(args, cursor.CreatedAt.Unix(), cursor.Hash)
+	}
+
+	query := `SELECT hash, type, size, created_at, auth_pubkey, marked_at FROM blobs
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY created_at DESC, hash DESC LIMIT ?`
+	args = append(args, f.Limit)
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list blobs: %w", err)
+	}
+	defer rows.Close()
+	return scanBlobs(rows)
+}
+
+// Mark marks a blob as unreferenced at the given time, if it is not already marked.
+func (s *T) Mark(ctx context.Context, hash blossom.Hash, at time.Time) error {
+	query := `UPDATE blobs SET marked_at = ? WHERE hash = ? AND marked_at IS NULL`
+	if _, err := s.DB.ExecContext(ctx, query, at.Unix(), hash); err != nil {
+		return fmt.Errorf("failed to mark blob: %w", err)
+	}
+	return nil
+}
+
+// Unmark removes the garbage collection mark of a blob.
+func (s *T) Unmark(ctx context.Context, hash blossom.Hash) error {
+	if _, err := s.DB.ExecContext(ctx, `UPDATE blobs SET marked_at = NULL WHERE hash = ?`, hash); err != nil {
+		return fmt.Errorf("failed to unmark blob: %w", err)
+	}
+	return nil
+}
+
+// Reclaimable returns the number and total size in bytes of the blobs marked by the garbage collector.
+func (s *T) Reclaimable(ctx context.Context) (count, bytes int64, err error) {
+	query := `SELECT COUNT(*), COALESCE(SUM(size), 0) FROM blobs WHERE marked_at IS NOT NULL`
+	if err := s.DB.QueryRowContext(ctx, query).Scan(&count, &bytes); err != nil {
+		return 0, 0, fmt.Errorf("failed to query reclaimable blobs: %w", err)
+	}
+	return count, bytes, nil
+}
+
+// UsageOf returns the storage used by the pubkey.
+func (s *T) UsageOf(ctx context.Context, pubkey string) (Usage, error) {
+	usage := Usage{Pubkey: pubkey}
+	query := `SELECT
+		(SELECT COUNT(*) FROM blobs WHERE auth_pubkey = ?1),
+		(SELECT COALESCE(SUM(size), 0) FROM blobs WHERE auth_pubkey = ?1),
+		(SELECT COALESCE(SUM(size), 0) FROM upload_sessions WHERE auth_pubkey = ?1)`
+
+	if err := s.DB.QueryRowContext(ctx, query, pubkey).Scan(&usage.Blobs, &usage.Bytes, &usage.Pending); err != nil {
+		return Usage{}, fmt.Errorf("failed to query usage: %w", err)
+	}
+	return usage, nil
+}
+
+// Usages returns the storage used by the pubkeys that uploaded at least one blob,
+// from the largest to the smallest, up to the limit.
+func (s *T) Usages(ctx context.Context, limit int) ([]Usage, error) {
+	query := `SELECT b.auth_pubkey, COUNT(*), SUM(b.size),
+		(SELECT COALESCE(SUM(size), 0) FROM upload_sessions WHERE auth_pubkey = b.auth_pubkey)
+		FROM blobs b
+		WHERE b.auth_pubkey IS NOT NULL AND b.auth_pubkey != ''
+		GROUP BY b.auth_pubkey
+		ORDER BY SUM(b.size) DESC LIMIT ?`
+
+	rows, err := s.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query usages: %w", err)
+	}
+	defer rows.Close()
+
+	var usages []Usage
+	for rows.Next() {
+		var u Usage
+		if err := rows.Scan(&u.Pubkey, &u.Blobs, &u.Bytes, &u.Pending); err != nil {
+			return nil, fmt.Errorf("failed to scan usage: %w", err)
+		}
+		usages = append(usages, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate usages: %w", err)
+	}
+	return usages, nil
+}
+
+// Delete removes a blob's metadata record from the database, together with the records of its replicas,
+// its inspection, its aliases and its variants. The variant blobs themselves are left to the garbage collector.
 func (s *T) Delete(ctx context.Context, hash blossom.Hash) error {
-	_, err := s.DB.ExecContext(ctx, `DELETE FROM blobs WHERE hash = ?`, hash)
+	tx, err := s.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to delete blob: %w", err)
 	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM replicas WHERE hash = ?`, hash); err != nil {
+		return fmt.Errorf("failed to delete blob replicas: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM apk_manifests WHERE hash = ?`, hash); err != nil {
+		return fmt.Errorf("failed to delete blob manifest: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM binary_reports WHERE hash = ?`, hash); err != nil {
+		return fmt.Errorf("failed to delete blob binary report: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM blob_aliases WHERE hash = ?`, hash); err != nil {
+		return fmt.Errorf("failed to delete blob aliases: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM blob_variants WHERE hash = ? OR variant = ?`, hash, hash); err != nil {
+		return fmt.Errorf("failed to delete blob variants: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM blobs WHERE hash = ?`, hash); err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
+
+// SaveReplica records that the blob has been copied to the backend at the given time.
+func (s *T) SaveReplica(ctx context.Context, hash blossom.Hash, backend string, at time.Time) error {
+	query := `INSERT INTO replicas (hash, backend, replicated_at, checked_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(hash, backend) DO UPDATE SET replicated_at = excluded.replicated_at, checked_at = excluded.checked_at`
+	if _, err := s.DB.ExecContext(ctx, query, hash, backend, at.Unix(), at.Unix()); err != nil {
+		return fmt.Errorf("failed to save replica: %w", err)
+	}
+	return nil
+}
+
+// TouchReplica records that the copy of the blob has been found in the backend at the given time.
+func (s *T) TouchReplica(ctx context.Context, hash blossom.Hash, backend string, at time.Time) error {
+	query := `UPDATE replicas SET checked_at = ? WHERE hash = ? AND backend = ?`
+	if _, err := s.DB.ExecContext(ctx, query, at.Unix(), hash, backend); err != nil {
+		return fmt.Errorf("failed to touch replica: %w", err)
+	}
 	return nil
 }
 
-// Has checks whether a blob with the given hash exists in the database.
+// DeleteReplica removes the record of the copy of the blob in the backend.
+func (s *T) DeleteReplica(ctx context.Context, hash blossom.Hash, backend string) error {
+	query := `DELETE FROM replicas WHERE hash = ? AND backend = ?`
+	if _, err := s.DB.ExecContext(ctx, query, hash, backend); err != nil {
+		return fmt.Errorf("failed to delete replica: %w", err)
+	}
+	return nil
+}
+
+// Replicas returns the names of the backends holding a copy of the blob, sorted alphabetically.
+func (s *T) Replicas(ctx context.Context, hash blossom.Hash) ([]string, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT backend FROM replicas WHERE hash = ? ORDER BY backend`, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query replicas: %w", err)
+	}
+	defer rows.Close()
+
+	var backends []string
+	for rows.Next() {
+		var backend string
+		if err := rows.Scan(&backend); err != nil {
+			return nil, fmt.Errorf("failed to scan replica: %w", err)
+		}
+		backends = append(backends, backend)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query replicas: %w", err)
+	}
+	return backends, nil
+}
+
+// Unreplicated returns up to limit blobs created before the given time without a copy in the backend,
+// from the oldest to the newest.
+func (s *T) Unreplicated(ctx context.Context, backend string, before time.Time, limit int) ([]BlobMeta, error) {
+	query := `SELECT hash, type, size, created_at, auth_pubkey, marked_at FROM blobs
+		WHERE created_at < ?
+		AND NOT EXISTS (SELECT 1 FROM replicas WHERE replicas.hash = blobs.hash AND replicas.backend = ?)
+		ORDER BY created_at ASC
+		LIMIT ?`
+
+	rows, err := s.DB.QueryContext(ctx, query, before.Unix(), backend, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query unreplicated blobs: %w", err)
+	}
+	defer rows.Close()
+	return scanBlobs(rows)
+}
+
+// StaleReplicas returns up to limit blobs whose copy in the backend was last checked before the given time,
+// from the least to the most recently checked.
+func (s *T) StaleReplicas(ctx context.Context, backend string, before time.Time, limit int) ([]BlobMeta, error) {
+	query := `SELECT b.hash, b.type, b.size, b.created_at, b.auth_pubkey, b.marked_at
+		FROM replicas r JOIN blobs b ON b.hash = r.hash
+		WHERE r.backend = ? AND r.checked_at < ?
+		ORDER BY r.checked_at ASC
+		LIMIT ?`
+
+	rows, err := s.DB.QueryContext(ctx, query, backend, before.Unix(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query stale replicas: %w", err)
+	}
+	defer rows.Close()
+	return scanBlobs(rows)
+}
+
+// Has checks whether a blob with the given hash, or a blob the hash is an alias of, exists in the database.
 func (s *T) Has(ctx context.Context, hash blossom.Hash) (bool, error) {
-	query := `SELECT EXISTS(SELECT 1 FROM blobs WHERE hash = ?)`
+	query := `SELECT EXISTS(SELECT 1 FROM blobs WHERE hash = ` + resolveAlias + `)`
 	var exists bool
-	err := s.DB.QueryRowContext(ctx, query, hash).Scan(&exists)
+	err := s.DB.QueryRowContext(ctx, query, hash, hash).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("failed to check if blob exists: %w", err)
 	}
 	return exists, nil
 }
+
+// SaveAlias records that the alias resolves to the blob with the given hash.
+// An alias that already exists keeps resolving to its first blob.
+func (s *T) SaveAlias(ctx context.Context, alias, hash blossom.Hash) error {
+	query := `INSERT OR IGNORE INTO blob_aliases (alias, hash, created_at) VALUES (?, ?, ?)`
+	if _, err := s.DB.ExecContext(ctx, query, alias, hash, time.Now().Unix()); err != nil {
+		return fmt.Errorf("failed to save blob alias: %w", err)
+	}
+	return nil
+}
+
+// AliasesOf returns the aliases of the blob with the given hash.
+func (s *T) AliasesOf(ctx context.Context, hash blossom.Hash) ([]blossom.Hash, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT alias FROM blob_aliases WHERE hash = ? ORDER BY created_at ASC`, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blob aliases: %w", err)
+	}
+	defer rows.Close()
+
+	var aliases []blossom.Hash
+	for rows.Next() {
+		var alias blossom.Hash
+		if err := rows.Scan(&alias); err != nil {
+			return nil, fmt.Errorf("failed to scan blob alias: %w", err)
+		}
+		aliases = append(aliases, alias)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query blob aliases: %w", err)
+	}
+	return aliases, nil
+}
+
+// Aliases returns the aliases of all the blobs that have any, keyed by the hash of the blob.
+func (s *T) Aliases(ctx context.Context) (map[blossom.Hash][]blossom.Hash, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT alias, hash FROM blob_aliases ORDER BY created_at ASC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blob aliases: %w", err)
+	}
+	defer rows.Close()
+
+	aliases := make(map[blossom.Hash][]blossom.Hash)
+	for rows.Next() {
+		var alias, hash blossom.Hash
+		if err := rows.Scan(&alias, &hash); err != nil {
+			return nil, fmt.Errorf("failed to scan blob alias: %w", err)
+		}
+		aliases[hash] = append(aliases[hash], alias)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query blob aliases: %w", err)
+	}
+	return aliases, nil
+}
+
+// SaveVariant records that the variant blob is the image with the given hash resized to the width,
+// replacing any previous variant of that width.
+func (s *T) SaveVariant(ctx context.Context, hash blossom.Hash, width int, variant blossom.Hash) error {
+	query := `INSERT OR REPLACE INTO blob_variants (hash, width, variant) VALUES (?, ?, ?)`
+	if _, err := s.DB.ExecContext(ctx, query, hash, width, variant); err != nil {
+		return fmt.Errorf("failed to save blob variant: %w", err)
+	}
+	return nil
+}
+
+// Variants returns the variants of the image with the given hash, from the narrowest to the widest.
+func (s *T) Variants(ctx context.Context, hash blossom.Hash) ([]Variant, error) {
+	query := `SELECT v.width, b.hash, b.type, b.size, b.created_at, b.auth_pubkey, b.marked_at
+		FROM blob_variants v JOIN blobs b ON b.hash = v.variant
+		WHERE v.hash = ? ORDER BY v.width ASC`
+
+	rows, err := s.DB.QueryContext(ctx, query, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blob variants: %w", err)
+	}
+	defer rows.Close()
+
+	var variants []Variant
+	for rows.Next() {
+		var v Variant
+		var createdAt int64
+		var authPubkey sql.NullString
+		var markedAt sql.NullInt64
+
+		if err := rows.Scan(&v.Width, &v.Blob.Hash, &v.Blob.Type, &v.Blob.Size, &createdAt, &authPubkey, &markedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan blob variant: %w", err)
+		}
+		v.Blob.CreatedAt = time.Unix(createdAt, 0).UTC()
+		if authPubkey.Valid {
+			v.Blob.AuthPubkey = authPubkey.String
+		}
+		if markedAt.Valid {
+			v.Blob.MarkedAt = time.Unix(markedAt.Int64, 0).UTC()
+		}
+		variants = append(variants, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query blob variants: %w", err)
+	}
+	return variants, nil
+}
+
+// VariantOrigins returns the hashes of the original images, keyed by the hash of their variants.
+func (s *T) VariantOrigins(ctx context.Context) (map[blossom.Hash]blossom.Hash, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT hash, variant FROM blob_variants`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query blob variants: %w", err)
+	}
+	defer rows.Close()
+
+	origins := make(map[blossom.Hash]blossom.Hash)
+	for rows.Next() {
+		var hash, variant blossom.Hash
+		if err := rows.Scan(&hash, &variant); err != nil {
+			return nil, fmt.Errorf("failed to scan blob variant: %w", err)
+		}
+		origins[variant] = hash
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query blob variants: %w", err)
+	}
+	return origins, nil
+}
+
+// SaveManifest saves the manifest of the APK with the given hash, replacing any previous one.
+func (s *T) SaveManifest(ctx context.Context, hash blossom.Hash, m apk.Manifest) error {
+	permissions, err := json.Marshal(nonNil(m.Permissions))
+	if err != nil {
+		return fmt.Errorf("failed to marshal permissions: %w", err)
+	}
+	abis, err := json.Marshal(nonNil(m.ABIs))
+	if err != nil {
+		return fmt.Errorf("failed to marshal ABIs: %w", err)
+	}
+	certificates, err := json.Marshal(nonNil(m.Certificates))
+	if err != nil {
+		return fmt.Errorf("failed to marshal certificates: %w", err)
+	}
+
+	query := `INSERT OR REPLACE INTO apk_manifests (hash, package, version_code, version_name, min_sdk, target_sdk, permissions, abis, signature_scheme, certificates)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err = s.DB.ExecContext(ctx, query, hash, m.Package, m.VersionCode, m.VersionName, m.MinSDK, m.TargetSDK, permissions, abis, m.Scheme, certificates)
+	if err != nil {
+		return fmt.Errorf("failed to save apk manifest: %w", err)
+	}
+	return nil
+}
+
+// QueryManifest returns the manifest of the APK with the given hash, or [ErrManifestNotFound]
+// if the blob is not an APK, or was not inspected.
+func (s *T) QueryManifest(ctx context.Context, hash blossom.Hash) (apk.Manifest, error) {
+	var m apk.Manifest
+	var permissions, abis, certificates []byte
+
+	query := `SELECT package, version_code, version_name, min_sdk, target_sdk, permissions, abis, signature_scheme, certificates
+		FROM apk_manifests WHERE hash = ?`
+	err := s.DB.QueryRowContext(ctx, query, hash).Scan(&m.Package, &m.VersionCode, &m.VersionName, &m.MinSDK, &m.TargetSDK, &permissions, &abis, &m.Scheme, &certificates)
+	if errors.Is(err, sql.ErrNoRows) {
+		return apk.Manifest{}, ErrManifestNotFound
+	}
+	if err != nil {
+		return apk.Manifest{}, fmt.Errorf("failed to query apk manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(permissions, &m.Permissions); err != nil {
+		return apk.Manifest{}, fmt.Errorf("failed to unmarshal permissions: %w", err)
+	}
+	if err := json.Unmarshal(abis, &m.ABIs); err != nil {
+		return apk.Manifest{}, fmt.Errorf("failed to unmarshal ABIs: %w", err)
+	}
+	if err := json.Unmarshal(certificates, &m.Certificates); err != nil {
+		return apk.Manifest{}, fmt.Errorf("failed to unmarshal certificates: %w", err)
+	}
+	if len(m.Permissions) == 0 {
+		m.Permissions = nil
+	}
+	if len(m.ABIs) == 0 {
+		m.ABIs = nil
+	}
+	if len(m.Certificates) == 0 {
+		m.Certificates = nil
+	}
+	return m, nil
+}
+
+// SaveReport saves the binary report of the blob with the given hash, replacing any previous one.
+func (s *T) SaveReport(ctx context.Context, hash blossom.Hash, r exe.Report) error {
+	binaries := r.Binaries
+	if binaries == nil {
+		binaries = []exe.Binary{}
+	}
+	binariesJSON, err := json.Marshal(binaries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal binaries: %w", err)
+	}
+	files, err := json.Marshal(nonNil(r.Files))
+	if err != nil {
+		return fmt.Errorf("failed to marshal files: %w", err)
+	}
+
+	query := `INSERT OR REPLACE INTO binary_reports (hash, format, binaries, files) VALUES (?, ?, ?, ?)`
+	if _, err := s.DB.ExecContext(ctx, query, hash, r.Format, binariesJSON, files); err != nil {
+		return fmt.Errorf("failed to save binary report: %w", err)
+	}
+	return nil
+}
+
+// QueryReport returns the binary report of the blob with the given hash, or [ErrReportNotFound]
+// if the blob is not a binary or an archive, or was not inspected.
+func (s *T) QueryReport(ctx context.Context, hash blossom.Hash) (exe.Report, error) {
+	var r exe.Report
+	var binaries, files []byte
+
+	query := `SELECT format, binaries, files FROM binary_reports WHERE hash = ?`
+	err := s.DB.QueryRowContext(ctx, query, hash).Scan(&r.Format, &binaries, &files)
+	if errors.Is(err, sql.ErrNoRows) {
+		return exe.Report{}, ErrReportNotFound
+	}
+	if err != nil {
+		return exe.Report{}, fmt.Errorf("failed to query binary report: %w", err)
+	}
+
+	if err := json.Unmarshal(binaries, &r.Binaries); err != nil {
+		return exe.Report{}, fmt.Errorf("failed to unmarshal binaries: %w", err)
+	}
+	if err := json.Unmarshal(files, &r.Files); err != nil {
+		return exe.Report{}, fmt.Errorf("failed to unmarshal files: %w", err)
+	}
+	if len(r.Binaries) == 0 {
+		r.Binaries = nil
+	}
+	if len(r.Files) == 0 {
+		r.Files = nil
+	}
+	return r, nil
+}
+
+// SaveSession saves a new upload session.
+func (s *T) SaveSession(ctx context.Context, session Session) error {
+	query := `INSERT INTO upload_sessions (id, hash, type, size, received, auth_pubkey, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := s.DB.ExecContext(ctx, query, session.ID, session.Hash, session.Type, session.Size,
+		session.Received, session.AuthPubkey, session.CreatedAt.Unix(), session.ExpiresAt.Unix())
+	if err != nil {
+		return fmt.Errorf("failed to save upload session: %w", err)
+	}
+	return nil
+}
+
+// QuerySession returns the upload session with the given id, or [ErrSessionNotFound].
+func (s *T) QuerySession(ctx context.Context, id string) (Session, error) {
+	query := `SELECT id, hash, type, size, received, auth_pubkey, created_at, expires_at FROM upload_sessions WHERE id = ?`
+	rows, err := s.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return Session{}, fmt.Errorf("failed to query upload session: %w", err)
+	}
+	defer rows.Close()
+
+	sessions, err := scanSessions(rows)
+	if err != nil {
+		return Session{}, err
+	}
+	if len(sessions) == 0 {
+		return Session{}, ErrSessionNotFound
+	}
+	return sessions[0], nil
+}
+
+// AdvanceSession moves the offset of the upload session from the given offset to the new one,
+// and extends its expiration. It returns [ErrOffsetMismatch] if the offset of the session is not from.
+func (s *T) AdvanceSession(ctx context.Context, id string, from, to int64, expiresAt time.Time) error {
+	query := `UPDATE upload_sessions SET received = ?, expires_at = ? WHERE id = ? AND received = ?`
+	res, err := s.DB.ExecContext(ctx, query, to, expiresAt.Unix(), id, from)
+	if err != nil {
+		return fmt.Errorf("failed to advance upload session: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if n == 0 {
+		return ErrOffsetMismatch
+	}
+	return nil
+}
+
+// DeleteSession removes the upload session with the given id.
+func (s *T) DeleteSession(ctx context.Context, id string) error {
+	if _, err := s.DB.ExecContext(ctx, `DELETE FROM upload_sessions WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete upload session: %w", err)
+	}
+	return nil
+}
+
+// CountSessions returns the number of upload sessions of the pubkey.
+func (s *T) CountSessions(ctx context.Context, pubkey string) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM upload_sessions WHERE auth_pubkey = ?`
+	if err := s.DB.QueryRowContext(ctx, query, pubkey).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count upload sessions: %w", err)
+	}
+	return count, nil
+}
+
+// Sessions returns all the upload sessions.
+func (s *T) Sessions(ctx context.Context) ([]Session, error) {
+	query := `SELECT id, hash, type, size, received, auth_pubkey, created_at, expires_at FROM upload_sessions`
+	rows, err := s.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query upload sessions: %w", err)
+	}
+	defer rows.Close()
+	return scanSessions(rows)
+}
+
+// scanSessions scans rows of id, hash, type, size, received, auth_pubkey, created_at and expires_at into sessions.
+func scanSessions(rows *sql.Rows) ([]Session, error) {
+	var sessions []Session
+	for rows.Next() {
+		var createdAt, expiresAt int64
+		var session Session
+
+		err := rows.Scan(&session.ID, &session.Hash, &session.Type, &session.Size,
+			&session.Received, &session.AuthPubkey, &createdAt, &expiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan upload session: %w", err)
+		}
+		session.CreatedAt = time.Unix(createdAt, 0).UTC()
+		session.ExpiresAt = time.Unix(expiresAt, 0).UTC()
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query upload sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// scanBlobs scans rows of hash, type, size, created_at, auth_pubkey and marked_at into blobs metadata.
+func scanBlobs(rows *sql.Rows) ([]BlobMeta, error) {
+	var blobs []BlobMeta
+	for rows.Next() {
+		var createdAt int64
+		var authPubkey sql.NullString
+		var markedAt sql.NullInt64
+		var meta BlobMeta
+
+		if err := rows.Scan(&meta.Hash, &meta.Type, &meta.Size, &createdAt, &authPubkey, &markedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan blob: %w", err)
+		}
+		meta.CreatedAt = time.Unix(createdAt, 0).UTC()
+		if authPubkey.Valid {
+			meta.AuthPubkey = authPubkey.String
+		}
+		if markedAt.Valid {
+			meta.MarkedAt = time.Unix(markedAt.Int64, 0).UTC()
+		}
+		blobs = append(blobs, meta)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query blobs: %w", err)
+	}
+	return blobs, nil
+}
+
+// nonNil returns an empty slice instead of nil, so that it's marshalled as an empty JSON array.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -3,11 +3,14 @@ package store
 import (
 	"context"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/blossom/apk"
+	"github.com/zapstore/relay/pkg/blossom/exe"
 )
 
 var ctx = context.Background()
@@ -61,3 +64,249 @@ func TestSaveAndQuery(t *testing.T) {
 		t.Errorf("expected blobmeta %v, got %v", want, got)
 	}
 }
+
+func TestSaveAndQueryManifest(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	hash := blossom.ComputeHash([]byte("test apk content"))
+	if _, err := store.QueryManifest(ctx, hash); err != ErrManifestNotFound {
+		t.Fatalf("expected error %v, got %v", ErrManifestNotFound, err)
+	}
+
+	want := apk.Manifest{
+		Package:      "com.example.app",
+		VersionCode:  42,
+		VersionName:  "1.4.2",
+		MinSDK:       24,
+		TargetSDK:    34,
+		Permissions:  []string{"android.permission.INTERNET"},
+		Scheme:       apk.SchemeV2,
+		Certificates: []string{"b2f0f1d0e4bd40b9f1d47d6a8c1c9f4c33ae8e0ed2e1cbb56a8f8bfc6c07e25b"},
+	}
+	if err := store.SaveManifest(ctx, hash, want); err != nil {
+		t.Fatalf("SaveManifest failed: %v", err)
+	}
+
+	got, err := store.QueryManifest(ctx, hash)
+	if err != nil {
+		t.Fatalf("QueryManifest failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestSaveAndQueryReport(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	hash := blossom.ComputeHash([]byte("test archive content"))
+	if _, err := store.QueryReport(ctx, hash); err != ErrReportNotFound {
+		t.Fatalf("expected error %v, got %v", ErrReportNotFound, err)
+	}
+
+	want := exe.Report{
+		Format:   exe.FormatTar,
+		Binaries: []exe.Binary{{Path: "bin/app", OS: "linux", Arch: "x86_64"}},
+		Files:    []string{"bin/app", "README.md"},
+	}
+	if err := store.SaveReport(ctx, hash, want); err != nil {
+		t.Fatalf("SaveReport failed: %v", err)
+	}
+
+	got, err := store.QueryReport(ctx, hash)
+	if err != nil {
+		t.Fatalf("QueryReport failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	original := blossom.ComputeHash([]byte("image with metadata"))
+	meta := BlobMeta{
+		Hash:      blossom.ComputeHash([]byte("image")),
+		Type:      "image/png",
+		Size:      5,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}
+
+	if _, err := store.Save(ctx, meta); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if err := store.SaveAlias(ctx, original, meta.Hash); err != nil {
+		t.Fatalf("SaveAlias failed: %v", err)
+	}
+
+	got, err := store.Query(ctx, original)
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if !reflect.DeepEqual(got, meta) {
+		t.Errorf("expected the alias to resolve to %v, got %v", meta, got)
+	}
+
+	has, err := store.Has(ctx, original)
+	if err != nil {
+		t.Fatalf("Has failed: %v", err)
+	}
+	if !has {
+		t.Fatalf("expected the alias to resolve to the blob")
+	}
+
+	aliases, err := store.Aliases(ctx)
+	if err != nil {
+		t.Fatalf("Aliases failed: %v", err)
+	}
+	if want := map[blossom.Hash][]blossom.Hash{meta.Hash: {original}}; !reflect.DeepEqual(aliases, want) {
+		t.Errorf("expected aliases %v, got %v", want, aliases)
+	}
+
+	of, err := store.AliasesOf(ctx, meta.Hash)
+	if err != nil {
+		t.Fatalf("AliasesOf failed: %v", err)
+	}
+	if want := []blossom.Hash{original}; !reflect.DeepEqual(of, want) {
+		t.Errorf("expected aliases %v, got %v", want, of)
+	}
+
+	if err := store.Delete(ctx, meta.Hash); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := store.Query(ctx, original); err != ErrBlobNotFound {
+		t.Fatalf("expected %v after deleting the blob, got %v", ErrBlobNotFound, err)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	original := BlobMeta{Hash: blossom.ComputeHash([]byte("icon")), Type: "image/png", Size: 4096, CreatedAt: now}
+	small := BlobMeta{Hash: blossom.ComputeHash([]byte("small icon")), Type: "image/png", Size: 256, CreatedAt: now}
+	large := BlobMeta{Hash: blossom.ComputeHash([]byte("large icon")), Type: "image/png", Size: 1024, CreatedAt: now}
+
+	for _, meta := range []BlobMeta{original, small, large} {
+		if _, err := store.Save(ctx, meta); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+	if err := store.SaveVariant(ctx, original.Hash, 256, large.Hash); err != nil {
+		t.Fatalf("SaveVariant failed: %v", err)
+	}
+	if err := store.SaveVariant(ctx, original.Hash, 64, small.Hash); err != nil {
+		t.Fatalf("SaveVariant failed: %v", err)
+	}
+
+	variants, err := store.Variants(ctx, original.Hash)
+	if err != nil {
+		t.Fatalf("Variants failed: %v", err)
+	}
+	if want := []Variant{{Width: 64, Blob: small}, {Width: 256, Blob: large}}; !reflect.DeepEqual(variants, want) {
+		t.Errorf("expected variants %v, got %v", want, variants)
+	}
+
+	origins, err := store.VariantOrigins(ctx)
+	if err != nil {
+		t.Fatalf("VariantOrigins failed: %v", err)
+	}
+	if want := map[blossom.Hash]blossom.Hash{small.Hash: original.Hash, large.Hash: original.Hash}; !reflect.DeepEqual(origins, want) {
+		t.Errorf("expected origins %v, got %v", want, origins)
+	}
+
+	// deleting a variant blob unlinks it from the original
+	if err := store.Delete(ctx, small.Hash); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	variants, err = store.Variants(ctx, original.Hash)
+	if err != nil {
+		t.Fatalf("Variants failed: %v", err)
+	}
+	if want := []Variant{{Width: 256, Blob: large}}; !reflect.DeepEqual(variants, want) {
+		t.Errorf("expected variants %v, got %v", want, variants)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	alice, bob := strings.Repeat("a", 64), strings.Repeat("b", 64)
+
+	for _, meta := range []BlobMeta{
+		{Hash: blossom.ComputeHash([]byte("a1")), Type: "image/png", Size: 100, CreatedAt: now, AuthPubkey: alice},
+		{Hash: blossom.ComputeHash([]byte("a2")), Type: "image/png", Size: 200, CreatedAt: now, AuthPubkey: alice},
+		{Hash: blossom.ComputeHash([]byte("b1")), Type: "image/png", Size: 1000, CreatedAt: now, AuthPubkey: bob},
+		{Hash: blossom.ComputeHash([]byte("variant")), Type: "image/png", Size: 5000, CreatedAt: now},
+	} {
+		if _, err := store.Save(ctx, meta); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	session := Session{
+		ID:         "session",
+		Hash:       blossom.ComputeHash([]byte("a3")),
+		Type:       "application/vnd.android.package-archive",
+		Size:       50,
+		AuthPubkey: alice,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(time.Hour),
+	}
+	if err := store.SaveSession(ctx, session); err != nil {
+		t.Fatalf("SaveSession failed: %v", err)
+	}
+
+	usage, err := store.UsageOf(ctx, alice)
+	if err != nil {
+		t.Fatalf("UsageOf failed: %v", err)
+	}
+	if want := (Usage{Pubkey: alice, Blobs: 2, Bytes: 300, Pending: 50}); usage != want {
+		t.Errorf("expected usage %v, got %v", want, usage)
+	}
+	if usage.Total() != 350 {
+		t.Errorf("expected a total of 350 bytes, got %d", usage.Total())
+	}
+
+	usage, err = store.UsageOf(ctx, strings.Repeat("c", 64))
+	if err != nil {
+		t.Fatalf("UsageOf failed: %v", err)
+	}
+	if usage.Total() != 0 {
+		t.Errorf("expected no usage, got %v", usage)
+	}
+
+	usages, err := store.Usages(ctx, 10)
+	if err != nil {
+		t.Fatalf("Usages failed: %v", err)
+	}
+	want := []Usage{
+		{Pubkey: bob, Blobs: 1, Bytes: 1000},
+		{Pubkey: alice, Blobs: 2, Bytes: 300, Pending: 50},
+	}
+	if !reflect.DeepEqual(usages, want) {
+		t.Errorf("expected usages %v, got %v", want, usages)
+	}
+}
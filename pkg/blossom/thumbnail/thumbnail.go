@@ -0,0 +1,145 @@
+// The thumbnail package is responsible for generating the resized variants of images (e.g. the thumbnails
+// of app icons and screenshots), which clients download in list views instead of the full-size images.
+// JPEG images are resized as JPEG, while PNG and GIF images are resized as PNG, preserving their transparency.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"slices"
+)
+
+// jpegQuality is the quality of the JPEG variants, which is a good compromise for thumbnails.
+const jpegQuality = 85
+
+var (
+	ErrInvalidImage = errors.New("invalid image")
+	ErrTooLarge     = errors.New("image dimensions are too large")
+)
+
+// MimeTypes are the types of the images whose variants can be generated.
+var MimeTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// Supports returns whether variants of images of the type can be generated.
+func Supports(mime string) bool {
+	return slices.Contains(MimeTypes, mime)
+}
+
+// Variant is an image resized to a width, preserving the aspect ratio of the original.
+type Variant struct {
+	Width int
+	Type  string // MIME type
+	Data  []byte
+}
+
+// Generate returns the variants of the image at the given widths. Widths that are not smaller than the width
+// of the image are skipped, because variants are only used to download less than the original.
+// It returns an error wrapping [ErrInvalidImage] if the image can't be decoded, or [ErrTooLarge] if its width
+// or height is larger than maxDimension, which is checked before decoding to bound the memory used.
+func Generate(mime string, data []byte, widths []int, maxDimension int) ([]Variant, error) {
+	var decodeConfig func(io.Reader) (image.Config, error)
+	var decode func(io.Reader) (image.Image, error)
+
+	switch mime {
+	case "image/jpeg":
+		decodeConfig, decode = jpeg.DecodeConfig, jpeg.Decode
+	case "image/png":
+		decodeConfig, decode = png.DecodeConfig, png.Decode
+	case "image/gif":
+		decodeConfig, decode = gif.DecodeConfig, gif.Decode // the first frame
+	default:
+		return nil, fmt.Errorf("unsupported image type %q", mime)
+	}
+
+	config, err := decodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImage, err)
+	}
+	if config.Width > maxDimension || config.Height > maxDimension {
+		return nil, fmt.Errorf("%w: %dx%d, the maximum is %d pixels per side", ErrTooLarge, config.Width, config.Height, maxDimension)
+	}
+
+	src, err := decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImage, err)
+	}
+
+	bounds := src.Bounds()
+	if bounds.Dx() <= 0 || bounds.Dy() <= 0 {
+		return nil, fmt.Errorf("%w: empty image", ErrInvalidImage)
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	variants := make([]Variant, 0, len(widths))
+	for _, width := range widths {
+		if width <= 0 || width >= bounds.Dx() {
+			continue
+		}
+
+		height := max(1, bounds.Dy()*width/bounds.Dx())
+		resized := resize(rgba, width, height)
+
+		var buf bytes.Buffer
+		variant := Variant{Width: width}
+		if mime == "image/jpeg" {
+			variant.Type = "image/jpeg"
+			err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: jpegQuality})
+		} else {
+			variant.Type = "image/png"
+			err = png.Encode(&buf, resized)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode the %dpx variant: %w", width, err)
+		}
+
+		variant.Data = buf.Bytes()
+		variants = append(variants, variant)
+	}
+	return variants, nil
+}
+
+// resize scales the image down to width x height, averaging the source pixels covered by each destination pixel.
+// Averaging premultiplied colors keeps transparent pixels from darkening the edges.
+func resize(src *image.RGBA, width, height int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcW, srcH := src.Bounds().Dx(), src.Bounds().Dy()
+
+	for y := range height {
+		y0 := y * srcH / height
+		y1 := max((y+1)*srcH/height, y0+1)
+
+		for x := range width {
+			x0 := x * srcW / width
+			x1 := max((x+1)*srcW/width, x0+1)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
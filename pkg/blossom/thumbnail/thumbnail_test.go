@@ -0,0 +1,148 @@
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name     string
+		mime     string
+		data     []byte
+		widths   []int
+		expected []image.Point // dimensions of the variants
+		types    []string
+		err      error
+	}{
+		{
+			name:     "jpeg",
+			mime:     "image/jpeg",
+			data:     encode(t, "image/jpeg", 400, 200),
+			widths:   []int{100, 200},
+			expected: []image.Point{{100, 50}, {200, 100}},
+			types:    []string{"image/jpeg", "image/jpeg"},
+		},
+		{
+			name:     "png",
+			mime:     "image/png",
+			data:     encode(t, "image/png", 300, 900),
+			widths:   []int{64},
+			expected: []image.Point{{64, 192}},
+			types:    []string{"image/png"},
+		},
+		{
+			name:     "widths not smaller than the image are skipped",
+			mime:     "image/png",
+			data:     encode(t, "image/png", 128, 128),
+			widths:   []int{64, 128, 256},
+			expected: []image.Point{{64, 64}},
+			types:    []string{"image/png"},
+		},
+		{
+			name:     "very wide image",
+			mime:     "image/png",
+			data:     encode(t, "image/png", 1000, 2),
+			widths:   []int{10},
+			expected: []image.Point{{10, 1}},
+			types:    []string{"image/png"},
+		},
+		{
+			name: "invalid",
+			mime: "image/jpeg",
+			data: []byte("not a jpeg"),
+			err:  ErrInvalidImage,
+		},
+		{
+			name:   "too large",
+			mime:   "image/png",
+			data:   encode(t, "image/png", 2000, 2),
+			widths: []int{10},
+			err:    ErrTooLarge,
+		},
+		{
+			name:   "too large gif",
+			mime:   "image/gif",
+			data:   gifHeader(65535, 65535),
+			widths: []int{10},
+			err:    ErrTooLarge,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			variants, err := Generate(test.mime, test.data, test.widths, 1024)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+			if len(variants) != len(test.expected) {
+				t.Fatalf("expected %d variants, got %d", len(test.expected), len(variants))
+			}
+
+			for i, variant := range variants {
+				config, format, err := image.DecodeConfig(bytes.NewReader(variant.Data))
+				if err != nil {
+					t.Fatalf("failed to decode variant %d: %v", i, err)
+				}
+				if size := (image.Point{config.Width, config.Height}); size != test.expected[i] {
+					t.Errorf("expected variant %d to be %v, got %v", i, test.expected[i], size)
+				}
+				if variant.Width != config.Width {
+					t.Errorf("expected variant %d width %d, got %d", i, config.Width, variant.Width)
+				}
+				if variant.Type != test.types[i] || "image/"+format != variant.Type {
+					t.Errorf("expected variant %d of type %s, got %s encoded as %s", i, test.types[i], variant.Type, format)
+				}
+			}
+		})
+	}
+}
+
+func TestResize(t *testing.T) {
+	// a 4x2 image whose left half is opaque red and right half transparent
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := range 2 {
+		for x := range 2 {
+			src.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+
+	dst := resize(src, 2, 1)
+	if got := dst.RGBAAt(0, 0); got != (color.RGBA{R: 255, A: 255}) {
+		t.Errorf("expected the left pixel to be opaque red, got %v", got)
+	}
+	if got := dst.RGBAAt(1, 0); got != (color.RGBA{}) {
+		t.Errorf("expected the right pixel to be transparent, got %v", got)
+	}
+}
+
+func encode(t *testing.T, mime string, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if mime == "image/jpeg" {
+		err = jpeg.Encode(&buf, img, nil)
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// gifHeader returns the header of a GIF image of width x height, without its frames.
+func gifHeader(width, height int) []byte {
+	data := []byte("GIF89a")
+	data = append(data, byte(width), byte(width>>8), byte(height), byte(height>>8))
+	return append(data, 0, 0, 0) // no global color table
+}
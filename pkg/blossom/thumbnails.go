@@ -0,0 +1,246 @@
+package blossom
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/blossy"
+	"github.com/zapstore/relay/pkg/blossom/sanitize"
+	"github.com/zapstore/relay/pkg/blossom/store"
+	"github.com/zapstore/relay/pkg/blossom/thumbnail"
+)
+
+const (
+	// thumbnailQueueSize is the number of images waiting for their thumbnails after their upload.
+	// When the queue is full, the thumbnails are generated on the first download of a variant.
+	thumbnailQueueSize = 1000
+	thumbnailTimeout   = time.Minute
+
+	// maxMediaThumbnails is the maximum number of media requests generating their variants at the same time.
+	// Other requests leave the generation to the thumbnail queue, to bound the memory used by decoded images.
+	maxMediaThumbnails = 2
+)
+
+func newThumbnailQueue() chan store.BlobMeta {
+	return make(chan store.BlobMeta, thumbnailQueueSize)
+}
+
+func newMediaSlots() chan struct{} {
+	return make(chan struct{}, maxMediaThumbnails)
+}
+
+// enqueueThumbnails schedules the generation of the variants of the image, without blocking.
+// Blobs whose variants can't be generated are ignored.
+func (b *T) enqueueThumbnails(meta store.BlobMeta) {
+	if b.thumbnails == nil || !thumbnail.Supports(meta.Type) {
+		return
+	}
+
+	select {
+	case b.thumbnails <- meta:
+	default:
+		slog.Warn("blossom: thumbnail queue is full, leaving the image to its first download", "hash", meta.Hash)
+	}
+}
+
+// runThumbnails generates the variants of the images in the thumbnail queue, until the context is cancelled.
+func (b *T) runThumbnails(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case meta := <-b.thumbnails:
+			err := safely(func() error { return b.generateThumbnails(ctx, meta) })
+			if err != nil && ctx.Err() == nil {
+				slog.Error("blossom: failed to generate thumbnails", "error", err, "hash", meta.Hash)
+			}
+		}
+	}
+}
+
+// generateThumbnails generates the variants of the image at the widths of [Config.ThumbnailWidths] that are missing,
+// stores them as blobs and links them to the image in the store. Widths that are not smaller than the image
+// are linked to the image itself, so that an image whose variants are all linked is never processed again.
+func (b *T) generateThumbnails(ctx context.Context, meta store.BlobMeta) error {
+	if len(b.config.ThumbnailWidths) == 0 || !thumbnail.Supports(meta.Type) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, thumbnailTimeout)
+	defer cancel()
+
+	variants, err := b.store.Variants(ctx, meta.Hash)
+	if err != nil {
+		return err
+	}
+	missing := missingWidths(b.config.ThumbnailWidths, variants)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	name := BlobPath(meta.Hash, meta.Type)
+	file, err := b.storage.Open(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to open image: %w", err)
+	}
+	image, err := b.readImage(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read image: %w", err)
+	}
+
+	generated, err := thumbnail.Generate(meta.Type, image, missing, b.config.ImageMaxDimension)
+	if err != nil {
+		return err
+	}
+
+	for _, variant := range generated {
+		stored, err := b.storeVariant(ctx, variant)
+		if err != nil {
+			return err
+		}
+		if err := b.store.SaveVariant(ctx, meta.Hash, variant.Width, stored.Hash); err != nil {
+			return err
+		}
+	}
+
+	for _, width := range missing {
+		if !slices.ContainsFunc(generated, func(v thumbnail.Variant) bool { return v.Width == width }) {
+			// the image is not wider than the variant, so it's its own variant
+			if err := b.store.SaveVariant(ctx, meta.Hash, width, meta.Hash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// storeVariant stores the variant as a blob, unless a blob with the same content already exists.
+// Variants are generated by the server, so they don't have the pubkey of an uploader.
+func (b *T) storeVariant(ctx context.Context, variant thumbnail.Variant) (store.BlobMeta, error) {
+	meta := store.BlobMeta{
+		Hash:      blossom.ComputeHash(variant.Data),
+		Type:      variant.Type,
+		Size:      int64(len(variant.Data)),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	existing, err := b.store.Query(ctx, meta.Hash)
+	if err == nil {
+		return existing, nil
+	}
+	if !errors.Is(err, store.ErrBlobNotFound) {
+		return store.BlobMeta{}, err
+	}
+
+	name := BlobPath(meta.Hash, meta.Type)
+	if err := b.storage.Upload(ctx, bytes.NewReader(variant.Data), meta.Size, name, meta.Hash.Hex()); err != nil {
+		return store.BlobMeta{}, fmt.Errorf("failed to upload variant: %w", err)
+	}
+	if _, err := b.store.Save(ctx, meta); err != nil {
+		return store.BlobMeta{}, err
+	}
+
+	b.enqueueReplication(meta)
+	return meta, nil
+}
+
+// missingWidths returns the widths without a variant.
+func missingWidths(widths []int, variants []store.Variant) []int {
+	var missing []int
+	for _, width := range widths {
+		if !slices.ContainsFunc(variants, func(v store.Variant) bool { return v.Width == width }) {
+			missing = append(missing, width)
+		}
+	}
+	return missing
+}
+
+// variantFor returns the narrowest variant at least as wide as the requested width,
+// or false if the original should be served instead.
+func variantFor(variants []store.Variant, width int) (store.BlobMeta, bool) {
+	for _, variant := range variants {
+		if variant.Width >= width {
+			return variant.Blob, true
+		}
+	}
+	return store.BlobMeta{}, false
+}
+
+// redirectToVariant returns a redirect to the variant of the image requested with the "w" query parameter,
+// or nil if the image should be served as is. Missing variants are scheduled for generation.
+func (b *T) redirectToVariant(r blossy.Request, meta store.BlobMeta) blossy.BlobDelivery {
+	w := r.Raw().URL.Query().Get("w")
+	if w == "" || len(b.config.ThumbnailWidths) == 0 || !thumbnail.Supports(meta.Type) {
+		return nil
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil || width <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	variants, err := b.store.Variants(ctx, meta.Hash)
+	if err != nil {
+		slog.Error("blossom: failed to query blob variants", "error", err, "hash", meta.Hash)
+		return nil
+	}
+	if len(missingWidths(b.config.ThumbnailWidths, variants)) > 0 {
+		b.enqueueThumbnails(meta)
+	}
+
+	variant, ok := variantFor(variants, width)
+	if !ok || variant.Hash == meta.Hash {
+		return nil
+	}
+
+	url := fmt.Sprintf("https://%s/%s.%s", b.config.Hostname, variant.Hash.Hex(), blossom.ExtFromType(variant.Type))
+	return blossy.Redirect(url, http.StatusTemporaryRedirect)
+}
+
+// media handles PUT /media as per BUD-05. The image is sanitized and stored like an upload,
+// and its variants are generated before responding, so that they can be downloaded right away.
+// When [maxMediaThumbnails] requests are already generating variants, they are left to the thumbnail queue,
+// where the upload enqueued the image.
+func (b *T) media(r blossy.Request, hints blossy.UploadHints, data io.Reader) (blossom.BlobDescriptor, *blossom.Error) {
+	desc, err := b.upload(r, hints, data)
+	if err != nil {
+		return blossom.BlobDescriptor{}, err
+	}
+
+	meta := store.BlobMeta{Hash: desc.Hash, Type: desc.Type, Size: desc.Size}
+	select {
+	case b.mediaSlots <- struct{}{}:
+		err := safely(func() error { return b.generateThumbnails(context.WithoutCancel(r.Context()), meta) })
+		<-b.mediaSlots
+		if err != nil {
+			slog.Error("blossom: failed to generate thumbnails", "error", err, "hash", meta.Hash)
+		}
+
+	default:
+		// the variants are generated by runThumbnails
+	}
+	return desc, nil
+}
+
+// MediaNotImage rejects the media requests of blobs that are not images, which can't be optimized.
+func MediaNotImage() func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+	return func(r blossy.Request, hints blossy.UploadHints) *blossom.Error {
+		if !sanitize.Supports(hints.Type) && !thumbnail.Supports(hints.Type) {
+			return blossom.ErrUnsupportedMedia("only images can be uploaded to /media, use /upload for other blobs")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,139 @@
+package blossom
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/blossom/store"
+)
+
+func TestThumbnails(t *testing.T) {
+	b, primary := newSessionServer(t)
+	b.config.ThumbnailWidths = []int{64, 256}
+	b.server.On.Download = b.download
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 200, 100))); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := store.BlobMeta{Hash: blossom.ComputeHash(buf.Bytes()), Type: "image/png", Size: int64(buf.Len())}
+	primary.blobs[BlobPath(meta.Hash, meta.Type)] = buf.Bytes()
+	if _, err := b.store.Save(ctx, meta); err != nil {
+		t.Fatalf("failed to save blob: %v", err)
+	}
+
+	if err := b.generateThumbnails(ctx, meta); err != nil {
+		t.Fatalf("failed to generate thumbnails: %v", err)
+	}
+
+	variants, err := b.store.Variants(ctx, meta.Hash)
+	if err != nil {
+		t.Fatalf("failed to query variants: %v", err)
+	}
+	if len(variants) != 2 || variants[0].Width != 64 || variants[1].Width != 256 {
+		t.Fatalf("expected variants of 64 and 256 pixels, got %v", variants)
+	}
+	if variants[1].Blob.Hash != meta.Hash {
+		t.Fatalf("expected the image narrower than 256 pixels to be its own variant, got %v", variants[1].Blob.Hash)
+	}
+
+	thumbnail := variants[0].Blob
+	config, err := png.DecodeConfig(bytes.NewReader(primary.blobs[BlobPath(thumbnail.Hash, thumbnail.Type)]))
+	if err != nil {
+		t.Fatalf("failed to decode the thumbnail: %v", err)
+	}
+	if config.Width != 64 || config.Height != 32 {
+		t.Fatalf("expected a 64x32 thumbnail, got %dx%d", config.Width, config.Height)
+	}
+
+	tests := []struct {
+		query    string
+		location string
+	}{
+		{query: "?w=48", location: "https://example.com/" + thumbnail.Hash.Hex() + ".png"},
+		{query: "?w=64", location: "https://example.com/" + thumbnail.Hash.Hex() + ".png"},
+		{query: "?w=128"},
+		{query: "?w=1024"},
+		{query: "?w=invalid"},
+		{query: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/"+meta.Hash.Hex()+".png"+test.query, nil)
+			if test.location == "" {
+				if redirect := b.redirectToVariant(rawRequest{raw: req}, meta); redirect != nil {
+					t.Fatalf("expected the original image, got %v", redirect)
+				}
+				return
+			}
+
+			rec := httptest.NewRecorder()
+			b.server.ServeHTTP(rec, req)
+			if rec.Code != http.StatusTemporaryRedirect || rec.Header().Get("Location") != test.location {
+				t.Fatalf("expected a redirect to %s, got %d to %s", test.location, rec.Code, rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+func TestMediaSlots(t *testing.T) {
+	b, _ := newSessionServer(t)
+	b.config.ThumbnailWidths = []int{64}
+	b.config.AllowedMedia = []string{"image/png"}
+	b.thumbnails = newThumbnailQueue()
+	b.mediaSlots = newMediaSlots()
+	b.server.On.Media = b.media
+	handler := b.Handler()
+
+	media := func(width int) store.BlobMeta {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, 100))); err != nil {
+			t.Fatal(err)
+		}
+
+		hash := blossom.ComputeHash(buf.Bytes())
+		req := httptest.NewRequest(http.MethodPut, "/media", bytes.NewReader(buf.Bytes()))
+		req.Header.Set("Authorization", uploadAuth(t, hash))
+		req.Header.Set("Content-Digest", hash.Hex())
+		req.Header.Set("Content-Type", "image/png")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Header().Get("X-Reason"))
+		}
+
+		var desc blossom.BlobDescriptor
+		if err := json.Unmarshal(rec.Body.Bytes(), &desc); err != nil {
+			t.Fatalf("failed to decode descriptor: %v", err)
+		}
+		return store.BlobMeta{Hash: desc.Hash, Type: desc.Type, Size: desc.Size}
+	}
+
+	meta := media(200)
+	<-b.thumbnails // enqueued by the upload
+	if variants, _ := b.store.Variants(ctx, meta.Hash); len(variants) != 1 {
+		t.Fatalf("expected the variant to be generated before responding, got %v", variants)
+	}
+
+	// with every slot busy, the generation is left to the thumbnail queue
+	for range maxMediaThumbnails {
+		b.mediaSlots <- struct{}{}
+	}
+	meta = media(300)
+	if variants, _ := b.store.Variants(ctx, meta.Hash); len(variants) != 0 {
+		t.Fatalf("expected no variants, got %v", variants)
+	}
+	if queued := <-b.thumbnails; queued.Hash != meta.Hash {
+		t.Fatalf("expected the image to be queued, got %v", queued.Hash)
+	}
+}
@@ -16,8 +16,10 @@ import (
 	"github.com/caarlos0/env/v11"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/zapstore/relay/pkg/analytics"
+	"github.com/zapstore/relay/pkg/backup"
 	"github.com/zapstore/relay/pkg/blossom"
 	"github.com/zapstore/relay/pkg/dashboard"
+	"github.com/zapstore/relay/pkg/fetch"
 	"github.com/zapstore/relay/pkg/indexing"
 	"github.com/zapstore/relay/pkg/rate"
 	"github.com/zapstore/relay/pkg/relay"
@@ -29,11 +31,13 @@ var Version = "dev"
 type Config struct {
 	Sys       SystemConfig
 	Limiter   rate.Config
+	Fetch     fetch.Config
 	Analytics analytics.Config
 	Indexing  indexing.Config
 	Relay     relay.Config
 	Blossom   blossom.Config
 	Dashboard dashboard.Config
+	Backup    backup.Config
 }
 
 type SystemConfig struct {
@@ -125,11 +129,13 @@ func New() Config {
 	return Config{
 		Sys:       NewSystemConfig(),
 		Limiter:   rate.NewConfig(),
+		Fetch:     fetch.NewConfig(),
 		Analytics: analytics.NewConfig(),
 		Indexing:  indexing.NewConfig(),
 		Relay:     relay.NewConfig(),
 		Blossom:   blossom.NewConfig(),
 		Dashboard: dashboard.NewConfig(),
+		Backup:    backup.NewConfig(),
 	}
 }
 
@@ -140,6 +146,9 @@ func (c Config) Validate() error {
 	if err := c.Limiter.Validate(); err != nil {
 		return fmt.Errorf("rate: %w", err)
 	}
+	if err := c.Fetch.Validate(); err != nil {
+		return fmt.Errorf("fetch: %w", err)
+	}
 	if err := c.Analytics.Validate(); err != nil {
 		return fmt.Errorf("analytics: %w", err)
 	}
@@ -155,6 +164,15 @@ func (c Config) Validate() error {
 	if err := c.Dashboard.Validate(); err != nil {
 		return fmt.Errorf("dashboard: %w", err)
 	}
+	if err := c.Backup.Validate(); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	if c.Backup.BunnyPath != "" && c.Blossom.Backend != blossom.BackendBunny {
+		// backups are uploaded to bunny even when blobs are stored elsewhere
+		if err := c.Blossom.Bunny.Validate(); err != nil {
+			return fmt.Errorf("backup: bunny path requires a valid bunny config: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -164,6 +182,8 @@ func (c Config) String() string {
 	b.WriteByte('\n')
 	b.WriteString(c.Limiter.String())
 	b.WriteByte('\n')
+	b.WriteString(c.Fetch.String())
+	b.WriteByte('\n')
 	b.WriteString(c.Analytics.String())
 	b.WriteByte('\n')
 	b.WriteString(c.Indexing.String())
@@ -173,5 +193,7 @@ func (c Config) String() string {
 	b.WriteString(c.Blossom.String())
 	b.WriteByte('\n')
 	b.WriteString(c.Dashboard.String())
+	b.WriteByte('\n')
+	b.WriteString(c.Backup.String())
 	return b.String()
 }
@@ -107,10 +107,23 @@ func (d *T) relayPage(w http.ResponseWriter, r *http.Request) {
 }
 
 type blossomPageData struct {
-	Cards []CardData
-	Chart ChartData
+	Cards   []CardData
+	Chart   ChartData
+	GCCards []CardData
+	Usages  []UsageRow
 }
 
+// UsageRow is the storage used by a publisher, counted against its quota.
+type UsageRow struct {
+	Npub    string
+	Blobs   int64
+	MB      int64
+	Pending int64 // MB of the resumable uploads in progress
+}
+
+// usageLimit is the number of publishers shown in the usage table, from the largest.
+const usageLimit = 50
+
 func (d *T) blossomPage(w http.ResponseWriter, r *http.Request) {
 	if _, ok := d.authenticate(w, r); !ok {
 		return
@@ -150,6 +163,32 @@ func (d *T) blossomPage(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	marked, reclaimable, err := d.blossom.Reclaimable(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	usages, err := d.blossom.Usages(ctx, usageLimit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	publishers := make([]UsageRow, 0, len(usages))
+	for _, u := range usages {
+		npub, err := nip19.EncodePublicKey(u.Pubkey)
+		if err != nil {
+			npub = u.Pubkey
+		}
+		publishers = append(publishers, UsageRow{
+			Npub:    npub,
+			Blobs:   u.Blobs,
+			MB:      u.Bytes / 1_000_000,
+			Pending: u.Pending / 1_000_000,
+		})
+	}
+
 	data := blossomPageData{
 		Cards: []CardData{
 			{Label: "Checks", Value: totalChecks},
@@ -166,6 +205,11 @@ func (d *T) blossomPage(w http.ResponseWriter, r *http.Request) {
 				{Label: "Uploads", Data: uploads, BorderColor: "#a78bfa", BackgroundColor: "rgba(167,139,250,0.08)"},
 			},
 		},
+		GCCards: []CardData{
+			{Label: "Marked Blobs", Value: marked},
+			{Label: "Reclaimable MB", Value: reclaimable / 1_000_000},
+		},
+		Usages: publishers,
 	}
 
 	if err := d.template.ExecuteTemplate(w, "blossom", data); err != nil {
@@ -6,6 +6,7 @@ package events
 import (
 	"fmt"
 	"slices"
+	"strings"
 
 	"github.com/nbd-wtf/go-nostr"
 )
@@ -46,8 +47,22 @@ type App struct {
 	URL        string   // Website URL
 	Repository string   // Source code repository URL
 	License    string   // SPDX license ID
+
+	// Translated listings, keyed by lowercase language code (e.g. "es", "pt-br")
+	Locales map[string]Locale
+}
+
+// Locale holds the translated fields of an app listing in a specific language.
+// Fields that are not translated are empty, and clients should fall back to the default listing.
+type Locale struct {
+	Name    string
+	Summary string
+	Content string
 }
 
+// LocalizedFields are the app fields that can be translated with localized tags.
+var LocalizedFields = []string{"name", "summary", "content"}
+
 func (app App) Validate() error {
 	if app.D == "" {
 		return fmt.Errorf("missing or empty 'd' tag (app identifier)")
@@ -74,6 +89,13 @@ func ParseApp(event *nostr.Event) (App, error) {
 			continue
 		}
 
+		if field, lang, ok := localizedTag(tag); ok {
+			if err := app.setLocalized(field, lang, tag[1]); err != nil {
+				return App{}, err
+			}
+			continue
+		}
+
 		switch tag[0] {
 		case "d":
 			if app.D != "" {
@@ -129,6 +151,85 @@ func ParseApp(event *nostr.Event) (App, error) {
 	return app, nil
 }
 
+// localizedTag returns the field and language of a localized tag, which can be either in the form
+// ["<field>", "<value>", "<lang>"] or ["<field>:<lang>", "<value>"]. It returns false if the tag
+// is not a localized version of one of the [LocalizedFields]. Because other extra elements are allowed
+// in tags (e.g. markers), a third element is only a language if it's a valid language code.
+func localizedTag(tag nostr.Tag) (field, lang string, ok bool) {
+	field, lang, found := strings.Cut(tag[0], ":")
+	if !found {
+		if len(tag) < 3 {
+			return "", "", false
+		}
+		if _, err := NormalizeLanguage(tag[2]); err != nil {
+			return "", "", false
+		}
+		lang = tag[2]
+	}
+
+	if !slices.Contains(LocalizedFields, field) {
+		return "", "", false
+	}
+	return field, lang, true
+}
+
+// setLocalized sets the translated field of the app in the provided language.
+// Returns an error if the language code is invalid or the field was already translated.
+func (app *App) setLocalized(field, lang, value string) error {
+	lang, err := NormalizeLanguage(lang)
+	if err != nil {
+		return fmt.Errorf("invalid language in localized '%s' tag: %w", field, err)
+	}
+
+	if app.Locales == nil {
+		app.Locales = make(map[string]Locale)
+	}
+	locale := app.Locales[lang]
+
+	var target *string
+	switch field {
+	case "name":
+		target = &locale.Name
+	case "summary":
+		target = &locale.Summary
+	case "content":
+		target = &locale.Content
+	}
+
+	if *target != "" {
+		return fmt.Errorf("duplicate localized '%s' tag for language '%s'", field, lang)
+	}
+	*target = value
+	app.Locales[lang] = locale
+	return nil
+}
+
+// NormalizeLanguage validates a BCP 47 language code (e.g. "es", "pt-BR", "zh-Hant")
+// and returns it in lowercase, which is the form used for indexing and matching.
+func NormalizeLanguage(code string) (string, error) {
+	if code == "" {
+		return "", fmt.Errorf("language code is empty")
+	}
+
+	subtags := strings.Split(strings.ToLower(code), "-")
+	for i, sub := range subtags {
+		min, max := 2, 8
+		if i == 0 {
+			// the primary language subtag is an ISO 639 code
+			max = 3
+		}
+		if len(sub) < min || len(sub) > max {
+			return "", fmt.Errorf("invalid language code %q", code)
+		}
+		for _, c := range sub {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9' || i == 0) {
+				return "", fmt.Errorf("invalid language code %q", code)
+			}
+		}
+	}
+	return strings.Join(subtags, "-"), nil
+}
+
 // ValidateApp parses and validates a Software Application event.
 func ValidateApp(event *nostr.Event) error {
 	app, err := ParseApp(event)
@@ -54,6 +54,99 @@ func TestValidateApp_OnlyUnknownFTags_Rejected(t *testing.T) {
 	}
 }
 
+func TestParseApp_LocalizedTags(t *testing.T) {
+	event := &nostr.Event{
+		Kind:    KindApp,
+		Content: "A private messenger.",
+		Tags: nostr.Tags{
+			{"d", "com.example.app"},
+			{"name", "Messenger"},
+			{"name", "Mensajero", "es"},
+			{"summary", "Private messaging"},
+			{"summary:es", "Mensajería privada"},
+			{"content", "Un mensajero privado.", "ES"},
+			{"summary:pt-BR", "Mensagens privadas"},
+			{"f", "android-arm64-v8a"},
+		},
+	}
+
+	app, err := ParseApp(event)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	if app.Name != "Messenger" || app.Summary != "Private messaging" {
+		t.Errorf("default listing was overwritten: name %q, summary %q", app.Name, app.Summary)
+	}
+
+	want := map[string]Locale{
+		"es":    {Name: "Mensajero", Summary: "Mensajería privada", Content: "Un mensajero privado."},
+		"pt-br": {Summary: "Mensagens privadas"},
+	}
+	if len(app.Locales) != len(want) {
+		t.Fatalf("expected locales %v, got %v", want, app.Locales)
+	}
+	for lang, locale := range want {
+		if app.Locales[lang] != locale {
+			t.Errorf("locale %q: expected %+v, got %+v", lang, locale, app.Locales[lang])
+		}
+	}
+}
+
+func TestParseApp_ThirdElementNotLanguage(t *testing.T) {
+	event := &nostr.Event{
+		Kind:    KindApp,
+		Content: "A private messenger.",
+		Tags: nostr.Tags{
+			{"d", "com.example.app"},
+			{"name", "Foo", "marker"},
+			{"summary", "Private messaging", ""},
+			{"f", "android-arm64-v8a"},
+		},
+	}
+
+	app, err := ParseApp(event)
+	if err != nil {
+		t.Fatalf("unexpected parse error: %v", err)
+	}
+	if app.Name != "Foo" || app.Summary != "Private messaging" {
+		t.Errorf("expected name %q and summary %q, got %q and %q", "Foo", "Private messaging", app.Name, app.Summary)
+	}
+	if len(app.Locales) != 0 {
+		t.Errorf("expected no locales, got %v", app.Locales)
+	}
+}
+
+func TestParseApp_LocalizedTagsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  nostr.Tag
+		err  string
+	}{
+		{name: "invalid language", tag: nostr.Tag{"name:spanish", "Mensajero"}, err: "invalid language"},
+		{name: "empty language suffix", tag: nostr.Tag{"summary:", "Mensajería"}, err: "invalid language"},
+		{name: "duplicate translation", tag: nostr.Tag{"name:es", "Otro"}, err: "duplicate localized 'name' tag"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := &nostr.Event{
+				Kind: KindApp,
+				Tags: nostr.Tags{
+					{"d", "com.example.app"},
+					{"name", "Messenger"},
+					{"name", "Mensajero", "es"},
+					test.tag,
+				},
+			}
+
+			_, err := ParseApp(event)
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+}
+
 func TestParseAsset_UnknownFTagsIgnored(t *testing.T) {
 	event := &nostr.Event{
 		Kind: KindAsset,
@@ -0,0 +1,70 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+type Config struct {
+	// Schemes are the URL schemes that can be fetched, including after redirects. Default is "https".
+	Schemes []string `env:"FETCH_ALLOWED_SCHEMES"`
+
+	// MaxRedirects is the maximum number of redirects followed by a request. Default is 5.
+	MaxRedirects int `env:"FETCH_MAX_REDIRECTS"`
+
+	// MaxConnsPerHost is the maximum number of concurrent connections to a single host.
+	// Further requests to the host wait for a connection to be available. Default is 4.
+	MaxConnsPerHost int `env:"FETCH_MAX_CONNS_PER_HOST"`
+
+	// DialTimeout is the maximum duration for establishing a connection. Default is 10 seconds.
+	DialTimeout time.Duration `env:"FETCH_DIAL_TIMEOUT"`
+
+	// ResponseHeaderTimeout is the maximum duration to wait for the response headers
+	// after the request is sent. Default is 30 seconds.
+	ResponseHeaderTimeout time.Duration `env:"FETCH_RESPONSE_HEADER_TIMEOUT"`
+}
+
+func NewConfig() Config {
+	return Config{
+		Schemes:               []string{"https"},
+		MaxRedirects:          5,
+		MaxConnsPerHost:       4,
+		DialTimeout:           10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+	}
+}
+
+func (c Config) Validate() error {
+	if len(c.Schemes) == 0 {
+		return errors.New("allowed schemes must not be empty")
+	}
+	for _, scheme := range c.Schemes {
+		if scheme != "https" && scheme != "http" {
+			return fmt.Errorf("scheme %q is not supported", scheme)
+		}
+	}
+	if c.MaxRedirects < 0 {
+		return errors.New("max redirects must be non-negative")
+	}
+	if c.MaxConnsPerHost <= 0 {
+		return errors.New("max connections per host must be greater than 0")
+	}
+	if c.DialTimeout < time.Second {
+		return errors.New("dial timeout must be at least 1 second")
+	}
+	if c.ResponseHeaderTimeout < time.Second {
+		return errors.New("response header timeout must be at least 1 second")
+	}
+	return nil
+}
+
+func (c Config) String() string {
+	return fmt.Sprintf("Fetch:\n"+
+		"\tAllowed Schemes: %v\n"+
+		"\tMax Redirects: %d\n"+
+		"\tMax Connections Per Host: %d\n"+
+		"\tDial Timeout: %v\n"+
+		"\tResponse Header Timeout: %v\n",
+		c.Schemes, c.MaxRedirects, c.MaxConnsPerHost, c.DialTimeout, c.ResponseHeaderTimeout)
+}
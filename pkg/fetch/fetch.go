@@ -0,0 +1,122 @@
+// The fetch package provides the HTTP client used for every outbound request to user-supplied URLs,
+// such as asset URLs in events or blobs to mirror. It exposes a [NewClient] function that
+// creates a client with the given config.
+//
+// The client only connects to public IP addresses, which are checked after DNS resolution
+// to prevent DNS rebinding, so that users can't make the server probe its internal network
+// (e.g. the defender on localhost, or cloud metadata endpoints).
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"syscall"
+	"time"
+)
+
+var (
+	ErrForbiddenAddress = errors.New("address is not public")
+	ErrForbiddenScheme  = errors.New("scheme is not allowed")
+	ErrTooManyRedirects = errors.New("too many redirects")
+)
+
+// NewClient returns an http client that only connects to public IP addresses, with the URL schemes,
+// redirects and connections per host limited by the config. Requests have no overall timeout,
+// so they should be bounded by their context.
+func NewClient(c Config) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: c.DialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !IsPublicIP(ip) {
+				return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+			}
+			return nil
+		},
+	}
+
+	transport := &http.Transport{
+		Proxy:                 nil, // a proxy would connect on our behalf, bypassing the address check
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: c.ResponseHeaderTimeout,
+		MaxConnsPerHost:       c.MaxConnsPerHost,
+		MaxIdleConnsPerHost:   c.MaxConnsPerHost,
+		IdleConnTimeout:       time.Minute,
+	}
+
+	return &http.Client{
+		Transport: schemeChecker{schemes: c.Schemes, next: transport},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > c.MaxRedirects {
+				return fmt.Errorf("%w: stopped after %d", ErrTooManyRedirects, c.MaxRedirects)
+			}
+			return nil
+		},
+	}
+}
+
+// schemeChecker is an [http.RoundTripper] rejecting requests whose scheme is not allowed.
+// Because every redirect is a new round trip, redirects are also checked.
+type schemeChecker struct {
+	schemes []string
+	next    http.RoundTripper
+}
+
+func (s schemeChecker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !slices.Contains(s.schemes, req.URL.Scheme) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("%w: %q", ErrForbiddenScheme, req.URL.Scheme)
+	}
+	return s.next.RoundTrip(req)
+}
+
+var nonPublic = []*net.IPNet{
+	mustParseCIDR("100.64.0.0/10"), // carrier-grade NAT (RFC 6598), which includes some cloud metadata endpoints
+	mustParseCIDR("192.0.0.0/24"),  // IETF protocol assignments (RFC 6890)
+	mustParseCIDR("198.18.0.0/15"), // benchmarking (RFC 2544)
+	mustParseCIDR("240.0.0.0/4"),   // reserved, including the limited broadcast address
+}
+
+// nat64 is the well-known prefix of NAT64 (RFC 6052), which embeds an IPv4 address in the last 4 bytes.
+var nat64 = mustParseCIDR("64:ff9b::/96")
+
+// IsPublicIP returns whether the IP is a public unicast address, excluding loopback, private, link-local
+// (which includes cloud metadata endpoints like 169.254.169.254), multicast, unspecified and reserved addresses.
+func IsPublicIP(ip net.IP) bool {
+	switch {
+	case ip.IsLoopback(), ip.IsPrivate(), ip.IsUnspecified(),
+		ip.IsLinkLocalUnicast(), ip.IsLinkLocalMulticast(),
+		ip.IsInterfaceLocalMulticast(), ip.IsMulticast():
+		return false
+
+	case ip.To4() != nil && ip.To4()[0] == 0:
+		return false
+
+	case nat64.Contains(ip):
+		return IsPublicIP(ip[12:16])
+	}
+
+	for _, block := range nonPublic {
+		if block.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func mustParseCIDR(s string) *net.IPNet {
+	_, block, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return block
+}
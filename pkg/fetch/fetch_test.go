@@ -0,0 +1,114 @@
+package fetch
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"1.1.1.1", true},
+		{"140.82.112.3", true},
+		{"2606:4700:4700::1111", true},
+		{"64:ff9b::8c52:7003", true},
+		{"127.0.0.1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"100.100.100.200", false},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"192.0.0.1", false},
+		{"198.18.0.1", false},
+		{"224.0.0.1", false},
+		{"255.255.255.255", false},
+		{"::", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"fd00:ec2::254", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"64:ff9b::a9fe:a9fe", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			if public := IsPublicIP(net.ParseIP(test.ip)); public != test.public {
+				t.Errorf("expected IsPublicIP(%s) = %v, got %v", test.ip, test.public, public)
+			}
+		})
+	}
+}
+
+func TestClientRejectsPrivateAddresses(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("secret"))
+	}))
+	defer server.Close()
+
+	_, err := NewClient(NewConfig()).Get(server.URL)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Fatalf("expected error %v, got %v", ErrForbiddenAddress, err)
+	}
+}
+
+func TestClientRejectsSchemes(t *testing.T) {
+	tests := []struct {
+		url string
+	}{
+		{"http://example.com/app.apk"},
+		{"file:///etc/passwd"},
+		{"ftp://example.com/app.apk"},
+	}
+
+	client := NewClient(NewConfig())
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			if _, err := client.Get(test.url); !errors.Is(err, ErrForbiddenScheme) {
+				t.Errorf("expected error %v, got %v", ErrForbiddenScheme, err)
+			}
+		})
+	}
+}
+
+func TestSchemeCheckerOnRedirects(t *testing.T) {
+	hops := 0
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/loop":
+			hops++
+			http.Redirect(w, r, server.URL+"/loop", http.StatusFound)
+		case "/downgrade":
+			http.Redirect(w, r, "http://example.com/app.apk", http.StatusFound)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer server.Close()
+
+	// the test server is on loopback, so the transport of the test server is used instead
+	// of the address check, to exercise the scheme and redirect limits on their own.
+	config := NewConfig()
+	client := NewClient(config)
+	client.Transport = schemeChecker{schemes: config.Schemes, next: server.Client().Transport}
+
+	if _, err := client.Get(server.URL + "/downgrade"); !errors.Is(err, ErrForbiddenScheme) {
+		t.Errorf("expected error %v, got %v", ErrForbiddenScheme, err)
+	}
+
+	if _, err := client.Get(server.URL + "/loop"); !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("expected error %v, got %v", ErrTooManyRedirects, err)
+	}
+	if hops != config.MaxRedirects+1 {
+		t.Errorf("expected %d requests, got %d", config.MaxRedirects+1, hops)
+	}
+}
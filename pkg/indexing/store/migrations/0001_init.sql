@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS discovery_queue (
+    url             TEXT PRIMARY KEY,
+    request_count   INTEGER NOT NULL DEFAULT 1,
+    fail_count      INTEGER NOT NULL DEFAULT 0,
+    first_seen_at   INTEGER NOT NULL,
+    last_seen_at    INTEGER NOT NULL,
+    status          TEXT NOT NULL DEFAULT 'pending',
+    checked_at      INTEGER
+);
+
+CREATE INDEX IF NOT EXISTS idx_discovery_status ON discovery_queue(status);
+CREATE INDEX IF NOT EXISTS idx_discovery_request_count ON discovery_queue(request_count DESC);
+
+CREATE TABLE IF NOT EXISTS index_status (
+    app_id              TEXT PRIMARY KEY,
+    last_checked_at     INTEGER,
+    last_requested_at   INTEGER NOT NULL,
+    request_count       INTEGER NOT NULL DEFAULT 0,
+    window_start        INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_index_status_last_checked ON index_status(last_checked_at);
@@ -4,37 +4,23 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"embed"
 	"fmt"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/zapstore/relay/pkg/migrate"
 )
 
-const schema = `
-CREATE TABLE IF NOT EXISTS discovery_queue (
-    url             TEXT PRIMARY KEY,
-    request_count   INTEGER NOT NULL DEFAULT 1,
-    fail_count      INTEGER NOT NULL DEFAULT 0,
-    first_seen_at   INTEGER NOT NULL,
-    last_seen_at    INTEGER NOT NULL,
-    status          TEXT NOT NULL DEFAULT 'pending',
-    checked_at      INTEGER
-);
-
-CREATE INDEX IF NOT EXISTS idx_discovery_status ON discovery_queue(status);
-CREATE INDEX IF NOT EXISTS idx_discovery_request_count ON discovery_queue(request_count DESC);
-
-CREATE TABLE IF NOT EXISTS index_status (
-    app_id              TEXT PRIMARY KEY,
-    last_checked_at     INTEGER,
-    last_requested_at   INTEGER NOT NULL,
-    request_count       INTEGER NOT NULL DEFAULT 0,
-    window_start        INTEGER NOT NULL
-);
-
-CREATE INDEX IF NOT EXISTS idx_index_status_last_checked ON index_status(last_checked_at);
-`
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Migrations returns the schema migrations of the indexing database, in order.
+func Migrations() ([]migrate.Migration, error) {
+	return migrate.Load(migrations, "migrations")
+}
 
 // Store provides access to the indexing.db SQLite database.
 type Store struct {
@@ -51,15 +37,29 @@ func New(path string) (*Store, error) {
 		db.Close()
 		return nil, fmt.Errorf("indexing store: ping: %w", err)
 	}
-	if _, err := db.Exec(schema); err != nil {
+	if err := applyMigrations(db); err != nil {
 		db.Close()
 		return nil, fmt.Errorf("indexing store: migrate: %w", err)
 	}
-	// Additive migration for existing databases.
-	db.Exec(`ALTER TABLE discovery_queue ADD COLUMN fail_count INTEGER NOT NULL DEFAULT 0`)
 	return &Store{db: db}, nil
 }
 
+// applyMigrations brings databases created before versioned migrations up to the first migration,
+// then applies the pending schema migrations.
+func applyMigrations(db *sql.DB) error {
+	ctx := context.Background()
+	if err := migrate.AddColumn(ctx, db, "discovery_queue", "fail_count", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	_, err = migrate.Up(ctx, db, migrations)
+	return err
+}
+
 // Close closes the database connection.
 func (s *Store) Close() error {
 	return s.db.Close()
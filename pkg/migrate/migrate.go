@@ -0,0 +1,279 @@
+// Package migrate applies numbered, checksummed schema migrations to sqlite databases.
+//
+// Migrations are plain SQL files named "<version>_<name>.sql" (e.g. "0001_init.sql"),
+// usually embedded in the package owning the database. Each migration is applied in its own
+// transaction together with its row in the schema_version table, so a failed migration leaves
+// the database at the previous version. The checksum of every applied migration is recorded,
+// and [Up] refuses to run if an applied migration has since been modified.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrChecksumMismatch = errors.New("applied migration has been modified")
+	ErrUnknownVersion   = errors.New("database has a migration unknown to this binary")
+)
+
+const versionTable = `CREATE TABLE IF NOT EXISTS schema_version (
+	version    INTEGER PRIMARY KEY,
+	name       TEXT    NOT NULL,
+	checksum   TEXT    NOT NULL,    -- sha256 of the migration SQL, as hex
+	applied_at INTEGER NOT NULL     -- unix timestamp
+);`
+
+// Migration is a single numbered schema change.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Checksum returns the hex encoded sha256 of the migration SQL.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.SQL))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+// Load reads all the "<version>_<name>.sql" files in the given directory of fsys,
+// and returns them sorted by version. Versions must start at 1 and have no gaps.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || path.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		m, err := parseName(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+		m.SQL = string(data)
+		migrations = append(migrations, m)
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %s: expected version %d", m, i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// parseName parses a file name like "0002_add_blob_status.sql" into a migration without SQL.
+func parseName(file string) (Migration, error) {
+	base := strings.TrimSuffix(file, ".sql")
+	number, name, ok := strings.Cut(base, "_")
+	if !ok || name == "" {
+		return Migration{}, fmt.Errorf("invalid migration file name %q: expected <version>_<name>.sql", file)
+	}
+
+	version, err := strconv.Atoi(number)
+	if err != nil || version <= 0 {
+		return Migration{}, fmt.Errorf("invalid migration file name %q: version must be a positive integer", file)
+	}
+	return Migration{Version: version, Name: name}, nil
+}
+
+// Up applies all the migrations that have not been applied yet, in order, and returns them.
+// It returns [ErrChecksumMismatch] if an applied migration differs from its definition,
+// and [ErrUnknownVersion] if the database has been migrated by a newer binary.
+func Up(ctx context.Context, db *sql.DB, migrations []Migration) ([]Migration, error) {
+	if _, err := db.ExecContext(ctx, versionTable); err != nil {
+		return nil, fmt.Errorf("failed to create schema_version table: %w", err)
+	}
+
+	applied, err := appliedVersions(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if err := verify(applied, migrations); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		if err := apply(ctx, db, m); err != nil {
+			return done, err
+		}
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// apply runs the migration and records it in the schema_version table in a single transaction.
+func apply(ctx context.Context, db *sql.DB, m Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("migration %s: failed to begin transaction: %w", m, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return fmt.Errorf("migration %s: %w", m, err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO schema_version (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+		m.Version, m.Name, m.Checksum(), time.Now().Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("migration %s: failed to record version: %w", m, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("migration %s: failed to commit transaction: %w", m, err)
+	}
+	return nil
+}
+
+// verify returns an error if an applied migration is unknown or has a different checksum.
+func verify(applied map[int]State, migrations []Migration) error {
+	for version, state := range applied {
+		if version > len(migrations) {
+			return fmt.Errorf("%w: %04d_%s", ErrUnknownVersion, version, state.Name)
+		}
+		m := migrations[version-1]
+		if state.Checksum != m.Checksum() {
+			return fmt.Errorf("%w: %s", ErrChecksumMismatch, m)
+		}
+	}
+	return nil
+}
+
+// Status of a migration in a database.
+const (
+	Applied  = "applied"
+	Pending  = "pending"
+	Modified = "modified" // applied, but its definition has changed since
+	Unknown  = "unknown"  // applied, but not known to this binary
+)
+
+// State describes a migration and whether it has been applied to a database.
+type State struct {
+	Version   int
+	Name      string
+	Checksum  string
+	Status    string
+	AppliedAt time.Time // zero if pending
+}
+
+// Status returns the state of every known migration, plus any applied migration unknown to this binary.
+// It never modifies the database, so it can be used on a read-only connection.
+func Status(ctx context.Context, db *sql.DB, migrations []Migration) ([]State, error) {
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_version')`,
+	).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check schema_version table: %w", err)
+	}
+
+	applied := make(map[int]State)
+	if exists {
+		if applied, err = appliedVersions(ctx, db); err != nil {
+			return nil, err
+		}
+	}
+
+	states := make([]State, 0, len(migrations))
+	for _, m := range migrations {
+		state, ok := applied[m.Version]
+		switch {
+		case !ok:
+			state = State{Version: m.Version, Name: m.Name, Checksum: m.Checksum(), Status: Pending}
+		case state.Checksum != m.Checksum():
+			state.Status = Modified
+		default:
+			state.Status = Applied
+		}
+		states = append(states, state)
+		delete(applied, m.Version)
+	}
+
+	for _, state := range applied {
+		state.Status = Unknown
+		states = append(states, state)
+	}
+	slices.SortFunc(states, func(a, b State) int { return a.Version - b.Version })
+	return states, nil
+}
+
+// appliedVersions returns the migrations recorded in the schema_version table, by version.
+func appliedVersions(ctx context.Context, db *sql.DB) (map[int]State, error) {
+	rows, err := db.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM schema_version`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query schema_version: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]State)
+	for rows.Next() {
+		var state State
+		var appliedAt int64
+		if err := rows.Scan(&state.Version, &state.Name, &state.Checksum, &appliedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan schema_version: %w", err)
+		}
+		state.AppliedAt = time.Unix(appliedAt, 0).UTC()
+		applied[state.Version] = state
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query schema_version: %w", err)
+	}
+	return applied, nil
+}
+
+// AddColumn adds the column to the table if the table exists and doesn't have it yet.
+// It's meant for bringing databases created before versioned migrations up to their first version,
+// since sqlite has no "ADD COLUMN IF NOT EXISTS".
+func AddColumn(ctx context.Context, db *sql.DB, table, column, definition string) error {
+	var tables, columns int
+	err := db.QueryRowContext(ctx,
+		`SELECT
+			(SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?),
+			(SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?)`,
+		table, table, column,
+	).Scan(&tables, &columns)
+	if err != nil {
+		return fmt.Errorf("failed to inspect table %s: %w", table, err)
+	}
+	if tables == 0 || columns > 0 {
+		return nil
+	}
+
+	stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)
+	if _, err := db.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
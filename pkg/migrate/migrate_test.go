@@ -0,0 +1,244 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var ctx = context.Background()
+
+func newDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    fstest.MapFS
+		expected []Migration
+		isValid  bool
+	}{
+		{
+			name: "sorted by version",
+			files: fstest.MapFS{
+				"migrations/0002_add_index.sql": {Data: []byte("CREATE INDEX b ON a(x);")},
+				"migrations/0001_init.sql":      {Data: []byte("CREATE TABLE a (x);")},
+				"migrations/README.md":          {Data: []byte("ignored")},
+			},
+			expected: []Migration{
+				{Version: 1, Name: "init", SQL: "CREATE TABLE a (x);"},
+				{Version: 2, Name: "add_index", SQL: "CREATE INDEX b ON a(x);"},
+			},
+			isValid: true,
+		},
+		{
+			name: "gap in versions",
+			files: fstest.MapFS{
+				"migrations/0001_init.sql":      {Data: []byte("CREATE TABLE a (x);")},
+				"migrations/0003_add_index.sql": {Data: []byte("CREATE INDEX b ON a(x);")},
+			},
+		},
+		{
+			name: "duplicate version",
+			files: fstest.MapFS{
+				"migrations/0001_init.sql":  {Data: []byte("CREATE TABLE a (x);")},
+				"migrations/0001_other.sql": {Data: []byte("CREATE TABLE b (x);")},
+			},
+		},
+		{
+			name: "missing name",
+			files: fstest.MapFS{
+				"migrations/0001.sql": {Data: []byte("CREATE TABLE a (x);")},
+			},
+		},
+		{
+			name: "invalid version",
+			files: fstest.MapFS{
+				"migrations/init_tables.sql": {Data: []byte("CREATE TABLE a (x);")},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := Load(test.files, "migrations")
+			if (err == nil) != test.isValid {
+				t.Fatalf("Load() error = %v, isValid %v", err, test.isValid)
+			}
+			if test.isValid && !reflect.DeepEqual(migrations, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, migrations)
+			}
+		})
+	}
+}
+
+func TestUp(t *testing.T) {
+	db := newDB(t)
+	migrations := []Migration{
+		{Version: 1, Name: "init", SQL: "CREATE TABLE a (x INTEGER);"},
+		{Version: 2, Name: "add_column", SQL: "ALTER TABLE a ADD COLUMN y TEXT;"},
+	}
+
+	applied, err := Up(ctx, db, migrations[:1])
+	if err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if len(applied) != 1 {
+		t.Fatalf("expected 1 migration applied, got %d", len(applied))
+	}
+
+	applied, err = Up(ctx, db, migrations)
+	if err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if !reflect.DeepEqual(applied, migrations[1:]) {
+		t.Fatalf("expected %v applied, got %v", migrations[1:], applied)
+	}
+
+	applied, err = Up(ctx, db, migrations)
+	if err != nil {
+		t.Fatalf("Up() error = %v", err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("expected no migration applied, got %v", applied)
+	}
+
+	if _, err := db.Exec("INSERT INTO a (x, y) VALUES (1, 'one')"); err != nil {
+		t.Fatalf("expected migrated table: %v", err)
+	}
+}
+
+func TestUpRollback(t *testing.T) {
+	db := newDB(t)
+	migrations := []Migration{
+		{Version: 1, Name: "init", SQL: "CREATE TABLE a (x INTEGER);"},
+		{Version: 2, Name: "broken", SQL: "CREATE TABLE b (x INTEGER); INSERT INTO missing VALUES (1);"},
+	}
+
+	applied, err := Up(ctx, db, migrations)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+	if len(applied) != 1 {
+		t.Fatalf("expected 1 migration applied, got %d", len(applied))
+	}
+
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'b'").Scan(&tables); err != nil {
+		t.Fatalf("failed to query sqlite_master: %v", err)
+	}
+	if tables != 0 {
+		t.Fatal("expected the failed migration to be rolled back")
+	}
+
+	states, err := Status(ctx, db, migrations)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if states[1].Status != Pending {
+		t.Fatalf("expected failed migration to be pending, got %s", states[1].Status)
+	}
+}
+
+func TestUpVerify(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "init", SQL: "CREATE TABLE a (x INTEGER);"},
+		{Version: 2, Name: "add_index", SQL: "CREATE INDEX idx_a ON a(x);"},
+	}
+
+	tests := []struct {
+		name       string
+		migrations []Migration
+		err        error
+		status     []string
+	}{
+		{
+			name: "modified migration",
+			migrations: []Migration{
+				migrations[0],
+				{Version: 2, Name: "add_index", SQL: "CREATE INDEX idx_a ON a(x DESC);"},
+			},
+			err:    ErrChecksumMismatch,
+			status: []string{Applied, Modified},
+		},
+		{
+			name:       "unknown migration",
+			migrations: migrations[:1],
+			err:        ErrUnknownVersion,
+			status:     []string{Applied, Unknown},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newDB(t)
+			if _, err := Up(ctx, db, migrations); err != nil {
+				t.Fatalf("Up() error = %v", err)
+			}
+
+			_, err := Up(ctx, db, test.migrations)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			states, err := Status(ctx, db, test.migrations)
+			if err != nil {
+				t.Fatalf("Status() error = %v", err)
+			}
+
+			status := make([]string, len(states))
+			for i, s := range states {
+				status[i] = s.Status
+			}
+			if !reflect.DeepEqual(status, test.status) {
+				t.Errorf("expected status %v, got %v", test.status, status)
+			}
+		})
+	}
+}
+
+func TestStatusWithoutVersionTable(t *testing.T) {
+	db := newDB(t)
+	migrations := []Migration{{Version: 1, Name: "init", SQL: "CREATE TABLE a (x INTEGER);"}}
+
+	states, err := Status(ctx, db, migrations)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	if len(states) != 1 || states[0].Status != Pending {
+		t.Fatalf("expected one pending migration, got %v", states)
+	}
+}
+
+func TestAddColumn(t *testing.T) {
+	db := newDB(t)
+	if _, err := db.Exec("CREATE TABLE a (x INTEGER)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	for range 2 {
+		if err := AddColumn(ctx, db, "a", "y", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			t.Fatalf("AddColumn() error = %v", err)
+		}
+	}
+	if err := AddColumn(ctx, db, "missing", "y", "TEXT"); err != nil {
+		t.Fatalf("AddColumn() on a missing table error = %v", err)
+	}
+
+	if _, err := db.Exec("INSERT INTO a (x, y) VALUES (1, 'one')"); err != nil {
+		t.Fatalf("expected column to be added: %v", err)
+	}
+}
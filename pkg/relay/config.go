@@ -49,6 +49,17 @@ type Config struct {
 	// Default is 5 hours.
 	RemovePendingAfter time.Duration `env:"RELAY_REMOVE_PENDING_AFTER"`
 
+	// VerifyMaxSize is the maximum size in bytes of the content of an external asset URL that is downloaded
+	// to verify its hash, when the asset doesn't have a smaller "size" tag. Default is 1 GB.
+	VerifyMaxSize int64 `env:"RELAY_VERIFY_MAX_SIZE"`
+
+	// VerifyTimeout is the maximum duration of the download of an external asset URL. Default is 5 minutes.
+	VerifyTimeout time.Duration `env:"RELAY_VERIFY_TIMEOUT"`
+
+	// ReverifyInterval is the interval after which verified external asset URLs are checked again,
+	// flagging the ones whose content changed. Zero disables the re-verification. Default is 24 hours.
+	ReverifyInterval time.Duration `env:"RELAY_REVERIFY_INTERVAL"`
+
 	// Info contains the relay's metadata, such as name, description, and supported NIPs.
 	Info Info
 }
@@ -83,6 +94,9 @@ func NewConfig() Config {
 		},
 		ReconcileInterval:  1 * time.Minute,
 		RemovePendingAfter: 5 * time.Hour,
+		VerifyMaxSize:      1_000_000_000,
+		VerifyTimeout:      5 * time.Minute,
+		ReverifyInterval:   24 * time.Hour,
 	}
 }
 
@@ -117,6 +131,15 @@ func (c Config) Validate() error {
 	if c.ResponseLimit <= 0 {
 		return errors.New("response limit must be greater than 0")
 	}
+	if c.VerifyMaxSize <= 0 {
+		return errors.New("verify max size must be greater than 0")
+	}
+	if c.VerifyTimeout <= 0 {
+		return errors.New("verify timeout must be greater than 0")
+	}
+	if c.ReverifyInterval < 0 {
+		return errors.New("reverify interval must be non-negative")
+	}
 	if len(c.AllowedKinds) == 0 {
 		slog.Warn("relay allowed kinds is empty. No events will be accepted.")
 	}
@@ -193,7 +216,11 @@ func (c Config) String() string {
 		"\tMax REQ Filters: %d\n"+
 		"\tResponse Limit: %d\n"+
 		"\tAllowed Kinds: %v\n"+
+		"\tVerify Max Size: %d\n"+
+		"\tVerify Timeout: %v\n"+
+		"\tReverify Interval: %v\n"+
 		c.Info.String(),
 		c.Hostname, c.Address, c.QueueCapacity, c.MaxMessageBytes, c.MaxReqFilters, c.ResponseLimit, c.AllowedKinds,
+		c.VerifyMaxSize, c.VerifyTimeout, c.ReverifyInterval,
 	)
 }
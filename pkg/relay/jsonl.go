@@ -0,0 +1,161 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+// Export writes the events matching the kinds, authors, since and until of the filter to w as JSONL,
+// one event per line from the oldest to the newest. It returns the number of events written.
+func Export(ctx context.Context, db store.T, filter nostr.Filter, w io.Writer) (int, error) {
+	buf := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+
+	var count int
+	err := db.Stream(ctx, filter, func(event *nostr.Event) error {
+		if err := encoder.Encode(event); err != nil {
+			return fmt.Errorf("failed to encode event %s: %w", event.ID, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	if err := buf.Flush(); err != nil {
+		return count, fmt.Errorf("failed to write events: %w", err)
+	}
+	return count, nil
+}
+
+// ImportReport summarizes the result of an [Import].
+type ImportReport struct {
+	Saved    int         // events saved, or already present
+	Pending  int         // assets saved as pending, because their blob is not available yet
+	Rejected []Rejection // lines that were not imported, sorted by line number
+}
+
+// Rejection describes a line of the import that was not imported.
+type Rejection struct {
+	Line    int    // 1-based line number in the input
+	EventID string // empty if the line could not be parsed
+	Reason  string
+}
+
+// importLine is an event parsed from a line of the import.
+type importLine struct {
+	number int
+	event  nostr.Event
+}
+
+// Import reads events as JSONL from r and saves them in the database, applying the checks
+// events published to the relay go through, except for the rate limits and the defender: [KindNotAllowed],
+// ID and signature verification, [events.Validate], [NotAnchored], [AppOwnership] and [ContradictsBlob].
+// Assets whose blob is not available yet are saved as pending, and get promoted by the relay reconciliation loop once it is.
+//
+// Events are imported root kinds first, then the others from the oldest to the newest,
+// so that references between events in the same input are resolved regardless of the order of the lines.
+// The whole input is read in memory before any event is saved.
+func Import(ctx context.Context, config Config, db store.T, blssm Blossom, r io.Reader) (ImportReport, error) {
+	var report ImportReport
+	var lines []importLine
+
+	reader := bufio.NewReader(r)
+	for number := 1; ; number++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return report, fmt.Errorf("failed to read line %d: %w", number, err)
+		}
+
+		if len(bytes.TrimSpace(data)) > 0 {
+			var event nostr.Event
+			if err := json.Unmarshal(data, &event); err != nil {
+				report.Rejected = append(report.Rejected, Rejection{Line: number, Reason: fmt.Sprintf("invalid JSON: %v", err)})
+			} else {
+				lines = append(lines, importLine{number: number, event: event})
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	slices.SortStableFunc(lines, func(a, b importLine) int {
+		if rankA, rankB := importRank(a.event.Kind), importRank(b.event.Kind); rankA != rankB {
+			return rankA - rankB
+		}
+		return int(a.event.CreatedAt - b.event.CreatedAt)
+	})
+
+	relay := &T{config: config, store: db, blossom: blssm}
+	checks := eventChecks(config, db, blssm, nil)
+
+	for _, line := range lines {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		event := &line.event
+		if reason := relay.importEvent(ctx, checks, event, &report); reason != "" {
+			report.Rejected = append(report.Rejected, Rejection{Line: line.number, EventID: event.ID, Reason: reason})
+		}
+	}
+
+	slices.SortStableFunc(report.Rejected, func(a, b Rejection) int { return a.Line - b.Line })
+	return report, nil
+}
+
+// importEvent runs the checks on the event and saves it, updating the report.
+// It returns the reason the event was rejected, or an empty string if it was imported.
+func (r *T) importEvent(ctx context.Context, checks []func(rely.Client, *nostr.Event) error, event *nostr.Event, report *ImportReport) string {
+	for _, check := range checks {
+		if err := check(nil, event); err != nil {
+			return err.Error()
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	isPending, err := r.persist(ctx, event)
+	if err != nil {
+		return err.Error()
+	}
+
+	if isPending {
+		report.Pending++
+	} else {
+		report.Saved++
+	}
+	return ""
+}
+
+// importRank returns the position of the kind in the import order: root kinds first, then all others,
+// then profiles, which must be anchored by other events of the same pubkey, then deletions.
+func importRank(kind int) int {
+	switch {
+	case slices.Contains(RootKinds, kind):
+		return 0
+	case kind == events.KindProfile:
+		return 2
+	case kind == events.KindDeletion:
+		return 3
+	default:
+		return 1
+	}
+}
@@ -0,0 +1,241 @@
+package relay
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/blossom/apk"
+	"github.com/zapstore/relay/pkg/blossom/exe"
+	blossomstore "github.com/zapstore/relay/pkg/blossom/store"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+var ctx = context.Background()
+
+// mockBlossom has no blobs.
+type mockBlossom struct{}
+
+func (mockBlossom) Has(ctx context.Context, hash blossom.Hash) (bool, error) { return false, nil }
+func (mockBlossom) QueryManifest(ctx context.Context, hash blossom.Hash) (apk.Manifest, error) {
+	return apk.Manifest{}, blossomstore.ErrManifestNotFound
+}
+func (mockBlossom) QueryReport(ctx context.Context, hash blossom.Hash) (exe.Report, error) {
+	return exe.Report{}, blossomstore.ErrReportNotFound
+}
+
+func signed(t *testing.T, sk string, event nostr.Event) nostr.Event {
+	t.Helper()
+	if err := event.Sign(sk); err != nil {
+		t.Fatalf("failed to sign event: %v", err)
+	}
+	return event
+}
+
+func jsonl(t *testing.T, lines ...any) string {
+	t.Helper()
+	var b strings.Builder
+	for _, line := range lines {
+		if s, ok := line.(string); ok {
+			b.WriteString(s + "\n")
+			continue
+		}
+		data, err := json.Marshal(line)
+		if err != nil {
+			t.Fatalf("failed to marshal line: %v", err)
+		}
+		b.Write(data)
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+func TestImport(t *testing.T) {
+	db, err := store.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+
+	app := signed(t, sk, nostr.Event{
+		Kind:      events.KindApp,
+		CreatedAt: 1000,
+		Tags: nostr.Tags{
+			{"d", "com.example.app"},
+			{"name", "Example"},
+			{"f", "android-arm64-v8a"},
+		},
+	})
+
+	// the comment is before the app it references, and must be imported after it
+	comment := signed(t, sk, nostr.Event{
+		Kind:      events.KindComment,
+		CreatedAt: 1001,
+		Tags:      nostr.Tags{{"A", "32267:" + pk + ":com.example.app"}},
+		Content:   "great app",
+	})
+
+	asset := signed(t, sk, nostr.Event{
+		Kind:      events.KindAsset,
+		CreatedAt: 1002,
+		Tags: nostr.Tags{
+			{"i", "com.example.app"},
+			{"x", strings.Repeat("a", 64)},
+			{"version", "1.0.0"},
+			{"f", "linux-x86_64"},
+		},
+	})
+
+	tampered := signed(t, sk, nostr.Event{Kind: events.KindApp, CreatedAt: 1003, Tags: nostr.Tags{{"d", "com.other.app"}}})
+	tampered.Content = "tampered"
+
+	invalid := signed(t, sk, nostr.Event{
+		Kind:      events.KindApp,
+		CreatedAt: 1004,
+		Tags:      nostr.Tags{{"d", "com.invalid.app"}},
+	})
+
+	orphan := signed(t, sk, nostr.Event{
+		Kind:      events.KindComment,
+		CreatedAt: 1005,
+		Tags:      nostr.Tags{{"A", "32267:" + pk + ":com.missing.app"}},
+	})
+
+	input := jsonl(t, comment, "", app, asset, "{not json", tampered, invalid, orphan)
+
+	config := NewConfig()
+	report, err := Import(ctx, config, db, mockBlossom{}, strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	if report.Saved != 2 {
+		t.Errorf("expected 2 saved events, got %d", report.Saved)
+	}
+	if report.Pending != 1 {
+		t.Errorf("expected 1 pending asset, got %d", report.Pending)
+	}
+
+	var lines []int
+	for _, r := range report.Rejected {
+		lines = append(lines, r.Line)
+	}
+	if expected := []int{5, 6, 7, 8}; !slices.Equal(lines, expected) {
+		t.Fatalf("expected rejected lines %v, got %v (%+v)", expected, lines, report.Rejected)
+	}
+
+	pending, err := db.QueryPending(ctx, events.KindAsset)
+	if err != nil {
+		t.Fatalf("failed to query pending: %v", err)
+	}
+	if len(pending) != 1 || pending[0].ID != asset.ID {
+		t.Fatalf("expected the asset to be pending, got %v", pending)
+	}
+
+	var exported bytes.Buffer
+	count, err := Export(ctx, db, nostr.Filter{Authors: []string{pk}}, &exported)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	if count != 2 {
+		t.Fatalf("expected 2 exported events, got %d", count)
+	}
+	if expected := jsonl(t, app, comment); exported.String() != expected {
+		t.Errorf("expected export\n%s\ngot\n%s", expected, exported.String())
+	}
+}
+
+// manifestBlossom has the APKs of the manifests.
+type manifestBlossom map[blossom.Hash]apk.Manifest
+
+func (m manifestBlossom) Has(ctx context.Context, hash blossom.Hash) (bool, error) {
+	_, ok := m[hash]
+	return ok, nil
+}
+func (m manifestBlossom) QueryManifest(ctx context.Context, hash blossom.Hash) (apk.Manifest, error) {
+	manifest, ok := m[hash]
+	if !ok {
+		return apk.Manifest{}, blossomstore.ErrManifestNotFound
+	}
+	return manifest, nil
+}
+func (manifestBlossom) QueryReport(ctx context.Context, hash blossom.Hash) (exe.Report, error) {
+	return exe.Report{}, blossomstore.ErrReportNotFound
+}
+
+func TestImportChecks(t *testing.T) {
+	db, err := store.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	sk := nostr.GeneratePrivateKey()
+	hash := blossom.ComputeHash([]byte("apk"))
+	blssm := manifestBlossom{hash: {Package: "com.example.app", VersionCode: 42}}
+
+	app := signed(t, sk, nostr.Event{
+		Kind:      events.KindApp,
+		CreatedAt: 1000,
+		Tags: nostr.Tags{
+			{"d", "com.example.app"},
+			{"name", "Example"},
+			{"f", "android-arm64-v8a"},
+		},
+	})
+
+	asset := func(versionCode string, createdAt nostr.Timestamp) nostr.Event {
+		return signed(t, sk, nostr.Event{
+			Kind:      events.KindAsset,
+			CreatedAt: createdAt,
+			Tags: nostr.Tags{
+				{"i", "com.example.app"},
+				{"x", hash.Hex()},
+				{"version", "1.0.0"},
+				{"version_code", versionCode},
+				{"apk_certificate_hash", strings.Repeat("ab", 32)},
+				{"f", "android-arm64-v8a"},
+			},
+		})
+	}
+
+	valid := asset("42", 1001)
+	contradicting := asset("41", 1002)
+	disallowed := signed(t, sk, nostr.Event{Kind: 1, CreatedAt: 1003, Content: "hello"})
+
+	input := jsonl(t, app, valid, contradicting, disallowed)
+
+	config := NewConfig()
+	config.AllowedKinds = []int{events.KindApp, events.KindAsset}
+	report, err := Import(ctx, config, db, blssm, strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	if report.Saved != 2 {
+		t.Errorf("expected 2 saved events, got %d (%+v)", report.Saved, report.Rejected)
+	}
+
+	rejected := make(map[string]string)
+	for _, r := range report.Rejected {
+		rejected[r.EventID] = r.Reason
+	}
+	if len(rejected) != 2 {
+		t.Fatalf("expected 2 rejected events, got %+v", report.Rejected)
+	}
+	if reason, ok := rejected[contradicting.ID]; !ok || !strings.Contains(reason, "version") {
+		t.Errorf("expected the asset contradicting the manifest to be rejected, got %q", reason)
+	}
+	if _, ok := rejected[disallowed.ID]; !ok {
+		t.Errorf("expected the event of a disallowed kind to be rejected")
+	}
+}
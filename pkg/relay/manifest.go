@@ -0,0 +1,165 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/pippellia-btc/rely/v2"
+	"github.com/zapstore/relay/pkg/blossom/apk"
+	"github.com/zapstore/relay/pkg/blossom/exe"
+	blossomstore "github.com/zapstore/relay/pkg/blossom/store"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+var ErrBlobMismatch = errors.New("asset contradicts the uploaded blob")
+
+// ContradictsBlob rejects assets whose tags contradict the manifest of the APK or the binaries they reference,
+// if the blob has been uploaded and inspected already. Assets whose blob is uploaded later are checked by reconcile.
+func ContradictsBlob(blssm Blossom) func(_ rely.Client, e *nostr.Event) error {
+	return func(_ rely.Client, e *nostr.Event) error {
+		if e.Kind != events.KindAsset {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		err := checkBlob(ctx, blssm, e)
+		if err != nil && !errors.Is(err, ErrBlobMismatch) {
+			slog.Error("ContradictsBlob: failed to check asset", "error", err, "event", e.ID)
+			return ErrInternal
+		}
+		return err
+	}
+}
+
+// checkBlob returns an error wrapping [ErrBlobMismatch] if the asset contradicts the manifest of the APK
+// or the binaries of the blob referenced by its "x" tag. Assets whose blob was not inspected are not checked.
+func checkBlob(ctx context.Context, blssm Blossom, asset *nostr.Event) error {
+	parsed, err := events.ParseAsset(asset)
+	if err != nil {
+		return fmt.Errorf("failed to parse asset: %w", err)
+	}
+
+	hash, err := blossom.ParseHash(parsed.Hash)
+	if err != nil {
+		return fmt.Errorf("invalid x tag: %w", err)
+	}
+
+	manifest, err := blssm.QueryManifest(ctx, hash)
+	if err == nil {
+		return contradictions(parsed, manifest)
+	}
+	if !errors.Is(err, blossomstore.ErrManifestNotFound) {
+		return err
+	}
+
+	report, err := blssm.QueryReport(ctx, hash)
+	if errors.Is(err, blossomstore.ErrReportNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return binaryContradictions(parsed, report)
+}
+
+// contradictions returns an error wrapping [ErrBlobMismatch] listing the tags of the asset
+// that contradict the manifest of its APK.
+func contradictions(asset events.Asset, manifest apk.Manifest) error {
+	var problems []string
+	if asset.I != manifest.Package {
+		problems = append(problems, fmt.Sprintf("'i' tag is %q but the package is %q", asset.I, manifest.Package))
+	}
+
+	if asset.VersionCode != "" {
+		code, err := strconv.ParseInt(asset.VersionCode, 10, 64)
+		if err != nil || code != manifest.VersionCode {
+			problems = append(problems, fmt.Sprintf("'version_code' tag is %q but the versionCode is %d", asset.VersionCode, manifest.VersionCode))
+		}
+	}
+
+	if asset.MinPlatformVersion != "" {
+		sdk, err := strconv.Atoi(asset.MinPlatformVersion)
+		if err != nil || sdk != manifest.MinSDK {
+			problems = append(problems, fmt.Sprintf("'min_platform_version' tag is %q but the minSdkVersion is %d", asset.MinPlatformVersion, manifest.MinSDK))
+		}
+	}
+
+	for _, platform := range asset.Platforms {
+		if !manifest.Supports(platform) {
+			problems = append(problems, fmt.Sprintf("'f' tag %q is not supported by the APK (native ABIs: %s)", platform, abis(manifest)))
+		}
+	}
+
+	// manifests of APKs inspected before signatures were verified have no certificates
+	if len(manifest.Certificates) > 0 {
+		for _, hash := range asset.APKCertificateHashes {
+			if !slices.Contains(manifest.Certificates, normalizeCertificate(hash)) {
+				problems = append(problems, fmt.Sprintf("'apk_certificate_hash' tag %q doesn't match the signer of the APK (%s)",
+					hash, strings.Join(manifest.Certificates, ", ")))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrBlobMismatch, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// binaryContradictions returns an error wrapping [ErrBlobMismatch] listing the tags of the asset
+// that contradict the binaries of its blob, or the file list of its archive.
+func binaryContradictions(asset events.Asset, report exe.Report) error {
+	var problems []string
+
+	// archives of scripts or bytecode have no binaries, and run wherever their interpreter does
+	if len(report.Binaries) > 0 {
+		platforms := report.Platforms()
+		for _, platform := range asset.Platforms {
+			if !slices.Contains(platforms, platform) {
+				problems = append(problems, fmt.Sprintf("'f' tag %q doesn't match the binaries (platforms: %s)", platform, strings.Join(platforms, ", ")))
+			}
+		}
+	}
+
+	if report.IsArchive() {
+		for _, executable := range asset.Executables {
+			re, err := regexp.Compile("^(?:" + executable + ")$")
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("'executable' tag %q is not a valid regex", executable))
+				continue
+			}
+			if !slices.ContainsFunc(report.Files, re.MatchString) {
+				problems = append(problems, fmt.Sprintf("'executable' tag %q matches no file of the archive", executable))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrBlobMismatch, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// normalizeCertificate returns the certificate hash as lowercase hexadecimal,
+// removing the colons of the fingerprint format printed by keytool and apksigner.
+func normalizeCertificate(hash string) string {
+	return strings.ToLower(strings.ReplaceAll(hash, ":", ""))
+}
+
+func abis(m apk.Manifest) string {
+	if len(m.ABIs) == 0 {
+		return "none"
+	}
+	return strings.Join(m.ABIs, ", ")
+}
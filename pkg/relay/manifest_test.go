@@ -0,0 +1,115 @@
+package relay
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/zapstore/relay/pkg/blossom/apk"
+	"github.com/zapstore/relay/pkg/blossom/exe"
+	"github.com/zapstore/relay/pkg/events"
+)
+
+func TestContradictions(t *testing.T) {
+	manifest := apk.Manifest{
+		Package:     "com.example.app",
+		VersionCode: 42,
+		MinSDK:      24,
+		ABIs:        []string{"arm64-v8a", "armeabi-v7a"},
+		Certificates: []string{
+			"a40da80a59d170caa950cf15c18c454d47a39b26989d8b640ecd745ba71bf5dc",
+			"0f1e2d3c4b5a69788796a5b4c3d2e1f00f1e2d3c4b5a69788796a5b4c3d2e1f0",
+		},
+	}
+
+	valid := events.Asset{
+		I:                    "com.example.app",
+		VersionCode:          "42",
+		MinPlatformVersion:   "24",
+		Platforms:            []string{"android-arm64-v8a", "android-armeabi-v7a"},
+		APKCertificateHashes: []string{"a40da80a59d170caa950cf15c18c454d47a39b26989d8b640ecd745ba71bf5dc"},
+	}
+
+	tests := []struct {
+		name   string
+		modify func(a *events.Asset)
+		err    error
+	}{
+		{name: "valid", modify: func(a *events.Asset) {}},
+		{name: "no optional tags", modify: func(a *events.Asset) { a.MinPlatformVersion = "" }},
+		{name: "subset of ABIs", modify: func(a *events.Asset) { a.Platforms = []string{"android-arm64-v8a"} }},
+		{name: "wrong package", modify: func(a *events.Asset) { a.I = "com.example.other" }, err: ErrBlobMismatch},
+		{name: "wrong version code", modify: func(a *events.Asset) { a.VersionCode = "41" }, err: ErrBlobMismatch},
+		{name: "invalid version code", modify: func(a *events.Asset) { a.VersionCode = "v42" }, err: ErrBlobMismatch},
+		{name: "wrong min sdk", modify: func(a *events.Asset) { a.MinPlatformVersion = "21" }, err: ErrBlobMismatch},
+		{name: "unsupported ABI", modify: func(a *events.Asset) { a.Platforms = []string{"android-x86_64"} }, err: ErrBlobMismatch},
+		{name: "not android", modify: func(a *events.Asset) { a.Platforms = []string{"linux-x86_64"} }, err: ErrBlobMismatch},
+		{name: "fingerprint format", modify: func(a *events.Asset) {
+			a.APKCertificateHashes = []string{"A4:0D:A8:0A:59:D1:70:CA:A9:50:CF:15:C1:8C:45:4D:47:A3:9B:26:98:9D:8B:64:0E:CD:74:5B:A7:1B:F5:DC"}
+		}},
+		{name: "wrong certificate", modify: func(a *events.Asset) {
+			a.APKCertificateHashes = []string{"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"}
+		}, err: ErrBlobMismatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			asset := valid
+			test.modify(&asset)
+			if err := contradictions(asset, manifest); !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+
+	// an APK without native code runs on every Android platform
+	asset := valid
+	asset.Platforms = []string{"android-x86", "android-x86_64"}
+	if err := contradictions(asset, apk.Manifest{Package: "com.example.app", VersionCode: 42, MinSDK: 24}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func TestBinaryContradictions(t *testing.T) {
+	report := exe.Report{
+		Format: exe.FormatTar,
+		Binaries: []exe.Binary{
+			{Path: "app-x86_64/bin/app", OS: "linux", Arch: "x86_64"},
+			{Path: "app-aarch64/bin/app", OS: "linux", Arch: "aarch64"},
+		},
+		Files: []string{"app-x86_64/bin/app", "app-aarch64/bin/app", "README.md"},
+	}
+
+	valid := events.Asset{
+		Platforms:   []string{"linux-x86_64", "linux-aarch64"},
+		Executables: []string{`app-x86_64/bin/app`, `app-aarch64/bin/.*`},
+	}
+
+	tests := []struct {
+		name   string
+		report exe.Report
+		modify func(a *events.Asset)
+		err    error
+	}{
+		{name: "valid", report: report, modify: func(a *events.Asset) {}},
+		{name: "subset of platforms", report: report, modify: func(a *events.Asset) { a.Platforms = []string{"linux-x86_64"} }},
+		{name: "no binaries", report: exe.Report{Format: exe.FormatZip, Files: []string{"app.py"}}, modify: func(a *events.Asset) {
+			a.Executables = []string{`app\.py`}
+		}},
+		{name: "bare binary", report: exe.Report{Format: exe.FormatELF, Binaries: []exe.Binary{{OS: "linux", Arch: "x86_64"}}}, modify: func(a *events.Asset) {
+			a.Platforms = []string{"linux-x86_64"}
+		}},
+		{name: "wrong platform", report: report, modify: func(a *events.Asset) { a.Platforms = []string{"darwin-arm64"} }, err: ErrBlobMismatch},
+		{name: "missing executable", report: report, modify: func(a *events.Asset) { a.Executables = []string{`bin/app`} }, err: ErrBlobMismatch},
+		{name: "invalid executable", report: report, modify: func(a *events.Asset) { a.Executables = []string{`bin/(app`} }, err: ErrBlobMismatch},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			asset := valid
+			test.modify(&asset)
+			if err := binaryContradictions(asset, test.report); !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"slices"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -19,6 +20,8 @@ import (
 	defender "github.com/zapstore/defender/pkg/client"
 	"github.com/zapstore/defender/pkg/models"
 	"github.com/zapstore/relay/pkg/analytics"
+	"github.com/zapstore/relay/pkg/blossom/apk"
+	"github.com/zapstore/relay/pkg/blossom/exe"
 	"github.com/zapstore/relay/pkg/events"
 	"github.com/zapstore/relay/pkg/events/legacy"
 	"github.com/zapstore/relay/pkg/indexing"
@@ -70,8 +73,15 @@ type T struct {
 	analytics *analytics.Engine
 	indexing  *indexing.Engine
 
-	blossom Blossom
-	uploads chan upload
+	blossom  Blossom
+	uploads  chan upload
+	verified chan struct{} // signals the reconcile goroutine that external URLs have been verified
+	fetcher  *http.Client  // SSRF-safe client for user-supplied URLs
+
+	// unverified tracks when external URLs (and their hash) last failed verification,
+	// to avoid downloading them again on every verification run.
+	mu         sync.Mutex
+	unverified map[string]time.Time
 }
 
 type upload struct {
@@ -83,6 +93,14 @@ type upload struct {
 type Blossom interface {
 	// Has returns whether a hash exists in the blossom database.
 	Has(ctx context.Context, hash blossom.Hash) (bool, error)
+
+	// QueryManifest returns the manifest of the APK with the hash,
+	// or the ErrManifestNotFound of the blossom store if the blob is not an inspected APK.
+	QueryManifest(ctx context.Context, hash blossom.Hash) (apk.Manifest, error)
+
+	// QueryReport returns the binary report of the blob with the hash,
+	// or the ErrReportNotFound of the blossom store if the blob is not an inspected binary or archive.
+	QueryReport(ctx context.Context, hash blossom.Hash) (exe.Report, error)
 }
 
 // Setup creates a new relay instance with the given dependencies and configuration.
@@ -95,6 +113,7 @@ func Setup(
 	blssm Blossom,
 	analytics *analytics.Engine,
 	indexing *indexing.Engine,
+	fetcher *http.Client,
 ) (*T, error) {
 
 	server := rely.NewRelay(
@@ -112,16 +131,8 @@ func Setup(
 	)
 
 	server.Reject.Event.Clear()
-	server.Reject.Event.Append(
-		RateEventIP(limiter),
-		KindNotAllowed(config.AllowedKinds),
-		rely.InvalidID,
-		rely.InvalidSignature,
-		InvalidStructure,
-		NotAnchored(store),
-		NotAllowed(defender),
-		AppOwnership(store, config.Info.Pubkey),
-	)
+	server.Reject.Event.Append(RateEventIP(limiter))
+	server.Reject.Event.Append(eventChecks(config, store, blssm, NotAllowed(defender))...)
 
 	server.Reject.Req.Clear()
 	server.Reject.Req.Append(
@@ -141,8 +152,12 @@ func Setup(
 		analytics: analytics,
 		indexing:  indexing,
 
-		blossom: blssm,
-		uploads: make(chan upload, 100),
+		blossom:  blssm,
+		uploads:  make(chan upload, 100),
+		verified: make(chan struct{}, 1),
+		fetcher:  fetcher,
+
+		unverified: make(map[string]time.Time),
 	}
 
 	server.On.Event = relay.save
@@ -153,7 +168,11 @@ func Setup(
 // StartAndServe starts the relay, listens to the provided address and handles http requests.
 func (r *T) StartAndServe(ctx context.Context, addr string) error {
 	go r.runReconcile(ctx)
+	go r.runVerify(ctx)
 	go r.runStater(ctx)
+	if r.config.ReverifyInterval > 0 {
+		go r.runReverify(ctx)
+	}
 	return r.server.StartAndServe(ctx, addr)
 }
 
@@ -183,6 +202,26 @@ func (r *T) ResolveAssetURL(ctx context.Context, hash blossom.Hash) (string, err
 	return url, nil
 }
 
+// AssetsReferencing returns the saved kind 3063 assets referencing the SHA-256 hash in their "x" tag.
+// Pending assets are not returned.
+func (r *T) AssetsReferencing(ctx context.Context, hash blossom.Hash) ([]nostr.Event, error) {
+	filter := nostr.Filter{
+		Kinds: []int{events.KindAsset},
+		Tags:  nostr.TagMap{"x": []string{hash.Hex()}},
+		Limit: 100,
+	}
+	assets, err := r.store.Query(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query assets referencing %s: %w", hash.Hex(), err)
+	}
+	return assets, nil
+}
+
+// ExternalAssets returns the saved kind 3063 assets with at least one "url" tag.
+func (r *T) ExternalAssets(ctx context.Context) ([]nostr.Event, error) {
+	return r.store.ExternalAssets(ctx)
+}
+
 // TODO: this logs stats. Remove when done debugging
 func (r *T) runStater(ctx context.Context) {
 	ticker := time.NewTicker(10 * time.Minute)
@@ -216,6 +255,12 @@ func (r *T) runReconcile(ctx context.Context) {
 				slog.Error("reconcile failed", "error", err)
 			}
 
+		case <-r.verified:
+			err := r.reconcile(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("reconcile failed", "error", err)
+			}
+
 		case u := <-r.uploads:
 			if u.mime == "application/vnd.android.package-archive" {
 				// because assets are supposed to reference APKs in their "x" tags,
@@ -230,7 +275,8 @@ func (r *T) runReconcile(ctx context.Context) {
 }
 
 // reconcile is responsible for checking whether to promote pending events to normal events
-// so they can be served in queries.
+// so they can be served in queries. External URLs are not downloaded here, but by the runVerify loop,
+// so that a slow URL doesn't block the reconciliation of uploaded blobs.
 func (r *T) reconcile(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -243,13 +289,26 @@ func (r *T) reconcile(ctx context.Context) error {
 	errs := make([]error, 0, len(assets))
 	for _, asset := range assets {
 
-		ready, err := isAssetReady(ctx, r.blossom, &asset)
+		ready, err := r.isAssetReady(ctx, &asset, false)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("failed to check if asset is ready: %w", err))
 			continue
 		}
 
 		if ready {
+			if err := checkBlob(ctx, r.blossom, &asset); err != nil {
+				if !errors.Is(err, ErrBlobMismatch) {
+					errs = append(errs, fmt.Errorf("failed to check event %s against its blob: %w", asset.ID, err))
+					continue
+				}
+
+				slog.Info("reconcile: discarding pending asset that contradicts its blob", "id", asset.ID, "reason", err)
+				if err := r.store.DeletePending(ctx, asset.ID); err != nil {
+					errs = append(errs, fmt.Errorf("failed to delete pending event %s: %w", asset.ID, err))
+				}
+				continue
+			}
+
 			if _, err := r.store.Save(ctx, &asset); err != nil {
 				errs = append(errs, fmt.Errorf("failed to save event %s: %w", asset.ID, err))
 				continue
@@ -278,38 +337,42 @@ func (r *T) save(c rely.Client, event *nostr.Event) rely.EventResult {
 
 	r.analytics.RecordEvent(c, event)
 
+	isPending, err := r.persist(ctx, event)
+	if err != nil {
+		slog.Error("relay: failed to save event", "event", event.ID, "kind", event.Kind, "error", err)
+		return rely.Fail(err.Error())
+	}
+
+	if isPending {
+		// avoid broadcasting the event until it is fully saved
+		return rely.Success().NoBroadcast().WithReply("the event will be saved when the referenced blob is uploaded or its url is verified")
+	}
+	return rely.Success()
+}
+
+// persist saves the event in the store according to its kind, handling deletions and pending assets.
+// The event is assumed to have passed the Reject.Event checks.
+func (r *T) persist(ctx context.Context, event *nostr.Event) (isPending bool, err error) {
 	switch {
 	case event.Kind == nostr.KindDeletion:
 		if err := r.handleDelete(ctx, event); err != nil {
-			slog.Error("relay: failed to fullfil delete", "event", event.ID, "error", err)
-			return rely.Fail(err.Error())
+			return false, fmt.Errorf("failed to fullfil delete: %w", err)
 		}
 
 	case event.Kind == events.KindAsset:
-		isPending, err := r.saveAsset(ctx, event)
-		if err != nil {
-			slog.Error("relay: failed to save asset event", "event", event.ID, "error", err)
-			return rely.Fail(err.Error())
-		}
-
-		if isPending {
-			// avoid broadcasting the event until it is fully saved
-			return rely.Success().NoBroadcast().WithReply("the event will be saved when the referenced blob is uploaded")
-		}
+		return r.saveAsset(ctx, event)
 
 	case nostr.IsRegularKind(event.Kind):
 		if _, err := r.store.Save(ctx, event); err != nil {
-			slog.Error("relay: failed to save regular event", "event", event.ID, "error", err)
-			return rely.Fail(err.Error())
+			return false, fmt.Errorf("failed to save regular event: %w", err)
 		}
 
 	case nostr.IsReplaceableKind(event.Kind) || nostr.IsAddressableKind(event.Kind):
 		if _, err := r.store.Replace(ctx, event); err != nil {
-			slog.Error("relay: failed to replace event", "event", event.ID, "error", err)
-			return rely.Fail(err.Error())
+			return false, fmt.Errorf("failed to replace event: %w", err)
 		}
 	}
-	return rely.Success()
+	return false, nil
 }
 
 // handleDelete handles deletion events, either from the operator or a regular NIP-09 deletion.
@@ -355,7 +418,8 @@ func (r *T) saveAsset(ctx context.Context, event *nostr.Event) (isPending bool,
 		return false, errors.New("event is not an asset")
 	}
 
-	ready, err := isAssetReady(ctx, r.blossom, event)
+	// downloading external URLs would take too long for the publisher, so they are verified by runVerify
+	ready, err := r.isAssetReady(ctx, event, false)
 	if err != nil {
 		return false, fmt.Errorf("failed to check if asset is ready: %w", err)
 	}
@@ -374,18 +438,11 @@ func (r *T) saveAsset(ctx context.Context, event *nostr.Event) (isPending bool,
 	return true, nil
 }
 
-// assetChecker is used exclusively for HEAD requests in isAssetReady.
-var assetChecker = &http.Client{
-	Timeout: 10 * time.Second,
-	Transport: &http.Transport{
-		MaxIdleConnsPerHost: 4,
-		IdleConnTimeout:     30 * time.Second,
-	},
-}
-
 // isAssetReady returns whether the asset's blob has been correctly uploaded.
-// It first checks the local blossom database, and then falls back to checking all "url" tags in the event.
-func isAssetReady(ctx context.Context, b Blossom, asset *nostr.Event) (bool, error) {
+// It first checks the local blossom database, and then falls back to the "url" tags in the event,
+// which are ready if their content has been verified to match the "x" hash.
+// If verify is true, unverified URLs are downloaded and verified, otherwise they are skipped.
+func (r *T) isAssetReady(ctx context.Context, asset *nostr.Event, verify bool) (bool, error) {
 	if asset.Kind != events.KindAsset {
 		return false, errors.New("event must be an asset event")
 	}
@@ -401,7 +458,7 @@ func isAssetReady(ctx context.Context, b Blossom, asset *nostr.Event) (bool, err
 		return false, fmt.Errorf("invalid x tag: %w", err)
 	}
 
-	found, err := b.Has(ctx, hash)
+	found, err := r.blossom.Has(ctx, hash)
 	if err != nil {
 		return false, fmt.Errorf("failed to check hash: %w", err)
 	}
@@ -409,36 +466,31 @@ func isAssetReady(ctx context.Context, b Blossom, asset *nostr.Event) (bool, err
 		return true, nil
 	}
 
-	// if the hash is not found locally, we check the "url" tags
-	// by making a HEAD request for each URL
-	urls := events.FindAll(asset.Tags, "url")
-	if len(urls) == 0 {
-		return false, nil
-	}
-
-	for _, url := range urls {
+	// if the hash is not found locally, we check the "url" tags,
+	// whose content must match the hash
+	for _, url := range events.FindAll(asset.Tags, "url") {
 		if strings.HasPrefix(url, "https://cdn.zapstore.dev") {
 			// skip URLs from the zapstore CDN, because they would have been already in the blossom db
 			continue
 		}
 
-		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		verified, err := r.isVerified(ctx, url, hash)
 		if err != nil {
-			slog.Warn("isAssetReady: skipping malformed url tag", "event", asset.ID, "url", url, "error", err)
-			continue
+			return false, fmt.Errorf("failed to check verification: %w", err)
 		}
-
-		res, err := assetChecker.Do(req)
-		if err != nil {
-			slog.Warn("isAssetReady: skipping url", "event", asset.ID, "url", url, "error", err)
-			continue
+		if verified {
+			return true, nil
 		}
 
-		if res.StatusCode >= 200 && res.StatusCode < 300 {
-			res.Body.Close()
-			return true, nil
+		if verify {
+			verified, err := r.verifyURL(ctx, asset, url, hash)
+			if err != nil {
+				return false, fmt.Errorf("failed to verify url: %w", err)
+			}
+			if verified {
+				return true, nil
+			}
 		}
-		res.Body.Close()
 	}
 	return false, nil
 }
@@ -615,6 +667,26 @@ func specificity(filter nostr.Filter) int {
 	return points
 }
 
+// eventChecks returns the checks that published and imported events go through. Rate limits are not included.
+// The allowed check (e.g. [NotAllowed]) runs before [AppOwnership], which can delete the app of the indexer,
+// so that a blocked pubkey can't trigger a developer reclaim. It can be nil, like for imported events.
+func eventChecks(config Config, store store.T, blssm Blossom, allowed func(rely.Client, *nostr.Event) error) []func(rely.Client, *nostr.Event) error {
+	checks := []func(rely.Client, *nostr.Event) error{
+		KindNotAllowed(config.AllowedKinds),
+		rely.InvalidID,
+		rely.InvalidSignature,
+		InvalidStructure,
+		NotAnchored(store),
+	}
+	if allowed != nil {
+		checks = append(checks, allowed)
+	}
+	return append(checks,
+		AppOwnership(store, config.Info.Pubkey),
+		ContradictsBlob(blssm),
+	)
+}
+
 func KindNotAllowed(kinds []int) func(_ rely.Client, e *nostr.Event) error {
 	return func(_ rely.Client, e *nostr.Event) error {
 		if !slices.Contains(kinds, e.Kind) {
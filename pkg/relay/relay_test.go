@@ -0,0 +1,73 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	defender "github.com/zapstore/defender/pkg/client"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+func TestEventChecksBlockedReclaim(t *testing.T) {
+	reject := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"decision":"reject"}`))
+	}))
+	defer reject.Close()
+
+	def, err := defender.Default(reject.URL)
+	if err != nil {
+		t.Fatalf("failed to create defender: %v", err)
+	}
+
+	db, err := store.New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	indexer := nostr.GeneratePrivateKey()
+	indexerPubkey, _ := nostr.GetPublicKey(indexer)
+	app := func(sk string, createdAt nostr.Timestamp) nostr.Event {
+		return signed(t, sk, nostr.Event{
+			Kind:      events.KindApp,
+			CreatedAt: createdAt,
+			Tags: nostr.Tags{
+				{"d", "com.example.app"},
+				{"name", "Example"},
+				{"f", "android-arm64-v8a"},
+			},
+		})
+	}
+
+	listing := app(indexer, 1000)
+	if _, err := db.Save(ctx, &listing); err != nil {
+		t.Fatalf("failed to save the app of the indexer: %v", err)
+	}
+
+	config := NewConfig()
+	config.Info.Pubkey = indexerPubkey
+	checks := eventChecks(config, db, mockBlossom{}, NotAllowed(def))
+
+	reclaim := app(nostr.GeneratePrivateKey(), 1001)
+	var rejected error
+	for _, check := range checks {
+		if rejected = check(nil, &reclaim); rejected != nil {
+			break
+		}
+	}
+	if rejected != ErrEventPubkeyBlocked {
+		t.Fatalf("expected error %v, got %v", ErrEventPubkeyBlocked, rejected)
+	}
+
+	found, err := db.Query(ctx, nostr.Filter{IDs: []string{listing.ID}, Limit: 1})
+	if err != nil {
+		t.Fatalf("failed to query the app of the indexer: %v", err)
+	}
+	if len(found) != 1 {
+		t.Fatal("expected the blocked pubkey to not delete the app of the indexer")
+	}
+}
@@ -0,0 +1,256 @@
+-- Zapstore schema extensions for tag indexing and full-text search.
+-- Note: 'd' tags for kinds 30000-39999 are already indexed by the base schema.
+
+-- Pending events are events that have been received but not yet promoted to the main event table.
+-- Because of that, they can't be served by the relay.
+CREATE TABLE IF NOT EXISTS pending_events (
+    id          TEXT    PRIMARY KEY,    -- event id (sha256)
+    kind        INTEGER NOT NULL,       -- event kind, for efficient filtering during promotion
+    raw         TEXT    NOT NULL,       -- full event JSON
+    received_at INTEGER NOT NULL        -- unix timestamp of when we received the event
+);
+
+CREATE INDEX IF NOT EXISTS idx_pending_events_kind        ON pending_events(kind);
+CREATE INDEX IF NOT EXISTS idx_pending_events_received_at ON pending_events(received_at);
+
+-- Universal single-letter tag indexing for all event kinds.
+-- Covers tags like a, e, f, i, p, t, x, A, E, K, P, etc.
+-- The base schema already indexes 'd' for addressable kinds; INSERT OR IGNORE deduplicates.
+CREATE TRIGGER IF NOT EXISTS single_letter_tags_ai AFTER INSERT ON events
+BEGIN
+	INSERT OR IGNORE INTO tags (event_id, key, value)
+	SELECT NEW.id, json_extract(value, '$[0]'), json_extract(value, '$[1]')
+	FROM json_each(NEW.tags)
+	WHERE json_type(value) = 'array'
+		AND json_array_length(value) > 1
+		AND length(json_extract(value, '$[0]')) = 1;
+END;
+
+-- Apps have one row per language: the default listing has lang = '', and every translated
+-- listing (localized tags like ["name", "...", "es"] or ["summary:es", "..."]) has its own row.
+CREATE VIRTUAL TABLE IF NOT EXISTS apps_fts USING fts5(
+	id UNINDEXED,
+	name,
+	summary,
+	content,
+	lang UNINDEXED,
+	tokenize = 'trigram'
+);
+
+-- KindApp (32267) - multi-character tag indexing
+CREATE TRIGGER IF NOT EXISTS app_tags_ai AFTER INSERT ON events
+WHEN NEW.kind = 32267
+BEGIN
+	INSERT OR IGNORE INTO tags (event_id, key, value)
+	SELECT NEW.id, json_extract(value, '$[0]'), json_extract(value, '$[1]')
+	FROM json_each(NEW.tags)
+	WHERE json_type(value) = 'array'
+		AND json_array_length(value) > 1
+		AND json_extract(value, '$[0]') IN ('name', 'license', 'url', 'repository');
+END;
+
+-- Full-text search index for apps
+CREATE TRIGGER IF NOT EXISTS app_fts_ai AFTER INSERT ON events
+WHEN NEW.kind = 32267
+BEGIN
+	INSERT INTO apps_fts (id, name, summary, content, lang)
+	VALUES (
+		NEW.id,
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE json_extract(value, '$[0]') = 'name'
+				AND ifnull(json_extract(value, '$[2]'), '') = '' LIMIT 1),
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE json_extract(value, '$[0]') = 'summary'
+				AND ifnull(json_extract(value, '$[2]'), '') = '' LIMIT 1),
+		NEW.content,
+		''
+	);
+
+	INSERT INTO apps_fts (id, name, summary, content, lang)
+	SELECT
+		NEW.id,
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE (json_extract(value, '$[0]') = 'name' AND lower(json_extract(value, '$[2]')) = l.lang)
+				OR lower(json_extract(value, '$[0]')) = 'name:' || l.lang LIMIT 1),
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE (json_extract(value, '$[0]') = 'summary' AND lower(json_extract(value, '$[2]')) = l.lang)
+				OR lower(json_extract(value, '$[0]')) = 'summary:' || l.lang LIMIT 1),
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE (json_extract(value, '$[0]') = 'content' AND lower(json_extract(value, '$[2]')) = l.lang)
+				OR lower(json_extract(value, '$[0]')) = 'content:' || l.lang LIMIT 1),
+		l.lang
+	FROM (
+		SELECT lower(json_extract(value, '$[2]')) AS lang FROM json_each(NEW.tags)
+		WHERE json_extract(value, '$[0]') IN ('name', 'summary', 'content')
+			AND ifnull(json_extract(value, '$[2]'), '') != ''
+		UNION
+		SELECT lower(substr(json_extract(value, '$[0]'), instr(json_extract(value, '$[0]'), ':') + 1)) FROM json_each(NEW.tags)
+		WHERE json_extract(value, '$[0]') GLOB 'name:?*'
+			OR json_extract(value, '$[0]') GLOB 'summary:?*'
+			OR json_extract(value, '$[0]') GLOB 'content:?*'
+	) AS l;
+END;
+
+CREATE TRIGGER IF NOT EXISTS app_fts_ad AFTER DELETE ON events
+WHEN OLD.kind = 32267
+BEGIN
+	DELETE FROM apps_fts WHERE id = OLD.id;
+END;
+
+-- KindStack (30267) - full-text search index for curated collections.
+-- Stacks are NIP-51 sets, whose name is in the 'title' tag (or 'name' for older clients).
+CREATE VIRTUAL TABLE IF NOT EXISTS stacks_fts USING fts5(
+	id UNINDEXED,
+	name,
+	description,
+	tokenize = 'trigram'
+);
+
+CREATE TRIGGER IF NOT EXISTS stack_fts_ai AFTER INSERT ON events
+WHEN NEW.kind = 30267
+BEGIN
+	INSERT INTO stacks_fts (id, name, description)
+	VALUES (
+		NEW.id,
+		COALESCE(
+			(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+				WHERE json_extract(value, '$[0]') = 'title' LIMIT 1),
+			(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+				WHERE json_extract(value, '$[0]') = 'name' LIMIT 1)
+		),
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE json_extract(value, '$[0]') = 'description' LIMIT 1)
+	);
+END;
+
+CREATE TRIGGER IF NOT EXISTS stack_fts_ad AFTER DELETE ON events
+WHEN OLD.kind = 30267
+BEGIN
+	DELETE FROM stacks_fts WHERE id = OLD.id;
+END;
+
+-- KindRelease (30063) - full-text search index for release notes
+CREATE VIRTUAL TABLE IF NOT EXISTS releases_fts USING fts5(
+	id UNINDEXED,
+	version,
+	content,
+	tokenize = 'trigram'
+);
+
+CREATE TRIGGER IF NOT EXISTS release_fts_ai AFTER INSERT ON events
+WHEN NEW.kind = 30063
+BEGIN
+	INSERT INTO releases_fts (id, version, content)
+	VALUES (
+		NEW.id,
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE json_extract(value, '$[0]') = 'version' LIMIT 1),
+		NEW.content
+	);
+END;
+
+CREATE TRIGGER IF NOT EXISTS release_fts_ad AFTER DELETE ON events
+WHEN OLD.kind = 30063
+BEGIN
+	DELETE FROM releases_fts WHERE id = OLD.id;
+END;
+
+-- Backfill apps saved before their full-text index existed, or before it was made multilingual.
+-- Translations go first, because the default listing is used to tell whether an app is indexed.
+INSERT INTO apps_fts (id, name, summary, content, lang)
+SELECT l.id,
+	(SELECT json_extract(value, '$[1]') FROM json_each(l.tags)
+		WHERE (json_extract(value, '$[0]') = 'name' AND lower(json_extract(value, '$[2]')) = l.lang)
+			OR lower(json_extract(value, '$[0]')) = 'name:' || l.lang LIMIT 1),
+	(SELECT json_extract(value, '$[1]') FROM json_each(l.tags)
+		WHERE (json_extract(value, '$[0]') = 'summary' AND lower(json_extract(value, '$[2]')) = l.lang)
+			OR lower(json_extract(value, '$[0]')) = 'summary:' || l.lang LIMIT 1),
+	(SELECT json_extract(value, '$[1]') FROM json_each(l.tags)
+		WHERE (json_extract(value, '$[0]') = 'content' AND lower(json_extract(value, '$[2]')) = l.lang)
+			OR lower(json_extract(value, '$[0]')) = 'content:' || l.lang LIMIT 1),
+	l.lang
+FROM (
+	SELECT e.id, e.tags, lower(json_extract(t.value, '$[2]')) AS lang
+	FROM events e, json_each(e.tags) t
+	WHERE e.kind = 32267 AND e.id NOT IN (SELECT id FROM apps_fts)
+		AND json_extract(t.value, '$[0]') IN ('name', 'summary', 'content')
+		AND ifnull(json_extract(t.value, '$[2]'), '') != ''
+	UNION
+	SELECT e.id, e.tags, lower(substr(json_extract(t.value, '$[0]'), instr(json_extract(t.value, '$[0]'), ':') + 1))
+	FROM events e, json_each(e.tags) t
+	WHERE e.kind = 32267 AND e.id NOT IN (SELECT id FROM apps_fts)
+		AND (json_extract(t.value, '$[0]') GLOB 'name:?*'
+			OR json_extract(t.value, '$[0]') GLOB 'summary:?*'
+			OR json_extract(t.value, '$[0]') GLOB 'content:?*')
+) AS l;
+
+INSERT INTO apps_fts (id, name, summary, content, lang)
+SELECT e.id,
+	(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+		WHERE json_extract(value, '$[0]') = 'name'
+			AND ifnull(json_extract(value, '$[2]'), '') = '' LIMIT 1),
+	(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+		WHERE json_extract(value, '$[0]') = 'summary'
+			AND ifnull(json_extract(value, '$[2]'), '') = '' LIMIT 1),
+	e.content,
+	''
+FROM events e
+WHERE e.kind = 32267 AND e.id NOT IN (SELECT id FROM apps_fts WHERE lang = '');
+
+-- Backfill stacks and releases saved before their full-text indexes existed.
+INSERT INTO stacks_fts (id, name, description)
+SELECT e.id,
+	COALESCE(
+		(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+			WHERE json_extract(value, '$[0]') = 'title' LIMIT 1),
+		(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+			WHERE json_extract(value, '$[0]') = 'name' LIMIT 1)
+	),
+	(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+		WHERE json_extract(value, '$[0]') = 'description' LIMIT 1)
+FROM events e
+WHERE e.kind = 30267 AND e.id NOT IN (SELECT id FROM stacks_fts);
+
+INSERT INTO releases_fts (id, version, content)
+SELECT e.id,
+	(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+		WHERE json_extract(value, '$[0]') = 'version' LIMIT 1),
+	e.content
+FROM events e
+WHERE e.kind = 30063 AND e.id NOT IN (SELECT id FROM releases_fts);
+
+-- KindRelease (30063) - multi-character tag indexing
+CREATE TRIGGER IF NOT EXISTS release_tags_ai AFTER INSERT ON events
+WHEN NEW.kind = 30063
+BEGIN
+	INSERT OR IGNORE INTO tags (event_id, key, value)
+	SELECT NEW.id, json_extract(value, '$[0]'), json_extract(value, '$[1]')
+	FROM json_each(NEW.tags)
+	WHERE json_type(value) = 'array'
+		AND json_array_length(value) > 1
+		AND json_extract(value, '$[0]') IN ('version', 'commit');
+END;
+
+-- KindAsset (3063) - multi-character tag indexing
+CREATE TRIGGER IF NOT EXISTS asset_tags_ai AFTER INSERT ON events
+WHEN NEW.kind = 3063
+BEGIN
+	INSERT OR IGNORE INTO tags (event_id, key, value)
+	SELECT NEW.id, json_extract(value, '$[0]'), json_extract(value, '$[1]')
+	FROM json_each(NEW.tags)
+	WHERE json_type(value) = 'array'
+		AND json_array_length(value) > 1
+		AND json_extract(value, '$[0]') IN ('url', 'version', 'apk_certificate_hash');
+END;
+
+-- KindFile (1063) - multi-character tag indexing
+CREATE TRIGGER IF NOT EXISTS file_tags_ai AFTER INSERT ON events
+WHEN NEW.kind = 1063
+BEGIN
+	INSERT OR IGNORE INTO tags (event_id, key, value)
+	SELECT NEW.id, json_extract(value, '$[0]'), json_extract(value, '$[1]')
+	FROM json_each(NEW.tags)
+	WHERE json_type(value) = 'array'
+		AND json_array_length(value) > 1
+		AND json_extract(value, '$[0]') IN ('url', 'fallback', 'version', 'apk_signature_hash');
+END;
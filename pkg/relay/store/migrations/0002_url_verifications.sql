@@ -0,0 +1,12 @@
+-- External asset URLs whose content has been downloaded and verified against the asset's "x" hash.
+-- The etag allows cheap conditional re-verifications, and changed_at flags URLs whose content changed after.
+CREATE TABLE IF NOT EXISTS url_verifications (
+    url         TEXT    NOT NULL,
+    hash        TEXT    NOT NULL,       -- sha256 the content of the URL matched when verified
+    etag        TEXT    NOT NULL DEFAULT '',
+    verified_at INTEGER NOT NULL,       -- unix timestamp of the last successful verification
+    changed_at  INTEGER,                -- unix timestamp of when the content was found changed, NULL if unchanged
+    PRIMARY KEY (url, hash)
+);
+
+CREATE INDEX IF NOT EXISTS idx_url_verifications_verified_at ON url_verifications(verified_at);
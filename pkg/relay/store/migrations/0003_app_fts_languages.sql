@@ -0,0 +1,115 @@
+-- Only index a third tag element as a language if it looks like a language code (e.g. "es", "pt-br"),
+-- like events.ParseApp does, because tags can have other extra elements (e.g. markers).
+-- Tags with another third element are part of the default listing.
+DROP TRIGGER IF EXISTS app_fts_ai;
+
+CREATE TRIGGER app_fts_ai AFTER INSERT ON events
+WHEN NEW.kind = 32267
+BEGIN
+	INSERT INTO apps_fts (id, name, summary, content, lang)
+	VALUES (
+		NEW.id,
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE json_extract(value, '$[0]') = 'name'
+				AND NOT (lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z]'
+					OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z][a-z]'
+					OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z]-[a-z0-9][a-z0-9]*'
+					OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z][a-z]-[a-z0-9][a-z0-9]*') LIMIT 1),
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE json_extract(value, '$[0]') = 'summary'
+				AND NOT (lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z]'
+					OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z][a-z]'
+					OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z]-[a-z0-9][a-z0-9]*'
+					OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z][a-z]-[a-z0-9][a-z0-9]*') LIMIT 1),
+		NEW.content,
+		''
+	);
+
+	INSERT INTO apps_fts (id, name, summary, content, lang)
+	SELECT
+		NEW.id,
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE (json_extract(value, '$[0]') = 'name' AND lower(json_extract(value, '$[2]')) = l.lang)
+				OR lower(json_extract(value, '$[0]')) = 'name:' || l.lang LIMIT 1),
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE (json_extract(value, '$[0]') = 'summary' AND lower(json_extract(value, '$[2]')) = l.lang)
+				OR lower(json_extract(value, '$[0]')) = 'summary:' || l.lang LIMIT 1),
+		(SELECT json_extract(value, '$[1]') FROM json_each(NEW.tags)
+			WHERE (json_extract(value, '$[0]') = 'content' AND lower(json_extract(value, '$[2]')) = l.lang)
+				OR lower(json_extract(value, '$[0]')) = 'content:' || l.lang LIMIT 1),
+		l.lang
+	FROM (
+		SELECT lower(json_extract(value, '$[2]')) AS lang FROM json_each(NEW.tags)
+		WHERE json_extract(value, '$[0]') IN ('name', 'summary', 'content')
+			AND (lower(json_extract(value, '$[2]')) GLOB '[a-z][a-z]'
+				OR lower(json_extract(value, '$[2]')) GLOB '[a-z][a-z][a-z]'
+				OR lower(json_extract(value, '$[2]')) GLOB '[a-z][a-z]-[a-z0-9][a-z0-9]*'
+				OR lower(json_extract(value, '$[2]')) GLOB '[a-z][a-z][a-z]-[a-z0-9][a-z0-9]*')
+		UNION
+		SELECT lower(substr(json_extract(value, '$[0]'), instr(json_extract(value, '$[0]'), ':') + 1)) FROM json_each(NEW.tags)
+		WHERE json_extract(value, '$[0]') GLOB 'name:?*'
+			OR json_extract(value, '$[0]') GLOB 'summary:?*'
+			OR json_extract(value, '$[0]') GLOB 'content:?*'
+	) AS l;
+END;
+
+-- Re-index the apps whose tags have a third element that is not a language code.
+DELETE FROM apps_fts WHERE id IN (
+	SELECT e.id FROM events e, json_each(e.tags) t
+	WHERE e.kind = 32267
+		AND json_extract(t.value, '$[0]') IN ('name', 'summary', 'content')
+		AND ifnull(json_extract(t.value, '$[2]'), '') != ''
+		AND NOT (lower(json_extract(t.value, '$[2]')) GLOB '[a-z][a-z]'
+			OR lower(json_extract(t.value, '$[2]')) GLOB '[a-z][a-z][a-z]'
+			OR lower(json_extract(t.value, '$[2]')) GLOB '[a-z][a-z]-[a-z0-9][a-z0-9]*'
+			OR lower(json_extract(t.value, '$[2]')) GLOB '[a-z][a-z][a-z]-[a-z0-9][a-z0-9]*')
+);
+
+INSERT INTO apps_fts (id, name, summary, content, lang)
+SELECT l.id,
+	(SELECT json_extract(value, '$[1]') FROM json_each(l.tags)
+		WHERE (json_extract(value, '$[0]') = 'name' AND lower(json_extract(value, '$[2]')) = l.lang)
+			OR lower(json_extract(value, '$[0]')) = 'name:' || l.lang LIMIT 1),
+	(SELECT json_extract(value, '$[1]') FROM json_each(l.tags)
+		WHERE (json_extract(value, '$[0]') = 'summary' AND lower(json_extract(value, '$[2]')) = l.lang)
+			OR lower(json_extract(value, '$[0]')) = 'summary:' || l.lang LIMIT 1),
+	(SELECT json_extract(value, '$[1]') FROM json_each(l.tags)
+		WHERE (json_extract(value, '$[0]') = 'content' AND lower(json_extract(value, '$[2]')) = l.lang)
+			OR lower(json_extract(value, '$[0]')) = 'content:' || l.lang LIMIT 1),
+	l.lang
+FROM (
+	SELECT e.id, e.tags, lower(json_extract(t.value, '$[2]')) AS lang
+	FROM events e, json_each(e.tags) t
+	WHERE e.kind = 32267 AND e.id NOT IN (SELECT id FROM apps_fts)
+		AND json_extract(t.value, '$[0]') IN ('name', 'summary', 'content')
+		AND (lower(json_extract(t.value, '$[2]')) GLOB '[a-z][a-z]'
+			OR lower(json_extract(t.value, '$[2]')) GLOB '[a-z][a-z][a-z]'
+			OR lower(json_extract(t.value, '$[2]')) GLOB '[a-z][a-z]-[a-z0-9][a-z0-9]*'
+			OR lower(json_extract(t.value, '$[2]')) GLOB '[a-z][a-z][a-z]-[a-z0-9][a-z0-9]*')
+	UNION
+	SELECT e.id, e.tags, lower(substr(json_extract(t.value, '$[0]'), instr(json_extract(t.value, '$[0]'), ':') + 1))
+	FROM events e, json_each(e.tags) t
+	WHERE e.kind = 32267 AND e.id NOT IN (SELECT id FROM apps_fts)
+		AND (json_extract(t.value, '$[0]') GLOB 'name:?*'
+			OR json_extract(t.value, '$[0]') GLOB 'summary:?*'
+			OR json_extract(t.value, '$[0]') GLOB 'content:?*')
+) AS l;
+
+INSERT INTO apps_fts (id, name, summary, content, lang)
+SELECT e.id,
+	(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+		WHERE json_extract(value, '$[0]') = 'name'
+			AND NOT (lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z]'
+				OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z][a-z]'
+				OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z]-[a-z0-9][a-z0-9]*'
+				OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z][a-z]-[a-z0-9][a-z0-9]*') LIMIT 1),
+	(SELECT json_extract(value, '$[1]') FROM json_each(e.tags)
+		WHERE json_extract(value, '$[0]') = 'summary'
+			AND NOT (lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z]'
+				OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z][a-z]'
+				OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z]-[a-z0-9][a-z0-9]*'
+				OR lower(ifnull(json_extract(value, '$[2]'), '')) GLOB '[a-z][a-z][a-z]-[a-z0-9][a-z0-9]*') LIMIT 1),
+	e.content,
+	''
+FROM events e
+WHERE e.kind = 32267 AND e.id NOT IN (SELECT id FROM apps_fts WHERE lang = '');
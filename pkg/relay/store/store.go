@@ -4,24 +4,35 @@ package store
 
 import (
 	"context"
-	_ "embed"
+	"database/sql"
+	"embed"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"slices"
+	"net/url"
+	"path"
 	"strings"
 	"time"
 
 	"github.com/nbd-wtf/go-nostr"
 	sqlite "github.com/vertex-lab/nostr-sqlite"
 	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/migrate"
 	"github.com/zapstore/relay/pkg/repourl"
 )
 
-var ErrUnsupportedREQ = errors.New("unsupported REQ")
+var (
+	ErrUnsupportedREQ       = errors.New("unsupported REQ")
+	ErrVerificationNotFound = errors.New("url verification not found")
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
 
-//go:embed schema.sql
-var schema string
+// Migrations returns the schema migrations of the relay database, in order.
+func Migrations() ([]migrate.Migration, error) {
+	return migrate.Load(migrations, "migrations")
+}
 
 type T struct {
 	*sqlite.Store
@@ -30,7 +41,8 @@ type T struct {
 func New(path string) (T, error) {
 	store, err := sqlite.New(
 		path,
-		sqlite.WithAdditionalSchema(schema),
+		withMultilingualApps(),
+		withMigrations(),
 		sqlite.WithQueryBuilder(queryBuilder),
 		sqlite.WithBusyTimeout(10*time.Second),
 		sqlite.WithCacheSize(256*sqlite.MiB),
@@ -44,6 +56,42 @@ func New(path string) (T, error) {
 	return T{Store: store}, nil
 }
 
+// withMigrations applies the pending schema migrations on top of the base nostr-sqlite schema.
+func withMigrations() sqlite.Option {
+	return func(s *sqlite.Store) error {
+		migrations, err := Migrations()
+		if err != nil {
+			return err
+		}
+		if _, err := migrate.Up(context.Background(), s.DB, migrations); err != nil {
+			return fmt.Errorf("failed to migrate: %w", err)
+		}
+		return nil
+	}
+}
+
+// withMultilingualApps drops the apps_fts table (and its insert trigger) if it was created before the
+// lang column existed, which predates versioned migrations. The first migration then recreates both,
+// and re-indexes every app in all of its languages.
+func withMultilingualApps() sqlite.Option {
+	return func(s *sqlite.Store) error {
+		var columns, langs int
+		err := s.DB.QueryRow(`SELECT COUNT(*), COUNT(*) FILTER (WHERE name = 'lang')
+			FROM pragma_table_info('apps_fts')`).Scan(&columns, &langs)
+		if err != nil {
+			return fmt.Errorf("failed to inspect apps_fts: %w", err)
+		}
+		if columns == 0 || langs > 0 {
+			return nil
+		}
+
+		if _, err := s.DB.Exec(`DROP TRIGGER IF EXISTS app_fts_ai; DROP TABLE apps_fts;`); err != nil {
+			return fmt.Errorf("failed to drop apps_fts: %w", err)
+		}
+		return nil
+	}
+}
+
 // SavePending stores an event in the pending_events table in an idempotent way.
 // It returns true if the event was inserted (i.e. it was not already present), false otherwise.
 func (s T) SavePending(ctx context.Context, event *nostr.Event) (bool, error) {
@@ -184,6 +232,241 @@ func (s T) ForceDeleteRequest(ctx context.Context, event *nostr.Event) (int, err
 	return deleted, nil
 }
 
+// Stream calls fn for every event matching the kinds, authors, since and until of the filter,
+// from the oldest to the newest, without loading them all in memory. Other filter fields are ignored.
+// It stops at the first error returned by fn.
+func (s T) Stream(ctx context.Context, filter nostr.Filter, fn func(*nostr.Event) error) error {
+	var conditions []string
+	var args []any
+
+	if len(filter.Kinds) > 0 {
+		conditions = append(conditions, "kind"+inClause(len(filter.Kinds)))
+		for _, kind := range filter.Kinds {
+			args = append(args, kind)
+		}
+	}
+	if len(filter.Authors) > 0 {
+		conditions = append(conditions, "pubkey"+inClause(len(filter.Authors)))
+		for _, pk := range filter.Authors {
+			args = append(args, pk)
+		}
+	}
+	if filter.Since != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, filter.Since.Time().Unix())
+	}
+	if filter.Until != nil {
+		conditions = append(conditions, "created_at <= ?")
+		args = append(args, filter.Until.Time().Unix())
+	}
+
+	query := "SELECT id, pubkey, created_at, kind, tags, content, sig FROM events"
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY created_at ASC, id ASC"
+
+	rows, err := s.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to stream events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event nostr.Event
+		var tags string
+		if err := rows.Scan(&event.ID, &event.PubKey, &event.CreatedAt, &event.Kind, &tags, &event.Content, &event.Sig); err != nil {
+			return fmt.Errorf("failed to scan event: %w", err)
+		}
+		if err := json.Unmarshal([]byte(tags), &event.Tags); err != nil {
+			return fmt.Errorf("failed to unmarshal tags of event %s: %w", event.ID, err)
+		}
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to stream events: %w", err)
+	}
+	return nil
+}
+
+// ExternalAssets returns the saved assets with at least one "url" tag, whose blob might be hosted externally.
+func (s T) ExternalAssets(ctx context.Context) ([]nostr.Event, error) {
+	query := `SELECT id, pubkey, created_at, kind, tags, content, sig FROM events e
+		WHERE e.kind = ? AND EXISTS (
+			SELECT 1 FROM json_each(e.tags) WHERE json_extract(value, '$[0]') = 'url'
+		)`
+
+	rows, err := s.DB.QueryContext(ctx, query, events.KindAsset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query external assets: %w", err)
+	}
+	defer rows.Close()
+
+	var assets []nostr.Event
+	for rows.Next() {
+		var event nostr.Event
+		var tags string
+		if err := rows.Scan(&event.ID, &event.PubKey, &event.CreatedAt, &event.Kind, &tags, &event.Content, &event.Sig); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		if err := json.Unmarshal([]byte(tags), &event.Tags); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal tags of event %s: %w", event.ID, err)
+		}
+		assets = append(assets, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query external assets: %w", err)
+	}
+	return assets, nil
+}
+
+// Verification is the record of an external URL whose content matched the hash.
+type Verification struct {
+	URL        string
+	Hash       string
+	ETag       string
+	VerifiedAt time.Time
+	ChangedAt  time.Time // zero if the content hasn't changed since the verification
+}
+
+// Changed returns whether the content of the URL was found changed after the verification.
+func (v Verification) Changed() bool {
+	return !v.ChangedAt.IsZero()
+}
+
+// SaveVerification records that the content of the URL matched the hash, clearing any previous change flag.
+func (s T) SaveVerification(ctx context.Context, v Verification) error {
+	_, err := s.DB.ExecContext(ctx, `INSERT INTO url_verifications (url, hash, etag, verified_at, changed_at)
+		VALUES (?, ?, ?, ?, NULL)
+		ON CONFLICT (url, hash) DO UPDATE SET
+			etag = excluded.etag,
+			verified_at = excluded.verified_at,
+			changed_at = NULL`,
+		v.URL, v.Hash, v.ETag, v.VerifiedAt.Unix(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save verification of %s: %w", v.URL, err)
+	}
+	return nil
+}
+
+// QueryVerification returns the verification of the URL for the hash, or [ErrVerificationNotFound].
+func (s T) QueryVerification(ctx context.Context, url, hash string) (Verification, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT url, hash, etag, verified_at, changed_at
+		FROM url_verifications WHERE url = ? AND hash = ?`, url, hash)
+	if err != nil {
+		return Verification{}, fmt.Errorf("failed to query verification of %s: %w", url, err)
+	}
+
+	verifications, err := scanVerifications(rows)
+	if err != nil {
+		return Verification{}, err
+	}
+	if len(verifications) == 0 {
+		return Verification{}, ErrVerificationNotFound
+	}
+	return verifications[0], nil
+}
+
+// Verifications returns the unchanged verifications last verified before the given time, oldest first.
+func (s T) Verifications(ctx context.Context, before time.Time) ([]Verification, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT url, hash, etag, verified_at, changed_at
+		FROM url_verifications WHERE changed_at IS NULL AND verified_at < ?
+		ORDER BY verified_at`, before.Unix())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query verifications: %w", err)
+	}
+	return scanVerifications(rows)
+}
+
+// FlagChanged flags the verification of the URL for the hash as changed at the given time.
+func (s T) FlagChanged(ctx context.Context, url, hash string, at time.Time) error {
+	_, err := s.DB.ExecContext(ctx, `UPDATE url_verifications SET changed_at = ?
+		WHERE url = ? AND hash = ? AND changed_at IS NULL`, at.Unix(), url, hash)
+	if err != nil {
+		return fmt.Errorf("failed to flag verification of %s: %w", url, err)
+	}
+	return nil
+}
+
+// scanVerifications scans and closes the rows of a url_verifications query.
+func scanVerifications(rows *sql.Rows) ([]Verification, error) {
+	defer rows.Close()
+
+	var verifications []Verification
+	for rows.Next() {
+		var v Verification
+		var verifiedAt int64
+		var changedAt sql.NullInt64
+		if err := rows.Scan(&v.URL, &v.Hash, &v.ETag, &verifiedAt, &changedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan verification: %w", err)
+		}
+
+		v.VerifiedAt = time.Unix(verifiedAt, 0).UTC()
+		if changedAt.Valid {
+			v.ChangedAt = time.Unix(changedAt.Int64, 0).UTC()
+		}
+		verifications = append(verifications, v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to scan verifications: %w", err)
+	}
+	return verifications, nil
+}
+
+// ReferencedHashes returns the set of blob hashes (lowercase hex) referenced by saved and pending events:
+// the "x" tags of assets, and the "icon" and "image" tags of any event, whose URLs end with the hash
+// optionally followed by an extension (e.g. https://cdn.zapstore.dev/<sha256>.png).
+func (s T) ReferencedHashes(ctx context.Context) (map[string]struct{}, error) {
+	query := `
+		SELECT t.value FROM tags t JOIN events e ON e.id = t.event_id
+		WHERE t.key = 'x' AND e.kind = ?
+		UNION
+		SELECT json_extract(j.value, '$[1]') FROM events e, json_each(e.tags) j
+		WHERE (e.tags LIKE '%"icon"%' OR e.tags LIKE '%"image"%')
+			AND json_extract(j.value, '$[0]') IN ('icon', 'image')
+		UNION
+		SELECT json_extract(j.value, '$[1]') FROM pending_events p, json_each(p.raw, '$.tags') j
+		WHERE json_extract(j.value, '$[0]') IN ('x', 'icon', 'image')`
+
+	rows, err := s.DB.QueryContext(ctx, query, events.KindAsset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query referenced hashes: %w", err)
+	}
+	defer rows.Close()
+
+	hashes := make(map[string]struct{})
+	for rows.Next() {
+		var value sql.NullString
+		if err := rows.Scan(&value); err != nil {
+			return nil, fmt.Errorf("failed to scan referenced hash: %w", err)
+		}
+		if hash, ok := blobHash(value.String); ok {
+			hashes[hash] = struct{}{}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query referenced hashes: %w", err)
+	}
+	return hashes, nil
+}
+
+// blobHash extracts the lowercase hex sha256 from a tag value, which is either the hash itself
+// or a URL whose last path segment is the hash, optionally followed by an extension.
+func blobHash(value string) (string, bool) {
+	if u, err := url.Parse(value); err == nil && u.Path != "" {
+		value = path.Base(u.Path)
+	}
+	value, _, _ = strings.Cut(value, ".")
+	value = strings.ToLower(value)
+	if !nostr.IsValid32ByteHex(value) {
+		return "", false
+	}
+	return value, true
+}
+
 // findAll returns all values of the given key in the tags.
 func findAll(tags nostr.Tags, key string) []string {
 	var values []string
@@ -208,17 +491,71 @@ func Validate(filters ...nostr.Filter) error {
 		// because the order of the result events will inevitably be ambiguous.
 		return fmt.Errorf("%w: there can only be one filter per REQ when using NIP-50 search", ErrUnsupportedREQ)
 	}
-	if !slices.Equal(filters[0].Kinds, []int{events.KindApp}) {
-		return fmt.Errorf("%w: we allow NIP-50 search only for kind %d", ErrUnsupportedREQ, events.KindApp)
+	if !isSearchable(filters[0].Kinds) {
+		return fmt.Errorf("%w: we allow NIP-50 search only for one of the kinds %v", ErrUnsupportedREQ, searchableKinds)
 	}
-	if len(filters[0].Search) < 3 {
+
+	term, lang := parseSearch(filters[0].Search)
+	if len(term) < 3 {
 		// The trigram tokenizer requires at least 3 chars, as well as the repoURL search.
 		return fmt.Errorf("%w: search term must be at least 3 characters", ErrUnsupportedREQ)
 	}
+	if lang != "" {
+		if !searchIndexes[filters[0].Kinds[0]].localized {
+			return fmt.Errorf("%w: the lang extension is only supported for kind %d", ErrUnsupportedREQ, events.KindApp)
+		}
+		if _, err := events.NormalizeLanguage(lang); err != nil {
+			return fmt.Errorf("%w: %w", ErrUnsupportedREQ, err)
+		}
+	}
 	return nil
 }
 
-// queryBuilder handles FTS search for apps when there's exactly one app search filter.
+// parseSearch splits a NIP-50 search string into the search term and the
+// language requested with the "lang:xx" extension, if any.
+// Other "key:value" extensions are left in the term as they are.
+func parseSearch(search string) (term, lang string) {
+	words := strings.Fields(search)
+	kept := make([]string, 0, len(words))
+	for _, word := range words {
+		if value, ok := strings.CutPrefix(word, "lang:"); ok {
+			lang = value
+			continue
+		}
+		kept = append(kept, word)
+	}
+	return strings.Join(kept, " "), lang
+}
+
+// searchIndex describes the FTS table used to search a kind, and the bm25
+// column weights (one per column, including the unindexed ones) used to rank results.
+// A localized index has one row per language of an event, distinguished by its lang column.
+type searchIndex struct {
+	table     string
+	weights   string
+	localized bool
+}
+
+// searchIndexes maps each searchable kind to its FTS index.
+var searchIndexes = map[int]searchIndex{
+	events.KindApp:     {table: "apps_fts", weights: "0, 20, 5, 1, 0", localized: true},
+	events.KindStack:   {table: "stacks_fts", weights: "0, 10, 3"},
+	events.KindRelease: {table: "releases_fts", weights: "0, 5, 1"},
+}
+
+// searchableKinds is the list of kinds that support NIP-50 search, used in error messages.
+var searchableKinds = []int{events.KindApp, events.KindStack, events.KindRelease}
+
+// isSearchable returns whether the kinds of a search filter are exactly one searchable kind.
+func isSearchable(kinds []int) bool {
+	if len(kinds) != 1 {
+		return false
+	}
+	_, ok := searchIndexes[kinds[0]]
+	return ok
+}
+
+// queryBuilder handles FTS search when there's exactly one search filter.
 // When the search term is a repository URL (any host, /:user/:repo path), it performs
 // an exact match on the `repository` tag instead of FTS. Otherwise, it delegates to
 // the default query builder.
@@ -266,38 +603,93 @@ func repositoryURLQuery(filter nostr.Filter) ([]sqlite.Query, error) {
 	return []sqlite.Query{{SQL: query, Args: []any{canonical, withGit, limit}}}, nil
 }
 
-// appSearchQuery builds an FTS query for searching apps.
+// searchQuery builds an FTS query for searching apps, stacks or releases, depending on the filter kind.
 // Results are ordered by BM25 relevance with custom weights.
 func searchQuery(f nostr.Filter) ([]sqlite.Query, error) {
-	// Repository URL search: exact match on the `repository` tag (no FTS).
-	// Accepts any /:user/:repo URL (GitHub, GitLab, Codeberg, etc.) with or
-	// without a scheme and with or without trailing path/query.
-	if r, ok := repourl.Parse(f.Search); ok {
-		f.Search = r.Canonical
-		return repositoryURLQuery(f)
+	if !isSearchable(f.Kinds) {
+		return nil, fmt.Errorf("%w: we allow NIP-50 search only for one of the kinds %v", ErrUnsupportedREQ, searchableKinds)
+	}
+	index := searchIndexes[f.Kinds[0]]
+	term, lang := parseSearch(f.Search)
+
+	if f.Kinds[0] == events.KindApp {
+		// Repository URL search: exact match on the `repository` tag (no FTS).
+		// Accepts any /:user/:repo URL (GitHub, GitLab, Codeberg, etc.) with or
+		// without a scheme and with or without trailing path/query.
+		if r, ok := repourl.Parse(term); ok {
+			f.Search = r.Canonical
+			return repositoryURLQuery(f)
+		}
 	}
 
-	f.Search = escapeFTS5(f.Search)
-	conditions, args := appSearchSql(f)
+	if index.localized {
+		return localizedSearchQuery(index, f, term, lang)
+	}
+
+	conditions, args := searchSql(f)
+	conditions = append([]string{index.table + " MATCH ?"}, conditions...)
+	args = append([]any{escapeFTS5(term)}, args...)
 
 	query := `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
 		FROM events e
-		JOIN apps_fts fts ON e.id = fts.id
+		JOIN ` + index.table + ` fts ON e.id = fts.id
 		WHERE ` + strings.Join(conditions, " AND ") + `
-		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
+		ORDER BY bm25(` + index.table + `, ` + index.weights + `)
 		LIMIT ?`
 
 	args = append(args, f.Limit)
 	return []sqlite.Query{{SQL: query, Args: args}}, nil
 }
 
-// appSearchSql converts a nostr.Filter into SQL conditions and arguments.
+// localizedSearchQuery builds an FTS query over an index with one row per language of an event.
+// Each event is returned once, with matches in the requested language first, then matches in the
+// default language, then matches in any other language, each group ordered by BM25 relevance.
+// When no language is requested, matches in the default language go first.
+func localizedSearchQuery(index searchIndex, f nostr.Filter, term, lang string) ([]sqlite.Query, error) {
+	if lang != "" {
+		var err error
+		lang, err = events.NormalizeLanguage(lang)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUnsupportedREQ, err)
+		}
+	}
+
+	// bm25() can't be used in an aggregate, so the ranking function is configured via the rank column,
+	// and each event keeps the fallback and the rank of the same row: its best match in the best language.
+	matches := `SELECT id, fallback, rank FROM (
+			SELECT id, fallback, rank, ROW_NUMBER() OVER (PARTITION BY id ORDER BY fallback, rank) AS n
+			FROM (
+				SELECT id, CASE lang WHEN ? THEN 0 WHEN '' THEN 1 ELSE 2 END AS fallback, rank
+				FROM ` + index.table + `
+				WHERE ` + index.table + ` MATCH ? AND rank MATCH 'bm25(` + index.weights + `)'
+			)
+		)
+		WHERE n = 1`
+	args := []any{lang, escapeFTS5(term)}
+
+	query := `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
+		FROM events e
+		JOIN (` + matches + `) fts ON e.id = fts.id`
+
+	conditions, filterArgs := searchSql(f)
+	if len(conditions) > 0 {
+		query += `
+		WHERE ` + strings.Join(conditions, " AND ")
+		args = append(args, filterArgs...)
+	}
+
+	query += `
+		ORDER BY fts.fallback, fts.rank
+		LIMIT ?`
+
+	args = append(args, f.Limit)
+	return []sqlite.Query{{SQL: query, Args: args}}, nil
+}
+
+// searchSql converts the non-search fields of a nostr.Filter into SQL conditions and arguments on the events table.
 // Tags are filtered using subqueries to avoid JOIN and GROUP BY,
 // which would break bm25() ranking.
-func appSearchSql(filter nostr.Filter) (conditions []string, args []any) {
-	conditions = []string{"apps_fts MATCH ?"}
-	args = []any{filter.Search}
-
+func searchSql(filter nostr.Filter) (conditions []string, args []any) {
 	if len(filter.IDs) > 0 {
 		conditions = append(conditions, "e.id"+inClause(len(filter.IDs)))
 		for _, id := range filter.IDs {
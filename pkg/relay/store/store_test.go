@@ -7,6 +7,7 @@ import (
 	"reflect"
 	"slices"
 	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/nbd-wtf/go-nostr"
@@ -36,11 +37,18 @@ func TestSearchQuery(t *testing.T) {
 			want: sqlite.Query{
 				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
 		FROM events e
-		JOIN apps_fts fts ON e.id = fts.id
-		WHERE apps_fts MATCH ?
-		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
+		JOIN (SELECT id, fallback, rank FROM (
+			SELECT id, fallback, rank, ROW_NUMBER() OVER (PARTITION BY id ORDER BY fallback, rank) AS n
+			FROM (
+				SELECT id, CASE lang WHEN ? THEN 0 WHEN '' THEN 1 ELSE 2 END AS fallback, rank
+				FROM apps_fts
+				WHERE apps_fts MATCH ? AND rank MATCH 'bm25(0, 20, 5, 1, 0)'
+			)
+		)
+		WHERE n = 1) fts ON e.id = fts.id
+		ORDER BY fts.fallback, fts.rank
 		LIMIT ?`,
-				Args: []any{"\"signal\"", 50},
+				Args: []any{"", "\"signal\"", 50},
 			},
 		},
 		{
@@ -54,11 +62,19 @@ func TestSearchQuery(t *testing.T) {
 			want: sqlite.Query{
 				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
 		FROM events e
-		JOIN apps_fts fts ON e.id = fts.id
-		WHERE apps_fts MATCH ? AND e.id IN (?,?)
-		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
+		JOIN (SELECT id, fallback, rank FROM (
+			SELECT id, fallback, rank, ROW_NUMBER() OVER (PARTITION BY id ORDER BY fallback, rank) AS n
+			FROM (
+				SELECT id, CASE lang WHEN ? THEN 0 WHEN '' THEN 1 ELSE 2 END AS fallback, rank
+				FROM apps_fts
+				WHERE apps_fts MATCH ? AND rank MATCH 'bm25(0, 20, 5, 1, 0)'
+			)
+		)
+		WHERE n = 1) fts ON e.id = fts.id
+		WHERE e.id IN (?,?)
+		ORDER BY fts.fallback, fts.rank
 		LIMIT ?`,
-				Args: []any{"\"signal\"", "abc123", "def456", 10},
+				Args: []any{"", "\"signal\"", "abc123", "def456", 10},
 			},
 		},
 		{
@@ -72,11 +88,19 @@ func TestSearchQuery(t *testing.T) {
 			want: sqlite.Query{
 				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
 		FROM events e
-		JOIN apps_fts fts ON e.id = fts.id
-		WHERE apps_fts MATCH ? AND e.pubkey IN (?,?)
-		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
+		JOIN (SELECT id, fallback, rank FROM (
+			SELECT id, fallback, rank, ROW_NUMBER() OVER (PARTITION BY id ORDER BY fallback, rank) AS n
+			FROM (
+				SELECT id, CASE lang WHEN ? THEN 0 WHEN '' THEN 1 ELSE 2 END AS fallback, rank
+				FROM apps_fts
+				WHERE apps_fts MATCH ? AND rank MATCH 'bm25(0, 20, 5, 1, 0)'
+			)
+		)
+		WHERE n = 1) fts ON e.id = fts.id
+		WHERE e.pubkey IN (?,?)
+		ORDER BY fts.fallback, fts.rank
 		LIMIT ?`,
-				Args: []any{"\"signal\"", "pubkey1", "pubkey2", 20},
+				Args: []any{"", "\"signal\"", "pubkey1", "pubkey2", 20},
 			},
 		},
 		{
@@ -91,11 +115,19 @@ func TestSearchQuery(t *testing.T) {
 			want: sqlite.Query{
 				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
 		FROM events e
-		JOIN apps_fts fts ON e.id = fts.id
-		WHERE apps_fts MATCH ? AND e.created_at >= ? AND e.created_at <= ?
-		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
+		JOIN (SELECT id, fallback, rank FROM (
+			SELECT id, fallback, rank, ROW_NUMBER() OVER (PARTITION BY id ORDER BY fallback, rank) AS n
+			FROM (
+				SELECT id, CASE lang WHEN ? THEN 0 WHEN '' THEN 1 ELSE 2 END AS fallback, rank
+				FROM apps_fts
+				WHERE apps_fts MATCH ? AND rank MATCH 'bm25(0, 20, 5, 1, 0)'
+			)
+		)
+		WHERE n = 1) fts ON e.id = fts.id
+		WHERE e.created_at >= ? AND e.created_at <= ?
+		ORDER BY fts.fallback, fts.rank
 		LIMIT ?`,
-				Args: []any{"\"signal\"", int64(1700000000), int64(1800000000), 100},
+				Args: []any{"", "\"signal\"", int64(1700000000), int64(1800000000), 100},
 			},
 		},
 		{
@@ -109,11 +141,78 @@ func TestSearchQuery(t *testing.T) {
 			want: sqlite.Query{
 				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
 		FROM events e
-		JOIN apps_fts fts ON e.id = fts.id
-		WHERE apps_fts MATCH ? AND EXISTS (SELECT 1 FROM tags WHERE event_id = e.id AND key = ? AND value IN (?,?))
-		ORDER BY bm25(apps_fts, 0, 20, 5, 1)
+		JOIN (SELECT id, fallback, rank FROM (
+			SELECT id, fallback, rank, ROW_NUMBER() OVER (PARTITION BY id ORDER BY fallback, rank) AS n
+			FROM (
+				SELECT id, CASE lang WHEN ? THEN 0 WHEN '' THEN 1 ELSE 2 END AS fallback, rank
+				FROM apps_fts
+				WHERE apps_fts MATCH ? AND rank MATCH 'bm25(0, 20, 5, 1, 0)'
+			)
+		)
+		WHERE n = 1) fts ON e.id = fts.id
+		WHERE EXISTS (SELECT 1 FROM tags WHERE event_id = e.id AND key = ? AND value IN (?,?))
+		ORDER BY fts.fallback, fts.rank
 		LIMIT ?`,
-				Args: []any{"\"signal\"", "t", "productivity", "tools", 25},
+				Args: []any{"", "\"signal\"", "t", "productivity", "tools", 25},
+			},
+		},
+		{
+			name: "search with lang",
+			filter: nostr.Filter{
+				Kinds:  []int{events.KindApp},
+				Search: "lang:pt-BR signal",
+				Limit:  50,
+			},
+			want: sqlite.Query{
+				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
+		FROM events e
+		JOIN (SELECT id, fallback, rank FROM (
+			SELECT id, fallback, rank, ROW_NUMBER() OVER (PARTITION BY id ORDER BY fallback, rank) AS n
+			FROM (
+				SELECT id, CASE lang WHEN ? THEN 0 WHEN '' THEN 1 ELSE 2 END AS fallback, rank
+				FROM apps_fts
+				WHERE apps_fts MATCH ? AND rank MATCH 'bm25(0, 20, 5, 1, 0)'
+			)
+		)
+		WHERE n = 1) fts ON e.id = fts.id
+		ORDER BY fts.fallback, fts.rank
+		LIMIT ?`,
+				Args: []any{"pt-br", "\"signal\"", 50},
+			},
+		},
+		{
+			name: "stack search",
+			filter: nostr.Filter{
+				Kinds:  []int{events.KindStack},
+				Search: "privacy",
+				Limit:  10,
+			},
+			want: sqlite.Query{
+				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
+		FROM events e
+		JOIN stacks_fts fts ON e.id = fts.id
+		WHERE stacks_fts MATCH ?
+		ORDER BY bm25(stacks_fts, 0, 10, 3)
+		LIMIT ?`,
+				Args: []any{"\"privacy\"", 10},
+			},
+		},
+		{
+			name: "release search with tags",
+			filter: nostr.Filter{
+				Kinds:  []int{events.KindRelease},
+				Search: "fixed crash",
+				Tags:   nostr.TagMap{"i": {"com.example.app"}},
+				Limit:  10,
+			},
+			want: sqlite.Query{
+				SQL: `SELECT e.id, e.pubkey, e.created_at, e.kind, e.tags, e.content, e.sig
+		FROM events e
+		JOIN releases_fts fts ON e.id = fts.id
+		WHERE releases_fts MATCH ? AND EXISTS (SELECT 1 FROM tags WHERE event_id = e.id AND key = ? AND value = ?)
+		ORDER BY bm25(releases_fts, 0, 5, 1)
+		LIMIT ?`,
+				Args: []any{"\"fixed crash\"", "i", "com.example.app", 10},
 			},
 		},
 	}
@@ -232,6 +331,383 @@ func TestStoreQueryAppSearch(t *testing.T) {
 	}
 }
 
+func TestStoreQueryLocalizedAppSearch(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	apps := []*nostr.Event{
+		{
+			ID:        "app1",
+			PubKey:    "pubkey1",
+			CreatedAt: nostr.Timestamp(1700000001),
+			Kind:      events.KindApp,
+			Tags: nostr.Tags{
+				{"d", "com.example.calculator"},
+				{"name", "Calculator"},
+				{"summary", "A simple calculator"},
+				{"name", "Calculadora", "es"},
+				{"summary:es", "Una calculadora sencilla"},
+			},
+			Content: "Adds and subtracts numbers.",
+			Sig:     "sig1",
+		},
+		{
+			ID:        "app2",
+			PubKey:    "pubkey2",
+			CreatedAt: nostr.Timestamp(1700000002),
+			Kind:      events.KindApp,
+			Tags: nostr.Tags{
+				{"d", "com.example.calculadora"},
+				{"name", "Calculadora Pro"},
+				{"summary", "Scientific calculator"},
+			},
+			Content: "A calculator for engineers.",
+			Sig:     "sig2",
+		},
+		{
+			ID:        "app3",
+			PubKey:    "pubkey3",
+			CreatedAt: nostr.Timestamp(1700000003),
+			Kind:      events.KindApp,
+			Tags: nostr.Tags{
+				{"d", "com.example.rechner"},
+				{"name", "Calc Tool"},
+				{"name:DE", "Rechner"},
+				{"content:de", "Ein Rechner für alle."},
+			},
+			Content: "A calculator for everyone.",
+			Sig:     "sig3",
+		},
+		{
+			ID:        "app4",
+			PubKey:    "pubkey4",
+			CreatedAt: nostr.Timestamp(1700000004),
+			Kind:      events.KindApp,
+			Tags: nostr.Tags{
+				{"d", "com.example.notas"},
+				{"name", "Notas"},
+				{"content:es", "Una aplicación para escribir, organizar y compartir tus notas"},
+			},
+			Content: "Write things down.",
+			Sig:     "sig4",
+		},
+		{
+			ID:        "app5",
+			PubKey:    "pubkey5",
+			CreatedAt: nostr.Timestamp(1700000005),
+			Kind:      events.KindApp,
+			Tags: nostr.Tags{
+				{"d", "com.example.jotter"},
+				{"name", "Jotter"},
+				{"summary:es", "Notas rápidas"},
+			},
+			Content: "Quick notes.",
+			Sig:     "sig5",
+		},
+	}
+
+	for _, app := range apps {
+		if _, err := store.Save(ctx, app); err != nil {
+			t.Fatalf("failed to save app %s: %v", app.ID, err)
+		}
+	}
+
+	var langs []string
+	rows, err := store.DB.QueryContext(ctx, "SELECT lang FROM apps_fts WHERE id = ? ORDER BY lang", "app1")
+	if err != nil {
+		t.Fatalf("failed to query apps_fts: %v", err)
+	}
+	for rows.Next() {
+		var lang string
+		if err := rows.Scan(&lang); err != nil {
+			t.Fatalf("failed to scan lang: %v", err)
+		}
+		langs = append(langs, lang)
+	}
+	rows.Close()
+
+	if !reflect.DeepEqual(langs, []string{"", "es"}) {
+		t.Fatalf("expected app1 to be indexed in languages %v, got %v", []string{"", "es"}, langs)
+	}
+
+	tests := []struct {
+		name     string
+		search   string
+		expected []string
+	}{
+		{
+			name:     "requested language first",
+			search:   "lang:es calculadora",
+			expected: []string{"app1", "app2"},
+		},
+		{
+			name:     "default language first",
+			search:   "calculadora",
+			expected: []string{"app2", "app1"},
+		},
+		{
+			name:     "one result per app",
+			search:   "calc lang:es",
+			expected: []string{"app1", "app3", "app2"},
+		},
+		{
+			name:     "localized content",
+			search:   "rechner lang:de",
+			expected: []string{"app3"},
+		},
+		{
+			// app4 matches better in the default language, but only its match in the requested language counts
+			name:     "rank of the requested language",
+			search:   "notas lang:es",
+			expected: []string{"app5", "app4"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results, err := store.Query(ctx, nostr.Filter{
+				Kinds:  []int{events.KindApp},
+				Search: test.search,
+				Limit:  50,
+			})
+			if err != nil {
+				t.Fatalf("store.Query() error = %v", err)
+			}
+
+			IDs := make([]string, len(results))
+			for i, result := range results {
+				IDs[i] = result.ID
+			}
+			if !reflect.DeepEqual(IDs, test.expected) {
+				t.Errorf("expected %v, got %v", test.expected, IDs)
+			}
+		})
+	}
+}
+
+func TestStoreAppSearchNotLanguage(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	app := &nostr.Event{
+		ID:        "app1",
+		PubKey:    "pubkey1",
+		CreatedAt: nostr.Timestamp(1700000001),
+		Kind:      events.KindApp,
+		Tags: nostr.Tags{
+			{"d", "com.example.foo"},
+			{"name", "Foo", "marker"},
+			{"summary", "Does foo things", "es"},
+		},
+		Content: "Foo.",
+		Sig:     "sig1",
+	}
+
+	indexed := func() map[string]string {
+		t.Helper()
+		rows, err := store.DB.QueryContext(ctx, "SELECT lang, ifnull(name, '') FROM apps_fts WHERE id = ?", app.ID)
+		if err != nil {
+			t.Fatalf("failed to query apps_fts: %v", err)
+		}
+		defer rows.Close()
+
+		names := make(map[string]string)
+		for rows.Next() {
+			var lang, name string
+			if err := rows.Scan(&lang, &name); err != nil {
+				t.Fatalf("failed to scan row: %v", err)
+			}
+			names[lang] = name
+		}
+		return names
+	}
+
+	if _, err := store.Save(ctx, app); err != nil {
+		t.Fatalf("failed to save app: %v", err)
+	}
+	expected := map[string]string{"": "Foo", "es": ""}
+	if names := indexed(); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected the names by language %v, got %v", expected, names)
+	}
+
+	// apps indexed before the migration have a row for the marker and no default name
+	_, err = store.DB.ExecContext(ctx, `DELETE FROM apps_fts;
+		INSERT INTO apps_fts (id, name, summary, content, lang) VALUES ('app1', NULL, NULL, 'Foo.', ''), ('app1', 'Foo', NULL, NULL, 'marker')`)
+	if err != nil {
+		t.Fatalf("failed to reset apps_fts: %v", err)
+	}
+	migration, err := migrations.ReadFile("migrations/0003_app_fts_languages.sql")
+	if err != nil {
+		t.Fatalf("failed to read migration: %v", err)
+	}
+	if _, err := store.DB.ExecContext(ctx, string(migration)); err != nil {
+		t.Fatalf("failed to apply migration: %v", err)
+	}
+	if names := indexed(); !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected the names by language %v after the migration, got %v", expected, names)
+	}
+}
+
+func TestValidateSearch(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters nostr.Filters
+		err     error
+	}{
+		{
+			name:    "app search",
+			filters: nostr.Filters{{Kinds: []int{events.KindApp}, Search: "signal"}},
+		},
+		{
+			name:    "stack search",
+			filters: nostr.Filters{{Kinds: []int{events.KindStack}, Search: "privacy"}},
+		},
+		{
+			name:    "release search",
+			filters: nostr.Filters{{Kinds: []int{events.KindRelease}, Search: "crash"}},
+		},
+		{
+			name:    "asset search",
+			filters: nostr.Filters{{Kinds: []int{events.KindAsset}, Search: "signal"}},
+			err:     ErrUnsupportedREQ,
+		},
+		{
+			name:    "multiple kinds",
+			filters: nostr.Filters{{Kinds: []int{events.KindApp, events.KindStack}, Search: "signal"}},
+			err:     ErrUnsupportedREQ,
+		},
+		{
+			name:    "term too short",
+			filters: nostr.Filters{{Kinds: []int{events.KindRelease}, Search: "ok"}},
+			err:     ErrUnsupportedREQ,
+		},
+		{
+			name:    "app search with lang",
+			filters: nostr.Filters{{Kinds: []int{events.KindApp}, Search: "signal lang:es"}},
+		},
+		{
+			name:    "term too short without lang",
+			filters: nostr.Filters{{Kinds: []int{events.KindApp}, Search: "lang:es ok"}},
+			err:     ErrUnsupportedREQ,
+		},
+		{
+			name:    "invalid lang",
+			filters: nostr.Filters{{Kinds: []int{events.KindApp}, Search: "signal lang:e$"}},
+			err:     ErrUnsupportedREQ,
+		},
+		{
+			name:    "lang on stack search",
+			filters: nostr.Filters{{Kinds: []int{events.KindStack}, Search: "privacy lang:es"}},
+			err:     ErrUnsupportedREQ,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Validate(test.filters...)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestStoreQueryStackAndReleaseSearch(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	stack := &nostr.Event{
+		ID:        "stack1",
+		PubKey:    "pubkey1",
+		CreatedAt: nostr.Timestamp(1700000001),
+		Kind:      events.KindStack,
+		Tags: nostr.Tags{
+			{"d", "privacy"},
+			{"title", "Privacy essentials"},
+			{"description", "Apps that respect your data"},
+			{"f", "android-arm64-v8a"},
+			{"h", "community"},
+		},
+		Sig: "sig1",
+	}
+
+	releases := []*nostr.Event{
+		{
+			ID:        "release1",
+			PubKey:    "pubkey1",
+			CreatedAt: nostr.Timestamp(1700000002),
+			Kind:      events.KindRelease,
+			Tags: nostr.Tags{
+				{"d", "com.example.app@1.0.1"},
+				{"i", "com.example.app"},
+				{"version", "1.0.1"},
+			},
+			Content: "Fixed crash on Android 14 when opening settings.",
+			Sig:     "sig2",
+		},
+		{
+			ID:        "release2",
+			PubKey:    "pubkey1",
+			CreatedAt: nostr.Timestamp(1700000003),
+			Kind:      events.KindRelease,
+			Tags: nostr.Tags{
+				{"d", "com.example.app@1.1.0"},
+				{"i", "com.example.app"},
+				{"version", "1.1.0"},
+			},
+			Content: "New dark theme.",
+			Sig:     "sig3",
+		},
+	}
+
+	for _, event := range append([]*nostr.Event{stack}, releases...) {
+		if _, err := store.Save(ctx, event); err != nil {
+			t.Fatalf("failed to save event %s: %v", event.ID, err)
+		}
+	}
+
+	results, err := store.Query(ctx, nostr.Filter{Kinds: []int{events.KindStack}, Search: "privacy", Limit: 10})
+	if err != nil {
+		t.Fatalf("store.Query() error = %v", err)
+	}
+	if !reflect.DeepEqual(results, []nostr.Event{*stack}) {
+		t.Errorf("stack results mismatch\ngot:  %v\nwant: %v", results, []nostr.Event{*stack})
+	}
+
+	results, err = store.Query(ctx, nostr.Filter{Kinds: []int{events.KindRelease}, Search: "fixed crash on android 14", Limit: 10})
+	if err != nil {
+		t.Fatalf("store.Query() error = %v", err)
+	}
+	if !reflect.DeepEqual(results, []nostr.Event{*releases[0]}) {
+		t.Errorf("release results mismatch\ngot:  %v\nwant: %v", results, []nostr.Event{*releases[0]})
+	}
+
+	if _, err := store.Delete(ctx, nostr.Filter{IDs: []string{stack.ID, releases[0].ID}}); err != nil {
+		t.Fatalf("failed to delete events: %v", err)
+	}
+
+	var count int
+	err = store.DB.QueryRowContext(ctx,
+		"SELECT (SELECT COUNT(*) FROM stacks_fts) + (SELECT COUNT(*) FROM releases_fts WHERE id = ?)",
+		releases[0].ID,
+	).Scan(&count)
+	if err != nil {
+		t.Fatalf("failed to query fts tables: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("FTS entries not cleaned up: got %d entries, want 0", count)
+	}
+}
+
 // Multi-character tag keys indexed per event kind (kind-specific triggers).
 // All single-letter tags are indexed universally by single_letter_tags_ai.
 var (
@@ -663,6 +1139,73 @@ func TestPendingEvents(t *testing.T) {
 	}
 }
 
+func TestReferencedHashes(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	hash := func(c byte) string { return strings.Repeat(string(c), 64) }
+
+	saved := []nostr.Event{
+		{
+			ID:   "asset",
+			Kind: events.KindAsset,
+			Tags: nostr.Tags{{"i", "com.example"}, {"x", hash('a')}},
+		},
+		{
+			ID:   "app",
+			Kind: events.KindApp,
+			Tags: nostr.Tags{
+				{"d", "com.example"},
+				{"icon", "https://cdn.zapstore.dev/" + hash('b') + ".png"},
+				{"image", "https://cdn.zapstore.dev/" + strings.ToUpper(hash('c'))},
+				{"image", "https://example.com/screenshot.png"},
+			},
+		},
+		{
+			// the x tag of a non-asset event is not a blob reference
+			ID:   "comment",
+			Kind: events.KindComment,
+			Tags: nostr.Tags{{"x", hash('d')}},
+		},
+	}
+	for _, e := range saved {
+		e.PubKey, e.Sig = "pubkey", "sig"
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("Save(%s): %v", e.ID, err)
+		}
+	}
+
+	pending := nostr.Event{
+		ID:     "pending",
+		PubKey: "pubkey",
+		Kind:   events.KindAsset,
+		Tags:   nostr.Tags{{"x", hash('e')}},
+		Sig:    "sig",
+	}
+	if _, err := store.SavePending(ctx, &pending); err != nil {
+		t.Fatalf("SavePending: %v", err)
+	}
+
+	refs, err := store.ReferencedHashes(ctx)
+	if err != nil {
+		t.Fatalf("ReferencedHashes: %v", err)
+	}
+
+	var got []string
+	for h := range refs {
+		got = append(got, h)
+	}
+	slices.Sort(got)
+
+	expected := []string{hash('a'), hash('b'), hash('c'), hash('e')}
+	if !slices.Equal(got, expected) {
+		t.Errorf("expected hashes %v, got %v", expected, got)
+	}
+}
+
 func TestForceDeleteRequest(t *testing.T) {
 	const (
 		alice = "alice"
@@ -982,3 +1525,34 @@ func equalTags(a, b nostr.Tags) bool {
 	}
 	return true
 }
+
+func TestExternalAssets(t *testing.T) {
+	store, err := New(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	saved := []nostr.Event{
+		{ID: "external", Kind: events.KindAsset, Tags: nostr.Tags{{"x", "hash"}, {"url", "https://example.com/app.apk"}}},
+		{ID: "hosted", Kind: events.KindAsset, Tags: nostr.Tags{{"x", "hash"}}},
+		{ID: "app", Kind: events.KindApp, Tags: nostr.Tags{{"d", "com.example"}, {"url", "https://example.com"}}},
+	}
+	for _, e := range saved {
+		e.PubKey, e.Sig = "pubkey", "sig"
+		if _, err := store.Save(ctx, &e); err != nil {
+			t.Fatalf("Save(%s): %v", e.ID, err)
+		}
+	}
+
+	assets, err := store.ExternalAssets(ctx)
+	if err != nil {
+		t.Fatalf("ExternalAssets: %v", err)
+	}
+	if len(assets) != 1 || assets[0].ID != "external" {
+		t.Fatalf("expected only the external asset, got %v", assets)
+	}
+	if url, _ := events.Find(assets[0].Tags, "url"); url != "https://example.com/app.apk" {
+		t.Errorf("expected the url tag to be returned, got %v", assets[0].Tags)
+	}
+}
@@ -0,0 +1,313 @@
+package relay
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+const (
+	// retryVerifyAfter is the minimum duration between two verifications of an external URL that failed.
+	retryVerifyAfter = 15 * time.Minute
+
+	// maxConcurrentVerify is the maximum number of pending assets whose URLs are verified at the same time.
+	maxConcurrentVerify = 4
+
+	// maxVerifyDuration is the maximum duration of a single run of verifyPending.
+	maxVerifyDuration = 30 * time.Minute
+)
+
+var (
+	errNotModified = errors.New("content not modified")
+	errTooLarge    = errors.New("content exceeds the maximum size")
+)
+
+// fetchHash downloads the content of the URL, returning its SHA-256 and etag.
+// If an etag is provided, the request is conditional, and [errNotModified] is returned when the
+// content hasn't changed. Content larger than max bytes is rejected with [errTooLarge].
+func fetchHash(ctx context.Context, client *http.Client, url, etag string, max int64) (blossom.Hash, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return blossom.Hash{}, "", fmt.Errorf("invalid url: %w", err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return blossom.Hash{}, "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotModified && etag != "" {
+		return blossom.Hash{}, etag, errNotModified
+	}
+	if res.StatusCode != http.StatusOK {
+		return blossom.Hash{}, "", fmt.Errorf("unexpected status %s", res.Status)
+	}
+	if res.ContentLength > max {
+		return blossom.Hash{}, "", fmt.Errorf("%w: %d > %d bytes", errTooLarge, res.ContentLength, max)
+	}
+
+	hasher := sha256.New()
+	n, err := io.Copy(hasher, io.LimitReader(res.Body, max+1))
+	if err != nil {
+		return blossom.Hash{}, "", fmt.Errorf("failed to read content: %w", err)
+	}
+	if n > max {
+		return blossom.Hash{}, "", fmt.Errorf("%w: more than %d bytes", errTooLarge, max)
+	}
+	return blossom.Hash(hasher.Sum(nil)), res.Header.Get("ETag"), nil
+}
+
+// maxAssetSize returns the maximum size of the asset's blob, which is the one in its "size" tag if valid,
+// capped to the configured maximum.
+func (r *T) maxAssetSize(asset *nostr.Event) int64 {
+	tag, ok := events.Find(asset.Tags, "size")
+	if !ok {
+		return r.config.VerifyMaxSize
+	}
+
+	size, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || size <= 0 {
+		return r.config.VerifyMaxSize
+	}
+	return min(size, r.config.VerifyMaxSize)
+}
+
+// isVerified returns whether the content of the URL has been verified to match the hash,
+// and hasn't been found changed since.
+func (r *T) isVerified(ctx context.Context, url string, hash blossom.Hash) (bool, error) {
+	v, err := r.store.QueryVerification(ctx, url, hash.Hex())
+	if errors.Is(err, store.ErrVerificationNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !v.Changed(), nil
+}
+
+// verifyURL downloads the content of the URL and compares its SHA-256 with the hash,
+// recording the verification if they match. URLs that failed are not retried for [retryVerifyAfter].
+func (r *T) verifyURL(ctx context.Context, asset *nostr.Event, url string, hash blossom.Hash) (bool, error) {
+	key := url + " " + hash.Hex()
+	r.mu.Lock()
+	failed, ok := r.unverified[key]
+	r.mu.Unlock()
+	if ok && time.Since(failed) < retryVerifyAfter {
+		return false, nil
+	}
+
+	verified, err := r.fetchAndVerify(ctx, asset, url, hash)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if (err != nil || !verified) && ctx.Err() == nil {
+		// URLs interrupted by the end of the run are retried on the next one
+		r.unverified[key] = time.Now()
+		return verified, err
+	}
+
+	delete(r.unverified, key)
+	return true, nil
+}
+
+func (r *T) fetchAndVerify(ctx context.Context, asset *nostr.Event, url string, hash blossom.Hash) (bool, error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, r.config.VerifyTimeout)
+	defer cancel()
+
+	computed, etag, err := fetchHash(fetchCtx, r.fetcher, url, "", r.maxAssetSize(asset))
+	if err != nil {
+		slog.Warn("relay: failed to verify asset url", "event", asset.ID, "url", url, "error", err)
+		return false, nil
+	}
+	if computed != hash {
+		slog.Warn("relay: asset url content doesn't match its hash", "event", asset.ID, "url", url, "hash", hash, "computed", computed)
+		return false, nil
+	}
+
+	v := store.Verification{URL: url, Hash: hash.Hex(), ETag: etag, VerifiedAt: time.Now().UTC()}
+	if err := r.store.SaveVerification(ctx, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RecordVerification records that the content of the external URL matches the hash,
+// for example because the blob has been mirrored from it, so the URL doesn't have to be downloaded again.
+func (r *T) RecordVerification(ctx context.Context, url string, hash blossom.Hash) error {
+	v := store.Verification{URL: url, Hash: hash.Hex(), VerifiedAt: time.Now().UTC()}
+	if err := r.store.SaveVerification(ctx, v); err != nil {
+		return fmt.Errorf("failed to record verification of %s: %w", url, err)
+	}
+
+	r.mu.Lock()
+	delete(r.unverified, url+" "+hash.Hex())
+	r.mu.Unlock()
+	return nil
+}
+
+// runVerify periodically verifies the external URLs of pending assets, until the context is cancelled.
+// When some assets become ready, the reconcile goroutine is signaled to promote them.
+func (r *T) runVerify(ctx context.Context) {
+	ticker := time.NewTicker(r.config.ReconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			ready, err := r.verifyPending(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("verify failed", "error", err)
+			}
+			if ready > 0 {
+				select {
+				case r.verified <- struct{}{}:
+				default:
+					// a reconcile is already scheduled
+				}
+			}
+		}
+	}
+}
+
+// verifyPending downloads and verifies the external URLs of the pending assets whose blob is not in blossom,
+// at most [maxConcurrentVerify] assets at a time and for at most [maxVerifyDuration] overall.
+// It returns the number of assets that are ready to be promoted.
+func (r *T) verifyPending(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, maxVerifyDuration)
+	defer cancel()
+
+	assets, err := r.store.QueryPending(ctx, events.KindAsset)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query pending assets: %w", err)
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		ready int
+		errs  []error
+		sem   = make(chan struct{}, maxConcurrentVerify)
+	)
+
+	for _, asset := range assets {
+		select {
+		case <-ctx.Done():
+		case sem <- struct{}{}:
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(asset nostr.Event) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			ok, err := r.isAssetReady(ctx, &asset, true)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to verify asset %s: %w", asset.ID, err))
+				return
+			}
+			if ok {
+				ready++
+			}
+		}(asset)
+	}
+
+	wg.Wait()
+	if ctx.Err() != nil && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		errs = append(errs, ctx.Err())
+	}
+	return ready, errors.Join(errs...)
+}
+
+// runReverify periodically re-verifies the external asset URLs, until the context is cancelled.
+func (r *T) runReverify(ctx context.Context) {
+	ticker := time.NewTicker(r.config.ReverifyInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			if err := r.reverify(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("reverify failed", "error", err)
+			}
+		}
+	}
+}
+
+// reverify checks again the URLs verified more than [Config.ReverifyInterval] ago, using their etag
+// to skip unchanged content. URLs whose content no longer matches the hash are flagged as changed,
+// so they are no longer trusted. URLs that can't be fetched are retried on the next run.
+func (r *T) reverify(ctx context.Context) error {
+	now := time.Now().UTC()
+	verifications, err := r.store.Verifications(ctx, now.Add(-r.config.ReverifyInterval))
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, v := range verifications {
+		hash, err := blossom.ParseHash(v.Hash)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid hash of verification of %s: %w", v.URL, err))
+			continue
+		}
+
+		fetchCtx, cancel := context.WithTimeout(ctx, r.config.VerifyTimeout)
+		computed, etag, err := fetchHash(fetchCtx, r.fetcher, v.URL, v.ETag, r.config.VerifyMaxSize)
+		cancel()
+
+		switch {
+		case errors.Is(err, errNotModified) || (err == nil && computed == hash):
+			v.ETag, v.VerifiedAt = etag, time.Now().UTC()
+			if err := r.store.SaveVerification(ctx, v); err != nil {
+				errs = append(errs, err)
+			}
+
+		case err != nil:
+			if ctx.Err() != nil {
+				return errors.Join(append(errs, ctx.Err())...)
+			}
+			slog.Warn("relay: failed to reverify asset url", "url", v.URL, "hash", v.Hash, "error", err)
+
+		default:
+			if err := r.store.FlagChanged(ctx, v.URL, v.Hash, now); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			var ids []string
+			if assets, err := r.AssetsReferencing(ctx, hash); err == nil {
+				for _, asset := range assets {
+					ids = append(ids, asset.ID)
+				}
+			}
+			slog.Warn("relay: asset url content changed", "url", v.URL, "hash", v.Hash, "computed", computed, "assets", ids)
+		}
+	}
+	return errors.Join(errs...)
+}
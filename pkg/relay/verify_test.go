@@ -0,0 +1,253 @@
+package relay
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/pippellia-btc/blossom"
+	"github.com/zapstore/relay/pkg/events"
+	"github.com/zapstore/relay/pkg/relay/store"
+)
+
+func TestFetchHash(t *testing.T) {
+	content := []byte("the apk content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	hash, etag, err := fetchHash(ctx, server.Client(), server.URL, "", 100)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if hash != blossom.ComputeHash(content) || etag != `"v1"` {
+		t.Errorf("expected hash %s and etag \"v1\", got %s and %s", blossom.ComputeHash(content), hash, etag)
+	}
+
+	if _, _, err := fetchHash(ctx, server.Client(), server.URL, `"v1"`, 100); !errors.Is(err, errNotModified) {
+		t.Errorf("expected error %v, got %v", errNotModified, err)
+	}
+	if _, _, err := fetchHash(ctx, server.Client(), server.URL, "", int64(len(content)-1)); !errors.Is(err, errTooLarge) {
+		t.Errorf("expected error %v, got %v", errTooLarge, err)
+	}
+	if _, _, err := fetchHash(ctx, server.Client(), server.URL+"/missing", "", 100); err == nil {
+		t.Error("expected an error for a missing url")
+	}
+}
+
+func TestIsAssetReady(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "relay.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	content := []byte("the apk content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/app.apk":
+			w.Write(content)
+		case "/other.apk":
+			w.Write([]byte("a different binary"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	r := &T{config: NewConfig(), store: db, blossom: mockBlossom{}, fetcher: server.Client(), unverified: make(map[string]time.Time)}
+	asset := func(url string) *nostr.Event {
+		return &nostr.Event{ID: url, Kind: events.KindAsset, Tags: nostr.Tags{
+			{"x", blossom.ComputeHash(content).Hex()},
+			{"url", url},
+		}}
+	}
+
+	tests := []struct {
+		name   string
+		url    string
+		verify bool
+		ready  bool
+	}{
+		{"not verified yet", server.URL + "/app.apk", false, false},
+		{"matching content", server.URL + "/app.apk", true, true},
+		{"cached verification", server.URL + "/app.apk", false, true},
+		{"different content", server.URL + "/other.apk", true, false},
+		{"missing content", server.URL + "/missing.apk", true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ready, err := r.isAssetReady(ctx, asset(test.url), test.verify)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if ready != test.ready {
+				t.Errorf("expected ready %v, got %v", test.ready, ready)
+			}
+		})
+	}
+
+	hash := blossom.ComputeHash(content).Hex()
+	if err := db.FlagChanged(ctx, server.URL+"/app.apk", hash, time.Now()); err != nil {
+		t.Fatalf("failed to flag verification: %v", err)
+	}
+	if ready, _ := r.isAssetReady(ctx, asset(server.URL+"/app.apk"), false); ready {
+		t.Error("expected an asset with a changed url to not be ready")
+	}
+}
+
+func TestReverify(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "relay.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	content := []byte("the apk content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/unchanged.apk":
+			w.Write(content)
+		case "/changed.apk":
+			w.Write([]byte("a different binary"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	hash := blossom.ComputeHash(content).Hex()
+	verifiedAt := time.Now().Add(-48 * time.Hour).UTC()
+	for _, path := range []string{"/unchanged.apk", "/changed.apk", "/missing.apk"} {
+		v := store.Verification{URL: server.URL + path, Hash: hash, VerifiedAt: verifiedAt}
+		if err := db.SaveVerification(ctx, v); err != nil {
+			t.Fatalf("failed to save verification: %v", err)
+		}
+	}
+
+	relay := &T{config: NewConfig(), store: db, blossom: mockBlossom{}, fetcher: server.Client()}
+	if err := relay.reverify(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tests := []struct {
+		path    string
+		changed bool
+		renewed bool
+	}{
+		{"/unchanged.apk", false, true},
+		{"/changed.apk", true, false},
+		{"/missing.apk", false, false},
+	}
+
+	for _, test := range tests {
+		v, err := db.QueryVerification(ctx, server.URL+test.path, hash)
+		if err != nil {
+			t.Fatalf("%s: failed to query verification: %v", test.path, err)
+		}
+		if v.Changed() != test.changed {
+			t.Errorf("%s: expected changed %v, got %v", test.path, test.changed, v.Changed())
+		}
+		if renewed := v.VerifiedAt.After(verifiedAt); renewed != test.renewed {
+			t.Errorf("%s: expected renewed %v, got %v", test.path, test.renewed, renewed)
+		}
+	}
+}
+
+func TestVerifyPending(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "relay.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	content := []byte("the apk content")
+	var downloads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		switch r.URL.Path {
+		case "/app.apk":
+			w.Write(content)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	hash := blossom.ComputeHash(content).Hex()
+	for _, path := range []string{"/app.apk", "/missing.apk"} {
+		asset := nostr.Event{ID: path, PubKey: "pubkey", CreatedAt: nostr.Now(), Kind: events.KindAsset, Sig: "sig", Tags: nostr.Tags{
+			{"x", hash},
+			{"url", server.URL + path},
+		}}
+		if _, err := db.SavePending(ctx, &asset); err != nil {
+			t.Fatalf("failed to save pending asset: %v", err)
+		}
+	}
+
+	r := &T{config: NewConfig(), store: db, blossom: mockBlossom{}, fetcher: server.Client(), unverified: make(map[string]time.Time)}
+	if err := r.reconcile(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n := downloads.Load(); n != 0 {
+		t.Fatalf("expected reconcile to not download external urls, got %d downloads", n)
+	}
+
+	ready, err := r.verifyPending(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if ready != 1 {
+		t.Errorf("expected 1 ready asset, got %d", ready)
+	}
+	if verified, _ := r.isVerified(ctx, server.URL+"/app.apk", blossom.ComputeHash(content)); !verified {
+		t.Error("expected the matching url to be verified")
+	}
+
+	// the missing url failed recently, so it's not downloaded again
+	downloads.Store(0)
+	if _, err := r.verifyPending(ctx); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n := downloads.Load(); n != 0 {
+		t.Errorf("expected no downloads, got %d", n)
+	}
+}
+
+func TestRecordVerification(t *testing.T) {
+	db, err := store.New(filepath.Join(t.TempDir(), "relay.db"))
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer db.Close()
+
+	hash := blossom.ComputeHash([]byte("the apk content"))
+	url := "https://example.com/app.apk"
+
+	r := &T{config: NewConfig(), store: db, blossom: mockBlossom{}, unverified: map[string]time.Time{url + " " + hash.Hex(): time.Now()}}
+	if err := r.RecordVerification(ctx, url, hash); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if verified, _ := r.isVerified(ctx, url, hash); !verified {
+		t.Error("expected the url to be verified")
+	}
+	if len(r.unverified) != 0 {
+		t.Errorf("expected the failed verification to be forgotten, got %v", r.unverified)
+	}
+}